@@ -0,0 +1,231 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/wal"
+)
+
+const _cfDirPrefix = "cf-"
+
+var (
+	ErrCFExists      = errors.New("column family already exists")
+	ErrInvalidCFName = errors.New("invalid column family name")
+	ErrCFNotFound    = errors.New("column family not found")
+)
+
+// CF column family
+// a logically separate keyspace with its own memtable and sstables,
+// all column families of a db share the same oracle and wal, so a txn can commit across families atomically.
+type CF struct {
+	keyspace
+
+	name string
+	dir  string
+}
+
+func (cf *CF) Name() string {
+	return cf.name
+}
+
+// CreateColumnFamily create a column family named name
+// use ColumnFamily to get the handle of a column family recovered by Open
+func (db *DB) CreateColumnFamily(name string) (*CF, error) {
+	if db.State() == StateClosed {
+		return nil, ErrDBClosed
+	}
+	if db.readOnly {
+		return nil, ErrReadOnlyDB
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidCFName
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.cfs[name]; ok {
+		return nil, ErrCFExists
+	}
+
+	cf, _, err := db.openCF(name)
+	if err != nil {
+		return nil, err
+	}
+	db.cfs[name] = cf
+	return cf, nil
+}
+
+// ColumnFamily get the handle of an exist column family
+func (db *DB) ColumnFamily(name string) (*CF, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	cf, ok := db.cfs[name]
+	return cf, ok
+}
+
+// recoverCFs open all column families under db dir, return max version of them
+func (db *DB) recoverCFs() (uint64, error) {
+	files, err := os.ReadDir(db.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var maxVersion uint64
+	for _, file := range files {
+		if !file.IsDir() || !strings.HasPrefix(file.Name(), _cfDirPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(file.Name(), _cfDirPrefix)
+		cf, version, err := db.openCF(name)
+		if err != nil {
+			return 0, err
+		}
+		db.cfs[name] = cf
+		maxVersion = max(maxVersion, version)
+	}
+	return maxVersion, nil
+}
+
+func (db *DB) openCF(name string) (*CF, uint64, error) {
+	if db.inMemory {
+		cf := &CF{
+			keyspace: keyspace{
+				memtable:   newMemoryMemtable(db.config.MemtableIndex(), db.logger),
+				immutables: list.New(),
+			},
+			name: name,
+		}
+		cf.manager = newLevelManager(db, "")
+		cf.manager.family = name
+		return cf, 0, nil
+	}
+
+	dir := path.Join(db.dir, _cfDirPrefix+name)
+	if err := os.MkdirAll(dir, db.config.DirMode); err != nil {
+		return nil, 0, ErrMkDir
+	}
+
+	// writes are logged to the wal of the default keyspace, which is replayed once all families are opened
+	mt := newMemoryMemtable(db.config.MemtableIndex(), db.logger)
+	walMaxVersion, err := db.recoverLegacyWAL(name, dir, mt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// recover from exist data file
+	lm := newLevelManager(db, dir)
+	lm.family = name
+	dbMaxVersion, err := lm.recover()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cf := &CF{
+		keyspace: keyspace{
+			memtable:   mt,
+			immutables: list.New(),
+			manager:    lm,
+		},
+		name: name,
+		dir:  dir,
+	}
+	return cf, max(walMaxVersion, dbMaxVersion), nil
+}
+
+// recoverLegacyWAL replay wal files left in dir of column family name by a db whose families had wals of their own,
+// entries are logged to the wal of the default keyspace before the files are deleted. return max version of them
+func (db *DB) recoverLegacyWAL(name, dir string, mt *memtable) (uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var walFiles []string
+	for _, file := range files {
+		if !file.IsDir() && path.Ext(file.Name()) == ".log" {
+			walFiles = append(walFiles, file.Name())
+		}
+	}
+	// replay from old to new
+	slices.SortFunc(walFiles, func(a, b string) int {
+		return wal.CompareVersion(wal.ParseVersion(a), wal.ParseVersion(b))
+	})
+
+	var maxVersion uint64
+	for _, file := range walFiles {
+		l, err := wal.OpenWithLogger(path.Join(dir, file), db.logger)
+		if err != nil {
+			return 0, err
+		}
+
+		entries, err := l.Read()
+		if errors.Is(err, wal.ErrTruncatedRecord) {
+			db.logger.Warnf("wal %v of column family %v is truncated, replay %d entries before the tail: %v", file, name, len(entries), err)
+		} else if errors.Is(err, wal.ErrCorruptedRecord) && db.repair {
+			db.logger.Warnf("wal %v of column family %v is corrupted, replay %d entries before the corruption: %v", file, name, len(entries), err)
+		} else if err != nil {
+			return 0, errors.Join(err, l.Close())
+		}
+
+		var version uint64
+		for _, entry := range entries {
+			version = max(version, types.Ts(entry))
+		}
+		mt.apply(entries)
+		if len(entries) > 0 {
+			if err = db.memtable.wal.WriteBatch(map[string][]types.Entry{name: entries}, version, false); err != nil {
+				return 0, errors.Join(err, l.Close())
+			}
+		}
+		if err = l.Delete(); err != nil {
+			return 0, err
+		}
+		maxVersion = max(maxVersion, version)
+	}
+	return maxVersion, nil
+}
+
+// family return the name of cf in wal, nil cf means the default keyspace, which is ""
+func (cf *CF) family() string {
+	if cf == nil {
+		return ""
+	}
+	return cf.name
+}
+
+// keyspaceOf return keyspace of cf, nil cf means the default one
+func (db *DB) keyspaceOf(cf *CF) *keyspace {
+	if cf == nil {
+		return &db.keyspace
+	}
+	return &cf.keyspace
+}
+
+func (db *DB) searchCF(cf *CF, key types.Key) ([]byte, bool) {
+	if cf == nil {
+		return db.search(key)
+	}
+	return db.searchKeyspace(&cf.keyspace, key)
+}
@@ -0,0 +1,275 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateColumnFamily(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	cf, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+	assert.Equal(t, "users", cf.Name())
+
+	_, err = db.CreateColumnFamily("users")
+	assert.Equal(t, ErrCFExists, err)
+
+	_, err = db.CreateColumnFamily("")
+	assert.Equal(t, ErrInvalidCFName, err)
+
+	got, ok := db.ColumnFamily("users")
+	assert.True(t, ok)
+	assert.Equal(t, cf, got)
+}
+
+func TestColumnFamilyIsolation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+	orders, err := db.CreateColumnFamily("orders")
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		if err := txn.Set("key", []byte("default")); err != nil {
+			return err
+		}
+		if err := txn.SetCF(users, "key", []byte("users")); err != nil {
+			return err
+		}
+		return txn.SetCF(orders, "key", []byte("orders"))
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("default"), val)
+
+		val, found = txn.GetCF(users, "key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("users"), val)
+
+		val, found = txn.GetCF(orders, "key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("orders"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.DeleteCF(users, "key")
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.GetCF(users, "key")
+		assert.False(t, found)
+
+		val, found := txn.GetCF(orders, "key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("orders"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestColumnFamilyAtomicCommit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	accounts, err := db.CreateColumnFamily("accounts")
+	assert.NoError(t, err)
+	logs, err := db.CreateColumnFamily("logs")
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.SetCF(accounts, "alice", []byte("100"))
+	})
+	assert.NoError(t, err)
+
+	// txn1 reads alice and writes both families
+	txn1 := db.Begin(true)
+	_, found := txn1.GetCF(accounts, "alice")
+	assert.True(t, found)
+	assert.NoError(t, txn1.SetCF(accounts, "alice", []byte("90")))
+	assert.NoError(t, txn1.SetCF(logs, "1", []byte("alice -10")))
+
+	// txn2 modifies alice concurrently
+	err = db.Update(func(txn *Txn) error {
+		return txn.SetCF(accounts, "alice", []byte("50"))
+	})
+	assert.NoError(t, err)
+
+	// none of the writes of txn1 should be visible
+	assert.Equal(t, ErrConflictTxn, txn1.Commit())
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.GetCF(accounts, "alice")
+		assert.True(t, found)
+		assert.Equal(t, []byte("50"), val)
+
+		_, found = txn.GetCF(logs, "1")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestColumnFamilyRecover(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	cf, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.SetCF(cf, "alice", []byte("1"))
+	})
+	assert.NoError(t, err)
+	db.Close()
+
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cf, ok := db.ColumnFamily("users")
+	assert.True(t, ok)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.GetCF(cf, "alice")
+		assert.True(t, found)
+		assert.Equal(t, []byte("1"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestColumnFamilySharedWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+
+	commit := func(key string) {
+		err := db.Update(func(txn *Txn) error {
+			if err := txn.Set(key, []byte("default")); err != nil {
+				return err
+			}
+			return txn.SetCF(users, key, []byte("users"))
+		})
+		assert.NoError(t, err)
+	}
+	commit("first")
+	// the last commit is torn by a crash
+	commit("second")
+
+	// writes of column families are logged to the wal of the db
+	logs, err := filepath.Glob(path.Join(dir, _cfDirPrefix+"users", "*.log"))
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+	logs, err = filepath.Glob(path.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1)
+
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	assert.NoError(t, os.CopyFS(crashed, os.DirFS(dir)))
+	db.Close()
+
+	info, err := os.Stat(path.Join(crashed, path.Base(logs[0])))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(path.Join(crashed, path.Base(logs[0])), info.Size()-3))
+
+	db, err = Open(crashed, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	users, ok := db.ColumnFamily("users")
+	assert.True(t, ok)
+
+	err = db.View(func(txn *Txn) error {
+		// both families of a commit are replayed, or neither
+		_, ok := txn.Get("first")
+		assert.True(t, ok)
+		_, ok = txn.GetCF(users, "first")
+		assert.True(t, ok)
+		_, ok = txn.Get("second")
+		assert.False(t, ok)
+		_, ok = txn.GetCF(users, "second")
+		assert.False(t, ok)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestColumnFamilyLegacyWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	_, err = db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+	db.Close()
+
+	// a column family with a wal of its own, left by an older db
+	cfDir := path.Join(dir, _cfDirPrefix+"users")
+	l, err := wal.Create(cfDir)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Write(types.Entry{Key: types.KeyWithTs("alice", 7), Value: []byte("1"), Version: 7}))
+	assert.NoError(t, l.Close())
+
+	for range 2 {
+		db, err = Open(dir, Config{})
+		assert.NoError(t, err)
+		users, ok := db.ColumnFamily("users")
+		assert.True(t, ok)
+		err = db.View(func(txn *Txn) error {
+			val, found := txn.GetCF(users, "alice")
+			assert.True(t, found)
+			assert.Equal(t, []byte("1"), val)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(8), db.oracle.nextTs)
+
+		// the wal is moved into the wal of the db
+		logs, err := filepath.Glob(path.Join(cfDir, "*.log"))
+		assert.NoError(t, err)
+		assert.Empty(t, logs)
+		db.Close()
+	}
+}
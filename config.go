@@ -14,42 +14,243 @@
 
 package originium
 
-import "os"
+import (
+	"os"
+	"slices"
+	"time"
+
+	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/pkg/skiplist"
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
+)
 
 const (
 	_kb = 1024
 	_mb = 1024 * _kb
 )
 
+type CompactionStrategy int
+
+const (
+	// Leveled merge tables into the overlapping tables of the next level,
+	// keep the size of each level under its target
+	Leveled CompactionStrategy = iota
+	// Tiered merge tables of similar size into one table of the next level,
+	// trade read and space amplification for less write amplification
+	Tiered
+)
+
+// CompactionTrigger decide when a level of Leveled compaction is over its target
+type CompactionTrigger int
+
+const (
+	// TriggerByCount a level is over its target once it has more than L0TargetNum*LevelRatio^level tables
+	TriggerByCount CompactionTrigger = iota
+	// TriggerByBytes a level >= 1 is over its target once its sstables hold more than
+	// L1TargetBytes*LevelRatio^(level-1) bytes, tables of varying size are weighed by the space they take.
+	// L0 is always triggered by count, its tables overlap and each one is searched by lookups.
+	TriggerByBytes
+)
+
+// CompactionSchedule report whether compaction driven by flush may run at now, see Config.CompactionSchedule
+type CompactionSchedule func(now time.Time) bool
+
+// CompactBetween allow compaction from hour start to hour end of the local time of now, e.g. 1 to 5 for
+// the early morning, a window wraps over midnight if start > end, e.g. 22 to 6.
+func CompactBetween(start, end int) CompactionSchedule {
+	return func(now time.Time) bool {
+		hour := now.Hour()
+		if start <= end {
+			return hour >= start && hour < end
+		}
+		return hour >= start || hour < end
+	}
+}
+
 type Config struct {
 	// SkipList Config
 	SkipListMaxLevel int
 	SkipListP        float64
 
 	// Memtable Config
+	// constructor of the in-memory index of memtables, e.g. sortedslice.New for small memtables,
+	// default a skiplist of SkipListMaxLevel and SkipListP
+	MemtableIndex func() MemtableIndex
 	// memtable size threshold of turning to an immutable memtable
 	MemtableByteThreshold int
 	ImmutableBuffer       int
+	// number of immutables waiting for flush of a keyspace from which commits are slowed down
+	// to let flush catch up, 0 means never
+	WriteSlowdownImmutables int
+	// number of immutables waiting for flush of a keyspace from which commits fail with ErrWriteStalled
+	// instead of blocking on a full flush queue, 0 means never.
+	// it should not exceed ImmutableBuffer, a commit which rotates multiple memtables may still block.
+	WriteStallImmutables int
 
 	// SSTable Config
 	DataBlockByteThreshold int
+	// data block size of each level overriding DataBlockByteThreshold, e.g. small blocks of L0 for less
+	// read amplification and large blocks of deep levels for better compression and smaller indexes,
+	// levels deeper than len(LevelDataBlockByteThreshold) use the last one, default DataBlockByteThreshold for all levels
+	LevelDataBlockByteThreshold []int
+	// size of sstable built by compaction, a larger output is split into multiple sstables
+	TargetFileSize int
+	// false positive rate of bloom filter of each level,
+	// levels deeper than len(BloomFilterP) use the last one
+	BloomFilterP []float64
+	// length of key prefixes of which a bloom filter is built per sstable besides the key one, 0 means none,
+	// a scan of [start, end) whose keys all share a prefix of this length skips sstables without it,
+	// e.g. 5 for keys like "user1:..." scanned from "user1:" to "user1;". filters are rebuilt when a db is opened.
+	PrefixBloomFilterLen int
+	// compression level of each level, e.g. stronger compression for deep levels which are rarely rewritten,
+	// levels deeper than len(CompressionLevel) use the last one, default CompressionDefault for all levels
+	CompressionLevel []CompressionLevel
+	// store blocks of all levels uncompressed, overriding CompressionLevel, so reads skip decompression,
+	// e.g. for small values whose read latency matters more than size. sstables are readable either way.
+	DisableCompression bool
+	// number of data blocks of each sstable a scan fetches ahead of the reader in background, 0 means none,
+	// e.g. 4 for large sequential scans to overlap reads and decoding of blocks with processing of entries.
+	// at most this many blocks per sstable are held ahead, prefetching stops once the scan is done.
+	ScanPrefetchBlocks int
 
 	// Level Config
 	L0TargetNum int
 	LevelRatio  int
+	// strategy of compaction, default Leveled
+	CompactionStrategy CompactionStrategy
+	// trigger of Leveled compaction, default TriggerByCount
+	CompactionTrigger CompactionTrigger
+	// max bytes of sstables of L1 under TriggerByBytes, each deeper level is LevelRatio times larger
+	L1TargetBytes int
+	// max number of levels including L0, at least 2, 0 means unlimited
+	// tables of the deepest level over its target are merged within the level instead of into a new level.
+	MaxLevels int
+	// prefixes of keys kept in shallow levels of Leveled compaction, e.g. known hot data,
+	// so point lookups of them search fewer levels. a level over its target compacts
+	// its tables without these keys into the next level first.
+	HotPrefixes []string
+	// number of newest versions of each key kept by compaction regardless of the discard watermark,
+	// e.g. a time-series or audit store reading them by Txn.History, 0 or 1 keeps only the newest version
+	// no active txn can read past. versions newer than the watermark are always kept.
+	NumVersionsToKeep int
+	// optional filter of user-defined garbage collection, e.g. application-level ttl,
+	// an entry it does not keep is replaced with a tombstone, i.e. an implicit delete.
+	// it runs on already-version-collapsed candidates, only the newest version of a key
+	// no active txn can read past is passed, tombstones are not passed.
+	// it may run at any compaction, so it must be deterministic.
+	CompactionFilter func(key string, value []byte, version uint64) (keep bool)
+	// max bytes per second of sstables written by compaction of all keyspaces, 0 means unlimited
+	// it smooths latency spikes of foreground reads and writes, flush is not throttled.
+	CompactionRateLimitBytesPerSec int
+	// window of compaction driven by flush, e.g. CompactBetween for batch deployments compacting off-peak,
+	// or a check of the write rate, nil means always. compaction outside of it is deferred, L0 keeps growing
+	// past its target meanwhile. it is checked again every CompactionScheduleInterval, and levels of all keyspaces
+	// over their target are compacted inside it even if nothing is flushed. DB.CompactRange is never deferred.
+	CompactionSchedule CompactionSchedule
+	// interval of checking CompactionSchedule without flushes, default 1 minute
+	CompactionScheduleInterval time.Duration
+	// clock of CompactionSchedule, default time.Now, e.g. a fake clock of tests
+	Clock func() time.Time
+
+	// Txn Config
+	// max time View and Update wait for commits before their read ts to complete, no limit if <= 0
+	ReadTimeout time.Duration
+	// size of pending writes of a txn over which they are spilled to a temp file in TempDir,
+	// so a bulk load larger than memory can be staged and committed atomically.
+	// on commit the writes are streamed to wal and held by the memtable until it is flushed after the commit.
+	TxnSpillThreshold int
+	// record the keys written by each commit in a txn log read by DB.TransactionLog, e.g. for auditing or
+	// invalidating caches, at the cost of one more fsync per commit. records at or below the discard
+	// watermark are pruned with the versions compaction may discard. ignored by OpenReadOnly and OpenInMemory.
+	TxnLog bool
+	// window in which commits are held in a write buffer in front of wal and memtables, 0 means none,
+	// commits within the window are logged as one wal batch where writes of a key are coalesced to the latest one,
+	// e.g. for storms of updates of hot keys by concurrent writers. Commit returns once its batch is logged,
+	// so each commit waits up to the window, and a writer committing one txn after another coalesces nothing
+	// with itself. txns begun meanwhile read the snapshot before buffered commits without waiting for them.
+	// ignored by OpenReadOnly and OpenInMemory.
+	WriteBufferWindow time.Duration
+	// max bytes of writes held in the write buffer, it is drained once they reach it, default 1MB
+	WriteBufferBytes int
 
+	// Value Config
+	// optional hooks applied to values of all keyspaces, e.g. transparent encryption or checksums,
+	// MarshalValue on Set and UnmarshalValue on every read, keys and tombstones are never passed.
+	// values are stored, passed to CompactionFilter and exported as returned by MarshalValue.
+	// MarshalValue must not modify value, UnmarshalValue is passed a copy it may decode in place.
+	// both must be set or neither, and a db must always be opened with the same hooks.
+	MarshalValue   func(value []byte) ([]byte, error)
+	UnmarshalValue func(data []byte) ([]byte, error)
+
+	// dir of scratch files of sstables being written by flush and compaction and of writes spilled by txns,
+	// default the db dir, e.g. a faster or larger volume. a scratch file is renamed into the db dir once complete,
+	// if TempDir is on another file system, which is warned by Open, sstables are written again into the db dir instead.
+	// scratch files left by a crash are not removed from TempDir.
+	TempDir string
+
+	// permission of dirs and files created by db, both are subject to umask of the process,
+	// temp files of spilled txns are always private to the owner
+	DirMode  os.FileMode
 	FileMode os.FileMode
+
+	// Metrics Config
+	// recorder of latency histograms and counters, default discard all
+	Metrics metrics.Recorder
+
+	// Logger Config
+	// logger of the db, e.g. with a prefix to tell dbs of a process apart,
+	// default the global logger of logger.GetLogger at Open
+	Logger logger.Logger
+
+	// Event Config
+	// optional callbacks after a flush writes a table of L0 and after a compaction replaces inputs with outputs,
+	// e.g. for monitoring or backups, of sstables of all keyspaces, TableInfo.Family tells the column family of them.
+	// a compaction which leaves nothing of a table has no outputs. they are called one at a time and in order,
+	// by the goroutine which flushed or compacted once it releases engine locks, and the flush or compaction waits
+	// for them, so they must return quickly and must not call back into the db synchronously, e.g. Sync,
+	// CompactRange or Close.
+	OnFlush      func(level int, table TableInfo)
+	OnCompaction func(inputs, outputs []TableInfo)
+
+	// Debug Config
+	// verify entries before they are flushed to L0 at the cost of a pass over them,
+	// i.e. the ts of each internal key equals its Entry.Version, a mismatch fails the flush with ErrVersionMismatch,
+	// and verify levels after each compaction like DB.Verify.
+	DebugChecks bool
 }
 
+// MemtableIndex in-memory index of a memtable, *skiplist.SkipList and *sortedslice.SortedSlice implement it
+type MemtableIndex = types.MemtableIndex
+
+// CompressionLevel trade cpu for compression ratio of sstable blocks,
+// tables of any level are readable regardless of the level they were written with
+type CompressionLevel = utils.CompressionLevel
+
+const (
+	CompressionDefault = utils.CompressionDefault
+	CompressionBetter  = utils.CompressionBetter
+	CompressionBest    = utils.CompressionBest
+	CompressionNone    = utils.CompressionNone
+)
+
 var DefaultConfig = Config{
 	SkipListMaxLevel:       9,
 	SkipListP:              0.5,
 	MemtableByteThreshold:  4 * _mb,
 	ImmutableBuffer:        10,
 	DataBlockByteThreshold: 4 * _kb,
+	TargetFileSize:         8 * _mb,
+	BloomFilterP:           []float64{0.01},
 	L0TargetNum:            5,
+	L1TargetBytes:          64 * _mb,
 	LevelRatio:             10,
-	FileMode:               0755,
+	TxnSpillThreshold:      64 * _mb,
+	WriteBufferBytes:       _mb,
+	DirMode:                0755,
+	FileMode:               0644,
+	Metrics:                metrics.Nop,
 }
 
 func (c *Config) validate() error {
@@ -59,20 +260,85 @@ func (c *Config) validate() error {
 	if c.SkipListP <= 0 {
 		c.SkipListP = DefaultConfig.SkipListP
 	}
+	if c.MemtableIndex == nil {
+		maxLevel, p := c.SkipListMaxLevel, c.SkipListP
+		c.MemtableIndex = func() MemtableIndex {
+			return skiplist.New(maxLevel, p)
+		}
+	}
 	if c.MemtableByteThreshold <= 0 {
 		c.MemtableByteThreshold = DefaultConfig.MemtableByteThreshold
 	}
+	if c.WriteSlowdownImmutables < 0 {
+		c.WriteSlowdownImmutables = 0
+	}
+	if c.WriteStallImmutables < 0 {
+		c.WriteStallImmutables = 0
+	}
 	if c.DataBlockByteThreshold <= 0 {
 		c.DataBlockByteThreshold = DefaultConfig.DataBlockByteThreshold
 	}
+	// normalize a copy, the slice of caller is left as it is
+	c.LevelDataBlockByteThreshold = slices.Clone(c.LevelDataBlockByteThreshold)
+	for i, size := range c.LevelDataBlockByteThreshold {
+		if size <= 0 {
+			c.LevelDataBlockByteThreshold[i] = c.DataBlockByteThreshold
+		}
+	}
+	if c.TargetFileSize <= 0 {
+		c.TargetFileSize = DefaultConfig.TargetFileSize
+	}
+	if len(c.BloomFilterP) == 0 {
+		c.BloomFilterP = DefaultConfig.BloomFilterP
+	}
 	if c.L0TargetNum <= 0 {
 		c.L0TargetNum = DefaultConfig.L0TargetNum
 	}
+	if c.L1TargetBytes <= 0 {
+		c.L1TargetBytes = DefaultConfig.L1TargetBytes
+	}
 	if c.LevelRatio <= 0 {
 		c.LevelRatio = DefaultConfig.LevelRatio
 	}
+	if c.MaxLevels < 0 {
+		c.MaxLevels = 0
+	}
+	// L0 is never the bottom level
+	if c.MaxLevels == 1 {
+		c.MaxLevels = 2
+	}
+	if c.NumVersionsToKeep < 1 {
+		c.NumVersionsToKeep = 1
+	}
+	if c.CompactionRateLimitBytesPerSec < 0 {
+		c.CompactionRateLimitBytesPerSec = 0
+	}
+	if c.TxnSpillThreshold <= 0 {
+		c.TxnSpillThreshold = DefaultConfig.TxnSpillThreshold
+	}
+	if c.CompactionScheduleInterval <= 0 {
+		c.CompactionScheduleInterval = time.Minute
+	}
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	if c.WriteBufferBytes <= 0 {
+		c.WriteBufferBytes = DefaultConfig.WriteBufferBytes
+	}
+	if c.DirMode <= 0 {
+		c.DirMode = DefaultConfig.DirMode
+	}
 	if c.FileMode <= 0 {
 		c.FileMode = DefaultConfig.FileMode
 	}
+	if (c.MarshalValue == nil) != (c.UnmarshalValue == nil) {
+		return ErrValueHooks
+	}
+	if c.Metrics == nil {
+		c.Metrics = DefaultConfig.Metrics
+	}
+	if c.Logger == nil {
+		c.Logger = logger.GetLogger()
+	}
 	return nil
 }
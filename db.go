@@ -15,19 +15,58 @@
 package originium
 
 import (
+	"bytes"
 	"container/list"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/rand/v2"
 	"os"
+	"path"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/pkg/ratelimit"
+	"github.com/B1NARY-GR0UP/originium/pkg/watermark"
 	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
+)
+
+const (
+	_discardFile    = "DISCARD"
+	_filterSeedFile = "FILTER_SEED"
+
+	// delay of a commit while immutables reach WriteSlowdownImmutables
+	_writeSlowdownDelay = time.Millisecond
+	// max number of keys sampled by KeyStats
+	_keyStatsSamples = 1024
+	// backoff of retrying a failed flush, doubled after each failure up to _flushMaxRetryDelay
+	_flushRetryDelay    = 10 * time.Millisecond
+	_flushMaxRetryDelay = 500 * time.Millisecond
+	// failures of a flush in a row after which writes are rejected until it succeeds
+	_flushFailuresToDegrade = 3
 )
 
 var (
-	ErrMkDir    = errors.New("failed to create db dir")
-	ErrDBClosed = errors.New("db closed")
+	ErrMkDir                = errors.New("failed to create db dir")
+	ErrDBClosed             = errors.New("db closed")
+	ErrReadOnlyDB           = errors.New("db is read-only")
+	ErrNotReaderAt          = errors.New("sstable file does not support random access")
+	ErrCorruptedDiscardFile = errors.New("discard file is corrupted")
+	ErrCorruptedSeedFile    = errors.New("filter seed file is corrupted")
+	ErrInvalidLevel         = errors.New("level must not be negative")
+	ErrTableNotFound        = errors.New("sstable not found")
+	ErrValueHooks           = errors.New("MarshalValue and UnmarshalValue must be set together")
+	// ErrDegraded is returned by writes while flush keeps failing, e.g. the disk is full,
+	// reads are still served, writes are accepted again once a retry of flush succeeds
+	ErrDegraded = errors.New("db is degraded by failing flush")
 )
 
 type DB struct {
@@ -37,20 +76,93 @@ type DB struct {
 	logger logger.Logger
 	dir    string
 	state  uint32
+	// opened by OpenReadOnly, no wal, flush or compaction
+	readOnly bool
+	// opened by OpenInMemory, no file is read or written
+	inMemory bool
+	// opened by Repair, damaged files are skipped instead of failing Open
+	repair bool
+	// opened by OpenForImport, ImportEntry may write entries at their own versions
+	importing bool
+
+	// default keyspace
+	keyspace
+	flushC chan flushTask
+	// sync signals of Sync, closed by flush loop after flushC is drained
+	syncC chan chan struct{}
+
+	// column families, protected by mu
+	cfs map[string]*CF
+
+	oracle *oracle
+	// held while callbacks of Config.OnFlush and Config.OnCompaction run, see levelManager.notify
+	eventsMu sync.Mutex
+	// discard watermark persisted in discard file, protected by discardMu
+	discardMu sync.Mutex
+	discardTs uint64
+	// log of keys written by each commit, nil unless Config.TxnLog
+	txnLog *txnLog
+	// coalescing buffer of commits, nil unless Config.WriteBufferWindow
+	writeBuffer *writeBuffer
+	// seed of bloom filters of all keyspaces, random per db, so keys colliding in filters are not predictable
+	filterSeed uint32
+	// limiter of compaction writes shared by all keyspaces, nil if unlimited
+	compactionLimiter *ratelimit.Limiter
+	// number of flushes and compactions in progress, reported by IsBusy
+	flushing   atomic.Int32
+	compacting atomic.Int32
+	// error of the flush failing in a row, nil if healthy, writes are rejected while it is set
+	flushErr      atomic.Pointer[error]
+	flushFailures atomic.Uint64
+	// error of the last compaction if it failed, nil once one succeeds
+	compactErr atomic.Pointer[error]
+	// last error of flush or compaction since Open, reported by Health
+	lastErr atomic.Pointer[error]
 
+	// set by the first Close
+	closing atomic.Bool
+	closed  chan struct{}
+	closeC  chan struct{}
+}
+
+// keyspace memtable, immutables and sstables of a set of keys
+type keyspace struct {
 	memtable   *memtable
 	immutables *list.List
-	flushC     chan *memtable
+	manager    *levelManager
+}
 
-	manager *levelManager
-	oracle  *oracle
+// immutable memtable of a keyspace to be flushed
+type immutable struct {
+	ks *keyspace
+	mt *memtable
+}
 
-	closed chan struct{}
-	closeC chan struct{}
+type flushTask struct {
+	// immutables rotated together, writes of all of them are logged to the wal of the default one
+	imts []immutable
 }
 
 type State uint32
 
+// HealthState whether flush and compaction of db keep up, see DB.Health
+type HealthState uint32
+
+const (
+	_ HealthState = iota
+	HealthOK
+	// flush or compaction is failing, writes fail with ErrDegraded while flush is failing
+	HealthDegraded
+	HealthClosed
+)
+
+// HealthStatus result of DB.Health
+type HealthStatus struct {
+	State HealthState
+	// last error of flush or compaction since Open, kept after they recover
+	LastError error
+}
+
 const (
 	_ State = iota
 	StateInitialize
@@ -59,70 +171,264 @@ const (
 )
 
 func Open(dir string, config Config) (*DB, error) {
+	return open(dir, config, false)
+}
+
+// Repair open a db whose files are damaged, e.g. by a crash or a bad disk, keeping as much data as possible.
+// there is no manifest, the level layout is rebuilt from names of sstables that can be decoded,
+// the others are moved to the quarantine dir. entries of a wal before its first corrupted record are
+// replayed and the rest of it is dropped, a damaged drop file is quarantined and damaged filter seed
+// and discard files are replaced. the repaired db is a normal db, which can be reopened by Open after Close.
+// sstables written in an unsupported format version are not damaged, they fail Repair like Open.
+func Repair(dir string, config Config) (*DB, error) {
+	return open(dir, config, true)
+}
+
+func open(dir string, config Config, repair bool) (*DB, error) {
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
 
-	if err := os.MkdirAll(dir, config.FileMode); err != nil {
+	if err := os.MkdirAll(dir, config.DirMode); err != nil {
 		return nil, ErrMkDir
 	}
 
+	// pending writes of txns not committed before crash
+	if err := removeSpills(dir); err != nil {
+		return nil, err
+	}
+	if err := checkTempDir(dir, config); err != nil {
+		return nil, err
+	}
+
 	db := &DB{
-		config:     config,
-		dir:        dir,
-		logger:     logger.GetLogger(),
-		immutables: list.New(),
-		oracle:     newOracle(),
-		flushC:     make(chan *memtable, config.ImmutableBuffer),
-		closeC:     make(chan struct{}),
-		closed:     make(chan struct{}),
+		config: config,
+		dir:    dir,
+		logger: config.Logger,
+		repair: repair,
+		keyspace: keyspace{
+			immutables: list.New(),
+		},
+		cfs:    make(map[string]*CF),
+		oracle: newOracle(),
+		flushC: make(chan flushTask, config.ImmutableBuffer),
+		syncC:  make(chan chan struct{}),
+		closeC: make(chan struct{}),
+		closed: make(chan struct{}),
+
+		compactionLimiter: ratelimit.New(config.CompactionRateLimitBytesPerSec),
+		writeBuffer:       newWriteBuffer(config),
 	}
 
 	atomic.StoreUint32(&db.state, uint32(StateInitialize))
 
-	// recover from exist wal
-	mt := newMemtable(dir, config.SkipListMaxLevel, config.SkipListP)
-	walMaxVersion := mt.recover()
+	// recover filter seed before filters are built
+	filterSeed, err := db.recoverFilterSeed()
+	if err != nil {
+		return nil, err
+	}
+	db.filterSeed = filterSeed
+
+	// writes of all keyspaces are logged to the wal of the default memtable
+	db.memtable = newMemtable(dir, config.FileMode, config.MemtableIndex(), config.Logger)
 
 	// recover from exist data file
-	lm := newLevelManager(db)
-	dbMaxVersion := lm.recover()
+	db.manager = newLevelManager(db, dir)
+	dbMaxVersion, err := db.manager.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	// recover column families, before wal which has entries of them
+	cfMaxVersion, err := db.recoverCFs()
+	if err != nil {
+		return nil, err
+	}
+
+	// recover from exist wal
+	walMaxVersion, err := db.memtable.recover(repair, func(family string) (*memtable, bool) {
+		cf, ok := db.cfs[family]
+		if !ok {
+			return nil, false
+		}
+		return cf.memtable, true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// recover discard watermark
+	discardTs, err := db.recoverDiscardTs()
+	if err != nil {
+		return nil, err
+	}
+
+	// recover oracle, the watermark is a ts allocated before, e.g. of a drop pruned by pruneDrops
+	if err = db.oracle.recover(max(walMaxVersion, dbMaxVersion, cfMaxVersion, discardTs)); err != nil {
+		return nil, err
+	}
+	db.discardTs = discardTs
+	db.oracle.discardTs.Store(discardTs)
+
+	if config.TxnLog {
+		if db.txnLog, err = openTxnLog(dir, config.FileMode, db.repair, db.logger); err != nil {
+			return nil, err
+		}
+	}
+
+	go db.run()
+	return db, nil
+}
+
+// OpenReadOnly open a db over the sstables in the root of fsys, e.g. an embed.FS or os.DirFS
+// nothing is written to fsys, update txns fail with ErrReadOnlyDB.
+// files of fsys must implement io.ReaderAt, column families and wal files are not loaded.
+func OpenReadOnly(fsys fs.FS, config Config) (*DB, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		config:   config,
+		logger:   config.Logger,
+		readOnly: true,
+		keyspace: keyspace{
+			memtable: &memtable{
+				logger:   config.Logger,
+				index:    config.MemtableIndex(),
+				readOnly: true,
+			},
+			immutables: list.New(),
+		},
+		cfs:    make(map[string]*CF),
+		oracle: newOracle(),
+		// filters are built on recover, any seed works
+		filterSeed: rand.Uint32(),
+	}
 
-	db.memtable = mt
+	// recover from exist data file
+	lm := newLevelManager(db, "")
+	lm.fsys = fsys
+	maxVersion, err := lm.recover()
+	if err != nil {
+		return nil, err
+	}
 	db.manager = lm
 
 	// recover oracle
-	maxTs := uint64(max(walMaxVersion, dbMaxVersion))
-	db.oracle.readMark.Done(maxTs)
-	db.oracle.commitMark.Done(maxTs)
-	db.oracle.nextTs = maxTs + 1
+	if err := db.oracle.recover(maxVersion); err != nil {
+		return nil, err
+	}
 
-	go db.run()
+	atomic.StoreUint32(&db.state, uint32(StateOpened))
 	return db, nil
 }
 
-func (db *DB) Close() {
-	defer atomic.StoreUint32(&db.state, uint32(StateClosed))
-	db.closeC <- struct{}{}
+// OpenInMemory open a memory-only db, e.g. a test double or an ephemeral cache
+// there is no wal, flush or compaction, all data is kept in the memtable and lost on Close,
+// MemtableByteThreshold and TxnSpillThreshold are ignored.
+func OpenInMemory(config Config) (*DB, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
 
-	mt := db.memtable
-	mt.freeze()
-	if mt.size() > 0 {
-		db.flushImmutable(mt)
-	} else {
-		if err := mt.wal.Delete(); err != nil {
-			db.logger.Warnf("failed to delete immutable wal file: %v", err)
+	db := &DB{
+		config:   config,
+		logger:   config.Logger,
+		inMemory: true,
+		keyspace: keyspace{
+			memtable:   newMemoryMemtable(config.MemtableIndex(), config.Logger),
+			immutables: list.New(),
+		},
+		cfs:    make(map[string]*CF),
+		oracle: newOracle(),
+	}
+	db.manager = newLevelManager(db, "")
+
+	// nothing to recover, start from ts 1
+	if err := db.oracle.recover(0); err != nil {
+		return nil, err
+	}
+
+	atomic.StoreUint32(&db.state, uint32(StateOpened))
+	return db, nil
+}
+
+// Close flush memtables and stop the db
+// txns begin or commit after Close fail with ErrDBClosed.
+// txns begun before Close keep reading their snapshot, memtables stay readable after they are flushed.
+func (db *DB) Close() {
+	if db.readOnly || db.inMemory {
+		if atomic.SwapUint32(&db.state, uint32(StateClosed)) != uint32(StateClosed) {
+			db.oracle.Stop()
 		}
+		return
 	}
 
+	if !db.closing.CompareAndSwap(false, true) {
+		return
+	}
+	// immutables queued before are flushed first, so L0 keeps newer sstables after older ones,
+	// a failing flush is not retried any more, its wal is replayed by the next Open.
+	// closeC is closed before writeLock is taken, a commit blocked on a full flushC under writeLock
+	// gives up sending once the flush loop stops.
+	close(db.closeC)
+
+	// no txn commits to memtables once they are frozen, buffered commits are written before
+	db.oracle.writeLock.Lock()
+	db.drainLocked()
+	atomic.StoreUint32(&db.state, uint32(StateClosed))
+	db.oracle.writeLock.Unlock()
+
+	// txns beginning after stop fail instead of waiting forever
+	defer db.oracle.Stop()
+
 	<-db.closed
+
+	// no commit is logged after the db is closed, and compaction pruning the log is stopped
+	if db.txnLog != nil {
+		if err := db.txnLog.close(); err != nil {
+			db.logger.Errorf("failed to close txn log: %v", err)
+		}
+	}
+
+	var imts []immutable
+	for _, ks := range db.keyspaces() {
+		ks.memtable.freeze()
+		imts = append(imts, immutable{ks: ks, mt: ks.memtable})
+	}
+	// wals are replayed from old to new by the next Open, memtables must not be flushed before
+	// immutables left by a failing flush, or L0 would hold newer sstables before older ones.
+	// immutables of all keyspaces are rotated with the default one, so it has the most of them.
+	if db.immutables.Len() > 0 {
+		return
+	}
+	// wal is kept and replayed by the next Open if flush fails
+	if err := db.flushImmutables(imts); err != nil {
+		db.logger.Errorf("failed to flush memtables on close: %v", err)
+	}
+}
+
+// keyspaces return the default keyspace and keyspaces of all column families
+func (db *DB) keyspaces() []*keyspace {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keyspaces := []*keyspace{&db.keyspace}
+	for _, cf := range db.cfs {
+		keyspaces = append(keyspaces, &cf.keyspace)
+	}
+	return keyspaces
 }
 
 func (db *DB) View(fn TxnFunc) error {
 	if db.State() == StateClosed {
 		return ErrDBClosed
 	}
-	txn := db.Begin(false)
+	txn, err := db.begin(false)
+	if err != nil {
+		return err
+	}
 	defer txn.Discard()
 
 	return fn(txn)
@@ -132,28 +438,378 @@ func (db *DB) Update(fn TxnFunc) error {
 	if db.State() == StateClosed {
 		return ErrDBClosed
 	}
-	txn := db.Begin(true)
+	if db.readOnly {
+		return ErrReadOnlyDB
+	}
+	txn, err := db.begin(true)
+	if err != nil {
+		return err
+	}
 	defer txn.Discard()
 
-	if err := fn(txn); err != nil {
+	if err = fn(txn); err != nil {
 		return err
 	}
 
 	return txn.Commit()
 }
 
+// GetStale get the newest value of key without waiting for in-progress commits, trading
+// linearizability for latency, e.g. for caches and dashboards.
+// it never returns a value of a failed or later commit, but unlike a txn it may see some writes
+// of a commit in progress and not others, and miss writes of a commit in progress with a smaller ts,
+// so two calls may return values which no snapshot contains together.
+// like reads of Txn, the value returned is a copy owned by the caller.
+func (db *DB) GetStale(key string) ([]byte, bool) {
+	switch {
+	case db.State() == StateClosed:
+		db.logger.Errorf(ErrDBClosed.Error())
+		return nil, false
+	case key == "":
+		db.logger.Errorf(ErrEmptyKey.Error())
+		return nil, false
+	}
+
+	// versions visible at readTs must be kept by compaction until the read is done
+	readTs := db.oracle.staleReadTs()
+	defer db.oracle.readMark.Done(readTs)
+
+	val, ok := db.search(types.KeyWithTs(key, readTs))
+	if !ok {
+		return nil, false
+	}
+	val, err := db.unmarshalValue(val)
+	if err != nil {
+		db.logger.Errorf("failed to unmarshal value of %s: %v", key, err)
+		return nil, false
+	}
+	return val, true
+}
+
+// marshalValue encode value to be stored with MarshalValue of config
+func (db *DB) marshalValue(value []byte) ([]byte, error) {
+	if db.config.MarshalValue == nil {
+		return value, nil
+	}
+	return db.config.MarshalValue(value)
+}
+
+// unmarshalValue decode a stored or pending value with UnmarshalValue of config,
+// the value returned is a copy owned by the caller
+func (db *DB) unmarshalValue(data []byte) ([]byte, error) {
+	if db.config.UnmarshalValue == nil {
+		return bytes.Clone(data), nil
+	}
+	return db.config.UnmarshalValue(bytes.Clone(data))
+}
+
+// Begin begin a txn, the txn is discarded if db is closed, use BeginWithContext to get the error
 func (db *DB) Begin(update bool) *Txn {
+	// fails only if db is closed without deadline
+	txn, err := db.BeginWithContext(context.Background(), update)
+	if err != nil {
+		db.logger.Errorf(err.Error())
+		return &Txn{
+			readOnly:  !update || db.readOnly,
+			discarded: true,
+			doneRead:  true,
+			db:        db,
+		}
+	}
+	return txn
+}
+
+// BeginWithContext same as Begin, but fail with the error of ctx if it is done
+// before all commits the txn must see are complete, e.g. a committer is stuck.
+// it fails with ErrDBClosed if db is closed.
+func (db *DB) BeginWithContext(ctx context.Context, update bool) (*Txn, error) {
+	return db.beginWithOptions(ctx, TxnOptions{Update: update})
+}
+
+// BeginWithOptions begin a txn with opts, see IsolationLevel for the guarantees of update txns
+// it fails with ErrDBClosed if db is closed.
+func (db *DB) BeginWithOptions(opts TxnOptions) (*Txn, error) {
+	return db.beginWithOptions(context.Background(), opts)
+}
+
+func (db *DB) beginWithOptions(ctx context.Context, opts TxnOptions) (*Txn, error) {
+	if db.State() == StateClosed {
+		return nil, ErrDBClosed
+	}
+	update := opts.Update
+	readOnly := !update || db.readOnly
+	readTs, err := db.oracle.readTs(ctx, !readOnly && opts.Isolation == Serializable)
+	if errors.Is(err, watermark.ErrStopped) {
+		return nil, ErrDBClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	txn := &Txn{
-		readTs:   db.oracle.readTs(),
-		readOnly: !update,
-		db:       db,
+		readTs:    readTs,
+		readOnly:  readOnly,
+		isolation: opts.Isolation,
+		db:        db,
 	}
 
 	if update {
 		txn.pendingWrites = make(map[types.Key]types.Entry)
 		txn.writesFp = make(map[uint64]struct{})
+		txn.cfWrites = make(map[*CF]map[types.Key]types.Entry)
 	}
-	return txn
+	return txn, nil
+}
+
+// ViewAt run fn in a read-only txn reading the snapshot at ts instead of the latest one,
+// e.g. to check entries imported at their versions. ts must not be after the latest commit,
+// and versions at ts must be kept by compaction, which discards those older than any active txn,
+// ErrFutureTs or ErrTsTooOld is returned otherwise.
+func (db *DB) ViewAt(ts uint64, fn TxnFunc) error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+
+	ctx := context.Background()
+	if db.config.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.config.ReadTimeout)
+		defer cancel()
+	}
+	err := db.oracle.readAt(ctx, ts)
+	if errors.Is(err, watermark.ErrStopped) {
+		return ErrDBClosed
+	}
+	if err != nil {
+		return err
+	}
+
+	txn := &Txn{
+		readTs:   ts,
+		readOnly: true,
+		db:       db,
+	}
+	defer txn.Discard()
+
+	return fn(txn)
+}
+
+// begin begin a txn for View and Update, which waits at most ReadTimeout
+func (db *DB) begin(update bool) (*Txn, error) {
+	ctx := context.Background()
+	if db.config.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.config.ReadTimeout)
+		defer cancel()
+	}
+	return db.BeginWithContext(ctx, update)
+}
+
+// Stats statistics of sstables of all keyspaces, counters are accumulated since Open
+type Stats struct {
+	// number of sstables
+	Tables int
+	// memory of bloom filters, the bitset takes a byte per bit
+	FilterBytes int
+	// false positive rate of bloom filters estimated from their fill, weighted by filter size
+	FilterFPRate float64
+
+	// encoded size of entries flushed from memtables or ingested
+	UserBytes uint64
+	// bytes of sstables written by flush, compaction and ingest, in total and of each level
+	WriteBytes      uint64
+	LevelWriteBytes []uint64
+	// WriteBytes per byte of UserBytes
+	WriteAmplification float64
+
+	// lookups and scans of sstables, each key of a batch lookup counts as one
+	Reads uint64
+	// data blocks read by lookups and scans, in total and of each level, compaction is excluded
+	BlockReads      uint64
+	LevelBlockReads []uint64
+	// BlockReads per read
+	ReadAmplification float64
+
+	// failed attempts of flush, see Health for whether it keeps failing
+	FlushFailures uint64
+}
+
+// TableInfo information of a sstable
+type TableInfo struct {
+	Level int
+	// file of sstable is named level-idx.db
+	Idx int
+	// smallest and largest user key in sstable
+	Smallest string
+	Largest  string
+	// number of entries, including all versions and tombstones
+	Entries uint64
+	// size of sstable file in bytes
+	Size uint64
+	// time the sstable was written
+	Created time.Time
+	// memory of bloom filters of sstable, including the prefix one, the bitset takes a byte per bit
+	FilterBytes int
+	// column family of sstable, empty for the default keyspace
+	Family string
+}
+
+// Stats return statistics of sstables of the db and all column families
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	managers := []*levelManager{db.manager}
+	for _, cf := range db.cfs {
+		managers = append(managers, cf.manager)
+	}
+	db.mu.RUnlock()
+
+	var stats Stats
+	var weightedFPRate float64
+	for _, lm := range managers {
+		tables, bits, rate := lm.filterStats()
+		stats.Tables += tables
+		stats.FilterBytes += bits
+		weightedFPRate += rate
+	}
+	if stats.FilterBytes > 0 {
+		stats.FilterFPRate = weightedFPRate / float64(stats.FilterBytes)
+	}
+
+	for _, lm := range managers {
+		lm.amp.addTo(&stats)
+	}
+	if stats.UserBytes > 0 {
+		stats.WriteAmplification = float64(stats.WriteBytes) / float64(stats.UserBytes)
+	}
+	if stats.Reads > 0 {
+		stats.ReadAmplification = float64(stats.BlockReads) / float64(stats.Reads)
+	}
+	stats.FlushFailures = db.flushFailures.Load()
+	return stats
+}
+
+// TableInfos return information of all sstables of the default keyspace, ordered by level,
+// tables of L0 are ordered from newest to oldest and tables of other levels by key range.
+func (db *DB) TableInfos() []TableInfo {
+	return db.manager.tableInfos()
+}
+
+// KeyStats statistics of keys sampled by DB.KeyStats
+type KeyStats struct {
+	// number of keys sampled
+	Samples int
+	// average length of keys
+	AvgKeyLen float64
+	// average longest common prefix of adjacent keys, the bytes prefix compression of sstables saves per key
+	AvgLCP float64
+	// share of key bytes saved by prefix compression, AvgLCP per AvgKeyLen
+	CompressionRatio float64
+}
+
+// KeyStats sample keys of the memtable and immutables of the default keyspace,
+// to judge how effective prefix compression is, e.g. long random prefixes defeat it.
+// keys are internal keys (key@ts) as stored in sstables, sstables are not read, so only recent writes are sampled.
+// memtables are walked in key order from the newest one, and the walk stops once _keyStatsSamples keys are sampled.
+func (db *DB) KeyStats() KeyStats {
+	db.mu.RLock()
+	mts := []*memtable{db.memtable}
+	for e := db.immutables.Back(); e != nil; e = e.Prev() {
+		mts = append(mts, e.Value.(*memtable))
+	}
+	db.mu.RUnlock()
+
+	var stats KeyStats
+	var keyLen, lcp int
+	for _, mt := range mts {
+		// each key is sampled with the key before it in the same memtable
+		var prev string
+		for key := ""; stats.Samples < _keyStatsSamples; key = nextKey(key) {
+			entry, ok := mt.lowerBound(key)
+			if !ok {
+				break
+			}
+			key = entry.Key
+			stats.Samples++
+			keyLen += len(key)
+			lcp += utils.LCP(key, prev)
+			prev = key
+		}
+	}
+	if stats.Samples == 0 {
+		return stats
+	}
+	stats.AvgKeyLen = float64(keyLen) / float64(stats.Samples)
+	stats.AvgLCP = float64(lcp) / float64(stats.Samples)
+	if keyLen > 0 {
+		stats.CompressionRatio = float64(lcp) / float64(keyLen)
+	}
+	return stats
+}
+
+// nextKey return the smallest internal key after key in the order of types.CompareKeys,
+// so the lower bound of it is the entry next to key
+func nextKey(key types.Key) types.Key {
+	if ts := types.ParseTs(key); ts > 0 {
+		// older versions of the same key follow
+		return types.KeyWithTs(types.ParseKey(key), ts-1)
+	}
+	return types.KeyWithTs(types.ParseKey(key)+"\x00", math.MaxUint64)
+}
+
+// Verify check invariants of sstables of all keyspaces without reading them, e.g. after a crash or an upgrade,
+// ErrOverlappingTables is returned if two sstables of a level >= 1 overlap under Leveled compaction.
+// set Config.DebugChecks to verify after each compaction.
+func (db *DB) Verify() error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+	for _, ks := range db.keyspaces() {
+		if err := ks.manager.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateLevel return raw entries of all sstables at level of the default keyspace in key order,
+// for inspection of the physical state, e.g. verifying compaction results.
+// keys are internal keys (key@ts), all versions and tombstones are kept and memtables are not included,
+// a level without sstables returns no entry.
+func (db *DB) IterateLevel(level int) ([]types.Entry, error) {
+	if db.State() == StateClosed {
+		return nil, ErrDBClosed
+	}
+	if level < 0 {
+		return nil, ErrInvalidLevel
+	}
+	return db.manager.level(level), nil
+}
+
+// RewriteTable rewrite sstable level-idx of the default keyspace alone, without merging its neighbors,
+// versions no txn can read and tombstones shadowing no older version are dropped as by compaction.
+// it is cheaper than compacting the level to reclaim space of an sstable known to be mostly garbage.
+// the sstable is removed if nothing is left, ErrTableNotFound is returned if it does not exist.
+// an sstable of an older format, e.g. of the v0.2.1 release, is rewritten in the current one even if nothing is dropped.
+func (db *DB) RewriteTable(level, idx int) error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnlyDB
+	}
+	if level < 0 {
+		return ErrInvalidLevel
+	}
+
+	db.compacting.Add(1)
+	defer db.compacting.Add(-1)
+	return db.manager.rewriteTable(level, idx)
+}
+
+// IsBusy report whether an immutable memtable is being flushed or sstables are being compacted
+// it is a point-in-time view, heavy operations like backups may be deferred until both are false.
+func (db *DB) IsBusy() (flushing bool, compacting bool) {
+	return db.flushing.Load() > 0, db.compacting.Load() > 0
 }
 
 func (db *DB) State() State {
@@ -161,13 +817,115 @@ func (db *DB) State() State {
 }
 
 func (db *DB) search(key types.Key) ([]byte, bool) {
+	return db.searchKeyspace(&db.keyspace, key)
+}
+
+func (db *DB) searchKeyspace(ks *keyspace, key types.Key) ([]byte, bool) {
+	entry, ok := db.searchEntry(ks, key)
+	if !ok {
+		return nil, false
+	}
+	return types.Value(entry)
+}
+
+// searchEntry return the newest entry of key visible at ts of key, the ts of entry key is kept
+func (db *DB) searchEntry(ks *keyspace, key types.Key) (types.Entry, bool) {
+	defer db.observe(metrics.GetLatency, time.Now())
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	readTs := types.ParseTs(key)
+
+	// search memtable, the newest version found stops the search even if it is a tombstone,
+	// which shadows older versions of immutables and sstables
+	mtEntry, ok := ks.memtable.lowerBound(key)
+	if ok && types.IsSameKey(key, mtEntry.Key) {
+		return ks.visible(mtEntry, readTs), true
+	}
+
+	// search immutables
+	for e := ks.immutables.Back(); e != nil; e = e.Prev() {
+		imt := e.Value.(*memtable)
+		imtEntry, ok := imt.lowerBound(key)
+		if ok && types.IsSameKey(key, imtEntry.Key) {
+			return ks.visible(imtEntry, readTs), true
+		}
+	}
+
+	// search sstables
+	sstEntry, ok := ks.manager.searchLowerBound(key)
+	if ok && types.IsSameKey(key, sstEntry.Key) {
+		return ks.visible(sstEntry, readTs), true
+	}
+
+	return types.Entry{}, false
+}
+
+// seekKeyspace return the smallest base key of entries >= key in ks, tombstones and versions of any ts included
+func (db *DB) seekKeyspace(ks *keyspace, key types.Key) (types.Key, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	res, found := ks.manager.seek(key)
+	memtables := []*memtable{ks.memtable}
+	for e := ks.immutables.Back(); e != nil; e = e.Prev() {
+		memtables = append(memtables, e.Value.(*memtable))
+	}
+	for _, mt := range memtables {
+		entry, ok := mt.lowerBound(key)
+		if base := types.ParseKey(entry.Key); ok && (!found || base < res) {
+			res, found = base, true
+		}
+	}
+	return res, found
+}
+
+// scanAll return the newest version visible at readTs of all keys, tombstones included
+func (db *DB) scanAll(readTs uint64) []types.Entry {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := db.memtable.all()
+	for e := db.immutables.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, e.Value.(*memtable).all()...)
+	}
+	entries = append(entries, db.manager.all()...)
+
+	return db.newestVisible(entries, readTs)
+}
+
+// newestVisible return the newest version visible at readTs of each key in entries in key order
+func (db *DB) newestVisible(entries []types.Entry, readTs uint64) []types.Entry {
+	slices.SortFunc(entries, func(a, b types.Entry) int {
+		return types.CompareKeys(a.Key, b.Key)
+	})
+
+	var res []types.Entry
+	for _, entry := range entries {
+		if types.ParseTs(entry.Key) > readTs {
+			continue
+		}
+		// versions of a key are ordered new -> old, keep the first visible one
+		if len(res) > 0 && types.IsSameKey(res[len(res)-1].Key, entry.Key) {
+			continue
+		}
+		res = append(res, db.visible(entry, readTs))
+	}
+	return res
+}
+
+// exists report whether a live version of key exists
+func (db *DB) exists(key types.Key) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	readTs := types.ParseTs(key)
+
 	// search memtable
 	mtEntry, ok := db.memtable.lowerBound(key)
 	if ok && types.IsSameKey(key, mtEntry.Key) {
-		return types.Value(mtEntry)
+		return !db.visible(mtEntry, readTs).Tombstone
 	}
 
 	// search immutables
@@ -175,61 +933,533 @@ func (db *DB) search(key types.Key) ([]byte, bool) {
 		imt := e.Value.(*memtable)
 		imtEntry, ok := imt.lowerBound(key)
 		if ok && types.IsSameKey(key, imtEntry.Key) {
-			return types.Value(imtEntry)
+			return !db.visible(imtEntry, readTs).Tombstone
 		}
 	}
 
 	// search sstables
-	sstEntry, ok := db.manager.searchLowerBound(key)
-	if ok && types.IsSameKey(key, sstEntry.Key) {
-		return types.Value(sstEntry)
+	return db.manager.searchExists(key)
+}
+
+// multiSearch search keys sorted by types.CompareKeys, result is in the same order as keys
+func (db *DB) multiSearch(keys []types.Key) ([][]byte, []bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+
+	// keys not in memtable and immutables
+	var rest []types.Key
+	var restIdx []int
+	for i, key := range keys {
+		// search memtable
+		if entry, ok := db.memtable.lowerBound(key); ok && types.IsSameKey(key, entry.Key) {
+			values[i], found[i] = types.Value(db.visible(entry, types.ParseTs(key)))
+			continue
+		}
+		// search immutables
+		var hit bool
+		for e := db.immutables.Back(); e != nil; e = e.Prev() {
+			imt := e.Value.(*memtable)
+			if entry, ok := imt.lowerBound(key); ok && types.IsSameKey(key, entry.Key) {
+				values[i], found[i] = types.Value(db.visible(entry, types.ParseTs(key)))
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			rest = append(rest, key)
+			restIdx = append(restIdx, i)
+		}
 	}
 
-	return nil, false
+	// search sstables
+	entries, oks := db.manager.multiSearchLowerBound(rest)
+	for j, i := range restIdx {
+		if oks[j] {
+			values[i], found[i] = types.Value(db.visible(entries[j], types.ParseTs(keys[i])))
+		}
+	}
+	return values, found
 }
 
 func (db *DB) rawset(entry types.Entry) {
+	defer db.observe(metrics.SetLatency, time.Now())
+
 	db.memtable.set(entry)
+	db.rotateIfFull()
+}
+
+// rawsetBatch write entries of a commit at commitTs to keyspaces of column families, nil is the default one,
+// they are logged to wal as one batch, partial if more batches of the commit follow, see wal.WAL.WriteBatch
+func (db *DB) rawsetBatch(writes map[*CF][]types.Entry, commitTs uint64, partial bool) {
+	defer db.observe(metrics.SetLatency, time.Now())
+
+	// memtables are rotated together under writeLock, the wal of the default one logs writes of all of them
+	if l := db.memtable.wal; l != nil {
+		families := make(map[string][]types.Entry, len(writes))
+		for cf, entries := range writes {
+			families[cf.family()] = entries
+		}
+		if err := l.WriteBatch(families, commitTs, partial); err != nil {
+			db.logger.Panicf("write wal failed: %v", err)
+		}
+	}
+	for cf, entries := range writes {
+		db.keyspaceOf(cf).memtable.apply(entries)
+	}
+}
+
+// rotateIfFull rotate memtables once any of them reaches MemtableByteThreshold, e.g. after a commit
+func (db *DB) rotateIfFull() {
+	// memtable of memory-only db is never flushed
+	if db.inMemory {
+		return
+	}
+	for _, ks := range db.keyspaces() {
+		if ks.memtable.size() >= db.config.MemtableByteThreshold {
+			db.rotate()
+			return
+		}
+	}
+}
+
+// rotate turn memtables of all keyspaces into immutable memtables to be flushed together, and create new ones
+// the default memtable is always rotated, its wal logs writes of all keyspaces and is deleted once all are flushed.
+func (db *DB) rotate() {
+	var task flushTask
+
+	// readers search immutables with mu, imts must be in them before they may be flushed
+	db.mu.Lock()
+	keyspaces := []*keyspace{&db.keyspace}
+	for _, cf := range db.cfs {
+		keyspaces = append(keyspaces, &cf.keyspace)
+	}
+	for _, ks := range keyspaces {
+		if ks != &db.keyspace && ks.memtable.size() == 0 {
+			continue
+		}
+		ks.memtable.freeze()
+		imt := ks.memtable
+		ks.immutables.PushBack(imt)
+		ks.memtable = imt.reset()
+		task.imts = append(task.imts, immutable{ks: ks, mt: imt})
+	}
+	db.mu.Unlock()
+
+	select {
+	case db.flushC <- task:
+	case <-db.closed:
+		// the flush loop has stopped, imts are kept in immutables and their wal is replayed by the next Open
+	}
+}
+
+// Sync block until all committed data is flushed to sstables and no flush or compaction is in progress
+// memtables of all keyspaces are turned into immutable memtables, then the flush loop drains them.
+func (db *DB) Sync() error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnlyDB
+	}
+	// nothing to flush
+	if db.inMemory {
+		return nil
+	}
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	// no txn commits to memtables during rotation
+	db.oracle.writeLock.Lock()
+	if db.State() == StateClosed {
+		db.oracle.writeLock.Unlock()
+		return ErrDBClosed
+	}
+	db.drainLocked()
+	for _, ks := range db.keyspaces() {
+		if ks.memtable.size() > 0 {
+			db.rotate()
+			break
+		}
+	}
+	db.oracle.writeLock.Unlock()
+
+	// flush tasks are sent before the sync signal, they are done when it is closed
+	done := make(chan struct{})
+	select {
+	case db.syncC <- done:
+		<-done
+		return nil
+	case <-db.closed:
+		// closed before the flush loop received the signal, memtables are flushed by Close instead
+		return ErrDBClosed
+	}
+}
 
-	if db.memtable.size() >= db.config.MemtableByteThreshold {
-		db.memtable.freeze()
-		imt := db.memtable
+// flushImmutables flush immutable memtables rotated together to L0 of their keyspaces, then delete their wal,
+// a retry after the wal failed to be deleted does not flush them again
+func (db *DB) flushImmutables(imts []immutable) error {
+	defer db.observe(metrics.FlushDuration, time.Now())
 
-		db.flushC <- imt
-		db.immutables.PushBack(imt)
+	for _, imt := range imts {
+		if imt.mt.flushed || imt.mt.size() == 0 {
+			continue
+		}
+		if err := imt.ks.manager.flushToL0(imt.mt.all()); err != nil {
+			return err
+		}
+		imt.mt.flushed = true
+	}
+	// delete wal file, only the default memtable has one
+	for _, imt := range imts {
+		if imt.mt.wal == nil {
+			continue
+		}
+		if err := imt.mt.wal.Delete(); err != nil {
+			return fmt.Errorf("failed to delete immutable wal file: %w", err)
+		}
+	}
+	return nil
+}
 
-		db.memtable = db.memtable.reset()
+// Health report whether flush and compaction in the background keep up, unlike State which only tracks
+// the lifecycle, e.g. for monitoring to detect a db silently failing to flush.
+func (db *DB) Health() HealthStatus {
+	var status HealthStatus
+	if err := db.lastErr.Load(); err != nil {
+		status.LastError = *err
+	}
+	switch {
+	case db.State() == StateClosed:
+		status.State = HealthClosed
+	case db.flushErr.Load() != nil || db.compactErr.Load() != nil:
+		status.State = HealthDegraded
+	default:
+		status.State = HealthOK
 	}
+	return status
 }
 
-func (db *DB) flushImmutable(imt *memtable) {
-	// flush immutable memtable to L0
-	if err := db.manager.flushToL0(imt.all()); err != nil {
-		db.logger.Panicf("failed to flush immutable memtable: %v", err)
+// writable return nil if db accepts writes, or an error wrapping ErrDegraded and the error of
+// the flush failing in a row, e.g. no space left on device, writes fail with it until flush recovers.
+func (db *DB) writable() error {
+	if err := db.flushErr.Load(); err != nil {
+		return fmt.Errorf("%w: %w", ErrDegraded, *err)
 	}
-	// delete wal file
-	if err := imt.wal.Delete(); err != nil {
-		db.logger.Panicf("failed to delete immutable wal file: %v", err)
+	return nil
+}
+
+// compactFailed record err of compaction, nil if it succeeded
+func (db *DB) compactFailed(err error) {
+	if err == nil {
+		db.compactErr.Store(nil)
+		return
+	}
+	db.logger.Errorf("failed to compact: %v", err)
+	db.compactErr.Store(&err)
+	db.lastErr.Store(&err)
+}
+
+// triggerCompaction compact sstables of all keyspaces synchronously
+// it is a hook for tests, which can not wait for the compaction driven by flush in the background.
+func (db *DB) triggerCompaction() {
+	db.compacting.Add(1)
+	defer db.compacting.Add(-1)
+
+	err := db.manager.compactAll()
+
+	db.mu.RLock()
+	for _, cf := range db.cfs {
+		err = errors.Join(err, cf.manager.compactAll())
+	}
+	db.mu.RUnlock()
+	db.compactFailed(err)
+
+	db.persistDiscardTs()
+	db.pruneDrops()
+}
+
+// CompactRange compact sstables of all keyspaces holding user keys in [start, end] into the deepest level holding them,
+// e.g. after a bulk delete or out of CompactionSchedule. tables of L0 overlap each other, they are compacted as a whole.
+// it runs regardless of CompactionSchedule, and stops at the first failed compaction like the one driven by flush.
+func (db *DB) CompactRange(start, end string) error {
+	switch {
+	case db.State() == StateClosed:
+		return ErrDBClosed
+	case db.readOnly:
+		return ErrReadOnlyDB
+	case db.inMemory:
+		return nil
+	}
+
+	db.compacting.Add(1)
+	var err error
+	for _, ks := range db.keyspaces() {
+		if err = ks.manager.compactRange(start, end); err != nil {
+			break
+		}
+	}
+	db.compactFailed(err)
+	db.compacting.Add(-1)
+	db.persistDiscardTs()
+	db.pruneDrops()
+	return err
+}
+
+// persistDiscardTs record how far compaction may have discarded old versions in discard file
+// it is restored by Open, so compaction after restart discards as far as before restart.
+func (db *DB) persistDiscardTs() {
+	if db.inMemory {
+		return
+	}
+	db.discardMu.Lock()
+	defer db.discardMu.Unlock()
+
+	ts := db.oracle.discardAtOrBelow()
+	if ts <= db.discardTs {
+		return
+	}
+	if err := writeFileSync(path.Join(db.dir, _discardFile), binary.BigEndian.AppendUint64(nil, ts), db.config.FileMode); err != nil {
+		db.logger.Errorf("failed to persist discard watermark: %v", err)
+		return
+	}
+	db.discardTs = ts
+
+	// records at or below the watermark are pruned once it is persisted, so the log never misses
+	// records TransactionLog may return after restart
+	if db.txnLog != nil {
+		if err := db.txnLog.prune(ts); err != nil {
+			db.logger.Errorf("failed to prune txn log: %v", err)
+		}
+	}
+}
+
+func (db *DB) recoverDiscardTs() (uint64, error) {
+	data, err := os.ReadFile(path.Join(db.dir, _discardFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		if !db.repair {
+			return 0, ErrCorruptedDiscardFile
+		}
+		// compaction discards nothing below the watermark until it is persisted again
+		db.logger.Warnf("discard file is corrupted, remove it")
+		return 0, os.Remove(path.Join(db.dir, _discardFile))
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// recoverFilterSeed read filter seed from seed file, a random one is generated and persisted if absent
+// filters are rebuilt from data blocks on recover, the seed is persisted so they are the same across restarts.
+func (db *DB) recoverFilterSeed() (uint32, error) {
+	name := path.Join(db.dir, _filterSeedFile)
+	data, err := os.ReadFile(name)
+	if err == nil && len(data) != 4 && db.repair {
+		// filters are rebuilt on recover, any seed works
+		db.logger.Warnf("filter seed file is corrupted, generate a new seed")
+		err = fs.ErrNotExist
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		seed := rand.Uint32()
+		if err = writeFileSync(name, binary.BigEndian.AppendUint32(nil, seed), db.config.FileMode); err != nil {
+			return 0, err
+		}
+		return seed, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, ErrCorruptedSeedFile
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// checkTempDir create TempDir of config, and warn if a scratch file in it can not be renamed into db dir,
+// e.g. it is on another file system, so every sstable is written twice
+func checkTempDir(dir string, config Config) error {
+	if config.TempDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(config.TempDir, config.DirMode); err != nil {
+		return ErrMkDir
+	}
+
+	fd, err := os.CreateTemp(config.TempDir, "probe-*"+_tmpExt)
+	if err != nil {
+		return err
+	}
+	probe := fd.Name()
+	if err = fd.Close(); err != nil {
+		return err
+	}
+
+	// removed by recover if it is left by a crash
+	target := path.Join(dir, path.Base(probe))
+	if err = os.Rename(probe, target); err != nil {
+		config.Logger.Warnf("temp dir %s is not usable for atomic rename into %s, sstables are written twice: %v", config.TempDir, dir, err)
+		return os.Remove(probe)
+	}
+	return os.Remove(target)
+}
+
+func (db *DB) observe(name string, start time.Time) {
+	db.config.Metrics.Observe(name, time.Since(start))
+}
+
+// pendingFlushes max number of immutables waiting for flush of a keyspace
+func (db *DB) pendingFlushes() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	n := db.immutables.Len()
+	for _, cf := range db.cfs {
+		n = max(n, cf.immutables.Len())
+	}
+	return n
+}
+
+// slowdown delay a commit while flush falls behind
+func (db *DB) slowdown() {
+	if db.config.WriteSlowdownImmutables > 0 && db.pendingFlushes() >= db.config.WriteSlowdownImmutables {
+		db.config.Metrics.Add(metrics.WriteSlowdowns, 1)
+		time.Sleep(_writeSlowdownDelay)
+	}
+}
+
+// stalled report whether a commit should be rejected until flush catches up
+func (db *DB) stalled() bool {
+	if db.config.WriteStallImmutables > 0 && db.pendingFlushes() >= db.config.WriteStallImmutables {
+		db.config.Metrics.Add(metrics.WriteStalls, 1)
+		return true
+	}
+	return false
+}
+
+// flush immutable memtables of task, then compact their keyspaces
+// a failed flush is retried with backoff, db is degraded after _flushFailuresToDegrade failures in a row
+// until a retry succeeds. it returns false if db is closed before that, the wal of task is kept for recovery.
+func (db *DB) flush(task flushTask) bool {
+	db.flushing.Add(1)
+	delay := _flushRetryDelay
+	for failures := 1; ; failures++ {
+		err := db.flushImmutables(task.imts)
+		if err == nil {
+			break
+		}
+		db.flushFailures.Add(1)
+		db.lastErr.Store(&err)
+		db.logger.Errorf("failed to flush immutable memtable, attempt %d: %v", failures, err)
+		if failures >= _flushFailuresToDegrade {
+			db.flushErr.Store(&err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-db.closeC:
+			db.flushing.Add(-1)
+			return false
+		}
+		delay = min(delay*2, _flushMaxRetryDelay)
+	}
+	db.flushErr.Store(nil)
+	db.flushing.Add(-1)
+
+	// a failed compaction is retried after the next flush, it does not block writes
+	// compaction outside of CompactionSchedule is deferred, levels over their target are compacted by
+	// a later flush or by compactScheduled once it is inside the window.
+	if db.scheduled() {
+		keyspaces := make([]*keyspace, 0, len(task.imts))
+		for _, imt := range task.imts {
+			keyspaces = append(keyspaces, imt.ks)
+		}
+		db.compactKeyspaces(keyspaces)
+	}
+
+	db.mu.Lock()
+	for _, imt := range task.imts {
+		for e := imt.ks.immutables.Front(); e != nil; e = e.Next() {
+			if e.Value.(*memtable) == imt.mt {
+				imt.ks.immutables.Remove(e)
+				break
+			}
+		}
+	}
+	db.mu.Unlock()
+	return true
+}
+
+// scheduled report whether compaction driven by flush may run now, see Config.CompactionSchedule
+func (db *DB) scheduled() bool {
+	schedule := db.config.CompactionSchedule
+	return schedule == nil || schedule(db.config.Clock())
+}
+
+// compactKeyspaces compact levels of keyspaces over their target
+func (db *DB) compactKeyspaces(keyspaces []*keyspace) {
+	db.compacting.Add(1)
+	var err error
+	for _, ks := range keyspaces {
+		err = errors.Join(err, ks.manager.checkAndCompact())
+	}
+	db.compactFailed(err)
+	db.compacting.Add(-1)
+	db.persistDiscardTs()
+	db.pruneDrops()
+}
+
+// compactScheduled compact levels of all keyspaces over their target once inside CompactionSchedule,
+// compaction deferred outside of it would otherwise wait for a flush, which may never come off-peak
+func (db *DB) compactScheduled() {
+	if db.scheduled() {
+		db.compactKeyspaces(db.keyspaces())
 	}
 }
 
 func (db *DB) run() {
-	atomic.StoreUint32(&db.state, uint32(StateOpened))
+	// a Close right after Open is not overwritten
+	atomic.CompareAndSwapUint32(&db.state, uint32(StateInitialize), uint32(StateOpened))
+
+	// nil without CompactionSchedule, compaction is never deferred then
+	var scheduleC <-chan time.Time
+	if db.config.CompactionSchedule != nil {
+		ticker := time.NewTicker(db.config.CompactionScheduleInterval)
+		defer ticker.Stop()
+		scheduleC = ticker.C
+	}
+
 	var closed bool
 LOOP:
 	for {
 		select {
-		case imt := <-db.flushC:
-			db.flushImmutable(imt)
-			db.manager.checkAndCompact()
-
-			db.mu.Lock()
-			db.immutables.Remove(db.immutables.Back())
-			db.mu.Unlock()
+		case <-scheduleC:
+			if !closed {
+				db.compactScheduled()
+			}
+		case task := <-db.flushC:
+			// wals of immutables not flushed are kept for recovery
+			if !db.flush(task) {
+				break LOOP
+			}
 
 			if closed && len(db.flushC) == 0 {
 				break LOOP
 			}
+		case done := <-db.syncC:
+			// drain flush tasks sent before sync
+			for len(db.flushC) > 0 {
+				if !db.flush(<-db.flushC) {
+					break
+				}
+			}
+			close(done)
 		case <-db.closeC:
 			closed = true
 			if len(db.flushC) > 0 {
@@ -15,9 +15,32 @@
 package originium
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/table"
+	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -60,6 +83,126 @@ func TestClose(t *testing.T) {
 	assert.Equal(t, StateClosed, db.State())
 }
 
+func TestCloseInFlightTxn(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	set := func(round int) {
+		for i := range 100 {
+			err := db.Update(func(txn *Txn) error {
+				return txn.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d-%d", round, i)))
+			})
+			assert.NoError(t, err)
+		}
+	}
+
+	set(0)
+	reader := db.Begin(false)
+	writer := db.Begin(true)
+	assert.NoError(t, writer.Set("key000", []byte("uncommitted")))
+	// rotate memtables several times, so immutables are still queued when Close begins
+	set(1)
+
+	// concurrent readers of the snapshot during Close
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 500 {
+				key := fmt.Sprintf("key%03d", j%100)
+				value, ok := reader.Get(key)
+				assert.True(t, ok)
+				assert.Equal(t, []byte(fmt.Sprintf("value0-%d", j%100)), value)
+			}
+		}()
+	}
+	db.Close()
+	wg.Wait()
+
+	// snapshot is still readable after Close
+	for i := range 100 {
+		value, ok := reader.Get(fmt.Sprintf("key%03d", i))
+		assert.True(t, ok)
+		assert.Equal(t, []byte(fmt.Sprintf("value0-%d", i)), value)
+	}
+	reader.Discard()
+
+	// write txn begun before Close is rejected
+	assert.ErrorIs(t, writer.Commit(), ErrDBClosed)
+	assert.ErrorIs(t, db.Sync(), ErrDBClosed)
+
+	// closing twice is a no-op
+	db.Close()
+
+	// newest values win after restart
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		for i := range 100 {
+			value, ok := txn.Get(fmt.Sprintf("key%03d", i))
+			assert.True(t, ok)
+			assert.Equal(t, []byte(fmt.Sprintf("value1-%d", i)), value)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCloseConcurrentBegin(t *testing.T) {
+	db := setupTestDB(t)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				err := db.Update(func(txn *Txn) error {
+					return txn.Set(fmt.Sprintf("key%d-%d", i, j), []byte("value"))
+				})
+				if err != nil {
+					assert.ErrorIs(t, err, ErrDBClosed)
+					return
+				}
+				err = db.View(func(txn *Txn) error {
+					txn.Get(fmt.Sprintf("key%d-%d", i, j))
+					return nil
+				})
+				if err != nil {
+					assert.ErrorIs(t, err, ErrDBClosed)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	db.Close()
+	wg.Wait()
+
+	_, err := db.BeginWithContext(context.Background(), false)
+	assert.ErrorIs(t, err, ErrDBClosed)
+	assert.ErrorIs(t, db.View(func(txn *Txn) error { return nil }), ErrDBClosed)
+
+	// txn begun after close can not be used
+	txn := db.Begin(true)
+	assert.ErrorIs(t, txn.Set("key", []byte("value")), ErrDiscardedTxn)
+	assert.ErrorIs(t, txn.Commit(), ErrDiscardedTxn)
+	txn.Discard()
+}
+
 func TestSetAndGet(t *testing.T) {
 	dir := t.TempDir()
 	config := Config{
@@ -95,6 +238,118 @@ func TestSetAndGet(t *testing.T) {
 	}
 }
 
+func TestHeadKey(t *testing.T) {
+	db := setupTestDB(t)
+	dir, config := db.dir, db.config
+
+	// "HEAD" was the key of the skiplist sentinel, it is an ordinary user key
+	keys := []string{"HEAD", "HEAD@1", "HEADER", "A", "Z"}
+	err := db.Update(func(txn *Txn) error {
+		for _, key := range keys {
+			if err := txn.Set(key, []byte("value of "+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	check := func(db *DB) {
+		err := db.View(func(txn *Txn) error {
+			for _, key := range keys {
+				value, ok := txn.Get(key)
+				assert.True(t, ok, key)
+				assert.Equal(t, []byte("value of "+key), value)
+			}
+			kvs, _ := txn.ScanLimit("", "\xff", 0)
+			assert.Equal(t, []types.KV{
+				{K: "A", V: []byte("value of A")},
+				{K: "HEAD", V: []byte("value of HEAD")},
+				{K: "HEAD@1", V: []byte("value of HEAD@1")},
+				{K: "HEADER", V: []byte("value of HEADER")},
+				{K: "Z", V: []byte("value of Z")},
+			}, kvs)
+			key, _, ok := txn.Seek("B")
+			assert.True(t, ok)
+			assert.Equal(t, "HEAD", key)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check(db)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("HEAD")
+	})
+	assert.NoError(t, err)
+	keys = keys[1:]
+
+	// same from sstables
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		_, ok := txn.Get("HEAD")
+		assert.False(t, ok)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("HEAD", []byte("value of HEAD"))
+	})
+	assert.NoError(t, err)
+	keys = append(keys, "HEAD")
+	check(db)
+}
+
+func TestKeysWithAt(t *testing.T) {
+	db := setupTestDB(t)
+	dir, config := db.dir, db.config
+
+	// '@' anywhere in user keys, including trailing "@digits" like an internal key
+	keys := []string{"a@b.com", "user@5", "user", "user@", "@", "@@1", "x@18446744073709551615", "plain"}
+	write := func(suffix string) {
+		err := db.Update(func(txn *Txn) error {
+			for _, key := range keys {
+				if err := txn.Set(key, []byte(key+suffix)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check := func(db *DB, suffix string) {
+		err := db.View(func(txn *Txn) error {
+			for _, key := range keys {
+				val, found := txn.Get(key)
+				assert.True(t, found, key)
+				assert.Equal(t, []byte(key+suffix), val, key)
+			}
+			kvs, _ := txn.ScanLimit("user", "user~", 0)
+			assert.Len(t, kvs, 3)
+			for _, kv := range kvs {
+				assert.True(t, kv.K == "user" || kv.K == "user@" || kv.K == "user@5", kv.K)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	write("-1")
+	write("-2")
+	check(db, "-2")
+
+	// same after flush and compaction
+	db.Close()
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	db.triggerCompaction()
+	check(db, "-2")
+}
+
 func TestDelete(t *testing.T) {
 	dir := t.TempDir()
 	config := Config{
@@ -134,3 +389,2612 @@ func TestDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDeleteShadowsSSTable(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("key1", []byte("value1"))
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	assert.Len(t, db.TableInfos(), 1)
+
+	// the tombstone is only in the memtable
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("key1")
+	})
+	assert.NoError(t, err)
+
+	reads := db.Stats().Reads
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.Get("key1")
+		assert.False(t, found)
+
+		_, found, err := txn.GetWithTombstone("key1")
+		assert.False(t, found)
+		assert.ErrorIs(t, err, ErrKeyDeleted)
+
+		exists, err := txn.Exists("key1")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		_, founds := txn.MultiGet([]string{"key1"})
+		assert.False(t, founds[0])
+		return nil
+	})
+	assert.NoError(t, err)
+	_, found := db.GetStale("key1")
+	assert.False(t, found)
+	// the search stops at the memtable, no sstable is searched
+	assert.Equal(t, reads, db.Stats().Reads)
+}
+
+func TestRecreateOverImmutableTombstone(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+
+	update := func(fn TxnFunc) {
+		assert.NoError(t, db.Update(fn))
+	}
+	// rotate the memtable into immutables like rotate, but keep it out of the flush loop
+	hold := func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.memtable.freeze()
+		db.immutables.PushBack(db.memtable)
+		db.memtable = db.memtable.reset()
+	}
+
+	// live in sstable
+	update(func(txn *Txn) error {
+		return errors.Join(txn.Set("key1", []byte("value1")), txn.Set("key2", []byte("value2")))
+	})
+	assert.NoError(t, db.Sync())
+	// deleted in immutable
+	update(func(txn *Txn) error {
+		return txn.DeleteMulti([]string{"key1", "key2"})
+	})
+	hold()
+	assert.Equal(t, 1, db.immutables.Len())
+	assert.Equal(t, 0, db.memtable.size())
+	_, found := db.GetStale("key1")
+	assert.False(t, found)
+	// re-created in memtable
+	update(func(txn *Txn) error {
+		return txn.Set("key1", []byte("value1-new"))
+	})
+
+	check := func() {
+		err := db.View(func(txn *Txn) error {
+			value, found := txn.Get("key1")
+			assert.True(t, found)
+			assert.Equal(t, []byte("value1-new"), value)
+			_, found = txn.Get("key2")
+			assert.False(t, found)
+
+			_, _, err := txn.GetWithTombstone("key2")
+			assert.ErrorIs(t, err, ErrKeyDeleted)
+			exists, err := txn.Exists("key1")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+			exists, err = txn.Exists("key2")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			values, founds := txn.MultiGet([]string{"key1", "key2"})
+			assert.Equal(t, []bool{true, false}, founds)
+			assert.Equal(t, []byte("value1-new"), values[0])
+
+			kvs, _ := txn.ScanLimit("key", "key~", 0)
+			assert.Equal(t, []types.KV{{K: "key1", V: []byte("value1-new")}}, kvs)
+			return nil
+		})
+		assert.NoError(t, err)
+		value, found := db.GetStale("key1")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value1-new"), value)
+	}
+	check()
+
+	// wals of the immutable and the memtable are replayed in order
+	db.Close()
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	check()
+}
+
+func TestMetrics(t *testing.T) {
+	dir := t.TempDir()
+	recorder := metrics.NewMemory()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  5,
+		ImmutableBuffer:        10,
+		Metrics:                recorder,
+	}
+
+	db, err := Open(dir, config)
+	defer db.Close()
+	assert.NoError(t, err)
+
+	for _, key := range []string{"key1", "key3"} {
+		err = db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("value"))
+		})
+		assert.NoError(t, err)
+	}
+	// wait for immutables to be flushed
+	time.Sleep(time.Second * 1)
+
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.Get("key1")
+		assert.True(t, found)
+		_, found = txn.Get("key2")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	snapshot := recorder.Snapshot()
+	assert.Equal(t, uint64(2), snapshot[metrics.SetLatency+"_count"])
+	assert.Equal(t, uint64(2), snapshot[metrics.GetLatency+"_count"])
+	assert.True(t, snapshot[metrics.FlushDuration+"_count"] > 0)
+	assert.True(t, snapshot[metrics.BlockReads] > 0)
+	assert.True(t, snapshot[metrics.BloomTrueNegatives]+snapshot[metrics.BloomFalsePositives] > 0)
+}
+
+// busyRecorder record IsBusy of db while a flush or compaction is reporting its metrics
+type busyRecorder struct {
+	metrics.Recorder
+	db atomic.Pointer[DB]
+
+	mu         sync.Mutex
+	flushing   []bool
+	compacting []bool
+}
+
+func (r *busyRecorder) Add(name string, delta uint64) {
+	if db := r.db.Load(); db != nil && name == metrics.CompactionBytes {
+		_, compacting := db.IsBusy()
+		r.mu.Lock()
+		r.compacting = append(r.compacting, compacting)
+		r.mu.Unlock()
+	}
+	r.Recorder.Add(name, delta)
+}
+
+func (r *busyRecorder) Observe(name string, d time.Duration) {
+	if db := r.db.Load(); db != nil && name == metrics.FlushDuration {
+		flushing, _ := db.IsBusy()
+		r.mu.Lock()
+		r.flushing = append(r.flushing, flushing)
+		r.mu.Unlock()
+	}
+	r.Recorder.Observe(name, d)
+}
+
+func TestIsBusy(t *testing.T) {
+	recorder := &busyRecorder{Recorder: metrics.Nop}
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            2,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  64 << 10,
+		Metrics:                recorder,
+	}
+	db, err := Open(t.TempDir(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+	recorder.db.Store(db)
+
+	flushing, compacting := db.IsBusy()
+	assert.False(t, flushing)
+	assert.False(t, compacting)
+
+	value := bytes.Repeat([]byte("v"), 1024)
+	for round := range 4 {
+		err = db.Update(func(txn *Txn) error {
+			for i := range 256 {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err, round)
+		assert.NoError(t, db.Sync())
+	}
+	db.triggerCompaction()
+
+	// both are reported while in progress
+	recorder.mu.Lock()
+	assert.NotEmpty(t, recorder.flushing)
+	assert.NotContains(t, recorder.flushing, false)
+	assert.NotEmpty(t, recorder.compacting)
+	assert.NotContains(t, recorder.compacting, false)
+	recorder.mu.Unlock()
+
+	// and cleared once done
+	flushing, compacting = db.IsBusy()
+	assert.False(t, flushing)
+	assert.False(t, compacting)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	db := setupSSTableDB(t, 100, nil)
+	dir := db.dir
+	db.Close()
+
+	// serve sstables from memory
+	fsys := fstest.MapFS{}
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, file := range files {
+		if path.Ext(file.Name()) != _dbExt {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(dir, file.Name()))
+		assert.NoError(t, err)
+		fsys[file.Name()] = &fstest.MapFile{Data: data}
+	}
+	assert.NotEmpty(t, fsys)
+
+	db, err = OpenReadOnly(fsys, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, StateOpened, db.State())
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key0042")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value42"), val)
+
+		_, found = txn.Get("absent")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("key", []byte("value"))
+	})
+	assert.Equal(t, ErrReadOnlyDB, err)
+
+	txn := db.Begin(true)
+	assert.Equal(t, ErrReadOnlyTxn, txn.Set("key", []byte("value")))
+	txn.Discard()
+
+	_, err = db.CreateColumnFamily("users")
+	assert.Equal(t, ErrReadOnlyDB, err)
+}
+
+func TestOpenInMemory(t *testing.T) {
+	// relative paths would be resolved against the working dir
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	db, err := OpenInMemory(Config{
+		MemtableByteThreshold: 1024,
+		TxnSpillThreshold:     1024,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, StateOpened, db.State())
+
+	// far over memtable and spill thresholds
+	err = db.Update(func(txn *Txn) error {
+		for i := range 1000 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return txn.Delete("key0001")
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	assert.NoError(t, db.DropPrefix("key09"))
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+	err = db.Update(func(txn *Txn) error {
+		return txn.SetCF(users, "alice", []byte("admin"))
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key0042")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value42"), val)
+
+		_, found = txn.Get("key0001")
+		assert.False(t, found)
+		_, found = txn.Get("key0900")
+		assert.False(t, found)
+
+		kvs, _ := txn.ScanLimit("key0000", "key0010", 0)
+		assert.Len(t, kvs, 9)
+
+		val, found = txn.GetCF(users, "alice")
+		assert.True(t, found)
+		assert.Equal(t, []byte("admin"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// conflict detection works as usual
+	txn1 := db.Begin(true)
+	txn2 := db.Begin(true)
+	txn1.Get("key0002")
+	assert.NoError(t, txn1.Set("key0002", []byte("txn1")))
+	assert.NoError(t, txn2.Set("key0002", []byte("txn2")))
+	assert.NoError(t, txn2.Commit())
+	assert.Equal(t, ErrConflictTxn, txn1.Commit())
+
+	db.Close()
+	assert.Equal(t, StateClosed, db.State())
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	assert.Empty(t, db.manager.acquire())
+	db.manager.release()
+	assert.Equal(t, 0, db.immutables.Len())
+}
+
+func TestTriggerCompaction(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// overlapping updates are flushed into many L0 tables
+	for round := range 5 {
+		err = db.Update(func(txn *Txn) error {
+			for i := range 50 {
+				if err := txn.Set(fmt.Sprintf("key%04d", i+round*10), []byte(fmt.Sprintf("value%d", round))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	// tables of L0 reported by TableInfos, which reads the levels published by flush and compaction
+	l0 := func() int {
+		var n int
+		for _, info := range db.TableInfos() {
+			if info.Level == 0 {
+				n++
+			}
+		}
+		return n
+	}
+	assert.NoError(t, db.Sync())
+	assert.Greater(t, l0(), 1)
+
+	db.triggerCompaction()
+	assert.Equal(t, 0, l0())
+	assert.NoError(t, db.Verify())
+
+	err = db.View(func(txn *Txn) error {
+		for i := range 90 {
+			val, found := txn.Get(fmt.Sprintf("key%04d", i))
+			assert.True(t, found)
+			assert.Equal(t, []byte(fmt.Sprintf("value%d", min(i/10, 4))), val)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCompactionFilter(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+		// drop expired values
+		CompactionFilter: func(key string, value []byte, version uint64) bool {
+			return !bytes.HasPrefix(value, []byte("expired"))
+		},
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// newer versions of even keys are expired
+	err = db.Update(func(txn *Txn) error {
+		for i := 0; i < 100; i += 2 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte("expired")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// flush all versions to sstables, no txn is active after reopen
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), db.oracle.nextTs-1))
+
+	db.triggerCompaction()
+	assert.Equal(t, 0, db.manager.levels[0].Len())
+
+	err = db.View(func(txn *Txn) error {
+		for i := range 100 {
+			val, found := txn.Get(fmt.Sprintf("key%04d", i))
+			if i%2 == 0 {
+				// dropped entry is an implicit delete, older version does not come back
+				assert.False(t, found)
+				continue
+			}
+			assert.True(t, found)
+			assert.Equal(t, []byte("value"), val)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestNumVersionsToKeep(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		L0TargetNum:       100,
+		NumVersionsToKeep: 3,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	var versions []uint64
+	for i := range 10 {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set("key", []byte(fmt.Sprintf("value%d", i)))
+		}))
+		assert.NoError(t, db.View(func(txn *Txn) error {
+			_, version, _, err := txn.GetVersioned("key")
+			versions = append(versions, version)
+			return err
+		}))
+		// each version in its own sstable
+		assert.NoError(t, db.Sync())
+	}
+
+	// no txn is active after reopen
+	reopen := func() {
+		db.Close()
+		db, err = Open(dir, config)
+		assert.NoError(t, err)
+		assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), db.oracle.nextTs-1))
+	}
+	reopen()
+	db.triggerCompaction()
+	assert.Equal(t, 0, db.manager.levels[0].Len())
+
+	// exactly the 3 newest versions survive
+	err = db.View(func(txn *Txn) error {
+		history, err := txn.History("key")
+		assert.NoError(t, err)
+		assert.Len(t, history, 3)
+		for j, entry := range history {
+			i := 9 - j
+			assert.Equal(t, "key", entry.Key)
+			assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), entry.Value)
+			assert.Equal(t, versions[i], types.Ts(entry))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// older versions are still shadowed by a tombstone kept with them
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("key")
+	})
+	assert.NoError(t, err)
+	reopen()
+	defer db.Close()
+	db.triggerCompaction()
+
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.Get("key")
+		assert.False(t, found)
+		history, err := txn.History("key")
+		assert.NoError(t, err)
+		assert.Len(t, history, 3)
+		assert.True(t, history[0].Tombstone)
+		assert.Equal(t, []byte("value9"), history[1].Value)
+		assert.Equal(t, []byte("value8"), history[2].Value)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestDiscardWatermark(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	versions := func(db *DB, key string) int {
+		entries := db.manager.scan(types.KeyWithTs(key, math.MaxUint64), types.KeyWithTs(key+"\x00", math.MaxUint64))
+		return len(entries)
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	for i := range 3 {
+		err = db.Update(func(txn *Txn) error {
+			return txn.Set("key", []byte(fmt.Sprintf("value%d", i)))
+		})
+		assert.NoError(t, err)
+	}
+	db.Close()
+
+	// before restart, no txn is active, compaction may discard old versions at or below ts 3
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), 3))
+	assert.Equal(t, uint64(3), db.oracle.discardAtOrBelow())
+	db.persistDiscardTs()
+	assert.Equal(t, 3, versions(db, "key"))
+	db.Close()
+
+	// after restart, discard watermark is restored regardless of read marks
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, uint64(3), db.oracle.discardTs.Load())
+	assert.GreaterOrEqual(t, db.oracle.discardAtOrBelow(), uint64(3))
+
+	db.triggerCompaction()
+	assert.Equal(t, 1, versions(db, "key"))
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value2"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		for i := range 200 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return txn.SetCF(cf, "cfkey", []byte("cfvalue"))
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Sync())
+	assert.Equal(t, 0, len(db.flushC))
+	assert.Equal(t, 0, db.memtable.size())
+	assert.Equal(t, 0, db.immutables.Len())
+	assert.Equal(t, 0, cf.memtable.size())
+	assert.Equal(t, 0, cf.immutables.Len())
+
+	// all data is read from sstables
+	readTs := db.oracle.nextTs - 1
+	for i := range 200 {
+		key := types.KeyWithTs(fmt.Sprintf("key%04d", i), readTs)
+		entry, found := db.manager.searchLowerBound(key)
+		assert.True(t, found)
+		assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), entry.Value)
+	}
+	entry, found := cf.manager.searchLowerBound(types.KeyWithTs("cfkey", readTs))
+	assert.True(t, found)
+	assert.Equal(t, []byte("cfvalue"), entry.Value)
+
+	// sync without pending writes returns at once
+	assert.NoError(t, db.Sync())
+}
+
+func TestStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	assert.Equal(t, Stats{}, db.Stats())
+
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+	err = db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			key := fmt.Sprintf("key%04d", i)
+			if err := errors.Join(txn.Set(key, []byte("value")), txn.SetCF(cf, key, []byte("value"))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+
+	// sstables of both the default keyspace and cf
+	stats := db.Stats()
+	assert.GreaterOrEqual(t, stats.Tables, 2)
+	assert.Greater(t, stats.FilterBytes, 0)
+	assert.Greater(t, stats.FilterFPRate, 0.0)
+	assert.Less(t, stats.FilterFPRate, 0.05)
+}
+
+func TestKeyStats(t *testing.T) {
+	// keys stay in the memtable
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, KeyStats{}, db.KeyStats())
+
+	set := func(db *DB, keys []string) {
+		err := db.Update(func(txn *Txn) error {
+			for _, key := range keys {
+				if err := txn.Set(key, []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	// keys sharing a long prefix, e.g. tenant/0001/user/00042@1, are 24 bytes
+	var keys []string
+	for i := range 2000 {
+		keys = append(keys, fmt.Sprintf("tenant/0001/user/%05d", i))
+	}
+	set(db, keys)
+	stats := db.KeyStats()
+	// the walk stops once the sample is full
+	assert.Equal(t, _keyStatsSamples, stats.Samples)
+	assert.Equal(t, 24.0, stats.AvgKeyLen)
+	// the 17-byte prefix and 4 of 5 digits are shared with the key before, except by the first key
+	// and at every 10th, 100th and 1000th key
+	assert.InDelta(t, float64(1023*21-102-10-1)/1024, stats.AvgLCP, 1e-9)
+	assert.InDelta(t, stats.AvgLCP/24, stats.CompressionRatio, 1e-9)
+	assert.Greater(t, stats.CompressionRatio, 0.85)
+
+	// keys with random prefixes are barely compressed
+	random, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer random.Close()
+	keys = keys[:0]
+	for range 2000 {
+		prefix := make([]byte, 8)
+		_, _ = rand.Read(prefix)
+		keys = append(keys, fmt.Sprintf("%x/user", prefix))
+	}
+	set(random, keys)
+	stats = random.KeyStats()
+	assert.Equal(t, _keyStatsSamples, stats.Samples)
+	assert.Equal(t, 23.0, stats.AvgKeyLen)
+	assert.Less(t, stats.CompressionRatio, 0.15)
+
+	// versions of a key are walked new -> old before the next key
+	assert.Equal(t, "k@4", nextKey("k@5"))
+	assert.Equal(t, types.KeyWithTs("k\x00", math.MaxUint64), nextKey("k@0"))
+}
+
+func TestFlushFailure(t *testing.T) {
+	dir := t.TempDir()
+	// sstables are written through scratch files in tempDir, flush fails while it is missing
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	config := Config{
+		MemtableByteThreshold: 1024,
+		TempDir:               tempDir,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	set := func(from, to int) error {
+		return db.Update(func(txn *Txn) error {
+			for i := from; i < to; i++ {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	assert.NoError(t, os.RemoveAll(tempDir))
+	assert.NoError(t, set(0, 100))
+
+	// degraded instead of crashing
+	assert.Eventually(t, func() bool {
+		return db.Health().State == HealthDegraded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, db.Health().LastError, fs.ErrNotExist)
+	assert.ErrorIs(t, set(100, 101), ErrDegraded)
+	assert.ErrorIs(t, db.Sync(), ErrDegraded)
+	assert.GreaterOrEqual(t, db.Stats().FlushFailures, uint64(_flushFailuresToDegrade))
+	flushing, _ := db.IsBusy()
+	assert.True(t, flushing)
+
+	// reads are still served from immutables
+	value, ok := db.GetStale("key0042")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value42"), value)
+
+	// recovered once the error clears
+	assert.NoError(t, os.MkdirAll(tempDir, 0755))
+	assert.Eventually(t, func() bool {
+		return db.Health().State == HealthOK
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, set(100, 200))
+	assert.NoError(t, db.Sync())
+	assert.Greater(t, len(db.TableInfos()), 0)
+	err = db.View(func(txn *Txn) error {
+		for i := range 200 {
+			value, ok := txn.Get(fmt.Sprintf("key%04d", i))
+			assert.True(t, ok, i)
+			assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), value)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	// sstables are written through scratch files in tempDir, compaction fails while it is missing
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	db, err := Open(dir, Config{TempDir: tempDir})
+	assert.NoError(t, err)
+
+	assert.Equal(t, HealthStatus{State: HealthOK}, db.Health())
+
+	set := func(round int) error {
+		return db.Update(func(txn *Txn) error {
+			for i := range 100 {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", round))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	for round := range 2 {
+		assert.NoError(t, set(round))
+		assert.NoError(t, db.Sync())
+	}
+
+	// a failing compaction degrades db, but writes are still accepted
+	assert.NoError(t, os.RemoveAll(tempDir))
+	db.triggerCompaction()
+	health := db.Health()
+	assert.Equal(t, HealthDegraded, health.State)
+	assert.ErrorIs(t, health.LastError, fs.ErrNotExist)
+	assert.NoError(t, set(2))
+	// tables to be compacted are kept
+	assert.Len(t, db.TableInfos(), 2)
+	value, ok := db.GetStale("key0042")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value2"), value)
+
+	// healthy once compaction succeeds, the last error is kept
+	assert.NoError(t, os.MkdirAll(tempDir, 0755))
+	db.triggerCompaction()
+	health = db.Health()
+	assert.Equal(t, HealthOK, health.State)
+	assert.ErrorIs(t, health.LastError, fs.ErrNotExist)
+	assert.Len(t, db.TableInfos(), 1)
+
+	db.Close()
+	assert.Equal(t, HealthClosed, db.Health().State)
+}
+
+func TestCloseWhileFlushFails(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	config := Config{
+		MemtableByteThreshold: 1024,
+		TempDir:               tempDir,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.RemoveAll(tempDir))
+	err = db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// Close gives up the failing flush, wals are kept
+	db.Close()
+
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		for i := range 100 {
+			_, ok := txn.Get(fmt.Sprintf("key%04d", i))
+			assert.True(t, ok, i)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCloseWhileFlushQueueIsFull(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	config := Config{
+		MemtableByteThreshold: 1024,
+		ImmutableBuffer:       1,
+		TempDir:               tempDir,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	// each commit rotates the memtable, the flush loop keeps retrying the first one,
+	// so a later commit blocks on the full flush queue while holding the write lock
+	assert.NoError(t, os.RemoveAll(tempDir))
+	committed := make(chan error, 4)
+	go func() {
+		for round := range 4 {
+			committed <- db.Update(func(txn *Txn) error {
+				for i := range 50 {
+					if err := txn.Set(fmt.Sprintf("key%d-%04d", round, i), []byte("value")); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		db.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close deadlocked")
+	}
+
+	// commits accepted before Close are recovered from their wals
+	var accepted []int
+	for round := range 4 {
+		if err := <-committed; err == nil {
+			accepted = append(accepted, round)
+		}
+	}
+	assert.NotEmpty(t, accepted)
+
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		for _, round := range accepted {
+			for i := range 50 {
+				_, ok := txn.Get(fmt.Sprintf("key%d-%04d", round, i))
+				assert.True(t, ok, round, i)
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestStatsAmplification(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// random values, so sstables are about as large as the user data
+	const n = 2000
+	raw := make([]byte, 75)
+	for i := range n {
+		_, _ = rand.Read(raw)
+		err := db.Update(func(txn *Txn) error {
+			return txn.Set(fmt.Sprintf("key%04d", i), []byte(base64.StdEncoding.EncodeToString(raw)))
+		})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.Sync())
+
+	stats := db.Stats()
+	assert.Greater(t, stats.UserBytes, uint64(n*100))
+	assert.Equal(t, stats.WriteBytes, stats.LevelWriteBytes[0]+sum(stats.LevelWriteBytes[1:]))
+	// every entry is flushed once and compacted into deeper levels a few times
+	assert.Greater(t, stats.WriteBytes, stats.LevelWriteBytes[0])
+	assert.Greater(t, stats.WriteAmplification, 0.5)
+	assert.Less(t, stats.WriteAmplification, 10.0)
+	assert.Greater(t, len(stats.LevelWriteBytes), 1)
+	assert.Equal(t, uint64(0), stats.Reads)
+
+	// each lookup of a key in sstables reads about one data block, bloom filters skip the others
+	err := db.View(func(txn *Txn) error {
+		for i := range n {
+			_, ok := txn.Get(fmt.Sprintf("key%04d", i))
+			assert.True(t, ok)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	stats = db.Stats()
+	assert.GreaterOrEqual(t, stats.Reads, uint64(n))
+	assert.Equal(t, stats.BlockReads, sum(stats.LevelBlockReads))
+	assert.GreaterOrEqual(t, stats.ReadAmplification, 1.0)
+	assert.Less(t, stats.ReadAmplification, 2.0)
+}
+
+func TestPrefixBloomFilter(t *testing.T) {
+	for _, prefixLen := range []int{0, 5} {
+		// sstables share all other prefixes, a random seed may make "user3" a false positive of all of them
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(path.Join(dir, _filterSeedFile), binary.BigEndian.AppendUint32(nil, 1), 0600))
+		db, err := Open(dir, Config{
+			L0TargetNum:          100,
+			PrefixBloomFilterLen: prefixLen,
+		})
+		assert.NoError(t, err)
+
+		// each sstable spans all prefixes, but holds keys of only one user
+		for user := range 10 {
+			err = db.Update(func(txn *Txn) error {
+				for i := range 10 {
+					if err := errors.Join(
+						txn.Set(fmt.Sprintf("a%04d-%d", i, user), []byte("value")),
+						txn.Set(fmt.Sprintf("user%d:%04d", user, i), []byte("value")),
+						txn.Set(fmt.Sprintf("z%04d-%d", i, user), []byte("value")),
+					); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, db.Sync())
+		}
+		assert.Len(t, db.TableInfos(), 10)
+
+		before := db.Stats().BlockReads
+		err = db.View(func(txn *Txn) error {
+			kvs, _ := txn.ScanLimit("user3:", "user3;", 0)
+			assert.Len(t, kvs, 10)
+			assert.Equal(t, "user3:0000", kvs[0].K)
+			return nil
+		})
+		assert.NoError(t, err)
+		reads := db.Stats().BlockReads - before
+		if prefixLen == 0 {
+			// a data block of every sstable is read
+			assert.Equal(t, uint64(10), reads)
+		} else {
+			// sstables proven not to contain the prefix are skipped
+			assert.Equal(t, uint64(1), reads)
+		}
+
+		// a range of more than one prefix is not pruned
+		before = db.Stats().BlockReads
+		err = db.View(func(txn *Txn) error {
+			kvs, _ := txn.ScanLimit("user3:", "user5", 0)
+			assert.Len(t, kvs, 20)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(10), db.Stats().BlockReads-before)
+		db.Close()
+	}
+}
+
+func sum(s []uint64) uint64 {
+	var res uint64
+	for _, v := range s {
+		res += v
+	}
+	return res
+}
+
+func TestRecoverQuarantine(t *testing.T) {
+	db := setupSSTableDB(t, 100, nil)
+	dir := db.dir
+	db.Close()
+
+	garbage := make([]byte, 200)
+	_, _ = rand.Read(garbage)
+	// names that are not sstables
+	stray := map[string][]byte{
+		"notes.db": []byte("notes"),
+		"01-2.db":  []byte("not 1-2.db"),
+	}
+	damaged := map[string][]byte{
+		// garbage shorter than a footer
+		"0-100.db": []byte("garbage"),
+		// garbage without a valid footer
+		"1-100.db": garbage,
+	}
+	for name, data := range stray {
+		assert.NoError(t, os.WriteFile(path.Join(dir, name), data, 0600))
+	}
+	check := func(db *DB) {
+		err := db.View(func(txn *Txn) error {
+			for i := range 100 {
+				value, ok := txn.Get(fmt.Sprintf("key%04d", i))
+				assert.True(t, ok)
+				assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), value)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	check(db)
+	db.Close()
+
+	// stray files are moved instead of deleted
+	quarantined := func(files map[string][]byte) {
+		for name, data := range files {
+			_, err = os.Stat(path.Join(dir, name))
+			assert.True(t, os.IsNotExist(err))
+			got, err := os.ReadFile(path.Join(dir, _quarantineDir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, data, got)
+		}
+	}
+	quarantined(stray)
+
+	// a damaged sstable fails Open and is kept in place, Repair quarantines it
+	for name, data := range damaged {
+		assert.NoError(t, os.WriteFile(path.Join(dir, name), data, 0600))
+	}
+	_, err = Open(dir, Config{})
+	assert.Error(t, err)
+	for name := range damaged {
+		_, err = os.Stat(path.Join(dir, name))
+		assert.NoError(t, err)
+	}
+	db, err = Repair(dir, Config{})
+	assert.NoError(t, err)
+	check(db)
+	db.Close()
+	quarantined(damaged)
+
+	// quarantine dir is skipped by later recovers
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	db.Close()
+}
+
+func TestRecoverUnsupportedFormat(t *testing.T) {
+	db := setupSSTableDB(t, 100, nil)
+	dir := db.dir
+	db.Close()
+
+	tables, err := filepath.Glob(path.Join(dir, "*"+_dbExt))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tables)
+
+	// a format version never written by a release and the one of a future release
+	for _, version := range []uint64{3, uint64(FormatVersion()) + 1} {
+		// rewrite format version in meta block, after created unix and level
+		data, err := os.ReadFile(tables[0])
+		assert.NoError(t, err)
+		var footer table.Footer
+		assert.NoError(t, footer.Decode(data[len(data)-_footerSize:]))
+		binary.LittleEndian.PutUint64(data[footer.MetaBlock.Offset+16:], version)
+		assert.NoError(t, os.WriteFile(tables[0], data, 0600))
+
+		// the sstable is not damaged, Open fails instead of dropping it
+		_, err = Open(dir, Config{})
+		assert.ErrorIs(t, err, table.ErrUnsupportedFormat, version)
+		_, err = os.Stat(tables[0])
+		assert.NoError(t, err)
+	}
+}
+
+// sstables of the v0.2.1 release are read as they are and rewritten in the current format by compaction
+func TestOpenBaseline(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("table/testdata/baseline.db")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path.Join(dir, tableName(0, 1)), data, 0600))
+
+	check := func(db *DB) {
+		assert.NoError(t, db.View(func(txn *Txn) error {
+			for i := range 20 {
+				key := fmt.Sprintf("key%02d", i)
+				val, ok := txn.Get(key)
+				if i%5 == 4 {
+					assert.False(t, ok, key)
+					continue
+				}
+				assert.True(t, ok, key)
+				assert.Equal(t, []byte(fmt.Sprintf("value%02d", i)), val)
+			}
+			kvs, _ := txn.ScanLimit("key00", "key10", 0)
+			assert.Len(t, kvs, 8)
+			return nil
+		}))
+	}
+
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	check(db)
+	infos := db.TableInfos()
+	assert.Len(t, infos, 1)
+	assert.Equal(t, uint64(24), infos[0].Entries)
+	assert.Equal(t, "key00", infos[0].Smallest)
+
+	// writes continue after the versions of the sstable
+	assert.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set("key04", []byte("again"))
+	}))
+	assert.NoError(t, db.CompactRange("key00", "key19"))
+	db.Close()
+
+	// the deepest level is left as is by CompactRange, RewriteTable upgrades it
+	assert.NoError(t, os.WriteFile(path.Join(dir, tableName(2, 1)), data, 0600))
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.RewriteTable(2, 1))
+	db.Close()
+
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	for _, info := range db.TableInfos() {
+		data, err := os.ReadFile(path.Join(dir, tableName(info.Level, info.Idx)))
+		assert.NoError(t, err)
+		meta, err := table.ReadMeta(data)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(FormatVersion()), meta.Version)
+	}
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		val, ok := txn.Get("key04")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("again"), val)
+		val, ok = txn.Get("key05")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("value05"), val)
+		return nil
+	}))
+}
+
+func TestRepair(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	set := func(from, to int) {
+		err := db.Update(func(txn *Txn) error {
+			for i := from; i < to; i++ {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	// two sstables of L0 and entries only in wal
+	set(0, 100)
+	assert.NoError(t, db.Sync())
+	set(100, 200)
+	assert.NoError(t, db.Sync())
+	set(200, 299)
+	assert.NoError(t, db.DropPrefix("key002"))
+	// last record of wal
+	set(299, 300)
+
+	// files as left by a crash
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	assert.NoError(t, os.CopyFS(crashed, os.DirFS(dir)))
+	db.Close()
+
+	// the second sstable, the tail of wal, drop and filter seed files are damaged
+	assert.NoError(t, os.WriteFile(path.Join(crashed, tableName(0, 1)), []byte("garbage"), 0600))
+	files, err := os.ReadDir(crashed)
+	assert.NoError(t, err)
+	var logs int
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".log" {
+			continue
+		}
+		logs++
+		info, err := file.Info()
+		assert.NoError(t, err)
+		assert.NoError(t, os.Truncate(path.Join(crashed, file.Name()), info.Size()-3))
+	}
+	assert.Equal(t, 1, logs)
+	assert.NoError(t, os.WriteFile(path.Join(crashed, _dropFile), []byte{0xff}, 0600))
+	assert.NoError(t, os.WriteFile(path.Join(crashed, _filterSeedFile), []byte("bad"), 0600))
+
+	_, err = Open(crashed, config)
+	assert.ErrorIs(t, err, ErrCorruptedSeedFile)
+
+	check := func(db *DB) {
+		err := db.View(func(txn *Txn) error {
+			for i := range 300 {
+				value, ok := txn.Get(fmt.Sprintf("key%04d", i))
+				switch {
+				case i >= 100 && i < 200:
+					// lost with the damaged sstable
+					assert.False(t, ok, i)
+				case i == 299:
+					// lost with the torn tail of wal
+					assert.False(t, ok, i)
+				default:
+					// dropped prefix is visible again with the damaged drop file
+					assert.True(t, ok, i)
+					assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), value)
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	db, err = Repair(crashed, config)
+	assert.NoError(t, err)
+	check(db)
+	set(1000, 1010)
+	db.Close()
+
+	for _, name := range []string{tableName(0, 1), _dropFile} {
+		_, err = os.Stat(path.Join(crashed, _quarantineDir, name))
+		assert.NoError(t, err, name)
+	}
+
+	// the repaired db opens as usual
+	db, err = Open(crashed, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	check(db)
+	_, ok := db.GetStale("key1000")
+	assert.True(t, ok)
+}
+
+func TestRecoverTornCommit(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+
+	commit := func(prefix string) {
+		err := db.Update(func(txn *Txn) error {
+			for i := range 10 {
+				if err := txn.Set(fmt.Sprintf("%s%02d", prefix, i), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	commit("first")
+	commit("second")
+	// the last commit is torn by a crash
+	commit("third")
+
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	assert.NoError(t, os.CopyFS(crashed, os.DirFS(dir)))
+	db.Close()
+
+	files, err := os.ReadDir(crashed)
+	assert.NoError(t, err)
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".log" {
+			continue
+		}
+		info, err := file.Info()
+		assert.NoError(t, err)
+		assert.NoError(t, os.Truncate(path.Join(crashed, file.Name()), info.Size()-3))
+	}
+
+	// torn tail is not a corruption to repair
+	db, err = Open(crashed, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		for i := range 10 {
+			_, ok := txn.Get(fmt.Sprintf("first%02d", i))
+			assert.True(t, ok, i)
+			_, ok = txn.Get(fmt.Sprintf("second%02d", i))
+			assert.True(t, ok, i)
+			// none of the torn commit is replayed
+			_, ok = txn.Get(fmt.Sprintf("third%02d", i))
+			assert.False(t, ok, i)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestRecoverTornSpilledCommit(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		MemtableByteThreshold: 1024 * 1024,
+		TxnSpillThreshold:     1024,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("first", []byte("value"))
+	})
+	assert.NoError(t, err)
+	// spilled commit is logged as several partial batches and a last one
+	err = db.Update(func(txn *Txn) error {
+		for i := range 1000 {
+			if err := txn.Set(fmt.Sprintf("bulk%04d", i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		assert.NotNil(t, txn.spill)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// crash before and after the last batch is written
+	torn := filepath.Join(t.TempDir(), "torn")
+	assert.NoError(t, os.CopyFS(torn, os.DirFS(dir)))
+	complete := filepath.Join(t.TempDir(), "complete")
+	assert.NoError(t, os.CopyFS(complete, os.DirFS(dir)))
+	db.Close()
+
+	files, err := os.ReadDir(torn)
+	assert.NoError(t, err)
+	var logs int
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".log" {
+			continue
+		}
+		logs++
+		info, err := file.Info()
+		assert.NoError(t, err)
+		// several batches of the spilled commit
+		assert.Greater(t, info.Size(), int64(4*config.TxnSpillThreshold))
+		assert.NoError(t, os.Truncate(path.Join(torn, file.Name()), info.Size()-3))
+	}
+	assert.Equal(t, 1, logs)
+
+	for crashed, visible := range map[string]bool{torn: false, complete: true} {
+		db, err := Open(crashed, config)
+		assert.NoError(t, err)
+		err = db.View(func(txn *Txn) error {
+			_, ok := txn.Get("first")
+			assert.True(t, ok)
+			for i := range 1000 {
+				// all or none of the spilled commit is replayed
+				_, ok = txn.Get(fmt.Sprintf("bulk%04d", i))
+				assert.Equal(t, visible, ok, i)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		db.Close()
+	}
+}
+
+func TestNoInternalKeys(t *testing.T) {
+	dir := t.TempDir()
+	internal := regexp.MustCompile(`@\d+$`)
+	var filtered []string
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		CompactionFilter: func(key string, value []byte, version uint64) bool {
+			filtered = append(filtered, key)
+			return true
+		},
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+
+	// several versions of each key in sstables and memtables, some deleted
+	const n = 100
+	for round := range 3 {
+		err = db.Update(func(txn *Txn) error {
+			for i := range n {
+				key := fmt.Sprintf("key%03d", i)
+				if round == 2 && i%10 == 0 {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+					continue
+				}
+				value := []byte(fmt.Sprintf("value%d-%d", round, i))
+				if err := errors.Join(txn.Set(key, value), txn.SetCF(cf, key, value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Sync())
+	}
+	db.triggerCompaction()
+	assert.NotEmpty(t, filtered)
+	for _, key := range filtered {
+		assert.False(t, internal.MatchString(key), key)
+	}
+
+	txn := db.Begin(true)
+	defer txn.Discard()
+	// pending writes are returned with committed ones
+	assert.NoError(t, txn.Set("pending", []byte("value")))
+
+	var keys []string
+	kvs, next := txn.ScanLimit("", "\xff", n/2)
+	for _, kv := range kvs {
+		keys = append(keys, kv.K)
+	}
+	keys = append(keys, next)
+	for key, value, ok := txn.Seek(""); ok; key, value, ok = txn.Seek(key + "\x00") {
+		assert.NotEmpty(t, value)
+		keys = append(keys, key)
+	}
+	assert.Len(t, keys, n-n/10+1+n/2+1)
+	for _, key := range keys {
+		assert.False(t, internal.MatchString(key), key)
+	}
+
+	// values are found by user keys, and never carry internal keys
+	for i := range n {
+		key := fmt.Sprintf("key%03d", i)
+		if i%10 == 0 {
+			_, _, err = txn.GetWithTombstone(key)
+			assert.ErrorIs(t, err, ErrKeyDeleted)
+			continue
+		}
+		expected := []byte(fmt.Sprintf("value2-%d", i))
+		value, ok := txn.Get(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, value)
+		value, ok = txn.GetCF(cf, key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, value)
+		value, version, ok, err := txn.GetVersioned(key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected, value)
+		assert.Greater(t, version, uint64(0))
+	}
+
+	// exported keys are user keys
+	var buf bytes.Buffer
+	assert.NoError(t, db.Export(&buf))
+	entries, err := readExport(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, entries, n-n/10)
+	for _, entry := range entries {
+		// readExport restores internal keys from user keys and versions of the stream
+		assert.Regexp(t, `^key\d{3}@\d+$`, entry.Key)
+	}
+}
+
+func TestConfigLogger(t *testing.T) {
+	// logs of dbs with their own logger never reach the global one
+	var global bytes.Buffer
+	logger.SetLogger(&logger.FLogger{Logger: log.New(&global, "global ", log.LstdFlags)})
+	defer logger.ResetDefaultLogger()
+
+	open := func(dir string, buf *bytes.Buffer, prefix string) *DB {
+		db, err := Open(dir, Config{
+			MemtableByteThreshold: 1024,
+			Logger:                &logger.FLogger{Logger: log.New(buf, prefix, log.LstdFlags)},
+		})
+		assert.NoError(t, err)
+		return db
+	}
+
+	var buf1, buf2 bytes.Buffer
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	db1 := open(dir1, &buf1, "db1 ")
+	db2 := open(dir2, &buf2, "db2 ")
+
+	for i := range 100 {
+		assert.NoError(t, db1.Update(func(txn *Txn) error {
+			return txn.Set(fmt.Sprintf("first%03d", i), []byte("value"))
+		}))
+		assert.NoError(t, db2.Update(func(txn *Txn) error {
+			return txn.Set(fmt.Sprintf("second%03d", i), []byte("value"))
+		}))
+	}
+	db1.Close()
+	db2.Close()
+
+	// recover logs with the logger of the reopened db
+	db1 = open(dir1, &buf1, "db1 ")
+	db1.Close()
+
+	assert.Contains(t, buf1.String(), "first099")
+	assert.Contains(t, buf1.String(), "memtable recover")
+	assert.NotContains(t, buf1.String(), "second")
+	assert.Contains(t, buf2.String(), "second099")
+	assert.NotContains(t, buf2.String(), "first")
+	assert.Empty(t, global.String())
+}
+
+func TestIterateLevel(t *testing.T) {
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+	}
+	db, err := Open(t.TempDir(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// 3 versions of each key in 3 sstables of L0, the last one of odd keys is a tombstone
+	for round := range 3 {
+		err = db.Update(func(txn *Txn) error {
+			for i := range 10 {
+				key := fmt.Sprintf("key%d", i)
+				if round == 2 && i%2 == 1 {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := txn.Set(key, []byte(fmt.Sprintf("value%d-%d", round, i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Sync())
+	}
+
+	entries, err := db.IterateLevel(0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 30)
+	assert.True(t, slices.IsSortedFunc(entries, func(a, b types.Entry) int {
+		return types.CompareKeys(a.Key, b.Key)
+	}))
+	for i, entry := range entries {
+		// versions of a key are ordered new -> old
+		key, round := i/3, 2-i%3
+		assert.Equal(t, fmt.Sprintf("key%d", key), types.ParseKey(entry.Key))
+		if round == 2 && key%2 == 1 {
+			assert.True(t, entry.Tombstone)
+			continue
+		}
+		assert.False(t, entry.Tombstone)
+		assert.Equal(t, []byte(fmt.Sprintf("value%d-%d", round, key)), entry.Value)
+	}
+
+	// memtables are not included, and levels without sstables are empty
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("unflushed", []byte("value"))
+	})
+	assert.NoError(t, err)
+	entries, err = db.IterateLevel(0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 30)
+	entries, err = db.IterateLevel(5)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = db.IterateLevel(-1)
+	assert.ErrorIs(t, err, ErrInvalidLevel)
+}
+
+func TestFilterSeed(t *testing.T) {
+	db1 := setupSSTableDB(t, 100, nil)
+	db2 := setupSSTableDB(t, 100, nil)
+	assert.NotEqual(t, db1.filterSeed, db2.filterSeed)
+
+	// false positives of the filter of the first sstable
+	falsePositives := func(db *DB) []string {
+		levels := db.manager.acquire()
+		defer db.manager.release()
+
+		var res []string
+		for _, tables := range levels {
+			if len(tables) == 0 {
+				continue
+			}
+			for i := range 10000 {
+				if key := fmt.Sprintf("absent%d", i); tables[0].filter.Contains(key) {
+					res = append(res, key)
+				}
+			}
+			break
+		}
+		return res
+	}
+
+	// same key set, different bits
+	fp1 := falsePositives(db1)
+	assert.NotEmpty(t, fp1)
+	assert.NotEqual(t, fp1, falsePositives(db2))
+	db2.Close()
+
+	// seed is kept across restart
+	dir, seed := db1.dir, db1.filterSeed
+	config := db1.config
+	db1.Close()
+	db1, err := Open(dir, config)
+	assert.NoError(t, err)
+	assert.Equal(t, seed, db1.filterSeed)
+	assert.Equal(t, fp1, falsePositives(db1))
+	db1.Close()
+
+	assert.NoError(t, os.WriteFile(path.Join(dir, _filterSeedFile), []byte{1}, 0600))
+	_, err = Open(dir, config)
+	assert.ErrorIs(t, err, ErrCorruptedSeedFile)
+}
+
+func TestLargeTs(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig
+	assert.NoError(t, config.validate())
+
+	// ts beyond int64
+	const ts = 1<<63 + 10
+	lm := newLevelManager(&DB{config: config}, dir)
+	err := lm.ingest([]types.Entry{
+		{Key: types.KeyWithTs("key1", ts), Value: []byte("v1"), Version: types.Version(ts)},
+		{Key: types.KeyWithTs("key2", ts-1), Value: []byte("v2"), Version: types.Version(ts - 1)},
+	})
+	assert.NoError(t, err)
+
+	// oracle recovers after the largest ts
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(ts+1), db.oracle.nextTs)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("key1", []byte("v1-new"))
+	})
+	assert.NoError(t, err)
+
+	check := func(db *DB) {
+		err := db.View(func(txn *Txn) error {
+			val, version, found, err := txn.GetVersioned("key1")
+			assert.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, []byte("v1-new"), val)
+			assert.Equal(t, uint64(ts+1), version)
+
+			val, version, found, err = txn.GetVersioned("key2")
+			assert.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, []byte("v2"), val)
+			assert.Equal(t, uint64(ts-1), version)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check(db)
+
+	// through flush and compaction
+	assert.NoError(t, db.Sync())
+	db.triggerCompaction()
+	check(db)
+	db.Close()
+
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, uint64(ts+2), db.oracle.nextTs)
+	check(db)
+}
+
+func TestDebugChecks(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{DebugChecks: true})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for round := range 3 {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			for i := range 100 {
+				key := fmt.Sprintf("key%04d", i)
+				if i%10 == round {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := txn.Set(key, []byte(fmt.Sprintf("value%d", round))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		// versions of committed entries agree with their keys
+		assert.NoError(t, db.Sync())
+	}
+	assert.Equal(t, HealthOK, db.Health().State)
+	assert.Len(t, db.TableInfos(), 3)
+
+	// levels are verified after compaction
+	db.triggerCompaction()
+	assert.Equal(t, HealthOK, db.Health().State)
+
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		_, found := txn.Get("key0002")
+		assert.False(t, found)
+		value, found := txn.Get("key0043")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value2"), value)
+		return nil
+	}))
+}
+
+func TestWriteStall(t *testing.T) {
+	dir := t.TempDir()
+	recorder := metrics.NewMemory()
+	config := Config{
+		SkipListMaxLevel:        4,
+		SkipListP:               0.5,
+		L0TargetNum:             4,
+		LevelRatio:              10,
+		DataBlockByteThreshold:  4096,
+		MemtableByteThreshold:   1024,
+		ImmutableBuffer:         10,
+		WriteSlowdownImmutables: 2,
+		WriteStallImmutables:    3,
+		Metrics:                 recorder,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// block flush of every immutable
+	db.manager.mu.Lock()
+
+	var stalled atomic.Int32
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := db.Update(func(txn *Txn) error {
+					return txn.Set(fmt.Sprintf("key%d-%04d", w, i), bytes.Repeat([]byte("v"), 100))
+				})
+				if errors.Is(err, ErrWriteStalled) {
+					stalled.Add(1)
+					return
+				}
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		db.manager.mu.Unlock()
+		t.Fatal("writers are blocked instead of stalled")
+	}
+	assert.Equal(t, int32(4), stalled.Load())
+	assert.Equal(t, 3, db.pendingFlushes())
+
+	snapshot := recorder.Snapshot()
+	assert.True(t, snapshot[metrics.WriteSlowdowns] > 0)
+	assert.Equal(t, uint64(4), snapshot[metrics.WriteStalls])
+
+	// writes are accepted again once flush catches up
+	db.manager.mu.Unlock()
+	assert.NoError(t, db.Sync())
+	assert.Equal(t, 0, db.pendingFlushes())
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("key", []byte("value"))
+	})
+	assert.NoError(t, err)
+}
+
+func TestGetStale(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+		ReadTimeout:            100 * time.Millisecond,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("x", []byte("old"))
+	})
+	assert.NoError(t, err)
+
+	// a commit in progress which has not written x yet
+	ts, err := db.oracle.nextCommitTs()
+	assert.NoError(t, err)
+
+	// txns wait for the commit in progress, stale reads do not
+	err = db.View(func(txn *Txn) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	value, ok := db.GetStale("x")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("old"), value)
+
+	db.rawset(types.Entry{
+		Key:     types.KeyWithTs("x", ts),
+		Value:   []byte("new"),
+		Version: types.Version(ts),
+	})
+	value, ok = db.GetStale("x")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("new"), value)
+	db.oracle.doneCommit(ts)
+
+	err = db.View(func(txn *Txn) error {
+		value, ok := txn.Get("x")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("new"), value)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	_, ok = db.GetStale("")
+	assert.False(t, ok)
+}
+
+func TestGetStaleConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	const n = 500
+	// number of commits the writer has begun
+	var begun atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= n; i++ {
+			begun.Store(int64(i))
+			err := db.Update(func(txn *Txn) error {
+				return txn.Set("counter", []byte(strconv.Itoa(i)))
+			})
+			assert.NoError(t, err)
+		}
+	}()
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for last < n {
+				value, ok := db.GetStale("counter")
+				// loaded after GetStale, a value newer than it is a future value
+				upper := int(begun.Load())
+				if !ok {
+					assert.Equal(t, 0, last)
+					continue
+				}
+				i, err := strconv.Atoi(string(value))
+				assert.NoError(t, err)
+				// values of a reader never go backwards
+				assert.GreaterOrEqual(t, i, last)
+				assert.LessOrEqual(t, i, upper)
+				last = i
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReadDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             2,
+		DataBlockByteThreshold: 512,
+		MemtableByteThreshold:  1 << 20,
+		TargetFileSize:         1024,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	const keys, rounds = 100, 20
+	// last round of values committed to all keys
+	var committed atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for round := 1; round <= rounds; round++ {
+			err := db.Update(func(txn *Txn) error {
+				for i := range keys {
+					if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(strconv.Itoa(round))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			committed.Store(int64(round))
+			// old sstables are replaced and deleted while readers search them
+			assert.NoError(t, db.Sync())
+			db.manager.compactAll()
+		}
+	}()
+
+	var lookups atomic.Int64
+	for r := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := r; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				lower := int(committed.Load())
+				value, ok := db.GetStale(fmt.Sprintf("key%04d", i%keys))
+				// the round being committed may be read before it is recorded
+				upper := int(committed.Load()) + 1
+				lookups.Add(1)
+				if lower == 0 && !ok {
+					continue
+				}
+				assert.True(t, ok)
+				round, err := strconv.Atoi(string(value))
+				assert.NoError(t, err)
+				assert.GreaterOrEqual(t, round, lower)
+				assert.LessOrEqual(t, round, upper)
+				// leave cpu to the writer
+				if i%10 == 0 {
+					time.Sleep(100 * time.Microsecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Greater(t, lookups.Load(), int64(rounds))
+
+	// every lookup after the last compaction returns the last round
+	err = db.View(func(txn *Txn) error {
+		for i := range keys {
+			value, ok := txn.Get(fmt.Sprintf("key%04d", i))
+			assert.True(t, ok)
+			assert.Equal(t, []byte(strconv.Itoa(rounds)), value)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	levels := db.manager.acquire()
+	defer db.manager.release()
+	assert.Empty(t, levels[0])
+	assert.Greater(t, len(levels[1]), 1)
+}
+
+func TestFileMode(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+		// not affected by common umasks
+		DirMode:  0700,
+		FileMode: 0600,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+	err = db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			if err := txn.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+			if err := txn.SetCF(cf, fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	assert.NoError(t, db.DropPrefix("key00"))
+	db.Close()
+
+	// quarantined on recovery
+	assert.NoError(t, os.WriteFile(path.Join(dir, "notes.db"), []byte("garbage"), 0600))
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	exts := make(map[string]bool)
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), name)
+			exts[d.Name()] = true
+			return nil
+		}
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), name)
+		exts[path.Ext(name)] = true
+		return nil
+	})
+	assert.NoError(t, err)
+	for _, want := range []string{_quarantineDir, _dbExt, ".log"} {
+		assert.True(t, exts[want], want)
+	}
+
+	// defaults
+	config = Config{}
+	assert.NoError(t, config.validate())
+	assert.Equal(t, os.FileMode(0755), config.DirMode)
+	assert.Equal(t, os.FileMode(0644), config.FileMode)
+}
+
+func TestTempDir(t *testing.T) {
+	test := func(t *testing.T, tempDir string) {
+		dir := t.TempDir()
+		config := Config{
+			SkipListMaxLevel:       4,
+			SkipListP:              0.5,
+			L0TargetNum:            2,
+			LevelRatio:             2,
+			DataBlockByteThreshold: 4096,
+			MemtableByteThreshold:  1024,
+			ImmutableBuffer:        10,
+			TempDir:                tempDir,
+		}
+		db, err := Open(dir, config)
+		assert.NoError(t, err)
+
+		// flush and compaction write sstables through temp dir
+		for round := range 3 {
+			err = db.Update(func(txn *Txn) error {
+				for i := range 100 {
+					if err := txn.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d-%d", round, i))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, db.Sync())
+		}
+		db.manager.compactAll()
+		assert.True(t, len(db.manager.levels) > 1 && db.manager.levels[1].Len() > 0)
+		db.Close()
+
+		db, err = Open(dir, config)
+		assert.NoError(t, err)
+		defer db.Close()
+		err = db.View(func(txn *Txn) error {
+			for i := range 100 {
+				value, ok := txn.Get(fmt.Sprintf("key%03d", i))
+				assert.True(t, ok)
+				assert.Equal(t, []byte(fmt.Sprintf("value2-%d", i)), value)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+
+		// no scratch file is left in either dir
+		for _, d := range []string{dir, tempDir} {
+			files, err := os.ReadDir(d)
+			assert.NoError(t, err)
+			for _, file := range files {
+				assert.NotEqual(t, _tmpExt, path.Ext(file.Name()))
+			}
+		}
+	}
+
+	t.Run("same file system", func(t *testing.T) {
+		test(t, path.Join(t.TempDir(), "scratch"))
+	})
+
+	// tmpfs, which is usually another file system, scratch files are written again into db dir
+	t.Run("other file system", func(t *testing.T) {
+		tempDir, err := os.MkdirTemp("/dev/shm", "originium-")
+		if err != nil {
+			t.Skip("/dev/shm is not available")
+		}
+		defer os.RemoveAll(tempDir)
+		test(t, tempDir)
+	})
+}
+
+func TestReadsReturnCopies(t *testing.T) {
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+		ImmutableBuffer:        10,
+	}
+	db, err := Open(t.TempDir(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+
+	// key in sstable, memtable and column family
+	set := func(key string) {
+		err := db.Update(func(txn *Txn) error {
+			return errors.Join(txn.Set(key, []byte("value")), txn.SetCF(cf, key, []byte("value")))
+		})
+		assert.NoError(t, err)
+	}
+	set("flushed")
+	assert.NoError(t, db.Sync())
+	set("memtable")
+
+	// every read returns a value which is modified by the caller
+	reads := map[string]func(txn *Txn, key string) []byte{
+		"Get": func(txn *Txn, key string) []byte {
+			val, _ := txn.Get(key)
+			return val
+		},
+		"GetCF": func(txn *Txn, key string) []byte {
+			val, _ := txn.GetCF(cf, key)
+			return val
+		},
+		"GetWithTombstone": func(txn *Txn, key string) []byte {
+			val, _, _ := txn.GetWithTombstone(key)
+			return val
+		},
+		"GetVersioned": func(txn *Txn, key string) []byte {
+			val, _, _, _ := txn.GetVersioned(key)
+			return val
+		},
+		"MultiGet": func(txn *Txn, key string) []byte {
+			vals, _ := txn.MultiGet([]string{key})
+			return vals[0]
+		},
+		"ScanLimit": func(txn *Txn, key string) []byte {
+			kvs, _ := txn.ScanLimit(key, key+"\x00", 0)
+			assert.Len(t, kvs, 1)
+			return kvs[0].V
+		},
+		"Seek": func(txn *Txn, key string) []byte {
+			_, val, _ := txn.Seek(key)
+			return val
+		},
+		"GetStale": func(_ *Txn, key string) []byte {
+			val, _ := db.GetStale(key)
+			return val
+		},
+	}
+	check := func(txn *Txn, key string) {
+		for name, read := range reads {
+			val := read(txn, key)
+			assert.Equal(t, []byte("value"), val, "%s %s", name, key)
+			copy(val, "XXXXX")
+			val, ok := txn.Get(key)
+			assert.True(t, ok)
+			assert.Equal(t, []byte("value"), val, "%s %s", name, key)
+		}
+	}
+
+	err = db.View(func(txn *Txn) error {
+		check(txn, "flushed")
+		check(txn, "memtable")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// pending writes of the txn, GetStale reads the committed value
+	err = db.Update(func(txn *Txn) error {
+		value := []byte("value")
+		for _, key := range []string{"pending", "memtable"} {
+			assert.NoError(t, errors.Join(txn.Set(key, value), txn.SetCF(cf, key, value)))
+		}
+		check(txn, "memtable")
+		delete(reads, "GetStale")
+		check(txn, "pending")
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestValueHooks(t *testing.T) {
+	// xor with a prefix tag, so a value that was never marshaled fails to unmarshal
+	xor := func(data []byte) []byte {
+		for i := range data {
+			data[i] ^= 0x5a
+		}
+		return data
+	}
+	marshaled := func(value []byte) []byte {
+		return append([]byte("x:"), xor(bytes.Clone(value))...)
+	}
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+		ImmutableBuffer:        10,
+		MarshalValue: func(value []byte) ([]byte, error) {
+			return marshaled(value), nil
+		},
+		UnmarshalValue: func(data []byte) ([]byte, error) {
+			if !bytes.HasPrefix(data, []byte("x:")) {
+				return nil, errors.New("not marshaled")
+			}
+			return xor(data[2:]), nil
+		},
+	}
+	dir := t.TempDir()
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+
+	set := func(key string) {
+		err := db.Update(func(txn *Txn) error {
+			return errors.Join(txn.Set(key, []byte("value")), txn.SetCF(cf, key, []byte("value")), txn.Delete(key+"-deleted"))
+		})
+		assert.NoError(t, err)
+	}
+	set("flushed")
+	assert.NoError(t, db.Sync())
+	set("memtable")
+
+	// stored values are marshaled, tombstones and keys are not
+	entries, err := db.IterateLevel(0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "flushed", types.ParseKey(entries[0].Key))
+	assert.Equal(t, marshaled([]byte("value")), entries[0].Value)
+	assert.True(t, entries[1].Tombstone)
+	assert.Empty(t, entries[1].Value)
+	stored, ok := db.memtable.lowerBound(types.KeyWithTs("memtable", math.MaxUint64))
+	assert.True(t, ok)
+	assert.Equal(t, marshaled([]byte("value")), stored.Value)
+
+	check := func(txn *Txn, key string) {
+		val, ok := txn.Get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, []byte("value"), val, key)
+		val, ok = txn.GetCF(cf, key)
+		assert.True(t, ok, key)
+		assert.Equal(t, []byte("value"), val, key)
+		val, _, err := txn.GetWithTombstone(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val, key)
+		val, _, _, err = txn.GetVersioned(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val, key)
+		vals, _ := txn.MultiGet([]string{key})
+		assert.Equal(t, []byte("value"), vals[0], key)
+		kvs, _ := txn.ScanLimit(key, key+"\x00", 0)
+		assert.Equal(t, []types.KV{{K: key, V: []byte("value")}}, kvs)
+		_, val, _ = txn.Seek(key)
+		assert.Equal(t, []byte("value"), val, key)
+	}
+	err = db.View(func(txn *Txn) error {
+		check(txn, "flushed")
+		check(txn, "memtable")
+		_, _, err := txn.GetWithTombstone("flushed-deleted")
+		assert.ErrorIs(t, err, ErrKeyDeleted)
+		return nil
+	})
+	assert.NoError(t, err)
+	val, ok := db.GetStale("flushed")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), val)
+
+	// pending writes and Append
+	err = db.Update(func(txn *Txn) error {
+		assert.NoError(t, errors.Join(txn.Set("pending", []byte("value")), txn.SetCF(cf, "pending", []byte("value"))))
+		check(txn, "pending")
+		assert.NoError(t, txn.Append("memtable", []byte("-appended")))
+		val, ok := txn.Get("memtable")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("value-appended"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = db.View(func(txn *Txn) error {
+		val, ok := txn.Get("memtable")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("value-appended"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// a value that fails to unmarshal is an error or absent
+	db.rawset(types.Entry{Key: types.KeyWithTs("raw", db.oracle.nextTs-1), Value: []byte("value")})
+	err = db.View(func(txn *Txn) error {
+		_, ok := txn.Get("raw")
+		assert.False(t, ok)
+		_, _, err := txn.GetWithTombstone("raw")
+		assert.Error(t, err)
+		return nil
+	})
+	assert.NoError(t, err)
+	// Append does not overwrite it with the suffix
+	err = db.Update(func(txn *Txn) error {
+		return txn.Append("raw", []byte("-appended"))
+	})
+	assert.ErrorContains(t, err, "not marshaled")
+	entry, ok := db.searchEntry(&db.keyspace, types.KeyWithTs("raw", math.MaxUint64))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), entry.Value)
+
+	// hooks must be set together
+	config.UnmarshalValue = nil
+	_, err = Open(t.TempDir(), config)
+	assert.ErrorIs(t, err, ErrValueHooks)
+}
+
+func TestCompactionSchedule(t *testing.T) {
+	// compaction is allowed from 1 to 5 o'clock of the fake clock
+	var now atomic.Pointer[time.Time]
+	setNow := func(hour int) {
+		at := time.Date(2025, 1, 1, hour, 0, 0, 0, time.Local)
+		now.Store(&at)
+	}
+	setNow(12)
+
+	db, err := Open(t.TempDir(), Config{
+		L0TargetNum:        2,
+		CompactionSchedule: CompactBetween(1, 5),
+		Clock: func() time.Time {
+			return *now.Load()
+		},
+	})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	flush := func(round int) {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			for i := range 10 {
+				if err := txn.Set(fmt.Sprintf("key%02d", i), []byte(strconv.Itoa(round))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		assert.NoError(t, db.Sync())
+	}
+	tables := func(level int) int {
+		n := 0
+		for _, info := range db.TableInfos() {
+			if info.Level == level {
+				n++
+			}
+		}
+		return n
+	}
+
+	// deferred outside the window
+	for round := range 5 {
+		flush(round)
+	}
+	assert.Equal(t, 5, tables(0))
+
+	// levels over their target are compacted by the first flush inside it
+	setNow(2)
+	flush(5)
+	assert.LessOrEqual(t, tables(0), 2)
+	assert.Positive(t, tables(1))
+
+	// CompactRange is never deferred
+	setNow(12)
+	for round := 6; round < 9; round++ {
+		flush(round)
+	}
+	assert.Equal(t, 3, tables(0))
+	assert.NoError(t, db.CompactRange("key00", "key09"))
+	assert.Zero(t, tables(0))
+	assert.NoError(t, db.Verify())
+
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		for i := range 10 {
+			val, ok := txn.Get(fmt.Sprintf("key%02d", i))
+			assert.True(t, ok)
+			assert.Equal(t, []byte("8"), val)
+		}
+		return nil
+	}))
+}
+
+// deferred compaction runs once the window opens without any further write
+func TestCompactionScheduleIdle(t *testing.T) {
+	var now atomic.Pointer[time.Time]
+	setNow := func(hour int) {
+		at := time.Date(2025, 1, 1, hour, 0, 0, 0, time.Local)
+		now.Store(&at)
+	}
+	setNow(12)
+
+	db, err := Open(t.TempDir(), Config{
+		L0TargetNum:                2,
+		CompactionSchedule:         CompactBetween(1, 5),
+		CompactionScheduleInterval: 10 * time.Millisecond,
+		Clock: func() time.Time {
+			return *now.Load()
+		},
+	})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+
+	// tables of L0 of a keyspace
+	l0 := func(cf *CF) int {
+		infos := db.TableInfos()
+		if cf != nil {
+			infos = cf.manager.tableInfos()
+		}
+		n := 0
+		for _, info := range infos {
+			if info.Level == 0 {
+				n++
+			}
+		}
+		return n
+	}
+
+	for round := range 4 {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			for i := range 10 {
+				key, value := fmt.Sprintf("key%02d", i), []byte(strconv.Itoa(round))
+				if err := errors.Join(txn.Set(key, value), txn.SetCF(users, key, value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		assert.NoError(t, db.Sync())
+	}
+	// ticks outside the window compact nothing
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 4, l0(nil))
+	assert.Equal(t, 4, l0(users))
+
+	setNow(2)
+	assert.Eventually(t, func() bool {
+		return l0(nil) <= 2 && l0(users) <= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, db.Verify())
+}
+
+func TestCompactBetween(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2025, 1, 1, hour, 30, 0, 0, time.Local)
+	}
+	schedule := CompactBetween(1, 5)
+	assert.False(t, schedule(at(0)))
+	assert.True(t, schedule(at(1)))
+	assert.True(t, schedule(at(4)))
+	assert.False(t, schedule(at(5)))
+
+	// over midnight
+	schedule = CompactBetween(22, 6)
+	assert.True(t, schedule(at(23)))
+	assert.True(t, schedule(at(0)))
+	assert.False(t, schedule(at(6)))
+	assert.False(t, schedule(at(12)))
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+)
+
+const _dropFile = "DROP"
+
+var ErrCorruptedDropFile = errors.New("drop file is corrupted")
+
+// prefixDrop all versions of keys with prefix at or below ts are dropped
+type prefixDrop struct {
+	prefix string
+	ts     uint64
+}
+
+// DropPrefix delete all keys with base key prefix in the default keyspace
+// instead of writing a tombstone for each key, the drop is recorded durably before return,
+// matching versions are hidden from txns that begin after it and removed by compaction.
+func (db *DB) DropPrefix(prefix string) error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnlyDB
+	}
+	if prefix == "" {
+		return ErrEmptyKey
+	}
+
+	orc := db.oracle
+
+	// a drop is ordered with commits of txns
+	orc.writeLock.Lock()
+	defer orc.writeLock.Unlock()
+
+	ts, err := orc.nextCommitTs()
+	if err != nil {
+		return err
+	}
+	defer orc.doneCommit(ts)
+
+	return db.manager.dropPrefix(prefix, ts)
+}
+
+// visible turn entry dropped by DropPrefix into a tombstone for a read at readTs
+func (ks *keyspace) visible(entry types.Entry, readTs uint64) types.Entry {
+	if ks.manager.dropped(entry.Key, readTs) {
+		entry.Value = nil
+		entry.Tombstone = true
+	}
+	return entry
+}
+
+// dropPrefix write all drops to drop file, then apply the new drop to reads
+func (lm *levelManager) dropPrefix(prefix string, ts uint64) error {
+	lm.dropMu.Lock()
+	defer lm.dropMu.Unlock()
+
+	drops := append(slices.Clone(lm.drops), prefixDrop{
+		prefix: prefix,
+		ts:     ts,
+	})
+	if !lm.db.inMemory {
+		if err := writeFileSync(path.Join(lm.dir, _dropFile), encodeDrops(drops), lm.fileMode()); err != nil {
+			return err
+		}
+	}
+	lm.drops = drops
+	return nil
+}
+
+// dropped report whether the version of key is dropped for a read at readTs
+func (lm *levelManager) dropped(key types.Key, readTs uint64) bool {
+	lm.dropMu.RLock()
+	defer lm.dropMu.RUnlock()
+
+	if len(lm.drops) == 0 {
+		return false
+	}
+
+	base := types.ParseKey(key)
+	ts := types.ParseTs(key)
+	for _, drop := range lm.drops {
+		if readTs >= drop.ts && ts <= drop.ts && strings.HasPrefix(base, drop.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// droppedBetween report whether a drop at a ts in (from, to] may have versions to discard
+func (lm *levelManager) droppedBetween(from, to uint64) bool {
+	lm.dropMu.RLock()
+	defer lm.dropMu.RUnlock()
+
+	for _, drop := range lm.drops {
+		if drop.ts > from && drop.ts <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// discardDropped remove versions dropped for all reads at or above low
+// no active read txn is below low, so nothing can see these versions anymore.
+func (lm *levelManager) discardDropped(entries []types.Entry, low uint64) []types.Entry {
+	return slices.DeleteFunc(entries, func(entry types.Entry) bool {
+		return lm.dropped(entry.Key, low)
+	})
+}
+
+// pruneDrops remove drops no version is left for, so reads and compactions stop checking them
+// a drop is pruned once it is at or below the persisted discard watermark and no memtable or sstable may hold
+// a version it drops, i.e. every sstable overlapping its prefix is compacted after the watermark passed it.
+// ts of a drop is kept by the discard file, so no ts is reused after it is pruned.
+func (db *DB) pruneDrops() {
+	if db.inMemory {
+		return
+	}
+	db.discardMu.Lock()
+	low := db.discardTs
+	db.discardMu.Unlock()
+
+	lm := db.manager
+	lm.dropMu.RLock()
+	var candidates []prefixDrop
+	for _, drop := range lm.drops {
+		if drop.ts <= low {
+			candidates = append(candidates, drop)
+		}
+	}
+	lm.dropMu.RUnlock()
+	if len(candidates) == 0 {
+		return
+	}
+
+	// versions dropped only move from memtables to immutables and then to sstables,
+	// so one not found in memtables is found in sstables, unless a compaction has removed it
+	db.mu.RLock()
+	candidates = slices.DeleteFunc(candidates, func(drop prefixDrop) bool {
+		if db.memtable.holdsDropped(drop) {
+			return true
+		}
+		for e := db.immutables.Front(); e != nil; e = e.Next() {
+			if e.Value.(*memtable).holdsDropped(drop) {
+				return true
+			}
+		}
+		return false
+	})
+	db.mu.RUnlock()
+
+	if err := lm.pruneDrops(candidates); err != nil {
+		db.logger.Errorf("failed to prune drops: %v", err)
+	}
+}
+
+// pruneDrops remove drops of candidates no sstable may hold a version of
+func (lm *levelManager) pruneDrops(candidates []prefixDrop) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	candidates = slices.DeleteFunc(candidates, lm.holdsDropped)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	lm.dropMu.Lock()
+	defer lm.dropMu.Unlock()
+
+	drops := slices.DeleteFunc(slices.Clone(lm.drops), func(drop prefixDrop) bool {
+		return slices.Contains(candidates, drop)
+	})
+	if err := writeFileSync(path.Join(lm.dir, _dropFile), encodeDrops(drops), lm.fileMode()); err != nil {
+		return err
+	}
+	lm.drops = drops
+	return nil
+}
+
+// holdsDropped report whether a sstable overlapping prefix of drop may hold a version it drops
+// NOTE: call with mu
+func (lm *levelManager) holdsDropped(drop prefixDrop) bool {
+	for _, level := range lm.levels {
+		for e := level.Front(); e != nil; e = e.Next() {
+			th := e.Value.(tableHandle)
+			if th.discarded >= drop.ts {
+				continue
+			}
+			index := th.dataBlockIndex.Entries
+			start, end := types.ParseKey(index[0].StartKey), types.ParseKey(index[len(index)-1].EndKey)
+			// a start > prefix without it is greater than all keys with prefix
+			if end >= drop.prefix && (start <= drop.prefix || strings.HasPrefix(start, drop.prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holdsDropped report whether mt holds a version dropped by drop
+func (mt *memtable) holdsDropped(drop prefixDrop) bool {
+	key := drop.prefix
+	for {
+		// the newest version of key at or below ts of drop, or the newest version of the next key
+		entry, ok := mt.lowerBound(types.KeyWithTs(key, drop.ts))
+		if !ok || !strings.HasPrefix(types.ParseKey(entry.Key), drop.prefix) {
+			return false
+		}
+		if base := types.ParseKey(entry.Key); base != key {
+			key = base
+			continue
+		}
+		return true
+	}
+}
+
+// recoverDrops load drops from drop file, return max ts of them
+// a drop file which can not be read or decoded fails recover, unless db is opened by Repair, which quarantines it instead.
+func (lm *levelManager) recoverDrops() (uint64, error) {
+	data, err := fs.ReadFile(lm.tables(), _dropFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read drop file: %w", err)
+	}
+
+	drops, err := decodeDrops(data)
+	if err != nil && lm.db != nil && lm.db.repair {
+		// dropped prefixes are visible again until they are dropped again
+		lm.logger.Warnf("drop file is corrupted, quarantine it: %v", err)
+		lm.quarantine(_dropFile)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode drop file: %w", err)
+	}
+
+	lm.dropMu.Lock()
+	defer lm.dropMu.Unlock()
+	lm.drops = drops
+
+	var maxTs uint64
+	for _, drop := range drops {
+		maxTs = max(maxTs, drop.ts)
+	}
+	return maxTs, nil
+}
+
+// encodeDrops encode each drop as uvarint(ts) + uvarint(len(prefix)) + prefix
+func encodeDrops(drops []prefixDrop) []byte {
+	var buf []byte
+	for _, drop := range drops {
+		buf = binary.AppendUvarint(buf, drop.ts)
+		buf = binary.AppendUvarint(buf, uint64(len(drop.prefix)))
+		buf = append(buf, drop.prefix...)
+	}
+	return buf
+}
+
+func decodeDrops(data []byte) ([]prefixDrop, error) {
+	var drops []prefixDrop
+	for len(data) > 0 {
+		ts, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrCorruptedDropFile
+		}
+		data = data[n:]
+
+		l, n := binary.Uvarint(data)
+		if n <= 0 || l > uint64(len(data)-n) {
+			return nil, ErrCorruptedDropFile
+		}
+		data = data[n:]
+
+		drops = append(drops, prefixDrop{
+			prefix: string(data[:l]),
+			ts:     ts,
+		})
+		data = data[l:]
+	}
+	return drops, nil
+}
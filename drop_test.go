@@ -0,0 +1,180 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropPrefix(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		for i := range 200 {
+			if err := txn.Set(fmt.Sprintf("user:%03d", i), []byte("user")); err != nil {
+				return err
+			}
+			if err := txn.Set(fmt.Sprintf("order:%03d", i), []byte("order")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// wait for immutables to be flushed
+	time.Sleep(time.Second * 1)
+
+	// txn began before the drop still sees the keys
+	before := db.Begin(false)
+
+	assert.ErrorIs(t, db.DropPrefix(""), ErrEmptyKey)
+	assert.NoError(t, db.DropPrefix("user:"))
+
+	val, found := before.Get("user:001")
+	assert.True(t, found)
+	assert.Equal(t, []byte("user"), val)
+	before.Discard()
+
+	// keys written after the drop are kept
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("user:new", []byte("new"))
+	})
+	assert.NoError(t, err)
+
+	check := func(db *DB) {
+		err := db.View(func(txn *Txn) error {
+			for i := range 200 {
+				_, found := txn.Get(fmt.Sprintf("user:%03d", i))
+				assert.False(t, found)
+				val, found := txn.Get(fmt.Sprintf("order:%03d", i))
+				assert.True(t, found)
+				assert.Equal(t, []byte("order"), val)
+			}
+			exists, err := txn.Exists("user:100")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			val, found := txn.Get("user:new")
+			assert.True(t, found)
+			assert.Equal(t, []byte("new"), val)
+
+			kvs, _ := txn.ScanLimit("user:", "user;", 0)
+			assert.Len(t, kvs, 1)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check(db)
+
+	// drop is recovered after reopen
+	dropTs := db.manager.drops[0].ts
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+
+	assert.Greater(t, db.oracle.nextTs, dropTs)
+	assert.Len(t, db.manager.drops, 1)
+	check(db)
+
+	// compaction removes dropped versions once no txn began before the drop is active
+	assert.NoError(t, db.View(func(txn *Txn) error { return nil }))
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), dropTs))
+
+	db.triggerCompaction()
+	for _, entry := range db.manager.scan(types.KeyWithTs("", math.MaxUint64), types.KeyWithTs("~", math.MaxUint64)) {
+		key := types.ParseKey(entry.Key)
+		assert.False(t, strings.HasPrefix(key, "user:") && key != "user:new")
+	}
+	check(db)
+
+	// then the drop is pruned, no memtable or sstable holds a version it drops
+	assert.Empty(t, db.manager.drops)
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Empty(t, db.manager.drops)
+	assert.Greater(t, db.oracle.nextTs, dropTs)
+	check(db)
+}
+
+func TestRecoverCorruptedDropFile(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	assert.NoError(t, db.DropPrefix("user:"))
+	db.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, _dropFile))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, _dropFile), data[:len(data)-1], 0o644))
+
+	// open fails instead of panicking, repair quarantines the drop file
+	_, err = Open(dir, config)
+	assert.ErrorIs(t, err, ErrCorruptedDropFile)
+
+	db, err = Repair(dir, config)
+	assert.NoError(t, err)
+	assert.Empty(t, db.manager.drops)
+	db.Close()
+}
+
+func TestDecodeDrops(t *testing.T) {
+	drops := []prefixDrop{
+		{prefix: "user:", ts: 10},
+		{prefix: "order:", ts: 300},
+	}
+
+	decoded, err := decodeDrops(encodeDrops(drops))
+	assert.NoError(t, err)
+	assert.Equal(t, drops, decoded)
+
+	data := encodeDrops(drops)
+	_, err = decodeDrops(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrCorruptedDropFile)
+}
@@ -0,0 +1,295 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
+)
+
+// utils.Magic("B1NARY-GR0UP/originium/export")
+const _exportMagic uint64 = 0x093d46dd66713a56
+
+var (
+	ErrCorruptedExport = errors.New("export stream is corrupted")
+	ErrDirNotEmpty     = errors.New("db dir is not empty")
+	ErrNotImportMode   = errors.New("db is not opened by OpenForImport or Repair")
+)
+
+var _crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Export write all live entries of the default keyspace visible now to w in key order
+//
+// stream format, independent of the sstable format:
+// magic(8) | frame ... | end frame
+// frame: length(4) | crc32c of data(4) | data, data is an entry of base key, value and version encoded by thrift
+// end frame: length 0 and crc32c 0
+func (db *DB) Export(w io.Writer) error {
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+
+	txn := db.Begin(false)
+	defer txn.Discard()
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, _exportMagic); err != nil {
+		return err
+	}
+
+	for _, entry := range db.scanAll(txn.readTs) {
+		if entry.Tombstone {
+			continue
+		}
+		data, err := utils.TMarshal(&types.Entry{
+			Key:     types.ParseKey(entry.Key),
+			Value:   entry.Value,
+			Version: types.Version(types.ParseTs(entry.Key)),
+		})
+		if err != nil {
+			return err
+		}
+		if err = writeFrame(bw, data); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFrame(bw, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import build a new db in dir from a stream written by Export, dir must not exist or be empty
+// entries are written as sstables directly, then the db is opened with DefaultConfig.
+func Import(dir string, r io.Reader) (*DB, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, ErrDirNotEmpty
+	}
+
+	config := DefaultConfig
+	if err = config.validate(); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, config.DirMode); err != nil {
+		return nil, ErrMkDir
+	}
+
+	entries, err := readExport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		lm := newLevelManager(&DB{config: config}, dir)
+		if err = lm.ingest(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	return Open(dir, config)
+}
+
+// OpenForImport open a db like Open, in which ImportEntry may write entries at their own versions,
+// e.g. to replicate the exact MVCC state of another db. it is also allowed in a db opened by Repair.
+func OpenForImport(dir string, config Config) (*DB, error) {
+	db, err := open(dir, config, false)
+	if err != nil {
+		return nil, err
+	}
+	db.importing = true
+	return db, nil
+}
+
+// ImportEntry write e to the default keyspace at the version of e instead of a new commit ts,
+// e.Key is a user key and e.Version its commit ts, which nextTs is advanced past.
+// it breaks isolation, an entry at a version before the latest commit shows up in snapshots
+// of txns already reading past it, and it is not checked for conflicts with them,
+// so it is only allowed in a db opened by OpenForImport or Repair, ErrNotImportMode otherwise.
+func (db *DB) ImportEntry(e types.Entry) error {
+	switch {
+	case db.State() == StateClosed:
+		return ErrDBClosed
+	case !db.importing && !db.repair:
+		return ErrNotImportMode
+	case e.Key == "":
+		return ErrEmptyKey
+	}
+
+	if !e.Tombstone {
+		value, err := db.marshalValue(e.Value)
+		if err != nil {
+			return err
+		}
+		e.Value = value
+	}
+
+	orc := db.oracle
+
+	// an import is ordered with commits of txns
+	orc.writeLock.Lock()
+	defer orc.writeLock.Unlock()
+
+	// memtables are frozen by Close
+	if db.State() == StateClosed {
+		return ErrDBClosed
+	}
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	ts := types.Ts(e)
+	done, err := orc.importTs(ts)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	db.rawsetBatch(map[*CF][]types.Entry{nil: {{
+		Key:       types.KeyWithTs(e.Key, ts),
+		Value:     e.Value,
+		Tombstone: e.Tombstone,
+		Version:   e.Version,
+	}}}, ts, false)
+	db.rotateIfFull()
+	return nil
+}
+
+// Changes return all versions of the default keyspace committed after sinceTs, including tombstones,
+// e.g. for incremental replication, each change can be written to a replica by ImportEntry.
+// entries are ordered by key and then from new to old, e.Key is a user key and e.Version its commit ts,
+// values are decoded like reads of Txn. versions at or below the discard watermark may have been
+// removed by compaction, so ErrTsTooOld is returned if sinceTs is before it, a txn begun at sinceTs
+// and kept until Changes returns holds the watermark, e.g. the txn of the last replication.
+func (db *DB) Changes(sinceTs uint64) ([]types.Entry, error) {
+	if db.State() == StateClosed {
+		return nil, ErrDBClosed
+	}
+
+	// versions after sinceTs are kept by compaction while txn is active
+	txn, err := db.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Discard()
+
+	if sinceTs < db.oracle.discardAtOrBelow() {
+		return nil, ErrTsTooOld
+	}
+
+	// versions are merged from memtables and sstables as they are read
+	it, release := db.allVersions(&db.keyspace)
+	defer release()
+
+	var changes []types.Entry
+	for entry, ok := it.next(); ok; entry, ok = it.next() {
+		ts := types.ParseTs(entry.Key)
+		if ts <= sinceTs || ts > txn.readTs {
+			continue
+		}
+		entry = db.visible(entry, txn.readTs)
+		change := types.Entry{
+			Key:       types.ParseKey(entry.Key),
+			Tombstone: entry.Tombstone,
+			Version:   types.Version(ts),
+		}
+		if !entry.Tombstone {
+			if change.Value, err = db.unmarshalValue(entry.Value); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// readExport read entries of a stream written by Export, keys of entries are key@version
+func readExport(r io.Reader) ([]types.Entry, error) {
+	br := bufio.NewReader(r)
+
+	var magic uint64
+	if err := binary.Read(br, binary.LittleEndian, &magic); err != nil || magic != _exportMagic {
+		return nil, ErrCorruptedExport
+	}
+
+	var entries []types.Entry
+	for {
+		data, err := readFrame(br)
+		if err != nil {
+			return nil, err
+		}
+		// end frame
+		if data == nil {
+			return entries, nil
+		}
+
+		var entry types.Entry
+		if err = utils.TUnmarshal(data, &entry); err != nil {
+			return nil, ErrCorruptedExport
+		}
+		// keys are exported in order, each once
+		if len(entries) > 0 && types.ParseKey(entries[len(entries)-1].Key) >= entry.Key {
+			return nil, ErrCorruptedExport
+		}
+		entry.Key = types.KeyWithTs(entry.Key, types.Ts(entry))
+		entries = append(entries, entry)
+	}
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(data)))
+	if len(data) > 0 {
+		binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(data, _crcTable))
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame return data of a frame, nil data means the end frame
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, ErrCorruptedExport
+	}
+	n := binary.LittleEndian.Uint32(header[:4])
+	if n == 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrCorruptedExport
+	}
+	if crc32.Checksum(data, _crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, ErrCorruptedExport
+	}
+	return data, nil
+}
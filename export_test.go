@@ -0,0 +1,227 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// older versions in sstables, newer versions and deletes in memtable
+	err := db.Update(func(txn *Txn) error {
+		for i := range 300 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	err = db.Update(func(txn *Txn) error {
+		for i := 0; i < 300; i += 3 {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte("updated")); err != nil {
+				return err
+			}
+			if err := txn.Delete(fmt.Sprintf("key%04d", i+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, db.Export(&buf))
+	stream := buf.Bytes()
+
+	imported, err := Import(t.TempDir(), bytes.NewReader(stream))
+	assert.NoError(t, err)
+	defer imported.Close()
+
+	// identical reads, versions included
+	read := func(db *DB) []string {
+		var res []string
+		err := db.View(func(txn *Txn) error {
+			for i := range 300 {
+				val, version, found, err := txn.GetVersioned(fmt.Sprintf("key%04d", i))
+				assert.NoError(t, err)
+				res = append(res, fmt.Sprintf("%s %d %v", val, version, found))
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		return res
+	}
+	assert.Equal(t, read(db), read(imported))
+
+	// oracle of imported db continues after exported versions
+	err = imported.Update(func(txn *Txn) error {
+		return txn.Set("key0001", []byte("recreated"))
+	})
+	assert.NoError(t, err)
+	err = imported.View(func(txn *Txn) error {
+		val, found := txn.Get("key0001")
+		assert.True(t, found)
+		assert.Equal(t, []byte("recreated"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// dir must be empty
+	_, err = Import(imported.dir, bytes.NewReader(stream))
+	assert.Equal(t, ErrDirNotEmpty, err)
+
+	// corrupted or truncated stream
+	corrupted := bytes.Clone(stream)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = Import(t.TempDir(), bytes.NewReader(corrupted))
+	assert.Equal(t, ErrCorruptedExport, err)
+	_, err = Import(t.TempDir(), bytes.NewReader(stream[:len(stream)-8]))
+	assert.Equal(t, ErrCorruptedExport, err)
+}
+
+func TestImportEntry(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, db.ImportEntry(types.Entry{Key: "key", Value: []byte("v"), Version: 5}), ErrNotImportMode)
+	db.Close()
+
+	db, err = OpenForImport(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// versions of other dbs, out of order
+	entries := []types.Entry{
+		{Key: "a", Value: []byte("a10"), Version: 10},
+		{Key: "a", Value: []byte("a5"), Version: 5},
+		{Key: "b", Value: []byte("b7"), Version: 7},
+		{Key: "a", Tombstone: true, Version: 12},
+	}
+	for _, e := range entries {
+		assert.NoError(t, db.ImportEntry(e))
+	}
+	assert.ErrorIs(t, db.ImportEntry(types.Entry{Value: []byte("v"), Version: 1}), ErrEmptyKey)
+	assert.Equal(t, uint64(13), db.oracle.nextTs)
+
+	get := func(ts uint64, key string) ([]byte, bool) {
+		var value []byte
+		var ok bool
+		assert.NoError(t, db.ViewAt(ts, func(txn *Txn) error {
+			value, ok = txn.Get(key)
+			return nil
+		}))
+		return value, ok
+	}
+	// each version is read back at its ts, in increasing ts as reads move the discard watermark
+	tests := []struct {
+		ts    uint64
+		key   string
+		value []byte
+	}{
+		{4, "a", nil},
+		{5, "a", []byte("a5")},
+		{6, "b", nil},
+		{7, "a", []byte("a5")},
+		{7, "b", []byte("b7")},
+		{10, "a", []byte("a10")},
+		{12, "a", nil},
+		{12, "b", []byte("b7")},
+	}
+	for _, tt := range tests {
+		value, ok := get(tt.ts, tt.key)
+		assert.Equal(t, tt.value != nil, ok, tt)
+		assert.Equal(t, tt.value, value, tt)
+	}
+	assert.ErrorIs(t, db.ViewAt(13, func(*Txn) error { return nil }), ErrFutureTs)
+	// versions below reads done may be discarded
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), 12))
+	assert.ErrorIs(t, db.ViewAt(4, func(*Txn) error { return nil }), ErrTsTooOld)
+
+	// commits continue after the imported versions
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("a", []byte("a13"))
+	})
+	assert.NoError(t, err)
+	value, ok := get(13, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a13"), value)
+}
+
+func TestChanges(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	commit := func(fn func(txn *Txn) error) uint64 {
+		assert.NoError(t, db.Update(fn))
+		return db.oracle.nextTs - 1
+	}
+	set := func(keys ...string) func(txn *Txn) error {
+		return func(txn *Txn) error {
+			for _, key := range keys {
+				if err := txn.Set(key, []byte(key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	commit(set("a", "b", "c"))
+	commit(set("a", "d"))
+	// older batches are in sstables
+	assert.NoError(t, db.Sync())
+
+	// a replica has read up to mid, its txn holds the discard watermark
+	mid := db.Begin(false)
+	ts3 := commit(set("b", "e"))
+	ts4 := commit(func(txn *Txn) error {
+		return errors.Join(txn.Delete("a"), txn.Set("b", []byte("b")))
+	})
+	assert.NoError(t, db.Sync())
+	ts5 := commit(set("c"))
+
+	changes, err := db.Changes(mid.readTs)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Entry{
+		{Key: "a", Tombstone: true, Version: types.Version(ts4)},
+		{Key: "b", Value: []byte("b"), Version: types.Version(ts4)},
+		{Key: "b", Value: []byte("b"), Version: types.Version(ts3)},
+		{Key: "c", Value: []byte("c"), Version: types.Version(ts5)},
+		{Key: "e", Value: []byte("e"), Version: types.Version(ts3)},
+	}, changes)
+
+	changes, err = db.Changes(ts5)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// versions before the watermark may be gone
+	mid.Discard()
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), ts5-1))
+	_, err = db.Changes(ts3)
+	assert.ErrorIs(t, err, ErrTsTooOld)
+}
@@ -0,0 +1,277 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+
+	"github.com/B1NARY-GR0UP/originium/pkg/kway"
+	"github.com/B1NARY-GR0UP/originium/table"
+	"github.com/B1NARY-GR0UP/originium/types"
+)
+
+// entryIterator iterate entries in types.CompareKeys order, entries are read lazily as the iterator advances
+type entryIterator interface {
+	// next return the next entry, false once the iterator is exhausted
+	next() (types.Entry, bool)
+}
+
+// iterate return an iterator of the newest version visible at readTs of each key in [start, end) of ks,
+// tombstones included. call release once done, sstables of the iterator are not deleted until then.
+func (db *DB) iterate(ks *keyspace, start, end string, readTs uint64) (it entryIterator, release func()) {
+	it, release = db.versions(ks, start, end)
+	return &visibleIterator{
+		ks:     ks,
+		it:     it,
+		readTs: readTs,
+	}, release
+}
+
+// versions return an iterator of all versions of each key in [start, end) of ks, versions are ordered new -> old,
+// tombstones and versions dropped by DropPrefix included. call release once done like iterate.
+func (db *DB) versions(ks *keyspace, start, end string) (it entryIterator, release func()) {
+	return db.versionsFrom(ks, start, end, true)
+}
+
+// allVersions return an iterator of all versions of all keys of ks like versions
+func (db *DB) allVersions(ks *keyspace) (it entryIterator, release func()) {
+	return db.versionsFrom(ks, "", "", false)
+}
+
+// versionsFrom return an iterator of all versions of each key >= start of ks, and < end if bounded
+func (db *DB) versionsFrom(ks *keyspace, start, end string, bounded bool) (it entryIterator, release func()) {
+	// all versions of a key are in [key@MaxUint64, nextKey@MaxUint64), an empty high is no bound
+	low := types.KeyWithTs(start, math.MaxUint64)
+	var high types.Key
+	if bounded {
+		high = types.KeyWithTs(end, math.MaxUint64)
+	}
+
+	// an immutable is only removed once its sstable is published, so it is in either memtables or levels
+	db.mu.RLock()
+	its := []entryIterator{&memtableIterator{mt: ks.memtable, key: low, end: high}}
+	for e := ks.immutables.Back(); e != nil; e = e.Prev() {
+		its = append(its, &memtableIterator{mt: e.Value.(*memtable), key: low, end: high})
+	}
+	levels := ks.manager.acquire()
+	db.mu.RUnlock()
+
+	ks.manager.amp.addReads(1)
+	// prefetchers of table iterators are stopped before sstables are released
+	p := &prefetch{
+		depth: ks.manager.prefetch,
+		stop:  make(chan struct{}),
+	}
+	// tables without keys of the prefix of a prefix scan are skipped
+	prefix, pruning := scanPrefix(start, end, ks.manager.prefixLen)
+	for level, tables := range levels {
+		for _, th := range tables {
+			if pruning && !th.mayHavePrefix(prefix) {
+				continue
+			}
+			if handles := scanIndex(th.dataBlockIndex, low, high); len(handles) > 0 {
+				its = append(its, &tableIterator{
+					lm:      ks.manager,
+					level:   level,
+					th:      th,
+					start:   low,
+					end:     high,
+					handles: handles,
+					p:       p,
+				})
+			}
+		}
+	}
+
+	return newMergeIterator(its), func() {
+		p.close()
+		ks.manager.release()
+	}
+}
+
+// scanIndex return handles of data blocks of index overlapping [start, end), an empty end is no bound
+func scanIndex(index table.Index, start, end types.Key) []table.BlockHandle {
+	if end != "" {
+		return index.Scan(start, end)
+	}
+	var res []table.BlockHandle
+	for _, entry := range index.Entries {
+		if types.CompareKeys(entry.EndKey, start) >= 0 {
+			res = append(res, entry.DataHandle)
+		}
+	}
+	return res
+}
+
+// memtableIterator iterate entries of a memtable in [key, end) by one lower bound per entry,
+// nothing is copied ahead of the reader. an empty end is no bound.
+type memtableIterator struct {
+	mt  *memtable
+	key types.Key
+	end types.Key
+}
+
+func (it *memtableIterator) next() (types.Entry, bool) {
+	entry, ok := it.mt.lowerBound(it.key)
+	if !ok || it.end != "" && types.CompareKeys(entry.Key, it.end) >= 0 {
+		return types.Entry{}, false
+	}
+	it.key = nextKey(entry.Key)
+	return entry, true
+}
+
+// tableIterator iterate entries of a sstable in [start, end), an empty end is no bound, a data block is fetched once the previous one is done,
+// or up to Config.ScanPrefetchBlocks blocks ahead of the reader by a background goroutine
+type tableIterator struct {
+	lm    *levelManager
+	level int
+	th    tableHandle
+	start types.Key
+	end   types.Key
+	// data blocks not fetched yet
+	handles []table.BlockHandle
+	// entries of the current data block not returned yet
+	entries []types.Entry
+	p       *prefetch
+	// entries of data blocks fetched ahead, nil until prefetch starts
+	prefetched chan []types.Entry
+}
+
+func (it *tableIterator) next() (types.Entry, bool) {
+	for len(it.entries) == 0 {
+		if it.prefetched != nil {
+			entries, ok := <-it.prefetched
+			if !ok {
+				return types.Entry{}, false
+			}
+			it.entries = entries
+			continue
+		}
+		if len(it.handles) == 0 {
+			return types.Entry{}, false
+		}
+		it.entries = it.lm.fetchAndScan(it.start, it.end, it.level, it.th, it.handles[0])
+		it.handles = it.handles[1:]
+		// the first block is fetched by the reader, so a scan reading only a few entries fetches no more
+		if it.p != nil && it.p.depth > 0 && len(it.handles) > 0 {
+			it.prefetched = it.p.start(it)
+		}
+	}
+	entry := it.entries[0]
+	it.entries = it.entries[1:]
+	return entry, true
+}
+
+// prefetch fetch data blocks of table iterators of a scan ahead of the reader, at most depth blocks per table
+type prefetch struct {
+	depth int
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// start fetch the remaining data blocks of it in background, the returned channel is closed once they are all fetched
+func (p *prefetch) start(it *tableIterator) chan []types.Entry {
+	c := make(chan []types.Entry, p.depth)
+	handles := it.handles
+	it.handles = nil
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(c)
+		for _, handle := range handles {
+			entries := it.lm.fetchAndScan(it.start, it.end, it.level, it.th, handle)
+			select {
+			case c <- entries:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// close stop prefetching and wait for it, sstables are in use until then
+func (p *prefetch) close() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// mergeIterator merge iterators into one, a version in more than one of them is returned once,
+// e.g. it is in a memtable and the sstable it is being flushed to.
+type mergeIterator struct {
+	its  []entryIterator
+	h    *kway.Heap
+	last types.Key
+}
+
+func newMergeIterator(its []entryIterator) *mergeIterator {
+	m := &mergeIterator{
+		its: its,
+		h:   &kway.Heap{},
+	}
+	for i, it := range its {
+		if entry, ok := it.next(); ok {
+			heap.Push(m.h, kway.Element{Entry: entry, LI: i})
+		}
+	}
+	return m
+}
+
+func (m *mergeIterator) next() (types.Entry, bool) {
+	for m.h.Len() > 0 {
+		e := heap.Pop(m.h).(kway.Element)
+		if entry, ok := m.its[e.LI].next(); ok {
+			heap.Push(m.h, kway.Element{Entry: entry, LI: e.LI})
+		}
+		// internal keys are never empty
+		if e.Key == m.last {
+			continue
+		}
+		m.last = e.Key
+		return e.Entry, true
+	}
+	return types.Entry{}, false
+}
+
+// visibleIterator return the newest version of each key visible at readTs, versions are ordered new -> old
+type visibleIterator struct {
+	ks     *keyspace
+	it     entryIterator
+	readTs uint64
+	// user key returned last
+	last    types.Key
+	started bool
+}
+
+func (v *visibleIterator) next() (types.Entry, bool) {
+	for {
+		entry, ok := v.it.next()
+		if !ok {
+			return types.Entry{}, false
+		}
+		if types.ParseTs(entry.Key) > v.readTs {
+			continue
+		}
+		key := types.ParseKey(entry.Key)
+		if v.started && key == v.last {
+			continue
+		}
+		v.last, v.started = key, true
+		return v.ks.visible(entry, v.readTs), true
+	}
+}
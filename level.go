@@ -15,534 +15,1873 @@
 package originium
 
 import (
+	"cmp"
 	"container/list"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/filter"
 	"github.com/B1NARY-GR0UP/originium/pkg/kway"
 	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/pkg/ratelimit"
 	"github.com/B1NARY-GR0UP/originium/table"
 	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/B1NARY-GR0UP/originium/utils"
 )
 
+const (
+	_dbExt  = ".db"
+	_tmpExt = ".tmp"
+)
+
+// _quarantineDir sub dir of sstables recover can not parse or decode
+const _quarantineDir = "quarantine"
+
+// _footerSize size of encoded table.Footer
+const _footerSize = table.FooterSize
+
+// ErrUnsortedEntries entries to be flushed are not strictly sorted, e.g. two entries of the same key and ts,
+// which means a bug of ts assignment, since a key is written at most once at a commit ts
+var ErrUnsortedEntries = errors.New("entries are not strictly sorted")
+
+// ErrVersionMismatch ts of an internal key to be flushed differs from its Entry.Version, which are written
+// independently by commit, sstables store Entry.Version while compaction discards stale versions by the key.
+// it is only checked if Config.DebugChecks is set.
+var ErrVersionMismatch = errors.New("version of entry does not match ts of its key")
+
+// ErrOverlappingTables two sstables of a level >= 1 hold overlapping user key ranges under Leveled compaction,
+// lookups search at most one sstable of such a level, so a version in the other one may be missed.
+var ErrOverlappingTables = errors.New("sstables of a level overlap")
+
+// size-tiered compaction buckets tables whose size is within [avg*_bucketLow, avg*_bucketHigh]
+const (
+	_bucketLow  = 0.5
+	_bucketHigh = 1.5
+)
+
 type levelManager struct {
+	// serialize flush and compaction, which modify levels and publish a new snapshot of them
 	mu sync.Mutex
+	// searches hold read lock, sstables are deleted by compaction with write lock
+	filesMu sync.RWMutex
 
 	dir           string
 	l0TargetNum   int
 	ratio         int
 	dataBlockSize int
-
-	// list.Element: tableHandle
+	// data block size of each level, see Config.LevelDataBlockByteThreshold
+	levelDataBlockSizes []int
+	// max size of sstable built by compaction, 0 means unlimited
+	targetFileSize int
+	filterP        []float64
+	// length of key prefixes of prefix bloom filters, 0 means none, see Config.PrefixBloomFilterLen
+	prefixLen int
+	// number of data blocks fetched ahead of a scan per sstable, 0 means none, see Config.ScanPrefetchBlocks
+	prefetch int
+	// compression level of each level, see Config.CompressionLevel
+	compression []utils.CompressionLevel
+	filterSeed  uint32
+	// limiter of sstables written by compaction, nil if unlimited
+	limiter  *ratelimit.Limiter
+	strategy CompactionStrategy
+	// trigger of Leveled compaction and target bytes of L1 under TriggerByBytes
+	trigger       CompactionTrigger
+	l1TargetBytes uint64
+	// max number of levels including L0, 0 means unlimited
+	maxLevels int
+	// tables of keys with these prefixes are compacted into the next level after others, see Config.HotPrefixes
+	hotPrefixes []string
+	// newest versions of each key kept by compaction, see Config.NumVersionsToKeep
+	numVersions int
+	filter      func(key string, value []byte, version uint64) bool
+	metrics     metrics.Recorder
+	// verify entries before flush, see Config.DebugChecks
+	debug bool
+	// state of bottom levels after they were last merged within themselves, see mergeable, protected by mu
+	merged map[int]bottomMerge
+	// column family of the keyspace, empty for the default one
+	family string
+	// callbacks of flushes and compactions, see Config.OnFlush and Config.OnCompaction
+	onFlush      func(level int, table TableInfo)
+	onCompaction func(inputs, outputs []TableInfo)
+	// callbacks of flushes and compactions done under mu, called by notify once it is released, protected by mu
+	events []func()
+
+	// list.Element: tableHandle, protected by mu
 	levels []*list.List
-	logger logger.Logger
+	// immutable copy of levels searched by readers, swapped by publish
+	snapshot atomic.Pointer[[][]tableHandle]
+	logger   logger.Logger
+
+	// bytes of sstables written by flush and compaction
+	written uint64
+	// bytes written and data blocks read of each level, for amplification of Stats
+	amp amplification
+
+	// sstables are read from fsys instead of dir if set, nothing is written to it
+	fsys fs.FS
+
+	// prefixes dropped by DropPrefix, protected by dropMu
+	dropMu sync.RWMutex
+	drops  []prefixDrop
 
 	db *DB
 }
 
+// amplification counters of a levelManager, write amplification is bytes of sstables written per byte of user data,
+// read amplification is data blocks read per lookup or scan of sstables
+type amplification struct {
+	mu sync.Mutex
+	// encoded size of entries flushed from memtables or ingested
+	userBytes uint64
+	// bytes of sstables written to each level by flush, compaction and ingest
+	levelWriteBytes []uint64
+	// lookups and scans of sstables, each key of a multi-key lookup is one
+	reads uint64
+	// data blocks read from each level by lookups and scans, blocks read by compaction are excluded
+	levelBlockReads []uint64
+}
+
+func (a *amplification) addUser(entries []types.Entry) {
+	var n uint64
+	for _, entry := range entries {
+		n += uint64(types.EncodedSize(entry))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.userBytes += n
+}
+
+func (a *amplification) addWrite(level int, n uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for len(a.levelWriteBytes) <= level {
+		a.levelWriteBytes = append(a.levelWriteBytes, 0)
+	}
+	a.levelWriteBytes[level] += n
+}
+
+func (a *amplification) addReads(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reads += uint64(n)
+}
+
+func (a *amplification) addBlockRead(level int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for len(a.levelBlockReads) <= level {
+		a.levelBlockReads = append(a.levelBlockReads, 0)
+	}
+	a.levelBlockReads[level]++
+}
+
+// addTo add counters to stats, per-level counters are summed by level
+func (a *amplification) addTo(stats *Stats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats.UserBytes += a.userBytes
+	stats.Reads += a.reads
+	for len(stats.LevelWriteBytes) < len(a.levelWriteBytes) {
+		stats.LevelWriteBytes = append(stats.LevelWriteBytes, 0)
+	}
+	for level, n := range a.levelWriteBytes {
+		stats.LevelWriteBytes[level] += n
+		stats.WriteBytes += n
+	}
+	for len(stats.LevelBlockReads) < len(a.levelBlockReads) {
+		stats.LevelBlockReads = append(stats.LevelBlockReads, 0)
+	}
+	for level, n := range a.levelBlockReads {
+		stats.LevelBlockReads[level] += n
+		stats.BlockReads += n
+	}
+}
+
 type tableHandle struct {
 	// list index of table within a level
 	levelIdx int
 	// bloom filter
 	filter filter.Filter
+	// bloom filter of key prefixes, nil without Config.PrefixBloomFilterLen
+	prefixFilter *filter.Filter
 	// index of data blocks in this sstable
 	dataBlockIndex table.Index
+	// size of sstable file in bytes
+	size uint64
+	// meta block of sstable
+	meta table.Meta
+	// discardAtOrBelow the entries were compacted at, versions dropped at or below it are gone,
+	// 0 if the sstable is flushed, ingested or recovered
+	discarded uint64
+}
+
+// newTableHandle return handle of sstable idx just built by table.Build, pf is its prefix bloom filter or nil
+func (lm *levelManager) newTableHandle(idx int, bf, pf *filter.Filter, dataBlockIndex table.Index, tableBytes []byte) tableHandle {
+	meta, err := table.ReadMeta(tableBytes)
+	if err != nil {
+		lm.logger.Panicf("failed to read meta of built sstable: %v", err)
+	}
+	return tableHandle{
+		levelIdx:       idx,
+		filter:         *bf,
+		prefixFilter:   pf,
+		dataBlockIndex: dataBlockIndex,
+		size:           uint64(len(tableBytes)),
+		meta:           meta,
+	}
 }
 
-func newLevelManager(db *DB) *levelManager {
+func newLevelManager(db *DB, dir string) *levelManager {
 	return &levelManager{
-		dir:           db.dir,
-		l0TargetNum:   db.config.L0TargetNum,
-		ratio:         db.config.LevelRatio,
-		dataBlockSize: db.config.DataBlockByteThreshold,
-		logger:        logger.GetLogger(),
-		db:            db,
+		dir:                 dir,
+		l0TargetNum:         db.config.L0TargetNum,
+		ratio:               db.config.LevelRatio,
+		dataBlockSize:       db.config.DataBlockByteThreshold,
+		levelDataBlockSizes: db.config.LevelDataBlockByteThreshold,
+		targetFileSize:      db.config.TargetFileSize,
+		filterP:             db.config.BloomFilterP,
+		prefixLen:           db.config.PrefixBloomFilterLen,
+		prefetch:            db.config.ScanPrefetchBlocks,
+		compression:         levelCompressions(db.config),
+		filterSeed:          db.filterSeed,
+		limiter:             db.compactionLimiter,
+		strategy:            db.config.CompactionStrategy,
+		trigger:             db.config.CompactionTrigger,
+		l1TargetBytes:       uint64(db.config.L1TargetBytes),
+		maxLevels:           db.config.MaxLevels,
+		hotPrefixes:         db.config.HotPrefixes,
+		numVersions:         db.config.NumVersionsToKeep,
+		filter:              db.config.CompactionFilter,
+		metrics:             db.config.Metrics,
+		debug:               db.config.DebugChecks,
+		onFlush:             db.config.OnFlush,
+		onCompaction:        db.config.OnCompaction,
+		logger:              db.logger,
+		db:                  db,
 	}
 }
 
-func (lm *levelManager) recover() int64 {
+// recover load sstables in dir, return max version of them
+// a file not named like a sstable is quarantined, a sstable which can not be recovered fails recover,
+// e.g. it is damaged or can not be read, unless db is opened by Repair, which quarantines it instead.
+// a sstable of an unsupported format always fails recover, it is not damaged.
+func (lm *levelManager) recover() (uint64, error) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 	defer utils.Elapsed(time.Now(), lm.logger, "level index recover")
 
-	files, err := os.ReadDir(lm.dir)
+	files, err := fs.ReadDir(lm.tables(), ".")
 	if err != nil {
 		lm.logger.Panicf("read dir %v failed: %v", lm.dir, err)
 	}
 
 	var dbFiles []string
 	for _, file := range files {
-		if !file.IsDir() && path.Ext(file.Name()) == ".db" {
+		if file.IsDir() {
+			continue
+		}
+		switch path.Ext(file.Name()) {
+		case _dbExt:
 			dbFiles = append(dbFiles, file.Name())
+		case _tmpExt:
+			if lm.fsys != nil {
+				continue
+			}
+			// sstable or drop file not completely written before crash
+			lm.logger.Warnf("remove incomplete file %s", file.Name())
+			if err = os.Remove(path.Join(lm.dir, file.Name())); err != nil {
+				lm.logger.Errorf("failed to remove incomplete file %s: %v", file.Name(), err)
+			}
 		}
 	}
 
+	// recover prefixes dropped by DropPrefix, ts of a drop is a version of db as well
+	maxVersion, err := lm.recoverDrops()
+	if err != nil {
+		return 0, err
+	}
+
 	if len(dbFiles) == 0 {
-		return 0
+		return maxVersion, nil
 	}
 
 	slices.Sort(dbFiles)
 
-	var maxVersion int64
-
+	repair := lm.db != nil && lm.db.repair
 	for _, file := range dbFiles {
-		level, idx, err := parseFileName(file)
-		if err != nil {
-			lm.logger.Panicf("failed to parse file name %s: %v", file, err)
+		// a stray file does not prevent opening the db
+		if _, _, err = parseFileName(file); err != nil {
+			lm.logger.Warnf("skip stray file %s: %v", file, err)
+			lm.quarantine(file)
+			continue
 		}
 
-		fd, err := os.Open(path.Join(lm.dir, file))
-		if err != nil {
-			lm.logger.Panicf("failed to open file %s: %v", file, err)
+		level, th, version, err := lm.recoverTable(file)
+		if errors.Is(err, table.ErrUnsupportedFormat) {
+			return 0, fmt.Errorf("sstable %s: %w", file, err)
 		}
-
-		// read and decode footer
-		_, err = fd.Seek(-40, io.SeekEnd)
-		if err != nil {
-			lm.logger.Panicf("failed to seek footer: %v", err)
+		if err != nil && repair {
+			lm.logger.Warnf("skip sstable %s: %v", file, err)
+			lm.quarantine(file)
+			continue
 		}
-
-		footerBytes := make([]byte, 40)
-		_, err = fd.Read(footerBytes)
 		if err != nil {
-			lm.logger.Panicf("failed to read footer: %v", err)
+			return 0, fmt.Errorf("failed to recover sstable %s: %w", file, err)
 		}
+		maxVersion = max(maxVersion, version)
 
-		var footer table.Footer
-		if err = footer.Decode(footerBytes); err != nil {
-			lm.logger.Panicf("failed to decode footer: %v", err)
+		for len(lm.levels) <= level {
+			lm.levels = append(lm.levels, list.New())
 		}
+		lm.levels[level].PushBack(th)
+	}
 
-		// read and decode index block
-		_, err = fd.Seek(int64(footer.IndexBlock.Offset), io.SeekStart)
-		if err != nil {
-			lm.logger.Panicf("failed to seek index: %v", err)
-		}
+	lm.publish()
+	return maxVersion, nil
+}
 
-		indexBytes := make([]byte, footer.IndexBlock.Length)
-		_, err = fd.Read(indexBytes)
-		if err != nil {
-			lm.logger.Panicf("failed to read index: %v", err)
-		}
+// recoverTable read index and entries of sstable file, return its level, handle and max version of entries
+func (lm *levelManager) recoverTable(file string) (int, tableHandle, uint64, error) {
+	level, idx, err := parseFileName(file)
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to parse file name: %w", err)
+	}
 
-		var index table.Index
-		if err = index.Decode(indexBytes); err != nil {
-			lm.logger.Panicf("failed to decode index: %v", err)
+	fd, err := lm.openTable(file)
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := fd.Close(); err != nil {
+			lm.logger.Errorf("failed to close file: %v", err)
 		}
+	}()
 
-		// read and decode data blocks
-		_, err = fd.Seek(int64(index.DataBlock.Offset), io.SeekStart)
-		if err != nil {
-			lm.logger.Panicf("failed to seek data block: %v", err)
-		}
+	info, err := fd.Stat()
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() < _footerSize {
+		return 0, tableHandle{}, 0, fmt.Errorf("file of %d bytes is smaller than footer", info.Size())
+	}
 
-		dataBlockBytes := make([]byte, index.DataBlock.Length)
-		_, err = fd.Read(dataBlockBytes)
-		if err != nil {
-			lm.logger.Panicf("failed to read data block: %v", err)
-		}
+	// read and decode footer
+	footerBytes, err := readBlock(fd, table.BlockHandle{
+		Offset: uint64(info.Size() - _footerSize),
+		Length: _footerSize,
+	})
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to read footer: %w", err)
+	}
 
-		var dataBlock table.Data
-		if err = dataBlock.Decode(dataBlockBytes); err != nil {
-			lm.logger.Panicf("failed to decode data block: %v", err)
-		}
+	var footer table.Footer
+	if err = footer.Decode(footerBytes); err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to decode footer: %w", err)
+	}
 
-		// record max version
-		for _, entry := range dataBlock.Entries {
-			maxVersion = max(maxVersion, entry.Version)
-		}
+	// read and decode meta block
+	if !inFile(footer.MetaBlock, info.Size()) {
+		return 0, tableHandle{}, 0, fmt.Errorf("meta block out of file: %w", table.ErrCorruptedBlock)
+	}
+	metaBytes, err := readBlock(fd, footer.MetaBlock)
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to read meta: %w", err)
+	}
 
-		// build bloom filter
-		bf := filter.Build(dataBlock.Entries)
+	var meta table.Meta
+	if err = meta.Decode(metaBytes); err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to decode meta: %w", err)
+	}
+	if err = meta.CheckVersion(); err != nil {
+		return 0, tableHandle{}, 0, err
+	}
 
-		for len(lm.levels) <= level {
-			lm.levels = append(lm.levels, list.New())
-		}
+	// read and decode index block
+	if !inFile(footer.IndexBlock, info.Size()) {
+		return 0, tableHandle{}, 0, fmt.Errorf("index block out of file: %w", table.ErrCorruptedBlock)
+	}
+	indexBytes, err := readBlock(fd, footer.IndexBlock)
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to read index: %w", err)
+	}
 
-		th := tableHandle{
-			levelIdx:       idx,
-			filter:         *bf,
-			dataBlockIndex: index,
-		}
+	var index table.Index
+	if err = index.DecodeVersion(indexBytes, meta.Version); err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to decode index: %w", err)
+	}
 
-		lm.levels[level].PushBack(th)
+	// read and decode data blocks
+	if !inFile(index.DataBlock, info.Size()) {
+		return 0, tableHandle{}, 0, fmt.Errorf("data blocks out of file: %w", table.ErrCorruptedBlock)
+	}
+	dataBlockBytes, err := readBlock(fd, index.DataBlock)
+	if err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to read data block: %w", err)
+	}
+
+	var dataBlock table.Data
+	if err = dataBlock.DecodeVersion(dataBlockBytes, meta.Version); err != nil {
+		return 0, tableHandle{}, 0, fmt.Errorf("failed to decode data block: %w", err)
+	}
+	if len(dataBlock.Entries) == 0 {
+		return 0, tableHandle{}, 0, fmt.Errorf("no entry: %w", table.ErrCorruptedBlock)
+	}
+
+	// record max version
+	var maxVersion uint64
+	for _, entry := range dataBlock.Entries {
+		maxVersion = max(maxVersion, types.Ts(entry))
+	}
+
+	// sstable written before format version 6 has no number of entries in meta block
+	if meta.NumEntries == 0 {
+		meta.NumEntries = uint64(len(dataBlock.Entries))
+	}
+
+	// build bloom filters
+	bf := filter.BuildWithSeed(dataBlock.Entries, lm.levelFilterP(level), lm.filterSeed)
+
+	return level, tableHandle{
+		levelIdx:       idx,
+		filter:         *bf,
+		prefixFilter:   lm.buildPrefixFilter(dataBlock.Entries, level),
+		dataBlockIndex: index,
+		size:           uint64(info.Size()),
+		meta:           meta,
+	}, maxVersion, nil
+}
+
+// quarantine move file that can not be recovered to the quarantine dir instead of deleting it,
+// so it can be inspected or restored by hand
+func (lm *levelManager) quarantine(file string) {
+	// nothing is written to fsys
+	if lm.fsys != nil {
+		return
+	}
+	mode := DefaultConfig.DirMode
+	if lm.db != nil {
+		mode = lm.db.config.DirMode
 	}
+	dir := path.Join(lm.dir, _quarantineDir)
+	if err := os.MkdirAll(dir, mode); err != nil {
+		lm.logger.Errorf("failed to create quarantine dir: %v", err)
+		return
+	}
+	if err := os.Rename(path.Join(lm.dir, file), path.Join(dir, file)); err != nil {
+		lm.logger.Errorf("failed to quarantine %s: %v", file, err)
+	}
+}
 
-	return maxVersion
+// inFile report whether block handle is within a file of size
+func inFile(handle table.BlockHandle, size int64) bool {
+	return handle.Offset <= uint64(size) && handle.Length <= uint64(size)-handle.Offset
 }
 
 func (lm *levelManager) searchLowerBound(key types.Key) (types.Entry, bool) {
-	lm.mu.Lock()
-	defer lm.mu.Unlock()
+	levels := lm.acquire()
+	defer lm.release()
+	lm.amp.addReads(1)
 
-	if len(lm.levels) == 0 {
+	if len(levels) == 0 {
 		return types.Entry{}, false
 	}
 
-	for level, tables := range lm.levels {
-		for e := tables.Front(); e != nil; e = e.Next() {
-			th := e.Value.(tableHandle)
+	var res types.Entry
+	var found bool
+	for level, tables := range levels {
+		for _, th := range tables {
 
-			// search bloom filter
+			// search bloom filter with base key
 			if !th.filter.Contains(types.ParseKey(key)) {
+				lm.recorder().Add(metrics.BloomTrueNegatives, 1)
 				// not in this sstable, search next one
 				continue
 			}
 
-			// determine which data block the key is in
-			dataBlockHandle, ok := th.dataBlockIndex.Search(key)
+			// determine which data block the lower bound of key is in
+			dataBlockHandle, ok := th.dataBlockIndex.LowerBound(key)
 			if !ok {
+				lm.countFalsePositive(th, key, dataBlockHandle, nil)
 				// not in this sstable, search next one
 				continue
 			}
 
 			// in this sstable, search according to data block
-			entry, ok := lm.fetchAndSearchLowerBound(key, level, th.levelIdx, dataBlockHandle)
-			if ok {
-				return entry, true
+			dataBlock := lm.fetch(level, th, dataBlockHandle)
+			lm.amp.addBlockRead(level)
+			entry, ok := dataBlock.LowerBound(key)
+			if ok && types.IsSameKey(key, entry.Key) {
+				if !lm.overlapping() {
+					return entry, true
+				}
+				if !found || types.CompareKeys(entry.Key, res.Key) < 0 {
+					res, found = entry, true
+				}
+				continue
+			}
+			// lower bound is another key, the key may be in next sstable
+			lm.countFalsePositive(th, key, dataBlockHandle, &dataBlock)
+		}
+	}
+
+	return res, found
+}
+
+// countFalsePositive count a false positive of the bloom filter of th if th has no version of key at all,
+// the lower bound of key in th is another key, in block of handle, or none if block is nil.
+// versions of key newer than its ts are not visible but still make the filter right,
+// they are sorted right before the lower bound.
+func (lm *levelManager) countFalsePositive(th tableHandle, key types.Key, handle table.BlockHandle, block *table.Data) {
+	newest := types.KeyWithTs(types.ParseKey(key), math.MaxUint64)
+	newestHandle, ok := th.dataBlockIndex.LowerBound(newest)
+	switch {
+	case !ok:
+		// all entries are before key
+	case block == nil || newestHandle != handle:
+		// an earlier block ends with a version of key newer than the lower bound
+		return
+	default:
+		if entry, ok := block.LowerBound(newest); ok && types.IsSameKey(key, entry.Key) {
+			return
+		}
+	}
+	lm.recorder().Add(metrics.BloomFalsePositives, 1)
+}
+
+// overlapping report whether a newer version of a key may be in a table searched later,
+// tables of size-tiered compaction overlap within and across levels regardless of their age,
+// so every table is searched for the newest version.
+func (lm *levelManager) overlapping() bool {
+	return lm.strategy == Tiered
+}
+
+// seek return the smallest base key of entries >= key in sstables, tombstones included
+// unlike search, the lower bound may be another key, so bloom filters can not be used.
+func (lm *levelManager) seek(key types.Key) (types.Key, bool) {
+	levels := lm.acquire()
+	defer lm.release()
+	lm.amp.addReads(1)
+
+	var res types.Key
+	var found bool
+	for level, tables := range levels {
+		for _, th := range tables {
+			dataBlockHandle, ok := th.dataBlockIndex.LowerBound(key)
+			if !ok {
+				continue
+			}
+			entry, ok := lm.fetchAndSearchLowerBound(key, level, th, dataBlockHandle)
+			if !ok {
+				continue
+			}
+			if base := types.ParseKey(entry.Key); !found || base < res {
+				res, found = base, true
+			}
+		}
+	}
+	return res, found
+}
+
+// multiSearchLowerBound search entries of keys sorted by types.CompareKeys
+// only entries with the same base key are returned, each data block is fetched at most once per table.
+func (lm *levelManager) multiSearchLowerBound(keys []types.Key) ([]types.Entry, []bool) {
+	levels := lm.acquire()
+	defer lm.release()
+	lm.amp.addReads(len(keys))
+
+	entries := make([]types.Entry, len(keys))
+	found := make([]bool, len(keys))
+
+	for level, tables := range levels {
+		for _, th := range tables {
+
+			// keys are sorted, keys in the same data block are adjacent
+			var (
+				fetched   bool
+				handle    table.BlockHandle
+				dataBlock table.Data
+			)
+			for i, key := range keys {
+				if found[i] && !lm.overlapping() {
+					continue
+				}
+
+				// search bloom filter with base key
+				if !th.filter.Contains(types.ParseKey(key)) {
+					lm.recorder().Add(metrics.BloomTrueNegatives, 1)
+					continue
+				}
+
+				dataBlockHandle, ok := th.dataBlockIndex.LowerBound(key)
+				if !ok {
+					lm.countFalsePositive(th, key, dataBlockHandle, nil)
+					continue
+				}
+
+				if !fetched || dataBlockHandle != handle {
+					dataBlock = lm.fetch(level, th, dataBlockHandle)
+					lm.amp.addBlockRead(level)
+					handle = dataBlockHandle
+					fetched = true
+				}
+
+				entry, ok := dataBlock.LowerBound(key)
+				if !ok || !types.IsSameKey(key, entry.Key) {
+					lm.countFalsePositive(th, key, handle, &dataBlock)
+					continue
+				}
+				if !found[i] || types.CompareKeys(entry.Key, entries[i].Key) < 0 {
+					entries[i], found[i] = entry, true
+				}
+			}
+		}
+	}
+
+	return entries, found
+}
+
+// searchExists report whether a live version of key exists in sstables
+// absent keys are filtered by bloom filter and index without fetching data blocks, data blocks are decoded without values
+func (lm *levelManager) searchExists(key types.Key) bool {
+	levels := lm.acquire()
+	defer lm.release()
+	lm.amp.addReads(1)
+
+	var newest types.Entry
+	var found bool
+	for level, tables := range levels {
+		for _, th := range tables {
+
+			// search bloom filter with base key
+			if !th.filter.Contains(types.ParseKey(key)) {
+				lm.recorder().Add(metrics.BloomTrueNegatives, 1)
+				continue
+			}
+
+			// determine which data block the lower bound of key is in
+			dataBlockHandle, ok := th.dataBlockIndex.LowerBound(key)
+			if !ok {
+				lm.countFalsePositive(th, key, dataBlockHandle, nil)
+				continue
+			}
+
+			// tombstone is only known after fetching the data block, its values are not needed
+			dataBlock := lm.fetchKeys(level, th, dataBlockHandle)
+			lm.amp.addBlockRead(level)
+			entry, ok := dataBlock.LowerBound(key)
+			if ok && types.IsSameKey(key, entry.Key) {
+				if !lm.overlapping() {
+					return !entry.Tombstone && !lm.dropped(entry.Key, types.ParseTs(key))
+				}
+				if !found || types.CompareKeys(entry.Key, newest.Key) < 0 {
+					newest, found = entry, true
+				}
+				continue
 			}
+			lm.countFalsePositive(th, key, dataBlockHandle, &dataBlock)
 		}
 	}
 
-	return types.Entry{}, false
+	return found && !newest.Tombstone && !lm.dropped(newest.Key, types.ParseTs(key))
 }
 
 // TODO: replace with iterator
+// scan [start, end)
+// all versions in range are returned in order, tombstones are kept to shadow older versions
 func (lm *levelManager) scan(start, end types.Key) []types.Entry {
-	lm.mu.Lock()
-	defer lm.mu.Unlock()
+	levels := lm.acquire()
+	defer lm.release()
+	lm.amp.addReads(1)
 
-	if len(lm.levels) == 0 {
+	if len(levels) == 0 {
 		return nil
 	}
 
-	var entriesList [][]types.Entry
+	// tables without keys of the prefix of a prefix scan are skipped
+	prefix, pruning := scanPrefix(types.ParseKey(start), types.ParseKey(end), lm.prefixLen)
+
+	var entries []types.Entry
 	// scan L0 - LN
-	// sort and merge result
-	for level, tables := range lm.levels {
-		// tables in same level
-		var levelList [][]types.Entry
-		for e := tables.Front(); e != nil; e = e.Next() {
-			th := e.Value.(tableHandle)
+	for level, tables := range levels {
+		for _, th := range tables {
+			if pruning && !th.mayHavePrefix(prefix) {
+				continue
+			}
 
 			// search the data blocks where the range in
 			dataBlockHandles := th.dataBlockIndex.Scan(start, end)
 
 			for _, handle := range dataBlockHandles {
-				entries := lm.fetchAndScan(start, end, level, th.levelIdx, handle)
-				levelList = append(levelList, entries)
+				entries = append(entries, lm.fetchAndScan(start, end, level, th, handle)...)
 			}
 		}
-		slices.Reverse(levelList)
-		entriesList = append(entriesList, levelList...)
 	}
-	slices.Reverse(entriesList)
 
-	return kway.Merge(entriesList...)
+	// sort and merge result, an entry may be in more than one table until compaction removes it
+	slices.SortFunc(entries, func(a, b types.Entry) int {
+		return types.CompareKeys(a.Key, b.Key)
+	})
+	return slices.CompactFunc(entries, func(a, b types.Entry) bool {
+		return a.Key == b.Key
+	})
+}
+
+// all return all entries of sstables, versions of a key in more than one table are kept
+func (lm *levelManager) all() []types.Entry {
+	levels := lm.acquire()
+	defer lm.release()
+
+	var entries []types.Entry
+	for level, tables := range levels {
+		for _, th := range tables {
+			entries = append(entries, lm.fetch(level, th, th.dataBlockIndex.DataBlock).Entries...)
+		}
+	}
+	return entries
+}
+
+// level return all entries of sstables at level in types.CompareKeys order, nothing is collapsed or filtered
+func (lm *levelManager) level(level int) []types.Entry {
+	levels := lm.acquire()
+	defer lm.release()
+
+	if level >= len(levels) {
+		return nil
+	}
+	lists := make([][]types.Entry, 0, len(levels[level]))
+	for _, th := range levels[level] {
+		lists = append(lists, lm.fetch(level, th, th.dataBlockIndex.DataBlock).Entries)
+	}
+	return kway.MergeAll(lists...)
+}
+
+// filterStats return number of sstables, total bits of their bloom filters,
+// and sum of estimated false positive rate of filters weighted by bits
+func (lm *levelManager) filterStats() (tables, bits int, weightedFPRate float64) {
+	levels := lm.acquire()
+	defer lm.release()
+
+	for _, handles := range levels {
+		for _, th := range handles {
+			b, _, rate := th.filter.Stats()
+			tables++
+			bits += b
+			weightedFPRate += rate * float64(b)
+		}
+	}
+	return tables, bits, weightedFPRate
+}
+
+func (lm *levelManager) tableInfos() []TableInfo {
+	levels := lm.acquire()
+	defer lm.release()
+
+	var infos []TableInfo
+	for level, handles := range levels {
+		for _, th := range handles {
+			info := tableInfo(level, th)
+			info.Family = lm.family
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+func tableInfo(level int, th tableHandle) TableInfo {
+	index := th.dataBlockIndex.Entries
+	info := TableInfo{
+		Level:       level,
+		Idx:         th.levelIdx,
+		Entries:     th.meta.NumEntries,
+		Size:        th.size,
+		Created:     time.Unix(th.meta.CreatedUnix, 0),
+		FilterBytes: th.filterBytes(),
+	}
+	if len(index) > 0 {
+		info.Smallest = types.ParseKey(index[0].StartKey)
+		info.Largest = types.ParseKey(index[len(index)-1].EndKey)
+	}
+	return info
+}
+
+// flushed record a flush of th into L0 for OnFlush
+// NOTE: call with mu
+func (lm *levelManager) flushed(th tableHandle) {
+	if lm.onFlush == nil {
+		return
+	}
+	info := tableInfo(0, th)
+	info.Family = lm.family
+	lm.events = append(lm.events, func() {
+		lm.onFlush(0, info)
+	})
+}
+
+// compacted record a compaction of inputs into built tables of target for OnCompaction
+// NOTE: call with mu, before inputs are removed
+func (lm *levelManager) compacted(inputs []TableInfo, target int, built []builtTable) {
+	if lm.onCompaction == nil {
+		return
+	}
+	var outputs []TableInfo
+	for _, bt := range built {
+		outputs = append(outputs, tableInfo(target, bt.handle))
+	}
+	for i := range inputs {
+		inputs[i].Family = lm.family
+	}
+	for i := range outputs {
+		outputs[i].Family = lm.family
+	}
+	lm.events = append(lm.events, func() {
+		lm.onCompaction(inputs, outputs)
+	})
+}
+
+// infos return TableInfo of tables of level
+func infos(level int, tables ...*list.Element) []TableInfo {
+	var infos []TableInfo
+	for _, e := range tables {
+		infos = append(infos, tableInfo(level, e.Value.(tableHandle)))
+	}
+	return infos
+}
+
+// notify call callbacks of flushes and compactions recorded so far, in order
+// callbacks of all keyspaces of db are called one at a time, a notify waits for the one in progress,
+// so events recorded by concurrent flushes and compactions are not reordered.
+// NOTE: call without mu, callbacks must not stall the engine
+func (lm *levelManager) notify() {
+	if lm.onFlush == nil && lm.onCompaction == nil {
+		return
+	}
+	lm.db.eventsMu.Lock()
+	defer lm.db.eventsMu.Unlock()
+
+	lm.mu.Lock()
+	events := lm.events
+	lm.events = nil
+	lm.mu.Unlock()
+
+	for _, event := range events {
+		event()
+	}
+}
+
+// ingest write entries sorted by types.CompareKeys as sstables of L1, e.g. entries imported to an empty db
+func (lm *levelManager) ingest(entries []types.Entry) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for len(lm.levels) <= 1 {
+		lm.levels = append(lm.levels, list.New())
+	}
+
+	lm.amp.addUser(entries)
+	for _, bt := range lm.buildTables(entries, 1, 0) {
+		if err := lm.writeTable(1, bt.handle.levelIdx, bt.bytes, nil); err != nil {
+			return err
+		}
+		lm.levels[1].PushBack(bt.handle)
+	}
+	lm.publish()
+	lm.bottomWritten(1, entries, false)
+	return nil
 }
 
+// flushToL0 write kvs as a new sstable of L0, no sstable is created if kvs is empty
 func (lm *levelManager) flushToL0(kvs []types.Entry) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	if err := checkSorted(kvs); err != nil {
+		return err
+	}
+	if lm.debug {
+		if err := checkVersions(kvs); err != nil {
+			return err
+		}
+	}
+
+	defer lm.notify()
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
 	// new and build bloom filter
-	bf := filter.Build(kvs)
+	bf := filter.BuildWithSeed(kvs, lm.levelFilterP(0), lm.filterSeed)
 	// build sstable
-	dataBlockIndex, tableBytes := table.Build(kvs, lm.dataBlockSize, 0)
+	dataBlockIndex, tableBytes := table.Build(kvs, lm.levelDataBlockSize(0), 0, lm.levelCompression(0))
 
 	// lazy init
 	if len(lm.levels) == 0 {
 		lm.levels = append(lm.levels, list.New())
 	}
 
-	// table handle
-	th := tableHandle{
-		levelIdx:       lm.maxLevelIdx(0) + 1,
-		filter:         *bf,
-		dataBlockIndex: dataBlockIndex,
+	// table handle
+	th := lm.newTableHandle(lm.maxLevelIdx(0)+1, bf, lm.buildPrefixFilter(kvs, 0), dataBlockIndex, tableBytes)
+
+	// file name format: level-idx.db
+	if err := lm.writeTable(0, th.levelIdx, tableBytes, nil); err != nil {
+		return err
+	}
+
+	// l0 list, only after the file is written, so a failed flush can be retried
+	lm.levels[0].PushBack(th)
+
+	lm.amp.addUser(kvs)
+	lm.publish()
+	lm.flushed(th)
+	return nil
+}
+
+// checkSorted ensure entries are strictly sorted by types.CompareKeys without duplicate full keys,
+// a duplicate would make two data blocks share a start key in the index of the sstable
+func checkSorted(entries []types.Entry) error {
+	for i := 1; i < len(entries); i++ {
+		if types.CompareKeys(entries[i-1].Key, entries[i].Key) >= 0 {
+			return fmt.Errorf("%w: %q at %d is not after %q", ErrUnsortedEntries, entries[i].Key, i, entries[i-1].Key)
+		}
+	}
+	return nil
+}
+
+// checkVersions ensure the ts of each internal key equals its Entry.Version
+func checkVersions(entries []types.Entry) error {
+	for i, entry := range entries {
+		if ts := types.ParseTs(entry.Key); ts != types.Ts(entry) {
+			return fmt.Errorf("%w: %q at %d has version %d", ErrVersionMismatch, entry.Key, i, types.Ts(entry))
+		}
+	}
+	return nil
+}
+
+// compactRange compact tables holding user keys in [start, end] level by level into the deepest non-empty level,
+// tables of the deepest level are left as they are. overlapping tables of a level of Tiered compaction are merged
+// into the next level together.
+func (lm *levelManager) compactRange(start, end string) error {
+	defer lm.notify()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	deepest := -1
+	for i, tables := range lm.levels {
+		if tables.Len() > 0 {
+			deepest = i
+		}
+	}
+	if deepest < 0 {
+		return nil
+	}
+
+	low, high := types.KeyWithTs(start, math.MaxUint64), types.KeyWithTs(end, 0)
+	// tables of L0 are compacted into L1 even if it is empty
+	for i := 0; i < max(deepest, 1); i++ {
+		// each compaction moves at least one overlapping table of the level into the next one
+		for overlaps := lm.overlapLN(i, low, high); len(overlaps) > 0; overlaps = lm.overlapLN(i, low, high) {
+			var err error
+			switch {
+			case lm.strategy == Tiered:
+				err = lm.compactBucket(i, overlaps)
+			case i == 0:
+				err = lm.compactL0()
+			default:
+				err = lm.compactTable(i, overlaps[0])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if lm.debug {
+		return lm.checkOverlaps()
+	}
+	return nil
+}
+
+// checkAndCompact compact levels over their target, it stops at the first failed compaction,
+// which leaves the tables it would replace as they are.
+func (lm *levelManager) checkAndCompact() error {
+	defer lm.notify()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.strategy == Tiered {
+		return lm.compactTiered()
+	}
+
+	for i := 0; i < len(lm.levels); i++ {
+		if !lm.overTarget(i) {
+			continue
+		}
+		var err error
+		switch {
+		case i == 0:
+			err = lm.compactL0()
+		case lm.bottom(i):
+			// no deeper level is allowed, merge tables within the level to drop stale versions,
+			// a merge which would drop nothing leaves the level over its target
+			if lm.mergeable(i) {
+				err = lm.compactLevel(i)
+			}
+		default:
+			err = lm.compactLN(i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if lm.debug {
+		return lm.checkOverlaps()
+	}
+	return nil
+}
+
+// verify check invariants of levels, see DB.Verify
+func (lm *levelManager) verify() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return lm.checkOverlaps()
+}
+
+// checkOverlaps ensure user key ranges of tables of each level >= 1 do not overlap under Leveled compaction,
+// tables of a level of Tiered compaction may overlap.
+// NOTE: call with mu
+func (lm *levelManager) checkOverlaps() error {
+	if lm.strategy == Tiered {
+		return nil
+	}
+	for level := 1; level < len(lm.levels); level++ {
+		var tables []*list.Element
+		for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+			tables = append(tables, e)
+		}
+		slices.SortFunc(tables, func(a, b *list.Element) int {
+			startA, _ := boundary(a)
+			startB, _ := boundary(b)
+			return types.CompareKeys(startA, startB)
+		})
+		for i := 1; i < len(tables); i++ {
+			prevStart, prevEnd := boundary(tables[i-1])
+			start, end := boundary(tables[i])
+			if types.ParseKey(start) <= types.ParseKey(prevEnd) {
+				return fmt.Errorf("%w: %d-%d [%q, %q] and %d-%d [%q, %q]", ErrOverlappingTables,
+					level, tables[i-1].Value.(tableHandle).levelIdx, prevStart, prevEnd,
+					level, tables[i].Value.(tableHandle).levelIdx, start, end)
+			}
+		}
+	}
+	return nil
+}
+
+// overTarget report whether level is over its target of Leveled compaction, see Config.CompactionTrigger
+// NOTE: call with mu
+func (lm *levelManager) overTarget(level int) bool {
+	if level == 0 || lm.trigger == TriggerByCount {
+		return lm.levels[level].Len() > lm.l0TargetNum*utils.Pow(lm.ratio, level)
+	}
+	var size uint64
+	for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+		size += e.Value.(tableHandle).size
+	}
+	return size > lm.l1TargetBytes*uint64(utils.Pow(lm.ratio, level-1))
+}
+
+// bottom report whether level is the deepest level allowed by MaxLevels
+func (lm *levelManager) bottom(level int) bool {
+	return lm.maxLevels > 0 && level >= lm.maxLevels-1
+}
+
+// bottomMerge state of a bottom level since all its tables were merged by compactLevel
+type bottomMerge struct {
+	// discardAtOrBelow of the merge
+	low uint64
+	// tables keep versions above low which a later watermark may discard, i.e. tombstones or older versions
+	garbage bool
+}
+
+// mergeable report whether merging bottom level within itself may drop anything, i.e. the discard watermark
+// advanced past versions, filtered entries or drops its tables kept, or a compaction wrote tombstones into it
+// which are dead already. rewriting live data does not shrink it, so the level is otherwise left over its target.
+// NOTE: call with mu
+func (lm *levelManager) mergeable(level int) bool {
+	m, ok := lm.merged[level]
+	if !ok {
+		return true
+	}
+	low := lm.db.oracle.discardAtOrBelow()
+	if low == m.low {
+		return false
+	}
+	// the compaction filter may replace versions which were above the last watermark
+	return m.garbage || lm.filter != nil || lm.droppedBetween(m.low, low)
+}
+
+// bottomWritten record sorted entries written into bottom level, whole report whether they replace all its tables
+// NOTE: call with mu
+func (lm *levelManager) bottomWritten(level int, entries []types.Entry, whole bool) {
+	if !lm.bottom(level) {
+		return
+	}
+	if lm.merged == nil {
+		lm.merged = make(map[int]bottomMerge)
+	}
+	m, ok := lm.merged[level]
+	if !ok && !whole {
+		// not merged yet, the next check merges it
+		return
+	}
+	low := lm.db.oracle.discardAtOrBelow()
+	dead, garbage := hasGarbage(entries, low)
+	switch {
+	case whole:
+		lm.merged[level] = bottomMerge{low: low, garbage: garbage}
+	case dead:
+		// a merge drops them right away
+		delete(lm.merged, level)
+	case garbage:
+		m.garbage = true
+		lm.merged[level] = m
+	}
+}
+
+// hasGarbage report whether sorted entries hold tombstones at or below low no older version is kept for,
+// which a merge of the whole bottom level drops, or versions above low a later watermark may discard
+func hasGarbage(entries []types.Entry, low uint64) (dead, garbage bool) {
+	for i, entry := range entries {
+		older := i+1 < len(entries) && types.IsSameKey(entries[i+1].Key, entry.Key)
+		if types.ParseTs(entry.Key) <= low {
+			dead = dead || entry.Tombstone && !older
+			continue
+		}
+		garbage = garbage || entry.Tombstone || older
+	}
+	return dead, garbage
+}
+
+// compactLevel merge all tables of the bottom level into new tables of the same level
+func (lm *levelManager) compactLevel(level int) error {
+	var tables []*list.Element
+	for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+		tables = append(tables, e)
+	}
+	return lm.compactBucket(level, tables)
+}
+
+// compactAll compact all tables of L0 into L1 regardless of L0TargetNum, then compact levels over their target
+func (lm *levelManager) compactAll() error {
+	defer lm.notify()
+	lm.mu.Lock()
+	for len(lm.levels) > 0 && lm.levels[0].Len() > 0 {
+		if err := lm.compactL0(); err != nil {
+			lm.mu.Unlock()
+			return err
+		}
+	}
+	lm.mu.Unlock()
+
+	return lm.checkAndCompact()
+}
+
+// fetch read and decode data blocks of handle of sstable th of level
+func (lm *levelManager) fetch(level int, th tableHandle, handle table.BlockHandle) table.Data {
+	return lm.fetchBlock(level, th, handle, false)
+}
+
+// fetchKeys fetch a data block like fetch, values of its entries are not decoded
+func (lm *levelManager) fetchKeys(level int, th tableHandle, handle table.BlockHandle) table.Data {
+	return lm.fetchBlock(level, th, handle, true)
+}
+
+func (lm *levelManager) fetchBlock(level int, th tableHandle, handle table.BlockHandle, keysOnly bool) table.Data {
+	lm.recorder().Add(metrics.BlockReads, 1)
+
+	fd, err := lm.openTable(tableName(level, th.levelIdx))
+	if err != nil {
+		lm.logger.Panicf("failed to open sstable: %v", err)
+	}
+	defer func() {
+		if err = fd.Close(); err != nil {
+			lm.logger.Errorf("failed to close file: %v", err)
+		}
+	}()
+
+	data, err := readBlock(fd, handle)
+	if err != nil {
+		lm.logger.Panicf("failed to read sstable: %v", err)
+	}
+
+	var dataBlock table.Data
+	decode := dataBlock.DecodeVersion
+	if keysOnly {
+		decode = dataBlock.DecodeKeysVersion
+	}
+	if err = decode(data, th.meta.Version); err != nil {
+		lm.logger.Panicf("failed to decode data block: %v", err)
+	}
+
+	return dataBlock
+}
+
+func (lm *levelManager) fetchAndSearch(key types.Key, level int, th tableHandle, handle table.BlockHandle) (types.Entry, bool) {
+	dataBlock := lm.fetch(level, th, handle)
+	lm.amp.addBlockRead(level)
+	return dataBlock.Search(key)
+}
+
+func (lm *levelManager) fetchAndSearchLowerBound(key types.Key, level int, th tableHandle, handle table.BlockHandle) (types.Entry, bool) {
+	dataBlock := lm.fetch(level, th, handle)
+	lm.amp.addBlockRead(level)
+	return dataBlock.LowerBound(key)
+}
+
+func (lm *levelManager) fetchAndScan(start, end types.Key, level int, th tableHandle, handle table.BlockHandle) []types.Entry {
+	dataBlock := lm.fetch(level, th, handle)
+	lm.amp.addBlockRead(level)
+	// an empty end is no bound, the block ends before the key after its last entry
+	if end == "" && len(dataBlock.Entries) > 0 {
+		end = nextKey(dataBlock.Entries[len(dataBlock.Entries)-1].Key)
+	}
+	return dataBlock.Scan(start, end)
+}
+
+// L0 -> L1
+func (lm *levelManager) compactL0() error {
+	defer utils.Elapsed(time.Now(), lm.logger, "compact level 0")
+
+	// lazy init
+	if len(lm.levels)-1 < 1 {
+		lm.levels = append(lm.levels, list.New())
+	}
+
+	// len(overlaps) >= 1
+	// overlap sstables in level 0
+	l0Tables := lm.overlapL0()
+
+	// boundary from first table to last table in l0Tables
+	start, end := boundary(l0Tables...)
+
+	// overlap sstables in L1
+	l1Tables := lm.overlapLN(1, start, end)
+
+	// old -> new (append L1 first)
+	var dataBlockList [][]types.Entry
+	// L1 data block entries
+	for _, tab := range l1Tables {
+		th := tab.Value.(tableHandle)
+		dataBlock := lm.fetch(1, th, th.dataBlockIndex.DataBlock)
+		dataBlockList = append(dataBlockList, dataBlock.Entries)
+	}
+	// L0 data block entries
+	for _, tab := range l0Tables {
+		th := tab.Value.(tableHandle)
+		dataBlock := lm.fetch(0, th, th.dataBlockIndex.DataBlock)
+		dataBlockList = append(dataBlockList, dataBlock.Entries)
+	}
+
+	// merge sstables
+	mergedEntries := kway.MergeWithTombstones(dataBlockList...)
+
+	low := lm.db.oracle.discardAtOrBelow()
+	discarded := lm.discardStaleEntries(mergedEntries, low)
+
+	// build new sstables
+	built := lm.buildTables(discarded, 1, low)
+
+	// write new sstables before updating index, a failure leaves the old ones in place
+	if err := lm.writeTables(1, built); err != nil {
+		return err
+	}
+
+	lm.compacted(append(infos(0, l0Tables...), infos(1, l1Tables...)...), 1, built)
+	lm.bottomWritten(1, discarded, false)
+
+	// update index
+	// add new index to L1
+	for _, bt := range built {
+		lm.levels[1].PushBack(bt.handle)
+	}
+
+	// remove old sstable index from L0
+	for _, e := range l0Tables {
+		lm.levels[0].Remove(e)
+	}
+	// remove old sstable index from L1
+	for _, e := range l1Tables {
+		lm.levels[1].Remove(e)
+	}
+
+	lm.publish()
+
+	// delete old sstables from L0 and L1
+	lm.removeTables(0, l0Tables)
+	lm.removeTables(1, l1Tables)
+	return nil
+}
+
+// LN -> LN+1
+func (lm *levelManager) compactLN(n int) error {
+	return lm.compactTable(n, lm.pickLN(n))
+}
+
+// compactTable merge lnTable of LN into the overlapping tables of LN+1
+func (lm *levelManager) compactTable(n int, lnTable *list.Element) error {
+	defer utils.Elapsed(time.Now(), lm.logger, fmt.Sprintf("compact level %v", n))
+
+	// lazy init
+	if len(lm.levels)-1 < n+1 {
+		lm.levels = append(lm.levels, list.New())
+	}
+
+	start, end := boundary(lnTable)
+
+	// overlap sstables in LN+1
+	ln1Tables := lm.overlapLN(n+1, start, end)
+
+	// old -> new (append LN+1 first)
+	var dataBlockList [][]types.Entry
+	// LN+1 data block entries
+	for _, tab := range ln1Tables {
+		th := tab.Value.(tableHandle)
+		dataBlockLN1 := lm.fetch(n+1, th, th.dataBlockIndex.DataBlock)
+		dataBlockList = append(dataBlockList, dataBlockLN1.Entries)
+	}
+	// LN data block entries
+	dataBlockLN := lm.fetch(n, lnTable.Value.(tableHandle), lnTable.Value.(tableHandle).dataBlockIndex.DataBlock)
+	dataBlockList = append(dataBlockList, dataBlockLN.Entries)
+
+	// merge sstables
+	mergedEntries := kway.MergeWithTombstones(dataBlockList...)
+
+	low := lm.db.oracle.discardAtOrBelow()
+	discarded := lm.discardStaleEntries(mergedEntries, low)
+
+	// build new sstables
+	built := lm.buildTables(discarded, n+1, low)
+
+	// write new sstables before updating index, a failure leaves the old ones in place
+	if err := lm.writeTables(n+1, built); err != nil {
+		return err
+	}
+
+	lm.compacted(append(infos(n, lnTable), infos(n+1, ln1Tables...)...), n+1, built)
+	lm.bottomWritten(n+1, discarded, false)
+
+	// update index
+	// add new index to LN+1
+	for _, bt := range built {
+		lm.levels[n+1].PushBack(bt.handle)
+	}
+
+	// remove old sstable index from LN
+	lm.levels[n].Remove(lnTable)
+	// remove old sstable index from LN+1
+	for _, e := range ln1Tables {
+		lm.levels[n+1].Remove(e)
+	}
+
+	lm.publish()
+
+	// delete old sstables from LN and LN+1
+	lm.removeTables(n, []*list.Element{lnTable})
+	lm.removeTables(n+1, ln1Tables)
+	return nil
+}
+
+// pickLN pick the table of LN to be compacted into LN+1, the oldest one without hot keys,
+// or the oldest one if all of them may hold hot keys
+func (lm *levelManager) pickLN(n int) *list.Element {
+	for e := lm.levels[n].Front(); e != nil; e = e.Next() {
+		start, end := boundary(e)
+		if !lm.hot(types.ParseKey(start), types.ParseKey(end)) {
+			return e
+		}
+	}
+	return lm.levels[n].Front()
+}
+
+// hot report whether user keys in [start, end] may have a prefix of hotPrefixes
+func (lm *levelManager) hot(start, end string) bool {
+	for _, prefix := range lm.hotPrefixes {
+		// keys of prefix are >= prefix, and start is above them all unless it has prefix
+		if end >= prefix && (start < prefix || strings.HasPrefix(start, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish install an immutable copy of levels as the snapshot searched by readers
+// tables of L0 overlap, they are ordered new -> old in the snapshot, so a search finds the newest version first.
+// NOTE: call with mu, after sstables of levels are written
+func (lm *levelManager) publish() {
+	levels := make([][]tableHandle, len(lm.levels))
+	for i, tables := range lm.levels {
+		levels[i] = make([]tableHandle, 0, tables.Len())
+		for e := tables.Front(); e != nil; e = e.Next() {
+			levels[i] = append(levels[i], e.Value.(tableHandle))
+		}
+	}
+	if len(levels) > 0 {
+		slices.Reverse(levels[0])
+	}
+	lm.snapshot.Store(&levels)
+}
+
+// acquire return the current snapshot of levels without blocking on compaction,
+// sstables of the snapshot are not deleted until release
+func (lm *levelManager) acquire() [][]tableHandle {
+	lm.filesMu.RLock()
+	if levels := lm.snapshot.Load(); levels != nil {
+		return *levels
+	}
+	return nil
+}
+
+func (lm *levelManager) release() {
+	lm.filesMu.RUnlock()
+}
+
+// removeTables delete sstables of level once no reader is searching a snapshot of them
+// NOTE: call after publish, so readers acquired later never see them
+func (lm *levelManager) removeTables(level int, tables []*list.Element) {
+	lm.filesMu.Lock()
+	defer lm.filesMu.Unlock()
+
+	for _, e := range tables {
+		if err := os.Remove(lm.fileName(level, e.Value.(tableHandle).levelIdx)); err != nil {
+			lm.logger.Panicf("failed to delete old sstable: %v", err)
+		}
+	}
+}
+
+// builtTable sstable built by compaction, not written yet
+type builtTable struct {
+	handle tableHandle
+	bytes  []byte
+}
+
+// buildTables build sorted entries into sstables of level, each of about target file size,
+// discarded is discardAtOrBelow they were compacted at, see tableHandle.discarded
+func (lm *levelManager) buildTables(entries []types.Entry, level int, discarded uint64) []builtTable {
+	// e.g. all entries are discarded by compaction, the tables compacted are removed without any new one
+	if len(entries) == 0 {
+		return nil
+	}
+
+	idx := lm.maxLevelIdx(level) + 1
+
+	var res []builtTable
+	for _, chunk := range lm.splitEntries(entries, level) {
+		// build new bloom filter
+		bf := filter.BuildWithSeed(chunk, lm.levelFilterP(level), lm.filterSeed)
+		// build new sstable
+		dataBlockIndex, tableBytes := table.Build(chunk, lm.levelDataBlockSize(level), level, lm.levelCompression(level))
+
+		handle := lm.newTableHandle(idx, bf, lm.buildPrefixFilter(chunk, level), dataBlockIndex, tableBytes)
+		handle.discarded = discarded
+		res = append(res, builtTable{
+			handle: handle,
+			bytes:  tableBytes,
+		})
+		idx++
+	}
+	return res
+}
+
+// splitEntries split sorted entries into chunks of about target file size
+// a chunk is only cut at a data block boundary of table.Build, and never between versions of the same key,
+// so chunks have non-overlapping key ranges.
+func (lm *levelManager) splitEntries(entries []types.Entry, level int) [][]types.Entry {
+	if lm.targetFileSize <= 0 {
+		return [][]types.Entry{entries}
+	}
+	dataBlockSize := lm.levelDataBlockSize(level)
+
+	var res [][]types.Entry
+	var start, fileSize, blockSize int
+	for i, entry := range entries {
+		// same as table.Build, a data block is full once its size exceeds data block size
+		if blockSize > dataBlockSize {
+			blockSize = 0
+			if fileSize >= lm.targetFileSize && !types.IsSameKey(entry.Key, entries[i-1].Key) {
+				res = append(res, entries[start:i])
+				start = i
+				fileSize = 0
+			}
+		}
+		size := types.EncodedSize(entry)
+		blockSize += size
+		fileSize += size
+	}
+	return append(res, entries[start:])
+}
+
+// writeTables write sstables built by compaction to level
+// if one fails, those already written are removed, so the compaction can be retried from scratch.
+func (lm *levelManager) writeTables(level int, built []builtTable) error {
+	for i, bt := range built {
+		if err := lm.writeTable(level, bt.handle.levelIdx, bt.bytes, lm.limiter); err != nil {
+			for _, written := range built[:i] {
+				if rmErr := os.Remove(lm.fileName(level, written.handle.levelIdx)); rmErr != nil {
+					lm.logger.Errorf("failed to remove sstable of failed compaction: %v", rmErr)
+				}
+			}
+			return fmt.Errorf("failed to write sstable: %w", err)
+		}
+		lm.recorder().Add(metrics.CompactionBytes, uint64(len(bt.bytes)))
+	}
+	return nil
+}
+
+// writeTable write sstable to level-idx.db, throttled by limiter if it is not nil
+func (lm *levelManager) writeTable(level, idx int, tableBytes []byte, limiter *ratelimit.Limiter) error {
+	if err := writeFileSyncScratch(lm.fileName(level, idx), tableBytes, lm.fileMode(), lm.tempDir(), limiter); err != nil {
+		return err
+	}
+
+	lm.written += uint64(len(tableBytes))
+	lm.amp.addWrite(level, uint64(len(tableBytes)))
+	return nil
+}
+
+// writeFileSync write data to a temp file with permission mode, sync it, then rename it to name and sync its dir
+// rename is atomic on POSIX, so recover will never see a partially written file,
+// and the file survives a crash once this returns, e.g. before the wal of a flushed memtable is deleted.
+// the temp file is removed on failure.
+func writeFileSync(name string, data []byte, mode os.FileMode) error {
+	return writeFileSyncLimited(name, data, mode, nil)
+}
+
+// writeFileSyncLimited same as writeFileSync, but the write is throttled by limiter
+func writeFileSyncLimited(name string, data []byte, mode os.FileMode, limiter *ratelimit.Limiter) error {
+	tmp := name + _tmpExt
+
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err = writeAndClose(fd, data, limiter); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return err
 	}
+	return syncDir(path.Dir(name))
+}
 
-	// l0 list
-	lm.levels[0].PushBack(th)
+// writeFileSyncScratch same as writeFileSyncLimited, but the temp file is written in scratch dir tempDir if it is set,
+// if it can not be renamed to name, e.g. tempDir is on another file system, data is written next to name again,
+// so name is still replaced atomically.
+func writeFileSyncScratch(name string, data []byte, mode os.FileMode, tempDir string, limiter *ratelimit.Limiter) error {
+	if tempDir == "" {
+		return writeFileSyncLimited(name, data, mode, limiter)
+	}
 
-	// file name format: level-idx.db
-	fd, err := os.OpenFile(lm.fileName(0, th.levelIdx), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	// scratch dir may be shared by dbs, temp files of them must not collide
+	fd, err := os.CreateTemp(tempDir, path.Base(name)+"-*"+_tmpExt)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err = fd.Close(); err != nil {
-			lm.logger.Errorf("failed to close file: %v", err)
-		}
-	}()
+	tmp := fd.Name()
+	if err = fd.Chmod(mode); err != nil {
+		_ = fd.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = writeAndClose(fd, data, limiter); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return writeFileSyncLimited(name, data, mode, nil)
+	}
+	return syncDir(path.Dir(name))
+}
 
-	// write sstable
-	_, err = fd.Write(tableBytes)
+// syncDir sync dir, so files created, renamed or removed in it are durable
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
+	if err = fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// writeAndClose write data to fd throttled by limiter, sync and close it
+func writeAndClose(fd *os.File, data []byte, limiter *ratelimit.Limiter) error {
+	// write file
+	if _, err := limiter.Writer(fd).Write(data); err != nil {
+		_ = fd.Close()
+		return err
+	}
 
 	// os sync
-	if err = fd.Sync(); err != nil {
-		lm.logger.Errorf("failed to sync file: %v", err)
+	if err := fd.Sync(); err != nil {
+		_ = fd.Close()
 		return err
 	}
 
-	return nil
+	return fd.Close()
 }
 
-func (lm *levelManager) checkAndCompact() {
-	lm.mu.Lock()
-	defer lm.mu.Unlock()
-
-	for i, tables := range lm.levels {
-		if tables.Len() > lm.l0TargetNum*utils.Pow(lm.ratio, i) {
-			if i == 0 {
-				lm.compactL0()
-				continue
+// size-tiered compaction
+// tables of similar size in the same level are grouped into a bucket,
+// a bucket with at least l0TargetNum tables is merged into one table of the next level,
+// tables already in the next level are not rewritten.
+func (lm *levelManager) compactTiered() error {
+	// levels may grow during compaction
+	for i := 0; i < len(lm.levels); i++ {
+		for _, bucket := range lm.buckets(i) {
+			if len(bucket) >= lm.l0TargetNum {
+				if err := lm.compactBucket(i, bucket); err != nil {
+					return err
+				}
 			}
-			lm.compactLN(i)
 		}
 	}
+	return nil
 }
 
-func (lm *levelManager) fetch(level, idx int, handle table.BlockHandle) table.Data {
-	fd, err := os.Open(lm.fileName(level, idx))
-	if err != nil {
-		lm.logger.Panicf("failed to open sstable: %v", err)
+// buckets group tables in level by size, tables keep their order (old -> new) in each bucket
+func (lm *levelManager) buckets(level int) [][]*list.Element {
+	var tables []*list.Element
+	for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+		tables = append(tables, e)
 	}
-	defer func() {
-		if err = fd.Close(); err != nil {
-			lm.logger.Errorf("failed to close file: %v", err)
-		}
-	}()
 
-	_, err = fd.Seek(int64(handle.Offset), io.SeekStart)
-	if err != nil {
-		lm.logger.Panicf("failed to seek sstable: %v", err)
-	}
+	sorted := slices.Clone(tables)
+	slices.SortStableFunc(sorted, func(a, b *list.Element) int {
+		return cmp.Compare(a.Value.(tableHandle).size, b.Value.(tableHandle).size)
+	})
 
-	data := make([]byte, handle.Length)
-	_, err = fd.Read(data)
-	if err != nil {
-		lm.logger.Panicf("failed to read sstable: %v", err)
+	var buckets [][]*list.Element
+	var bucket []*list.Element
+	var total uint64
+	for _, e := range sorted {
+		size := e.Value.(tableHandle).size
+		if len(bucket) > 0 {
+			avg := float64(total) / float64(len(bucket))
+			if float64(size) < avg*_bucketLow || float64(size) > avg*_bucketHigh {
+				buckets = append(buckets, bucket)
+				bucket, total = nil, 0
+			}
+		}
+		bucket = append(bucket, e)
+		total += size
 	}
-
-	var dataBlock table.Data
-	if err = dataBlock.Decode(data); err != nil {
-		lm.logger.Panicf("failed to decode data block: %v", err)
+	if len(bucket) > 0 {
+		buckets = append(buckets, bucket)
 	}
 
-	return dataBlock
-}
-
-func (lm *levelManager) fetchAndSearch(key types.Key, level, idx int, handle table.BlockHandle) (types.Entry, bool) {
-	dataBlock := lm.fetch(level, idx, handle)
-	return dataBlock.Search(key)
-}
-
-func (lm *levelManager) fetchAndSearchLowerBound(key types.Key, level, idx int, handle table.BlockHandle) (types.Entry, bool) {
-	dataBlock := lm.fetch(level, idx, handle)
-	return dataBlock.LowerBound(key)
+	// restore old -> new order
+	for _, b := range buckets {
+		slices.SortFunc(b, func(x, y *list.Element) int {
+			return slices.Index(tables, x) - slices.Index(tables, y)
+		})
+	}
+	return buckets
 }
 
-func (lm *levelManager) fetchAndScan(start, end types.Key, level, idx int, handle table.BlockHandle) []types.Entry {
-	dataBlock := lm.fetch(level, idx, handle)
-	return dataBlock.Scan(start, end)
-}
+// compactBucket merge tables of level into one table of level+1,
+// or into level itself if it is the bottom level
+func (lm *levelManager) compactBucket(level int, tables []*list.Element) error {
+	defer utils.Elapsed(time.Now(), lm.logger, fmt.Sprintf("tiered compact level %v", level))
 
-// L0 -> L1
-func (lm *levelManager) compactL0() {
-	defer utils.Elapsed(time.Now(), lm.logger, "compact level 0")
+	target := level + 1
+	if lm.bottom(level) {
+		target = level
+	}
 
 	// lazy init
-	if len(lm.levels)-1 < 1 {
+	if len(lm.levels)-1 < target {
 		lm.levels = append(lm.levels, list.New())
 	}
 
-	// len(overlaps) >= 1
-	// overlap sstables in level 0
-	l0Tables := lm.overlapL0()
-
-	// boundary from first table to last table in l0Tables
-	start, end := boundary(l0Tables...)
-
-	// overlap sstables in L1
-	l1Tables := lm.overlapLN(1, start, end)
-
-	// old -> new (append L1 first)
+	// old -> new
 	var dataBlockList [][]types.Entry
-	// L1 data block entries
-	for _, tab := range l1Tables {
-		th := tab.Value.(tableHandle)
-		dataBlock := lm.fetch(1, th.levelIdx, th.dataBlockIndex.DataBlock)
-		dataBlockList = append(dataBlockList, dataBlock.Entries)
-	}
-	// L0 data block entries
-	for _, tab := range l0Tables {
+	for _, tab := range tables {
 		th := tab.Value.(tableHandle)
-		dataBlock := lm.fetch(0, th.levelIdx, th.dataBlockIndex.DataBlock)
+		dataBlock := lm.fetch(level, th, th.dataBlockIndex.DataBlock)
 		dataBlockList = append(dataBlockList, dataBlock.Entries)
 	}
 
 	// merge sstables
-	mergedEntries := kway.Merge(dataBlockList...)
+	mergedEntries := kway.MergeWithTombstones(dataBlockList...)
 
-	discarded := lm.discardStaleEntries(mergedEntries)
-
-	// build new bloom filter
-	bf := filter.Build(discarded)
-	// build new sstable
-	dataBlockIndex, tableBytes := table.Build(discarded, lm.dataBlockSize, 1)
+	low := lm.db.oracle.discardAtOrBelow()
+	discarded := lm.discardStaleEntries(mergedEntries, low)
 
-	// table handle
-	th := tableHandle{
-		levelIdx:       lm.maxLevelIdx(1) + 1,
-		filter:         *bf,
-		dataBlockIndex: dataBlockIndex,
+	// all tables of the bottom level are merged, no other table may hold an older version a tombstone shadows
+	whole := target == level && len(tables) == lm.levels[level].Len()
+	if whole {
+		discarded = lm.discardDeadTombstones(level+1, nil, discarded)
 	}
 
-	// update index
-	// add new index to L1
-	lm.levels[1].PushBack(th)
+	// build new sstables
+	built := lm.buildTables(discarded, target, low)
 
-	// remove old sstable index from L0
-	for _, e := range l0Tables {
-		lm.levels[0].Remove(e)
-	}
-	// remove old sstable index from L1
-	for _, e := range l1Tables {
-		lm.levels[1].Remove(e)
+	// write new sstables before deleting the old ones
+	if err := lm.writeTables(target, built); err != nil {
+		return err
 	}
 
-	// delete old sstables from L0
-	for _, e := range l0Tables {
-		if err := os.Remove(lm.fileName(0, e.Value.(tableHandle).levelIdx)); err != nil {
-			lm.logger.Panicf("failed to delete old sstable: %v", err)
-		}
-	}
-	// delete old sstables from L1
-	for _, e := range l1Tables {
-		if err := os.Remove(lm.fileName(1, e.Value.(tableHandle).levelIdx)); err != nil {
-			lm.logger.Panicf("failed to delete old sstable: %v", err)
-		}
-	}
+	lm.compacted(infos(level, tables...), target, built)
 
-	// write new sstable
-	fd, err := os.OpenFile(lm.fileName(1, th.levelIdx), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
-	if err != nil {
-		lm.logger.Panicf("failed to open sstable: %v", err)
+	// update index
+	for _, bt := range built {
+		lm.levels[target].PushBack(bt.handle)
 	}
-	defer func() {
-		if err = fd.Close(); err != nil {
-			lm.logger.Errorf("failed to close file: %v", err)
-		}
-	}()
-
-	_, err = fd.Write(tableBytes)
-	if err != nil {
-		lm.logger.Panicf("failed to write sstable: %v", err)
+	for _, e := range tables {
+		lm.levels[level].Remove(e)
 	}
+	lm.publish()
+	lm.bottomWritten(target, discarded, whole)
+
+	// delete old sstables
+	lm.removeTables(level, tables)
+	return nil
 }
 
-// LN -> LN+1
-func (lm *levelManager) compactLN(n int) {
-	defer utils.Elapsed(time.Now(), lm.logger, fmt.Sprintf("compact level %v", n))
+// rewriteTable rewrite sstable level-idx in place with entries discardStaleEntries and discardDeadTombstones keep,
+// it is kept as is if nothing is discarded and it is of the current format, and removed if nothing is left.
+func (lm *levelManager) rewriteTable(level, idx int) error {
+	defer lm.notify()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
 
-	// lazy init
-	if len(lm.levels)-1 < n+1 {
-		lm.levels = append(lm.levels, list.New())
+	var elem *list.Element
+	if level < len(lm.levels) {
+		for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+			if e.Value.(tableHandle).levelIdx == idx {
+				elem = e
+				break
+			}
+		}
 	}
-
-	lnTable := lm.levels[n].Front()
-	start, end := boundary(lnTable)
-
-	// overlap sstables in LN+1
-	ln1Tables := lm.overlapLN(n+1, start, end)
-
-	// old -> new (append LN+1 first)
-	var dataBlockList [][]types.Entry
-	// LN+1 data block entries
-	for _, tab := range ln1Tables {
-		th := tab.Value.(tableHandle)
-		dataBlockLN1 := lm.fetch(n+1, th.levelIdx, th.dataBlockIndex.DataBlock)
-		dataBlockList = append(dataBlockList, dataBlockLN1.Entries)
+	if elem == nil {
+		return ErrTableNotFound
 	}
-	// LN data block entries
-	dataBlockLN := lm.fetch(n, lnTable.Value.(tableHandle).levelIdx, lnTable.Value.(tableHandle).dataBlockIndex.DataBlock)
-	dataBlockList = append(dataBlockList, dataBlockLN.Entries)
+	th := elem.Value.(tableHandle)
 
-	// merge sstables
-	mergedEntries := kway.Merge(dataBlockList...)
-
-	discarded := lm.discardStaleEntries(mergedEntries)
-
-	// build new bloom filter
-	bf := filter.Build(discarded)
-	// build new sstable
-	dataBlockIndex, tableBytes := table.Build(discarded, lm.dataBlockSize, n+1)
+	entries := lm.fetch(level, th, th.dataBlockIndex.DataBlock).Entries
+	n := len(entries)
+	low := lm.db.oracle.discardAtOrBelow()
+	entries = lm.discardDeadTombstones(level, elem, lm.discardStaleEntries(entries, low))
+	// a sstable of an older format is rewritten in the current one, e.g. one of the v0.2.1 release
+	if len(entries) == n && th.meta.Version == uint64(table.FormatVersion()) {
+		return nil
+	}
 
-	// table handle
-	th := tableHandle{
-		levelIdx:       lm.maxLevelIdx(n+1) + 1,
-		filter:         *bf,
-		dataBlockIndex: dataBlockIndex,
+	if len(entries) == 0 {
+		lm.compacted(infos(level, elem), level, nil)
+		lm.levels[level].Remove(elem)
+		lm.publish()
+		lm.removeTables(level, []*list.Element{elem})
+		return nil
 	}
 
-	// update index
-	// add new index to LN+1
-	lm.levels[n+1].PushBack(th)
+	bf := filter.BuildWithSeed(entries, lm.levelFilterP(level), lm.filterSeed)
+	dataBlockIndex, tableBytes := table.Build(entries, lm.levelDataBlockSize(level), level, lm.levelCompression(level))
 
-	// remove old sstable index from LN
-	lm.levels[n].Remove(lnTable)
-	// remove old sstable index from LN+1
-	for _, e := range ln1Tables {
-		lm.levels[n+1].Remove(e)
+	// readers open sstables by name, so the file must not be replaced while they search the old index of it
+	lm.filesMu.Lock()
+	defer lm.filesMu.Unlock()
+
+	if err := lm.writeTable(level, idx, tableBytes, lm.limiter); err != nil {
+		return err
 	}
+	lm.recorder().Add(metrics.CompactionBytes, uint64(len(tableBytes)))
+
+	// the table keeps its place in level, which orders tables of L0 by age
+	inputs := infos(level, elem)
+	th = lm.newTableHandle(idx, bf, lm.buildPrefixFilter(entries, level), dataBlockIndex, tableBytes)
+	th.discarded = low
+	elem.Value = th
+	lm.compacted(inputs, level, []builtTable{{handle: elem.Value.(tableHandle)}})
+	lm.publish()
+	return nil
+}
 
-	// delete old sstables from LN
-	if err := os.Remove(lm.fileName(n, lnTable.Value.(tableHandle).levelIdx)); err != nil {
-		lm.logger.Panicf("failed to delete old sstable: %v", err)
+// discardDeadTombstones remove tombstones at or below discardAtOrBelow of sorted entries of table self,
+// which is at level, if no other table of level or deeper levels may hold an older version of their key
+// a txn could read once they are gone.
+// NOTE: call with mu, after discardStaleEntries collapsed versions at or below discardAtOrBelow
+func (lm *levelManager) discardDeadTombstones(level int, self *list.Element, entries []types.Entry) []types.Entry {
+	low := lm.db.oracle.discardAtOrBelow()
+	if low == 0 {
+		return entries
 	}
-	// delete old sstables from LN+1
-	for _, e := range ln1Tables {
-		if err := os.Remove(lm.fileName(n+1, e.Value.(tableHandle).levelIdx)); err != nil {
-			lm.logger.Panicf("failed to delete old sstable: %v", err)
+	res := entries[:0]
+	for i, entry := range entries {
+		// an older version kept by Config.NumVersionsToKeep is still shadowed by the tombstone
+		oldest := i == len(entries)-1 || !types.IsSameKey(entries[i+1].Key, entry.Key)
+		if entry.Tombstone && oldest && types.ParseTs(entry.Key) <= low && !lm.mayContain(level, self, types.ParseKey(entry.Key)) {
+			continue
 		}
+		res = append(res, entry)
 	}
+	return res
+}
 
-	// write new sstable
-	fd, err := os.OpenFile(lm.fileName(n+1, th.levelIdx), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
-	if err != nil {
-		lm.logger.Panicf("failed to open sstable: %v", err)
-	}
-	defer func() {
-		if err = fd.Close(); err != nil {
-			lm.logger.Errorf("failed to close file: %v", err)
+// mayContain report whether a table other than self of level or deeper levels may hold versions of key
+// NOTE: call with mu
+func (lm *levelManager) mayContain(level int, self *list.Element, key string) bool {
+	for i := level; i < len(lm.levels); i++ {
+		for e := lm.levels[i].Front(); e != nil; e = e.Next() {
+			if e == self {
+				continue
+			}
+			th := e.Value.(tableHandle)
+			index := th.dataBlockIndex.Entries
+			if key < types.ParseKey(index[0].StartKey) || key > types.ParseKey(index[len(index)-1].EndKey) {
+				continue
+			}
+			if th.filter.Contains(key) {
+				return true
+			}
 		}
-	}()
-
-	_, err = fd.Write(tableBytes)
-	if err != nil {
-		lm.logger.Panicf("failed to write sstable: %v", err)
 	}
+	return false
 }
 
-// remove version <= discardAtOrBelow and keep latest version
-func (lm *levelManager) discardStaleEntries(entries []types.Entry) []types.Entry {
-	low := lm.db.oracle.discardAtOrBelow()
+// remove versions dropped by DropPrefix, then remove version <= low and keep latest version,
+// low is discardAtOrBelow read by the compaction, see tableHandle.discarded.
+// the latest version is passed to compaction filter. older versions are kept until a key has
+// numVersions of them in entries, see Config.NumVersionsToKeep.
+func (lm *levelManager) discardStaleEntries(entries []types.Entry, low uint64) []types.Entry {
+	entries = lm.discardDropped(entries, low)
 	if low == 0 {
 		return entries
 	}
 	res := make([]types.Entry, 0, len(entries))
-	latest := make(map[string]types.Entry)
+	// number of versions > low of each key
+	newer := make(map[string]int)
+	// versions <= low of each key
+	older := make(map[string][]types.Entry)
 
 	for _, entry := range entries {
 		key := types.ParseKey(entry.Key)
@@ -550,21 +1889,19 @@ func (lm *levelManager) discardStaleEntries(entries []types.Entry) []types.Entry
 
 		if ts > low {
 			res = append(res, entry)
+			newer[key]++
 			continue
 		}
-
-		if maxEntry, ok := latest[key]; ok {
-			maxTS := types.ParseTs(maxEntry.Key)
-			if ts > maxTS {
-				latest[key] = entry
-			}
-			continue
-		}
-		latest[key] = entry
+		older[key] = append(older[key], entry)
 	}
 
-	for _, entry := range latest {
-		res = append(res, entry)
+	for key, versions := range older {
+		// new -> old
+		slices.SortFunc(versions, func(a, b types.Entry) int {
+			return types.CompareKeys(a.Key, b.Key)
+		})
+		versions[0] = lm.filterEntry(versions[0])
+		res = append(res, versions[:min(len(versions), max(lm.numVersions-newer[key], 1))]...)
 	}
 
 	slices.SortFunc(res, func(a, b types.Entry) int {
@@ -574,13 +1911,32 @@ func (lm *levelManager) discardStaleEntries(entries []types.Entry) []types.Entry
 	return res
 }
 
-func (lm *levelManager) overlapL0() []*list.Element {
-	frontIndex := lm.levels[0].Front().Value.(tableHandle).dataBlockIndex
-
-	startKey := frontIndex.Entries[0].StartKey
-	endKey := frontIndex.Entries[len(frontIndex.Entries)-1].EndKey
+// filterEntry replace entry not kept by compaction filter with a tombstone
+func (lm *levelManager) filterEntry(entry types.Entry) types.Entry {
+	if lm.filter == nil || entry.Tombstone {
+		return entry
+	}
+	if lm.filter(types.ParseKey(entry.Key), entry.Value, types.ParseTs(entry.Key)) {
+		return entry
+	}
+	entry.Value = nil
+	entry.Tombstone = true
+	return entry
+}
 
-	return lm.overlapLN(0, startKey, endKey)
+// overlapL0 return the oldest table of L0 and all tables of L0 overlapping them transitively,
+// a table left in L0 must not hold a version of a key compacted into L1, which is searched after L0,
+// e.g. [a, b] and [c, d] both overlap [b, c], an older version of c would shadow the newer one in L1.
+func (lm *levelManager) overlapL0() []*list.Element {
+	overlaps := []*list.Element{lm.levels[0].Front()}
+	for {
+		start, end := boundary(overlaps...)
+		next := lm.overlapLN(0, start, end)
+		if len(next) == len(overlaps) {
+			return next
+		}
+		overlaps = next
+	}
 }
 
 func (lm *levelManager) overlapLN(level int, start, end string) []*list.Element {
@@ -591,11 +1947,13 @@ func (lm *levelManager) overlapLN(level int, start, end string) []*list.Element
 
 	ln := lm.levels[level]
 
+	// all versions of a key must be in one sstable of a level, so overlap is decided by user keys,
+	// e.g. [k@11, k@11] overlaps [k@10, z@1] though k@11 sorts before k@10
 	var overlaps []*list.Element
 	for e := ln.Front(); e != nil; e = e.Next() {
 		index := e.Value.(tableHandle).dataBlockIndex
-		if types.CompareKeys(index.Entries[0].StartKey, end) <= 0 &&
-			types.CompareKeys(index.Entries[len(index.Entries)-1].EndKey, start) >= 0 {
+		if types.ParseKey(index.Entries[0].StartKey) <= types.ParseKey(end) &&
+			types.ParseKey(index.Entries[len(index.Entries)-1].EndKey) >= types.ParseKey(start) {
 			overlaps = append(overlaps, e)
 		}
 	}
@@ -603,8 +1961,143 @@ func (lm *levelManager) overlapLN(level int, start, end string) []*list.Element
 	return overlaps
 }
 
+// recorder of metrics, discard all if not configured
+func (lm *levelManager) recorder() metrics.Recorder {
+	if lm.metrics == nil {
+		return metrics.Nop
+	}
+	return lm.metrics
+}
+
+// fileMode permission of files written by lm, see Config.FileMode
+func (lm *levelManager) fileMode() os.FileMode {
+	if lm.db == nil {
+		return DefaultConfig.FileMode
+	}
+	return lm.db.config.FileMode
+}
+
+// tempDir scratch dir of sstables written by lm, empty if they are written in dir, see Config.TempDir
+func (lm *levelManager) tempDir() string {
+	if lm.db == nil {
+		return ""
+	}
+	return lm.db.config.TempDir
+}
+
+// levelCompressions compression level of each level of config
+func levelCompressions(config Config) []utils.CompressionLevel {
+	if config.DisableCompression {
+		return []utils.CompressionLevel{utils.CompressionNone}
+	}
+	return config.CompressionLevel
+}
+
+// levelCompression compression level of sstables at level
+func (lm *levelManager) levelCompression(level int) utils.CompressionLevel {
+	if len(lm.compression) == 0 {
+		return utils.CompressionDefault
+	}
+	return lm.compression[min(level, len(lm.compression)-1)]
+}
+
+// levelDataBlockSize data block size of sstables at level
+func (lm *levelManager) levelDataBlockSize(level int) int {
+	if len(lm.levelDataBlockSizes) == 0 {
+		return lm.dataBlockSize
+	}
+	return lm.levelDataBlockSizes[min(level, len(lm.levelDataBlockSizes)-1)]
+}
+
+// buildPrefixFilter build the prefix bloom filter of sorted entries of a sstable at level, nil if prefixLen is not set
+func (lm *levelManager) buildPrefixFilter(entries []types.Entry, level int) *filter.Filter {
+	if lm.prefixLen <= 0 {
+		return nil
+	}
+	return filter.BuildPrefixWithSeed(entries, lm.prefixLen, lm.levelFilterP(level), lm.filterSeed)
+}
+
+// mayHavePrefix report whether th may hold a key with prefix, always true without a prefix filter
+func (th tableHandle) mayHavePrefix(prefix string) bool {
+	return th.prefixFilter == nil || th.prefixFilter.Contains(prefix)
+}
+
+// filterBytes memory of bloom filters of th
+func (th tableHandle) filterBytes() int {
+	if th.prefixFilter == nil {
+		return th.filter.Size()
+	}
+	return th.filter.Size() + th.prefixFilter.Size()
+}
+
+// scanPrefix return the prefix of n bytes every user key in [start, end) has, false if there is none,
+// e.g. "user1" of ["user1", "user2") and ["user1:a", "user1:b") for n = 5
+func scanPrefix(start, end string, n int) (string, bool) {
+	if n <= 0 || len(start) < n {
+		return "", false
+	}
+	prefix := start[:n]
+	// smallest string after all strings with prefix, there is none if prefix is all 0xff
+	limit := []byte(prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return prefix, end <= string(limit[:i+1])
+		}
+	}
+	return prefix, true
+}
+
+// levelFilterP false positive rate of bloom filter at level
+func (lm *levelManager) levelFilterP(level int) float64 {
+	if len(lm.filterP) == 0 {
+		return 0
+	}
+	return lm.filterP[min(level, len(lm.filterP)-1)]
+}
+
 func (lm *levelManager) fileName(level, idx int) string {
-	return path.Join(lm.dir, fmt.Sprintf("%d-%d.db", level, idx))
+	return path.Join(lm.dir, tableName(level, idx))
+}
+
+// tables return the file system sstables are read from
+func (lm *levelManager) tables() fs.FS {
+	if lm.fsys != nil {
+		return lm.fsys
+	}
+	return os.DirFS(lm.dir)
+}
+
+// openTable open sstable name for random access
+func (lm *levelManager) openTable(name string) (tableFile, error) {
+	f, err := lm.tables().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	tf, ok := f.(tableFile)
+	if !ok {
+		_ = f.Close()
+		return nil, ErrNotReaderAt
+	}
+	return tf, nil
+}
+
+// tableFile sstable file opened from a file system
+type tableFile interface {
+	fs.File
+	io.ReaderAt
+}
+
+func readBlock(r io.ReaderAt, handle table.BlockHandle) ([]byte, error) {
+	data := make([]byte, handle.Length)
+	if _, err := r.ReadAt(data, int64(handle.Offset)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func tableName(level, idx int) string {
+	return fmt.Sprintf("%d-%d%s", level, idx, _dbExt)
 }
 
 // if no elements in this level, return -1
@@ -626,9 +2119,14 @@ func parseFileName(name string) (int, int, error) {
 	if err != nil {
 		return 0, 0, err
 	}
+	// e.g. 01-2.db, sstables are always opened by tableName
+	if level < 0 || idx < 0 || tableName(level, idx) != name {
+		return 0, 0, fmt.Errorf("unexpected file name %s", name)
+	}
 	return level, idx, nil
 }
 
+// boundary return the smallest start key and the largest end key of tables in types.CompareKeys order
 func boundary(list ...*list.Element) (string, string) {
 	entries := list[0].Value.(tableHandle).dataBlockIndex.Entries
 	start := entries[0].StartKey
@@ -639,10 +2137,10 @@ func boundary(list ...*list.Element) (string, string) {
 		currStart := index.Entries[0].StartKey
 		currEnd := index.Entries[len(index.Entries)-1].EndKey
 
-		if currStart < start {
+		if types.CompareKeys(currStart, start) < 0 {
 			start = currStart
 		}
-		if currEnd > end {
+		if types.CompareKeys(currEnd, end) > 0 {
 			end = currEnd
 		}
 	}
@@ -15,10 +15,32 @@
 package originium
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/B1NARY-GR0UP/originium/pkg/filter"
 	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/pkg/ratelimit"
+	"github.com/B1NARY-GR0UP/originium/pkg/skiplist"
+	"github.com/B1NARY-GR0UP/originium/table"
 	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,6 +80,38 @@ func TestSearch(t *testing.T) {
 	entry, found = lm.searchLowerBound("key7@1")
 	assert.Equal(t, types.Entry{}, entry)
 	assert.False(t, found)
+
+	// bloom filter is version-independent
+	entry, found = lm.searchLowerBound("key2@10")
+	assert.True(t, found)
+	assert.Equal(t, "key2@1", entry.Key)
+}
+
+func TestSearchExists(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+
+	kvs := []types.Entry{
+		{Key: "key1@1", Value: []byte("value1")},
+		{Key: "key2@2", Value: []byte{}, Tombstone: true},
+		{Key: "key2@1", Value: []byte("value2")},
+	}
+
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+
+	assert.True(t, lm.searchExists("key1@5"))
+	// only a tombstone is visible
+	assert.False(t, lm.searchExists("key2@5"))
+	// older version is visible
+	assert.True(t, lm.searchExists("key2@1"))
+	assert.False(t, lm.searchExists("key3@5"))
 }
 
 func TestManagerScan(t *testing.T) {
@@ -97,101 +151,1629 @@ func TestManagerScan(t *testing.T) {
 	assert.Empty(t, entries)
 }
 
-//func TestCompact(t *testing.T) {
-//	lm := &levelManager{
-//		dir:           t.TempDir(),
-//		l0TargetNum:   1,
-//		ratio:         2,
-//		dataBlockSize: 500,
-//		logger:        logger.GetLogger(),
-//	}
-//
-//	// First flush: key100-key200
-//	kvs1 := make([]types.Entry, 0)
-//	for i := 100; i <= 200; i++ {
-//		kvs1 = append(kvs1, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err := lm.flushToL0(kvs1)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	// Second flush: key150-key300
-//	kvs2 := make([]types.Entry, 0)
-//	for i := 150; i <= 300; i++ {
-//		kvs2 = append(kvs2, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err = lm.flushToL0(kvs2)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	// Third flush: key250-key400
-//	kvs3 := make([]types.Entry, 0)
-//	for i := 250; i <= 400; i++ {
-//		kvs3 = append(kvs3, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err = lm.flushToL0(kvs3)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	kvs4 := make([]types.Entry, 0)
-//	for i := 500; i <= 600; i++ {
-//		kvs4 = append(kvs4, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err = lm.flushToL0(kvs4)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	kvs5 := make([]types.Entry, 0)
-//	for i := 700; i <= 800; i++ {
-//		kvs5 = append(kvs5, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err = lm.flushToL0(kvs5)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	kvs6 := make([]types.Entry, 0)
-//	for i := 900; i <= 1000; i++ {
-//		kvs6 = append(kvs6, types.Entry{
-//			Key:   fmt.Sprintf("key%d", i),
-//			Value: []byte(fmt.Sprintf("value%d", i)),
-//		})
-//	}
-//	err = lm.flushToL0(kvs6)
-//	assert.NoError(t, err)
-//
-//	// Perform compaction
-//	lm.checkAndCompact()
-//
-//	// Verify the compaction result
-//	for i := 100; i <= 400; i++ {
-//		entry, found := lm.searchLowerBound(fmt.Sprintf("key%d", i))
-//		assert.True(t, found)
-//		assert.Equal(t, fmt.Sprintf("key%d", i), entry.Key)
-//		assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), entry.Value)
-//	}
-//}
+func TestScanEndBoundary(t *testing.T) {
+	recorder := metrics.NewMemory()
+	lm := &levelManager{
+		dir:         t.TempDir(),
+		l0TargetNum: 4,
+		ratio:       10,
+		// one entry per data block
+		dataBlockSize: 1,
+		metrics:       recorder,
+		logger:        logger.GetLogger(),
+	}
+	mt := newMemtable(t.TempDir(), DefaultConfig.FileMode, skiplist.New(4, 0.5), logger.GetLogger())
+	defer func() {
+		assert.NoError(t, mt.wal.Delete())
+	}()
+
+	// odd keys in sstable, even keys in memtable
+	err := lm.flushToL0([]types.Entry{
+		{Key: "key1@1", Value: []byte("value1")},
+		{Key: "key3@1", Value: []byte("value3")},
+		{Key: "key5@1", Value: []byte("value5")},
+	})
+	assert.NoError(t, err)
+	for _, key := range []string{"key2@1", "key4@1"} {
+		mt.set(types.Entry{Key: key, Value: []byte("value")})
+	}
+
+	// end is exclusive for both sources
+	assert.Equal(t, []types.Entry{{Key: "key1@1", Value: []byte("value1")}}, lm.scan("key1@1", "key3@1"))
+	assert.Equal(t, []types.Entry{{Key: "key2@1", Value: []byte("value")}}, mt.scan("key1@1", "key4@1"))
+
+	// the block starting at end is not fetched
+	assert.Equal(t, uint64(1), recorder.Snapshot()[metrics.BlockReads])
+
+	// start is inclusive for both sources
+	assert.Equal(t, []types.Entry{{Key: "key3@1", Value: []byte("value3")}}, lm.scan("key3@1", "key4@1"))
+	assert.Equal(t, []types.Entry{{Key: "key4@1", Value: []byte("value")}}, mt.scan("key4@1", "key5@1"))
+}
+
+func TestRecoverIgnoreTmpTable(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+
+	kvs := []types.Entry{
+		{Key: "key1@1", Value: []byte("value1"), Version: 1},
+		{Key: "key2@2", Value: []byte("value2"), Version: 2},
+	}
+
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+
+	// simulate a crash in the middle of writing the next sstable
+	tmp := path.Join(dir, "0-1.db"+_tmpExt)
+	err = os.WriteFile(tmp, []byte("partial sstable"), 0600)
+	assert.NoError(t, err)
+
+	recovered := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+	maxVersion, err := recovered.recover()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), maxVersion)
+	assert.Equal(t, 1, recovered.levels[0].Len())
+
+	entry, found := recovered.searchLowerBound("key2@2")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value2"), entry.Value)
+
+	_, err = os.Stat(tmp)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLevelFilterP(t *testing.T) {
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		filterP:       []float64{0.001, 0.01, 0.1},
+		logger:        logger.GetLogger(),
+	}
+
+	var kvs []types.Entry
+	for i := range 1000 {
+		kvs = append(kvs, types.Entry{
+			Key:   types.KeyWithTs(fmt.Sprintf("key%04d", i), 1),
+			Value: []byte("value"),
+		})
+	}
+
+	assert.Equal(t, 0.1, lm.levelFilterP(5))
+
+	var sizes []int
+	for level := range 3 {
+		bf := filter.Build(kvs, lm.levelFilterP(level))
+		for _, kv := range kvs {
+			assert.True(t, bf.Contains(types.ParseKey(kv.Key)))
+		}
+		sizes = append(sizes, bf.Size())
+	}
+	assert.Greater(t, sizes[0], sizes[1])
+	assert.Greater(t, sizes[1], sizes[2])
+
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+	th := lm.levels[0].Front().Value.(tableHandle)
+	assert.Equal(t, sizes[0], th.filter.Size())
+}
+
+func TestLevelCompression(t *testing.T) {
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		compression:   []utils.CompressionLevel{utils.CompressionDefault, utils.CompressionBetter, utils.CompressionBest},
+		logger:        logger.GetLogger(),
+	}
+
+	var kvs []types.Entry
+	for i := range 1000 {
+		kvs = append(kvs, types.Entry{
+			Key:   types.KeyWithTs(fmt.Sprintf("key%04d", i), 1),
+			Value: []byte(fmt.Sprintf("value of key%04d", i)),
+		})
+	}
+
+	assert.Equal(t, utils.CompressionBest, lm.levelCompression(5))
+	assert.Equal(t, utils.CompressionDefault, (&levelManager{}).levelCompression(5))
+
+	var sizes []int
+	for level := range 3 {
+		_, tableBytes := table.Build(kvs, lm.dataBlockSize, level, lm.levelCompression(level))
+		sizes = append(sizes, len(tableBytes))
+	}
+	assert.Greater(t, sizes[0], sizes[2])
+
+	// tables compressed with a stronger level are read the same way
+	lm.compression = []utils.CompressionLevel{utils.CompressionBest}
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+	for _, kv := range kvs {
+		entry, found := lm.searchLowerBound(kv.Key)
+		assert.True(t, found)
+		assert.Equal(t, kv.Value, entry.Value)
+	}
+}
+
+func TestBloomFalsePositives(t *testing.T) {
+	recorder := metrics.NewMemory()
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 256,
+		// a filter passing almost every key
+		filterP: []float64{0.99},
+		metrics: recorder,
+		logger:  logger.GetLogger(),
+	}
+
+	var kvs []types.Entry
+	for i := range 100 {
+		kvs = append(kvs, types.Entry{
+			Key:   types.KeyWithTs(fmt.Sprintf("key%04d", i), 5),
+			Value: []byte(fmt.Sprintf("value of key%04d", i)),
+		})
+	}
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+
+	// keys only having versions newer than ts are not false positives
+	for i := range 100 {
+		_, found := lm.searchLowerBound(types.KeyWithTs(fmt.Sprintf("key%04d", i), 3))
+		assert.False(t, found)
+		assert.False(t, lm.searchExists(types.KeyWithTs(fmt.Sprintf("key%04d", i), 3)))
+	}
+	assert.Equal(t, uint64(0), recorder.Snapshot()[metrics.BloomFalsePositives])
+
+	// absent keys passed by the filter are, before, between and after keys of the table
+	absent := []types.Key{
+		types.KeyWithTs("a", 3),
+		types.KeyWithTs("key0050x", 3),
+		types.KeyWithTs("z", 3),
+	}
+	th := lm.levels[0].Front().Value.(tableHandle)
+	var passed uint64
+	for _, key := range absent {
+		if th.filter.Contains(types.ParseKey(key)) {
+			passed++
+		}
+		_, found := lm.searchLowerBound(key)
+		assert.False(t, found)
+	}
+	assert.Greater(t, passed, uint64(0))
+	assert.Equal(t, passed, recorder.Snapshot()[metrics.BloomFalsePositives])
+}
+
+func TestLevelDataBlockSize(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	defer db.oracle.Stop()
+
+	lm := &levelManager{
+		dir:                 t.TempDir(),
+		l0TargetNum:         4,
+		ratio:               10,
+		dataBlockSize:       4096,
+		levelDataBlockSizes: []int{256, 4096},
+		logger:              logger.GetLogger(),
+		db:                  db,
+	}
+
+	assert.Equal(t, 256, lm.levelDataBlockSize(0))
+	assert.Equal(t, 4096, lm.levelDataBlockSize(5))
+	assert.Equal(t, 4096, (&levelManager{dataBlockSize: 4096}).levelDataBlockSize(5))
+
+	// non-positive sizes fall back to DataBlockByteThreshold without touching the slice of caller
+	sizes := []int{256, 0}
+	config := Config{DataBlockByteThreshold: 1024, LevelDataBlockByteThreshold: sizes}
+	assert.NoError(t, config.validate())
+	assert.Equal(t, []int{256, 1024}, config.LevelDataBlockByteThreshold)
+	assert.Equal(t, []int{256, 0}, sizes)
+
+	var kvs []types.Entry
+	for i := range 1000 {
+		kvs = append(kvs, types.Entry{
+			Key:     types.KeyWithTs(fmt.Sprintf("key%04d", i), 1),
+			Value:   []byte(fmt.Sprintf("value of key%04d", i)),
+			Version: 1,
+		})
+	}
+
+	err := lm.flushToL0(kvs)
+	assert.NoError(t, err)
+	l0Blocks := len(lm.levels[0].Front().Value.(tableHandle).dataBlockIndex.Entries)
+
+	// the same entries compacted into L1 are built with larger blocks
+	err = lm.compactAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, lm.levels[0].Len())
+	assert.Equal(t, 1, lm.levels[1].Len())
+	l1Blocks := len(lm.levels[1].Front().Value.(tableHandle).dataBlockIndex.Entries)
+	assert.Greater(t, l0Blocks, l1Blocks)
+
+	// tables of both block sizes are read the same way
+	var more []types.Entry
+	for i := range 1000 {
+		more = append(more, types.Entry{
+			Key:     types.KeyWithTs(fmt.Sprintf("more%04d", i), 2),
+			Value:   []byte(fmt.Sprintf("value of more%04d", i)),
+			Version: 2,
+		})
+	}
+	err = lm.flushToL0(more)
+	assert.NoError(t, err)
+	for _, kv := range append(kvs, more...) {
+		entry, found := lm.searchLowerBound(kv.Key)
+		assert.True(t, found)
+		assert.Equal(t, kv.Value, entry.Value)
+	}
+}
+
+func compactionWorkload(t *testing.T, strategy CompactionStrategy) *levelManager {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		strategy:      strategy,
+		logger:        logger.GetLogger(),
+		db:            db,
+	}
+
+	// every flush updates keys spread over the whole key space
+	for ts := range 64 {
+		var kvs []types.Entry
+		for i := range 100 {
+			key := fmt.Sprintf("key%04d", (i*7+ts*13)%400)
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(key, uint64(ts+1)),
+				Value:   []byte(fmt.Sprintf("value%d", ts)),
+				Version: int64(ts + 1),
+			})
+		}
+		slices.SortFunc(kvs, func(a, b types.Entry) int {
+			return types.CompareKeys(a.Key, b.Key)
+		})
+		err := lm.flushToL0(kvs)
+		assert.NoError(t, err)
+		lm.checkAndCompact()
+	}
+	return lm
+}
+
+func TestScanPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		start, end string
+		n          int
+		prefix     string
+		ok         bool
+	}{
+		{"user1", "user2", 5, "user1", true},
+		{"user1:a", "user1:b", 5, "user1", true},
+		{"user1:", "user1;", 5, "user1", true},
+		// keys of user2 are in range
+		{"user1", "user3", 5, "", false},
+		{"user1", "user2\x00", 5, "", false},
+		// start is shorter than the prefix
+		{"use", "usf", 5, "", false},
+		{"user1", "user2", 0, "", false},
+		// carry of 0xff bytes
+		{"a\xff", "b", 2, "a\xff", true},
+		{"\xff\xff", "\xff\xff\xff", 2, "\xff\xff", true},
+	} {
+		prefix, ok := scanPrefix(tc.start, tc.end, tc.n)
+		assert.Equal(t, tc.ok, ok, tc)
+		if ok {
+			assert.Equal(t, tc.prefix, prefix, tc)
+		}
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+		levels:        []*list.List{list.New(), list.New()},
+	}
+	newTable := func(keys ...string) tableHandle {
+		var entries []types.Entry
+		for _, key := range keys {
+			entries = append(entries, types.Entry{Key: key})
+		}
+		index, _ := table.Build(entries, lm.dataBlockSize, 0, lm.levelCompression(0))
+		return tableHandle{dataBlockIndex: index}
+	}
+
+	// boundaries of L0 tables only differ by ts
+	l0 := lm.levels[0]
+	l0.PushBack(newTable("key1@5", "key5@9"))
+	l0.PushBack(newTable("key1@10", "key5@10"))
+	l0.PushBack(newTable("key1@3", "key5@7"))
+
+	start, end := boundary(l0.Front(), l0.Front().Next(), l0.Back())
+	assert.Equal(t, "key1@10", start)
+	assert.Equal(t, "key5@7", end)
+
+	// L1 table ends with an older version of the start key
+	l1 := lm.levels[1]
+	l1.PushBack(newTable("key0@1", "key1@4"))
+	// L1 table starts with a newer version of the end key
+	l1.PushBack(newTable("key5@8", "key6@1"))
+	// L1 tables out of range
+	l1.PushBack(newTable("key0@1", "key0@2"))
+	l1.PushBack(newTable("key6@1", "key7@1"))
+
+	overlaps := lm.overlapLN(1, start, end)
+	assert.Equal(t, []*list.Element{l1.Front(), l1.Front().Next()}, overlaps)
+}
+
+func TestOverlapL0(t *testing.T) {
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+		levels:        []*list.List{list.New()},
+	}
+	newTable := func(keys ...string) tableHandle {
+		var entries []types.Entry
+		for _, key := range keys {
+			entries = append(entries, types.Entry{Key: key})
+		}
+		index, _ := table.Build(entries, lm.dataBlockSize, 0, lm.levelCompression(0))
+		return tableHandle{dataBlockIndex: index}
+	}
+
+	// old -> new
+	l0 := lm.levels[0]
+	l0.PushBack(newTable("key1@1", "key2@1"))
+	l0.PushBack(newTable("key3@2", "key4@2"))
+	// overlaps the oldest table and the second one through it
+	l0.PushBack(newTable("key2@3", "key3@3"))
+	l0.PushBack(newTable("key5@4", "key6@4"))
+
+	overlaps := lm.overlapL0()
+	assert.Equal(t, []*list.Element{l0.Front(), l0.Front().Next(), l0.Back().Prev()}, overlaps)
+}
+
+func TestTieredCompaction(t *testing.T) {
+	leveled := compactionWorkload(t, Leveled)
+	tiered := compactionWorkload(t, Tiered)
+
+	// each full bucket of 4 tables is merged into one table of the next level
+	assert.Equal(t, 0, tiered.levels[0].Len())
+	assert.Equal(t, 0, tiered.levels[1].Len())
+	assert.Equal(t, 0, tiered.levels[2].Len())
+	assert.Equal(t, 1, tiered.levels[3].Len())
+
+	// tiered does not rewrite tables of the next level
+	assert.Less(t, tiered.written, leveled.written)
+
+	// all keys are readable after compaction
+	for i := range 400 {
+		key := types.KeyWithTs(fmt.Sprintf("key%04d", i), 64)
+		entry, found := tiered.searchLowerBound(key)
+		assert.True(t, found)
+		assert.True(t, types.IsSameKey(key, entry.Key))
+	}
+}
+
+func TestCompactionTargetFileSize(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:            t.TempDir(),
+		l0TargetNum:    4,
+		ratio:          10,
+		dataBlockSize:  256,
+		targetFileSize: 2048,
+		logger:         logger.GetLogger(),
+		db:             db,
+	}
+
+	// 5 overlapping flushes trigger a compaction of L0 into L1
+	for ts := 1; ts <= 5; ts++ {
+		var kvs []types.Entry
+		for i := range 200 {
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(fmt.Sprintf("key%04d", i), uint64(ts)),
+				Value:   []byte(fmt.Sprintf("value%d", ts)),
+				Version: int64(ts),
+			})
+		}
+		err := lm.flushToL0(kvs)
+		assert.NoError(t, err)
+		lm.checkAndCompact()
+	}
+
+	assert.Equal(t, 0, lm.levels[0].Len())
+	assert.Greater(t, lm.levels[1].Len(), 1)
+
+	var handles []tableHandle
+	for e := lm.levels[1].Front(); e != nil; e = e.Next() {
+		th := e.Value.(tableHandle)
+		handles = append(handles, th)
+
+		// output is bounded by target file size plus the data blocks crossing it,
+		// a cut is delayed by one block if it would split versions of a key
+		var size int
+		for _, entry := range lm.fetch(1, th, th.dataBlockIndex.DataBlock).Entries {
+			size += types.EncodedSize(entry)
+		}
+		assert.LessOrEqual(t, size, lm.targetFileSize+3*lm.dataBlockSize)
+	}
+
+	// output files have non-overlapping ranges, versions of a key are in the same file
+	slices.SortFunc(handles, func(a, b tableHandle) int {
+		return types.CompareKeys(a.dataBlockIndex.Entries[0].StartKey, b.dataBlockIndex.Entries[0].StartKey)
+	})
+	for i := 1; i < len(handles); i++ {
+		prevEnd := handles[i-1].dataBlockIndex.Entries[len(handles[i-1].dataBlockIndex.Entries)-1].EndKey
+		currStart := handles[i].dataBlockIndex.Entries[0].StartKey
+		assert.Less(t, types.CompareKeys(prevEnd, currStart), 0)
+		assert.False(t, types.IsSameKey(prevEnd, currStart))
+	}
+
+	// all keys are readable after compaction
+	for i := range 200 {
+		key := types.KeyWithTs(fmt.Sprintf("key%04d", i), 5)
+		entry, found := lm.searchLowerBound(key)
+		assert.True(t, found)
+		assert.Equal(t, key, entry.Key)
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:            t.TempDir(),
+		l0TargetNum:    4,
+		ratio:          10,
+		dataBlockSize:  256,
+		targetFileSize: 2048,
+		logger:         logger.GetLogger(),
+		db:             db,
+	}
+
+	var kvs []types.Entry
+	for i := range 200 {
+		kvs = append(kvs, types.Entry{
+			Key:     types.KeyWithTs(fmt.Sprintf("key%04d", i), 1),
+			Value:   []byte("value"),
+			Version: 1,
+		})
+	}
+	assert.NoError(t, lm.flushToL0(kvs))
+
+	// L0 is compacted into the empty L1
+	assert.NoError(t, lm.compactRange("key0000", "key0000"))
+	assert.Equal(t, 0, lm.levels[0].Len())
+	l1 := lm.levels[1].Len()
+	assert.Greater(t, l1, 1)
+
+	// tables of the deepest level stay
+	assert.NoError(t, lm.compactRange("key0000", "key0001"))
+	assert.Equal(t, l1, lm.levels[1].Len())
+
+	// only tables of L1 holding keys of the range are compacted into L2 once it is deeper
+	assert.NoError(t, lm.compactTable(1, lm.levels[1].Back()))
+	assert.NoError(t, lm.compactRange("key0000", "key0001"))
+	assert.Equal(t, 2, lm.levels[2].Len())
+	assert.Equal(t, l1-2, lm.levels[1].Len())
+	assert.Empty(t, lm.overlapLN(1, "key0000@1", "key0001@1"))
+	assert.NoError(t, lm.checkOverlaps())
+
+	for i := range 200 {
+		key := types.KeyWithTs(fmt.Sprintf("key%04d", i), 1)
+		entry, found := lm.searchLowerBound(key)
+		assert.True(t, found)
+		assert.Equal(t, key, entry.Key)
+	}
+}
+
+func TestMaxLevels(t *testing.T) {
+	for _, strategy := range []CompactionStrategy{Leveled, Tiered} {
+		db := &DB{oracle: newOracle()}
+		t.Cleanup(db.oracle.Stop)
+
+		lm := &levelManager{
+			dir:            t.TempDir(),
+			l0TargetNum:    1,
+			ratio:          2,
+			dataBlockSize:  500,
+			targetFileSize: 2048,
+			strategy:       strategy,
+			maxLevels:      3,
+			logger:         logger.GetLogger(),
+			db:             db,
+		}
+
+		// without a cap, leveled grows 5 levels, tiered a new level at each flush
+		newest := make(map[string]string)
+		for ts := 1; ts <= 64; ts++ {
+			var kvs []types.Entry
+			for i := range 50 {
+				key := fmt.Sprintf("key%04d", (i*7+ts*13)%800)
+				value := fmt.Sprintf("value%d", ts)
+				kvs = append(kvs, types.Entry{
+					Key:     types.KeyWithTs(key, uint64(ts)),
+					Value:   []byte(value),
+					Version: int64(ts),
+				})
+				newest[key] = value
+			}
+			slices.SortFunc(kvs, func(a, b types.Entry) int {
+				return types.CompareKeys(a.Key, b.Key)
+			})
+			err := lm.flushToL0(kvs)
+			assert.NoError(t, err)
+			lm.checkAndCompact()
+		}
+
+		assert.Len(t, lm.levels, 3, strategy)
+		assert.Greater(t, lm.levels[2].Len(), 0, strategy)
+
+		// every key resolves to its newest value
+		for key, value := range newest {
+			entry, found := lm.searchLowerBound(types.KeyWithTs(key, 64))
+			assert.True(t, found)
+			assert.True(t, types.IsSameKey(key+"@0", entry.Key), key)
+			assert.Equal(t, []byte(value), entry.Value, key)
+		}
+	}
+}
+
+func TestCompactionTriggerByBytes(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:            t.TempDir(),
+		l0TargetNum:    1,
+		ratio:          2,
+		dataBlockSize:  256,
+		targetFileSize: 512,
+		compression:    []utils.CompressionLevel{utils.CompressionNone},
+		trigger:        TriggerByBytes,
+		l1TargetBytes:  16 * _kb,
+		logger:         logger.GetLogger(),
+		db:             db,
+	}
+	entries := func(prefix string, valueSize int) []types.Entry {
+		var kvs []types.Entry
+		for i := range 100 {
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(fmt.Sprintf("%s%04d", prefix, i), 1),
+				Value:   bytes.Repeat([]byte{byte(i)}, valueSize),
+				Version: 1,
+			})
+		}
+		return kvs
+	}
+	levelBytes := func(level int) uint64 {
+		var size uint64
+		for e := lm.levels[level].Front(); e != nil; e = e.Next() {
+			size += e.Value.(tableHandle).size
+		}
+		return size
+	}
+
+	// many small tables, over the target count of L1 but under its target bytes
+	assert.NoError(t, lm.ingest(entries("a", 10)))
+	assert.Greater(t, lm.levels[1].Len(), lm.l0TargetNum*lm.ratio)
+	assert.Less(t, levelBytes(1), lm.l1TargetBytes)
+	assert.NoError(t, lm.checkAndCompact())
+	assert.Len(t, lm.levels, 2)
+
+	// one large table takes L1 over its target bytes
+	lm.targetFileSize = 0
+	assert.NoError(t, lm.ingest(entries("b", 300)))
+	assert.Greater(t, levelBytes(1), lm.l1TargetBytes)
+	assert.NoError(t, lm.checkAndCompact())
+	assert.Len(t, lm.levels, 3)
+	assert.Greater(t, lm.levels[2].Len(), 0)
+
+	// all keys are readable after compaction
+	for _, prefix := range []string{"a", "b"} {
+		for i := range 100 {
+			key := types.KeyWithTs(fmt.Sprintf("%s%04d", prefix, i), 1)
+			entry, found := lm.searchLowerBound(key)
+			assert.True(t, found)
+			assert.Equal(t, key, entry.Key)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:           t.TempDir(),
+		l0TargetNum:   1,
+		ratio:         2,
+		dataBlockSize: 500,
+		logger:        logger.GetLogger(),
+		db:            db,
+	}
+
+	// overlapping ranges, a later flush has newer versions
+	ranges := [][2]int{{100, 200}, {150, 300}, {250, 400}, {500, 600}, {550, 800}, {100, 1000}}
+	newest := make(map[string]string)
+	for i, r := range ranges {
+		ts := uint64(i + 1)
+		var kvs []types.Entry
+		for k := r[0]; k <= r[1]; k++ {
+			key := fmt.Sprintf("key%04d", k)
+			value := fmt.Sprintf("value%d-%d", k, ts)
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(key, ts),
+				Value:   []byte(value),
+				Version: int64(ts),
+			})
+			newest[key] = value
+		}
+		err := lm.flushToL0(kvs)
+		assert.NoError(t, err)
+
+		lm.checkAndCompact()
+	}
+	lm.compactAll()
+	assert.Equal(t, 0, lm.levels[0].Len())
+
+	// every key resolves to its newest value
+	for key, value := range newest {
+		entry, found := lm.searchLowerBound(types.KeyWithTs(key, uint64(len(ranges))))
+		assert.True(t, found)
+		assert.True(t, types.IsSameKey(key+"@0", entry.Key), key)
+		assert.Equal(t, []byte(value), entry.Value, key)
+	}
+
+	// no version is stored twice across levels
+	seen := make(map[string]struct{})
+	for level, tables := range lm.levels {
+		for e := tables.Front(); e != nil; e = e.Next() {
+			th := e.Value.(tableHandle)
+			for _, entry := range lm.fetch(level, th, th.dataBlockIndex.DataBlock).Entries {
+				_, dup := seen[entry.Key]
+				assert.False(t, dup, entry.Key)
+				seen[entry.Key] = struct{}{}
+			}
+		}
+	}
+}
+
+func BenchmarkSearchDuringCompaction(b *testing.B) {
+	db := &DB{oracle: newOracle()}
+	b.Cleanup(db.oracle.Stop)
+
+	lm := &levelManager{
+		dir:           b.TempDir(),
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+		db:            db,
+	}
+
+	flush := func(ts int) {
+		var kvs []types.Entry
+		for i := range 2000 {
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(fmt.Sprintf("key%04d", i), uint64(ts)),
+				Value:   []byte(fmt.Sprintf("value%d", ts)),
+				Version: int64(ts),
+			})
+		}
+		assert.NoError(b, lm.flushToL0(kvs))
+	}
+	flush(1)
+
+	// flush and compact in the background until searches are done
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ts := 2; ; ts++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			flush(ts)
+			lm.checkAndCompact()
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			key := types.KeyWithTs(fmt.Sprintf("key%04d", i%2000), math.MaxUint64)
+			_, found := lm.searchLowerBound(key)
+			assert.True(b, found)
+			i++
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+}
+
+// point lookups of small values with and without compression
+func BenchmarkSearchCompression(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		level utils.CompressionLevel
+	}{
+		{"s2", utils.CompressionDefault},
+		{"none", utils.CompressionNone},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			lm := &levelManager{
+				dir:           b.TempDir(),
+				l0TargetNum:   4,
+				ratio:         10,
+				dataBlockSize: 4096,
+				filterP:       []float64{0.01},
+				compression:   []utils.CompressionLevel{bc.level},
+				logger:        logger.GetLogger(),
+			}
+
+			var kvs []types.Entry
+			for i := range 10000 {
+				kvs = append(kvs, types.Entry{
+					Key:     types.KeyWithTs(fmt.Sprintf("key%05d", i), 1),
+					Value:   []byte(fmt.Sprintf("value%d", i)),
+					Version: 1,
+				})
+			}
+			assert.NoError(b, lm.flushToL0(kvs))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, found := lm.searchLowerBound(types.KeyWithTs(fmt.Sprintf("key%05d", i%10000), math.MaxUint64))
+				if !found {
+					b.Fatal("key not found")
+				}
+			}
+		})
+	}
+}
+
+func TestDisableCompression(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+		CompressionLevel:       []CompressionLevel{CompressionBest},
+	}
+	// same values written by a db with compression, then by a db without it
+	write := func(ts int) int64 {
+		db, err := Open(dir, config)
+		assert.NoError(t, err)
+		defer db.Close()
+
+		err = db.Update(func(txn *Txn) error {
+			for i := range 1000 {
+				if err := txn.Set(fmt.Sprintf("key%04d-%d", i, ts), []byte(fmt.Sprintf("value of key%04d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Sync())
+
+		levels := db.manager.acquire()
+		defer db.manager.release()
+		return int64(levels[0][0].size)
+	}
+	compressed := write(0)
+	config.DisableCompression = true
+	raw := write(1)
+	assert.Greater(t, raw, compressed)
+
+	// tables written with either setting are readable by both
+	for _, disable := range []bool{true, false} {
+		config.DisableCompression = disable
+		db, err := Open(dir, config)
+		assert.NoError(t, err)
+		err = db.View(func(txn *Txn) error {
+			for i := range 1000 {
+				for ts := range 2 {
+					value, ok := txn.Get(fmt.Sprintf("key%04d-%d", i, ts))
+					assert.True(t, ok)
+					assert.Equal(t, []byte(fmt.Sprintf("value of key%04d", i)), value)
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		db.Close()
+	}
+}
+
+func TestFlushEmpty(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+
+	assert.NoError(t, lm.flushToL0(nil))
+	assert.NoError(t, lm.flushToL0([]types.Entry{}))
+	assert.Empty(t, lm.buildTables(nil, 1, 0))
+
+	// no sstable is created
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	assert.Empty(t, lm.levels)
+}
+
+func TestFlushDuplicateKeys(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+
+	for _, kvs := range [][]types.Entry{
+		// same key and ts
+		{
+			{Key: "key1@2", Value: []byte("value1")},
+			{Key: "key1@2", Value: []byte("value2")},
+		},
+		// older version before newer one
+		{
+			{Key: "key1@1", Value: []byte("value1")},
+			{Key: "key1@2", Value: []byte("value2")},
+		},
+		// unsorted user keys
+		{
+			{Key: "key2@1", Value: []byte("value2")},
+			{Key: "key1@1", Value: []byte("value1")},
+		},
+	} {
+		assert.ErrorIs(t, lm.flushToL0(kvs), ErrUnsortedEntries)
+	}
+
+	// no sstable is created
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// versions of the same key are sorted newest first
+	assert.NoError(t, lm.flushToL0([]types.Entry{
+		{Key: "key1@2", Value: []byte("value2")},
+		{Key: "key1@1", Value: []byte("value1")},
+		{Key: "key2@1", Value: []byte("value2")},
+	}))
+}
+
+func TestFlushVersionMismatch(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+		debug:         true,
+	}
+
+	kvs := []types.Entry{
+		{Key: "key1@2", Value: []byte("value2"), Version: 2},
+		{Key: "key1@1", Value: []byte("value1"), Version: 3},
+	}
+	assert.ErrorIs(t, lm.flushToL0(kvs), ErrVersionMismatch)
+
+	// no sstable is created
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	kvs[1].Version = 1
+	assert.NoError(t, lm.flushToL0(kvs))
+
+	// not checked without debug
+	lm.debug = false
+	assert.NoError(t, lm.flushToL0([]types.Entry{{Key: "key2@1", Value: []byte("value2")}}))
+}
+
+func TestVerifyOverlappingTables(t *testing.T) {
+	dir := t.TempDir()
+	lm := &levelManager{
+		dir:           dir,
+		l0TargetNum:   4,
+		ratio:         10,
+		dataBlockSize: 4096,
+		logger:        logger.GetLogger(),
+	}
+
+	// [key1, key3] and [key3, key5], the same user key in both
+	assert.NoError(t, lm.flushToL0([]types.Entry{
+		{Key: "key1@1", Value: []byte("value1")},
+		{Key: "key3@1", Value: []byte("value3")},
+	}))
+	assert.NoError(t, lm.flushToL0([]types.Entry{
+		{Key: "key3@2", Value: []byte("value3")},
+		{Key: "key5@2", Value: []byte("value5")},
+	}))
+	// tables of L0 may overlap
+	assert.NoError(t, lm.verify())
+
+	// install them as L1 tables by a buggy compaction
+	lm.levels = append(lm.levels, lm.levels[0])
+	lm.levels[0] = list.New()
+	assert.ErrorIs(t, lm.verify(), ErrOverlappingTables)
+
+	// tables of a level of Tiered compaction may overlap
+	lm.strategy = Tiered
+	assert.NoError(t, lm.verify())
+	lm.strategy = Leveled
+
+	// adjacent tables do not overlap
+	lm.levels[1].Remove(lm.levels[1].Back())
+	assert.NoError(t, lm.flushToL0([]types.Entry{
+		{Key: "key4@3", Value: []byte("value4")},
+		{Key: "key5@3", Value: []byte("value5")},
+	}))
+	lm.levels[1].PushBack(lm.levels[0].Remove(lm.levels[0].Front()))
+	assert.NoError(t, lm.verify())
+}
+
+func TestCompactEmptyResult(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1024,
+		ImmutableBuffer:        10,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			if err := txn.Set(fmt.Sprintf("key:%03d", i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	assert.Greater(t, db.Stats().Tables, 0)
+
+	// every entry of sstables is discarded by compaction
+	assert.NoError(t, db.DropPrefix("key:"))
+	assert.NoError(t, db.View(func(txn *Txn) error { return nil }))
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), db.manager.drops[0].ts))
+	db.triggerCompaction()
+
+	assert.Equal(t, 0, db.Stats().Tables)
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, file := range files {
+		assert.NotEqual(t, _dbExt, path.Ext(file.Name()))
+	}
+}
+
+func TestBottomLevelOverTarget(t *testing.T) {
+	db := &DB{oracle: newOracle()}
+	t.Cleanup(db.oracle.Stop)
+
+	recorder := metrics.NewMemory()
+	lm := &levelManager{
+		dir:            t.TempDir(),
+		l0TargetNum:    1,
+		ratio:          4,
+		dataBlockSize:  500,
+		targetFileSize: 2048,
+		maxLevels:      3,
+		trigger:        TriggerByCount,
+		metrics:        recorder,
+		logger:         logger.GetLogger(),
+		db:             db,
+	}
+
+	// distinct keys at each flush, the bottom level has no stale version to drop
+	ts := 0
+	flush := func() {
+		ts++
+		var kvs []types.Entry
+		for i := range 50 {
+			kvs = append(kvs, types.Entry{
+				Key:     types.KeyWithTs(fmt.Sprintf("key%04d-%03d", ts, i), uint64(ts)),
+				Value:   []byte(fmt.Sprintf("value%d", ts)),
+				Version: int64(ts),
+			})
+		}
+		slices.SortFunc(kvs, func(a, b types.Entry) int {
+			return types.CompareKeys(a.Key, b.Key)
+		})
+		assert.NoError(t, lm.flushToL0(kvs))
+		assert.NoError(t, lm.checkAndCompact())
+	}
+	bottomSize := func() uint64 {
+		var size uint64
+		for e := lm.levels[2].Front(); e != nil; e = e.Next() {
+			size += e.Value.(tableHandle).size
+		}
+		return size
+	}
+
+	for len(lm.levels) < 3 || !lm.overTarget(2) {
+		flush()
+	}
+	// compactLN moves a table at a time, let the level above settle
+	for lm.overTarget(1) {
+		assert.NoError(t, lm.checkAndCompact())
+	}
+
+	// without new input, compaction leaves the bottom level over its target
+	written := recorder.Snapshot()[metrics.CompactionBytes]
+	for range 3 {
+		assert.NoError(t, lm.checkAndCompact())
+	}
+	assert.Equal(t, written, recorder.Snapshot()[metrics.CompactionBytes])
+	assert.True(t, lm.overTarget(2))
+
+	// input compacted from the level above is merged with overlapping tables only, the rest of the bottom
+	// level is not rewritten at each flush
+	for range 8 {
+		flush()
+	}
+	assert.Less(t, recorder.Snapshot()[metrics.CompactionBytes]-written, bottomSize())
+}
+
+func TestCompactionRateLimit(t *testing.T) {
+	// compact about 1.5MB of incompressible values, return elapsed time and bytes written
+	compact := func(limiter *ratelimit.Limiter) (time.Duration, uint64) {
+		db := &DB{oracle: newOracle()}
+		t.Cleanup(db.oracle.Stop)
+
+		recorder := metrics.NewMemory()
+		lm := &levelManager{
+			dir:           t.TempDir(),
+			l0TargetNum:   4,
+			ratio:         10,
+			dataBlockSize: 4096,
+			limiter:       limiter,
+			metrics:       recorder,
+			logger:        logger.GetLogger(),
+			db:            db,
+		}
+
+		value := make([]byte, 1024)
+		for ts := range 3 {
+			var kvs []types.Entry
+			for i := range 500 {
+				_, _ = rand.Read(value)
+				kvs = append(kvs, types.Entry{
+					Key:     types.KeyWithTs(fmt.Sprintf("key%04d", ts*500+i), uint64(ts+1)),
+					Value:   slices.Clone(value),
+					Version: int64(ts + 1),
+				})
+			}
+			assert.NoError(t, lm.flushToL0(kvs))
+		}
+
+		start := time.Now()
+		lm.compactAll()
+		return time.Since(start), recorder.Snapshot()[metrics.CompactionBytes]
+	}
+
+	// bytes over the first second of tokens are written at 1MB/s, the limiter holds at most a second of them,
+	// so compaction takes at least as long as the rest of written bytes take at the rate. only the lower bound is
+	// asserted, an upper one or a comparison with an unlimited run depends on the speed of the machine.
+	rate := 1 << 20
+	limited, written := compact(ratelimit.New(rate))
+	assert.Greater(t, written, uint64(rate))
+	expected := time.Duration(float64(written-uint64(rate)) / float64(rate) * float64(time.Second))
+	assert.GreaterOrEqual(t, limited, expected)
+}
+
+func TestRewriteTable(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	set := func(keys ...int) {
+		err := db.Update(func(txn *Txn) error {
+			for _, i := range keys {
+				if err := txn.Set(fmt.Sprintf("key%03d", i), bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	del := func(keys ...int) {
+		err := db.Update(func(txn *Txn) error {
+			for _, i := range keys {
+				if err := txn.Delete(fmt.Sprintf("key%03d", i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	// versions no txn can read are discardable
+	discardable := func() {
+		assert.NoError(t, db.View(func(txn *Txn) error { return nil }))
+		assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), db.oracle.nextTs-1))
+	}
+	check := func(live, deleted []int) {
+		err := db.View(func(txn *Txn) error {
+			for _, i := range live {
+				value, ok := txn.Get(fmt.Sprintf("key%03d", i))
+				assert.True(t, ok)
+				assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 100), value)
+			}
+			for _, i := range deleted {
+				_, ok := txn.Get(fmt.Sprintf("key%03d", i))
+				assert.False(t, ok)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	tableSize := func(level, idx int) int64 {
+		info, err := os.Stat(db.manager.fileName(level, idx))
+		assert.NoError(t, err)
+		return info.Size()
+	}
+
+	var live, deleted []int
+	for i := range 100 {
+		if i%10 == 0 {
+			live = append(live, i)
+		} else {
+			deleted = append(deleted, i)
+		}
+	}
+
+	// one sstable of L0 with 90 keys set then deleted, nothing else holds them
+	set(append(live, deleted...)...)
+	del(deleted...)
+	assert.NoError(t, db.Sync())
+	discardable()
+
+	assert.ErrorIs(t, db.RewriteTable(0, 1), ErrTableNotFound)
+	assert.ErrorIs(t, db.RewriteTable(1, 0), ErrTableNotFound)
+	assert.ErrorIs(t, db.RewriteTable(-1, 0), ErrInvalidLevel)
+
+	before := tableSize(0, 0)
+	assert.NoError(t, db.RewriteTable(0, 0))
+	assert.Less(t, tableSize(0, 0), before)
+	entries, err := db.IterateLevel(0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, len(live))
+	for _, entry := range entries {
+		assert.False(t, entry.Tombstone)
+	}
+	check(live, deleted)
+
+	// nothing left to discard
+	before = tableSize(0, 0)
+	assert.NoError(t, db.RewriteTable(0, 0))
+	assert.Equal(t, before, tableSize(0, 0))
+
+	// tombstones shadowing versions in a deeper level are kept
+	db.manager.compactAll()
+	set(deleted...)
+	assert.NoError(t, db.Sync())
+	db.manager.compactAll()
+	del(deleted...)
+	assert.NoError(t, db.Sync())
+	discardable()
+	assert.NoError(t, db.RewriteTable(0, 0))
+	entries, err = db.IterateLevel(0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, len(deleted))
+	check(live, deleted)
+
+	// every entry is discarded
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	check(live, deleted)
+	db.manager.compactAll()
+	others := []int{100, 101, 102}
+	set(others...)
+	del(others...)
+	assert.NoError(t, db.Sync())
+	discardable()
+	levels := db.manager.acquire()
+	idx := levels[0][0].levelIdx
+	db.manager.release()
+	assert.NoError(t, db.RewriteTable(0, idx))
+	_, err = os.Stat(db.manager.fileName(0, idx))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	check(live, append(deleted, others...))
+}
+
+func TestTableInfos(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            100,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	start := time.Now().Truncate(time.Second)
+	flush := func(from, to int) {
+		err := db.Update(func(txn *Txn) error {
+			for i := from; i < to; i++ {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%04d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Sync())
+	}
+	// infos match sstable files in dir and their content
+	check := func(infos []TableInfo) {
+		files, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		var names []string
+		for _, file := range files {
+			if path.Ext(file.Name()) == _dbExt {
+				names = append(names, file.Name())
+			}
+		}
+		assert.Len(t, infos, len(names))
+
+		for _, info := range infos {
+			name := tableName(info.Level, info.Idx)
+			assert.Contains(t, names, name)
+
+			tableBytes, err := os.ReadFile(path.Join(dir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(len(tableBytes)), info.Size)
+			meta, err := table.ReadMeta(tableBytes)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(info.Level), meta.Level)
+			assert.Equal(t, meta.CreatedUnix, info.Created.Unix())
+			assert.False(t, info.Created.Before(start))
+
+			entries := db.manager.level(info.Level)
+			var own []types.Entry
+			for _, entry := range entries {
+				key := types.ParseKey(entry.Key)
+				if key >= info.Smallest && key <= info.Largest {
+					own = append(own, entry)
+				}
+			}
+			if info.Level > 0 {
+				// tables of a level other than L0 do not overlap
+				assert.Len(t, own, int(info.Entries))
+			}
+			assert.NotZero(t, info.Entries)
+			assert.Equal(t, meta.NumEntries, info.Entries)
+			assert.Greater(t, info.FilterBytes, 0)
+		}
+	}
+
+	flush(0, 100)
+	flush(100, 200)
+	flush(50, 150)
+	infos := db.TableInfos()
+	assert.Len(t, infos, 3)
+	// L0 is ordered from newest to oldest
+	assert.Equal(t, 2, infos[0].Idx)
+	assert.Equal(t, "key0050", infos[0].Smallest)
+	assert.Equal(t, "key0149", infos[0].Largest)
+	assert.Equal(t, uint64(100), infos[0].Entries)
+	assert.Equal(t, "key0000", infos[2].Smallest)
+	assert.Equal(t, "key0099", infos[2].Largest)
+	check(infos)
+
+	db.manager.compactAll()
+	flush(300, 310)
+	infos = db.TableInfos()
+	levels := make(map[int]uint64)
+	for _, info := range infos {
+		levels[info.Level] += info.Entries
+	}
+	assert.Equal(t, uint64(10), levels[0])
+	// versions of key50 to key149 are kept until discardable
+	assert.Equal(t, uint64(300), levels[1])
+	check(infos)
+
+	// infos of recovered sstables are the same
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, infos, db.TableInfos())
+	check(db.TableInfos())
+}
+
+func TestFlushCompactionCallbacks(t *testing.T) {
+	var mu sync.Mutex
+	type compaction struct {
+		inputs, outputs []TableInfo
+	}
+	var flushes []TableInfo
+	var compactions []compaction
+	// flushes and compactions in order, a flush has the table as the only output
+	var log []compaction
+	// callbacks in progress, they are called one at a time
+	var running atomic.Int32
+	serial := func() func() {
+		assert.Equal(t, int32(1), running.Add(1))
+		time.Sleep(time.Millisecond)
+		return func() { running.Add(-1) }
+	}
+
+	config := Config{
+		L0TargetNum:           100,
+		MemtableByteThreshold: 1 << 20,
+		OnFlush: func(level int, table TableInfo) {
+			defer serial()()
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, 0, level)
+			flushes = append(flushes, table)
+			log = append(log, compaction{outputs: []TableInfo{table}})
+		},
+		OnCompaction: func(inputs, outputs []TableInfo) {
+			defer serial()()
+			mu.Lock()
+			defer mu.Unlock()
+			compactions = append(compactions, compaction{inputs, outputs})
+			log = append(log, compaction{inputs, outputs})
+		},
+	}
+	db, err := Open(t.TempDir(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	flush := func(from, to int) {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			for i := from; i < to; i++ {
+				if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%04d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		assert.NoError(t, db.Sync())
+	}
+
+	flush(0, 100)
+	flush(50, 150)
+	l0 := db.TableInfos()
+	mu.Lock()
+	assert.Len(t, l0, 2)
+	assert.ElementsMatch(t, l0, flushes)
+	for _, info := range flushes {
+		assert.Equal(t, 0, info.Level)
+		assert.Equal(t, uint64(100), info.Entries)
+	}
+	assert.Empty(t, compactions)
+	mu.Unlock()
+
+	// L0 is merged into L1
+	assert.NoError(t, db.CompactRange("key0000", "key0149"))
+	l1 := db.TableInfos()
+	mu.Lock()
+	assert.Len(t, flushes, 2)
+	assert.Len(t, compactions, 1)
+	assert.ElementsMatch(t, l0, compactions[0].inputs)
+	assert.NotEmpty(t, l1)
+	assert.ElementsMatch(t, l1, compactions[0].outputs)
+	var entries uint64
+	for _, info := range compactions[0].outputs {
+		assert.Equal(t, 1, info.Level)
+		entries += info.Entries
+	}
+	// both versions of overlapping keys are kept, no txn has ended to discard the older ones
+	assert.Equal(t, uint64(200), entries)
+	assert.Equal(t, "key0000", compactions[0].outputs[0].Smallest)
+	assert.Equal(t, "key0149", compactions[0].outputs[len(compactions[0].outputs)-1].Largest)
+	flushes, compactions = nil, nil
+	mu.Unlock()
+
+	// sstables of column families are reported as well, flushes and compactions of all keyspaces run concurrently
+	cf, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 5 {
+				assert.NoError(t, db.Update(func(txn *Txn) error {
+					return errors.Join(txn.Set(fmt.Sprintf("key%04d", i), []byte("value")), txn.SetCF(cf, fmt.Sprintf("key%04d", i), []byte("value")))
+				}))
+				assert.NoError(t, db.Sync())
+				assert.NoError(t, db.CompactRange("key0000", "key0149"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	families := make(map[string]int)
+	for _, info := range flushes {
+		families[info.Family]++
+	}
+	assert.Equal(t, families[""], families["users"])
+	assert.Positive(t, families["users"])
+	// replaying events in order yields the sstables of the column family
+	type file struct {
+		level, idx int
+	}
+	live := make(map[file]TableInfo)
+	for _, c := range log {
+		for _, info := range c.inputs {
+			if info.Family == "users" {
+				delete(live, file{info.Level, info.Idx})
+			}
+		}
+		for _, info := range c.outputs {
+			if info.Family == "users" {
+				live[file{info.Level, info.Idx}] = info
+			}
+		}
+	}
+	assert.ElementsMatch(t, cf.manager.tableInfos(), slices.Collect(maps.Values(live)))
+}
+
+func TestHotPrefixes(t *testing.T) {
+	// deepest level holding keys of prefix
+	depth := func(db *DB, prefix string) int {
+		levels := db.manager.acquire()
+		defer db.manager.release()
+		deepest := -1
+		for level := range levels {
+			entries, err := db.IterateLevel(level)
+			assert.NoError(t, err)
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Key, prefix) {
+					deepest = level
+					break
+				}
+			}
+		}
+		return deepest
+	}
+	run := func(hotPrefixes []string) (hot, cold int) {
+		config := Config{
+			SkipListMaxLevel:       4,
+			SkipListP:              0.5,
+			L0TargetNum:            2,
+			LevelRatio:             2,
+			DataBlockByteThreshold: 256,
+			MemtableByteThreshold:  1 << 20,
+			TargetFileSize:         1024,
+			HotPrefixes:            hotPrefixes,
+		}
+		db, err := Open(t.TempDir(), config)
+		assert.NoError(t, err)
+		defer db.Close()
+
+		// hot and cold keys are written at the same rate
+		for round := range 20 {
+			err := db.Update(func(txn *Txn) error {
+				for i := range 20 {
+					value := []byte(fmt.Sprintf("value%04d", round))
+					if err := errors.Join(
+						txn.Set(fmt.Sprintf("cold%02d%04d", i, round), value),
+						txn.Set(fmt.Sprintf("hot%02d%04d", i, round), value),
+					); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, db.Sync())
+		}
+		return depth(db, "hot"), depth(db, "cold")
+	}
+
+	hot, cold := run(nil)
+	assert.Greater(t, cold, 1)
+	assert.Equal(t, cold, hot)
+	// cold tables are compacted into deeper levels first
+	hotPrefixed, coldPrefixed := run([]string{"hot"})
+	assert.Equal(t, cold, coldPrefixed)
+	assert.Less(t, hotPrefixed, coldPrefixed)
+	assert.Less(t, hotPrefixed, hot)
+
+	lm := &levelManager{hotPrefixes: []string{"b", "d1"}}
+	assert.True(t, lm.hot("a", "b"))
+	assert.True(t, lm.hot("a", "c"))
+	assert.True(t, lm.hot("b2", "c"))
+	assert.True(t, lm.hot("c", "d1"))
+	assert.False(t, lm.hot("a", "az"))
+	assert.False(t, lm.hot("c", "czz"))
+	assert.False(t, lm.hot("c", "d0z"))
+	assert.False(t, lm.hot("d2", "e"))
+}
+
+func TestWriteFileSync(t *testing.T) {
+	dir, scratch := t.TempDir(), t.TempDir()
+	name := path.Join(dir, "file")
+
+	assert.NoError(t, writeFileSync(name, []byte("data"), 0600))
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	// name can not be replaced by a file, temp files are removed
+	busy := path.Join(dir, "busy")
+	assert.NoError(t, os.MkdirAll(path.Join(busy, "child"), 0700))
+	assert.Error(t, writeFileSync(busy, []byte("data"), 0600))
+	assert.Error(t, writeFileSyncScratch(busy, []byte("data"), 0600, scratch, nil))
+
+	for _, d := range []string{dir, scratch} {
+		tmps, err := filepath.Glob(path.Join(d, "*"+_tmpExt))
+		assert.NoError(t, err)
+		assert.Empty(t, tmps)
+	}
+}
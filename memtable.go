@@ -15,6 +15,9 @@
 package originium
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path"
 	"slices"
@@ -22,36 +25,55 @@ import (
 	"time"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/logger"
-	"github.com/B1NARY-GR0UP/originium/pkg/skiplist"
 	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/B1NARY-GR0UP/originium/utils"
 	"github.com/B1NARY-GR0UP/originium/wal"
 )
 
 type memtable struct {
-	mu       sync.RWMutex
-	logger   logger.Logger
-	skiplist *skiplist.SkipList
+	mu     sync.RWMutex
+	logger logger.Logger
+	index  types.MemtableIndex
+	// nil in memory-only db
 	wal      *wal.WAL
 	dir      string
 	readOnly bool
+	// sstable of the immutable memtable is written, only its wal is left to be deleted
+	flushed bool
 }
 
-func newMemtable(dir string, maxLevel int, p float64) *memtable {
-	l, err := wal.Create(dir)
+func newMemtable(dir string, mode os.FileMode, index types.MemtableIndex, lg logger.Logger) *memtable {
+	l, err := wal.CreateWithOptions(dir, wal.Options{
+		Logger:   lg,
+		FileMode: mode,
+	})
 	if err != nil {
 		panic(err)
 	}
 	return &memtable{
-		logger:   logger.GetLogger(),
-		skiplist: skiplist.New(maxLevel, p),
+		logger:   lg,
+		index:    index,
 		wal:      l,
 		dir:      dir,
 		readOnly: false,
 	}
 }
 
-func (mt *memtable) recover() int64 {
+// newMemoryMemtable create a memtable without wal, for memory-only db or a column family,
+// whose writes are logged to the wal of the default keyspace
+func newMemoryMemtable(index types.MemtableIndex, lg logger.Logger) *memtable {
+	return &memtable{
+		logger:   lg,
+		index:    index,
+		readOnly: false,
+	}
+}
+
+// recover replay wal files left by the last run, entries of a column family are set to the memtable returned by
+// memtables for its name, "" is the default one, which is mt. return max version of entries.
+// in repair mode, entries of a wal before its first corrupted record are replayed and the rest is dropped,
+// so are entries of column families which do not exist.
+func (mt *memtable) recover(repair bool, memtables func(family string) (*memtable, bool)) (uint64, error) {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
 	defer utils.Elapsed(time.Now(), mt.logger, "memtable recover")
@@ -69,32 +91,68 @@ func (mt *memtable) recover() int64 {
 	}
 
 	if len(walFiles) == 0 {
-		return 0
+		return 0, nil
 	}
 
-	slices.Sort(walFiles)
+	// replay from old to new
+	slices.SortFunc(walFiles, func(a, b string) int {
+		return wal.CompareVersion(wal.ParseVersion(path.Base(a)), wal.ParseVersion(path.Base(b)))
+	})
 
-	var maxVersion int64
+	var maxVersion uint64
+	// the last entry of each internal key read from wal files per memtable, checked against them after recovery
+	recovered := make(map[*memtable]map[types.Key]types.Entry)
 
 	mt.logger.Infof("found %d wal file, recovery start", len(walFiles))
 	// merge wal files
 	for _, file := range walFiles {
-		l, err := wal.Open(file)
+		l, err := wal.OpenWithLogger(file, mt.logger)
 		if err != nil {
 			mt.logger.Panicf("open wal %v failed: %v", file, err)
 		}
 
-		entries, err := l.Read()
-		if err != nil {
+		records, err := l.ReadRecords()
+		if errors.Is(err, wal.ErrTruncatedRecord) {
+			// the torn tail was never acknowledged, commits before it are replayed as a whole
+			mt.logger.Warnf("wal %v is truncated, replay %d entries before the tail: %v", file, len(records), err)
+		} else if errors.Is(err, wal.ErrCorruptedRecord) && repair {
+			mt.logger.Warnf("wal %v is corrupted, replay %d entries before the corruption: %v", file, len(records), err)
+		} else if err != nil {
 			mt.logger.Panicf("read wal %v failed: %v", file, err)
 		}
 
-		for _, entry := range entries {
+		var version uint64
+		families := make(map[string][]types.Entry)
+		for _, record := range records {
+			target := mt
+			if record.Family != "" {
+				cfmt, ok := memtables(record.Family)
+				if !ok && repair {
+					mt.logger.Warnf("wal %v has entries of column family %v which does not exist, drop them", file, record.Family)
+					continue
+				}
+				if !ok {
+					return 0, fmt.Errorf("%w: wal %v has entries of column family %v", ErrCFNotFound, file, record.Family)
+				}
+				target = cfmt
+			}
 			// record max version
-			maxVersion = max(maxVersion, entry.Version)
-
-			mt.skiplist.Set(entry)
-			if err = mt.wal.Write(entry); err != nil {
+			version = max(version, types.Ts(record.Entry))
+			families[record.Family] = append(families[record.Family], record.Entry)
+			if recovered[target] == nil {
+				recovered[target] = make(map[types.Key]types.Entry)
+			}
+			recovered[target][record.Entry.Key] = record.Entry
+			if target == mt {
+				mt.index.Set(record.Entry)
+			} else {
+				target.apply([]types.Entry{record.Entry})
+			}
+		}
+		maxVersion = max(maxVersion, version)
+		// entries of the file are kept as one batch, so a crash during recovery does not tear them
+		if len(families) > 0 {
+			if err = mt.wal.WriteBatch(families, version, false); err != nil {
 				mt.logger.Panicf("write wal failed: %v", err)
 			}
 		}
@@ -105,7 +163,44 @@ func (mt *memtable) recover() int64 {
 	}
 	mt.logger.Infof("recovery finished")
 
-	return maxVersion
+	// a discrepancy is logged instead of failing Open, entries are in the new wal already
+	if err = mt.checkRecovered(recovered); err != nil {
+		mt.logger.Errorf("wal recovery self-check failed: %v", err)
+	}
+
+	return maxVersion, nil
+}
+
+// checkRecovered compare memtables with entries read from wal files by recover, which were empty before,
+// i.e. each memtable holds exactly the entries of it, and the ts of each internal key equals its Entry.Version.
+// mt.mu is held by the caller.
+func (mt *memtable) checkRecovered(recovered map[*memtable]map[types.Key]types.Entry) error {
+	var errs []error
+	for target, want := range recovered {
+		var got []types.Entry
+		if target == mt {
+			got = mt.index.All()
+		} else {
+			got = target.all()
+		}
+		if len(got) != len(want) {
+			errs = append(errs, fmt.Errorf("memtable has %d entries, %d read from wal", len(got), len(want)))
+		}
+		for _, entry := range got {
+			w, ok := want[entry.Key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("entry %q is not in wal", entry.Key))
+				continue
+			}
+			if !bytes.Equal(entry.Value, w.Value) || entry.Tombstone != w.Tombstone || entry.Version != w.Version {
+				errs = append(errs, fmt.Errorf("entry %q differs from wal", entry.Key))
+			}
+			if types.ParseTs(entry.Key) != types.Ts(entry) {
+				errs = append(errs, fmt.Errorf("%w: %q has version %d", ErrVersionMismatch, entry.Key, types.Ts(entry)))
+			}
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (mt *memtable) set(entry types.Entry) {
@@ -116,18 +211,35 @@ func (mt *memtable) set(entry types.Entry) {
 		mt.logger.Panicf("write readonly memtable")
 	}
 
-	mt.skiplist.Set(entry)
-	if err := mt.wal.Write(entry); err != nil {
-		mt.logger.Panicf("write wal failed: %v", err)
+	mt.index.Set(entry)
+	if mt.wal != nil {
+		if err := mt.wal.Write(entry); err != nil {
+			mt.logger.Panicf("write wal failed: %v", err)
+		}
+	}
+	mt.logger.Infof("memtable set [key: %v] [value: %v] [tombstone: %v] [version: %v]", entry.Key, string(entry.Value), entry.Tombstone, types.Ts(entry))
+}
+
+// apply set entries to the index, they are logged to wal by the caller, e.g. as one batch of a commit
+func (mt *memtable) apply(entries []types.Entry) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	if mt.readOnly {
+		mt.logger.Panicf("write readonly memtable")
+	}
+
+	for _, entry := range entries {
+		mt.index.Set(entry)
+		mt.logger.Infof("memtable set [key: %v] [value: %v] [tombstone: %v] [version: %v]", entry.Key, string(entry.Value), entry.Tombstone, types.Ts(entry))
 	}
-	mt.logger.Infof("memtable set [key: %v] [value: %v] [tombstone: %v] [version: %v]", entry.Key, string(entry.Value), entry.Tombstone, entry.Version)
 }
 
 func (mt *memtable) get(key types.Key) (types.Entry, bool) {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
 
-	return mt.skiplist.Get(key)
+	return mt.index.Get(key)
 }
 
 // first entry greater or equal than key
@@ -135,36 +247,39 @@ func (mt *memtable) lowerBound(key types.Key) (types.Entry, bool) {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
 
-	return mt.skiplist.LowerBound(key)
+	return mt.index.LowerBound(key)
 }
 
+// scan [start, end)
 func (mt *memtable) scan(start, end types.Key) []types.Entry {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
 
-	return mt.skiplist.Scan(start, end)
+	return mt.index.Scan(start, end)
 }
 
 func (mt *memtable) all() []types.Entry {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
 
-	return mt.skiplist.All()
+	return mt.index.All()
 }
 
 func (mt *memtable) size() int {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
 
-	return mt.skiplist.Size()
+	return mt.index.Size()
 }
 
 func (mt *memtable) freeze() {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
 
-	if err := mt.wal.Close(); err != nil {
-		mt.logger.Panicf("wal close failed: %v", err)
+	if mt.wal != nil {
+		if err := mt.wal.Close(); err != nil {
+			mt.logger.Panicf("wal close failed: %v", err)
+		}
 	}
 	mt.readOnly = true
 }
@@ -173,13 +288,17 @@ func (mt *memtable) reset() *memtable {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
 
-	l, err := mt.wal.Reset()
-	if err != nil {
-		mt.logger.Panicf("wal reset failed: %v", err)
+	// memtable of a column family has no wal of its own
+	var l *wal.WAL
+	if mt.wal != nil {
+		var err error
+		if l, err = mt.wal.Reset(); err != nil {
+			mt.logger.Panicf("wal reset failed: %v", err)
+		}
 	}
 	return &memtable{
-		logger:   logger.GetLogger(),
-		skiplist: mt.skiplist.Reset(),
+		logger:   mt.logger,
+		index:    mt.index.Reset(),
 		wal:      l,
 		dir:      mt.dir,
 		readOnly: false,
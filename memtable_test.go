@@ -15,15 +15,39 @@
 package originium
 
 import (
+	"os"
+	"path"
 	"testing"
 
+	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+	"github.com/B1NARY-GR0UP/originium/pkg/skiplist"
+	"github.com/B1NARY-GR0UP/originium/pkg/sortedslice"
 	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/wal"
 	"github.com/stretchr/testify/assert"
 )
 
+// memtable tests run against each index
+var _memtableIndexes = map[string]func() MemtableIndex{
+	"skiplist": func() MemtableIndex {
+		return skiplist.New(4, 0.5)
+	},
+	"sortedslice": func() MemtableIndex {
+		return sortedslice.New()
+	},
+}
+
 func TestMemtableSetAndGet(t *testing.T) {
+	for name, index := range _memtableIndexes {
+		t.Run(name, func(t *testing.T) {
+			testMemtableSetAndGet(t, index)
+		})
+	}
+}
+
+func testMemtableSetAndGet(t *testing.T, index func() MemtableIndex) {
 	dir := t.TempDir()
-	mt := newMemtable(dir, 4, 0.5)
+	mt := newMemtable(dir, DefaultConfig.FileMode, index(), logger.GetLogger())
 
 	entry := types.Entry{Key: "key1@1", Value: []byte("value1"), Tombstone: false}
 
@@ -39,3 +63,113 @@ func TestMemtableSetAndGet(t *testing.T) {
 	err := mt.wal.Delete()
 	assert.NoError(t, err)
 }
+
+func TestMemtableRecoverMultipleWAL(t *testing.T) {
+	for name, index := range _memtableIndexes {
+		t.Run(name, func(t *testing.T) {
+			testMemtableRecoverMultipleWAL(t, index)
+		})
+	}
+}
+
+func testMemtableRecoverMultipleWAL(t *testing.T, index func() MemtableIndex) {
+	dir := t.TempDir()
+
+	// interleaved versions of the same key in two wal files created in the same second,
+	// the nanoseconds of the older one are longer as a string
+	files := map[string][]types.Entry{
+		"wal-20250101000000-999.log": {
+			{Key: "key@1", Value: []byte("v1"), Version: 1},
+			{Key: "key@3", Value: []byte("v3"), Version: 3},
+		},
+		"wal-20250101000000-1000.log": {
+			{Key: "key@2", Value: []byte("v2"), Version: 2},
+			{Key: "key@4", Value: []byte("v4"), Version: 4},
+			{Key: "other@1", Value: []byte("other"), Version: 1},
+		},
+	}
+	for name, entries := range files {
+		l, err := wal.Create(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, l.Write(entries...))
+		assert.NoError(t, l.Close())
+		assert.NoError(t, os.Rename(path.Join(dir, "wal-"+l.Version()+".log"), path.Join(dir, name)))
+	}
+
+	db, err := Open(dir, Config{MemtableIndex: index})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// all versions are recovered
+	for _, entries := range files {
+		for _, entry := range entries {
+			got, ok := db.memtable.get(entry.Key)
+			assert.True(t, ok)
+			assert.Equal(t, entry, got)
+		}
+	}
+
+	// oracle continues after the max recovered version
+	assert.Equal(t, uint64(5), db.oracle.nextTs)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("v4"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestMemtableRecoverSelfCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	// the second file repeats an entry of the first one, e.g. a crash after it was rewritten by recovery
+	files := map[string][]types.Entry{
+		"wal-20250101000000-1.log": {
+			{Key: "a@1", Value: []byte("a1"), Version: 1},
+			{Key: "b@2", Value: []byte("b2"), Version: 2},
+		},
+		"wal-20250101000000-2.log": {
+			{Key: "b@2", Value: []byte("b2"), Version: 2},
+			{Key: "a@3", Tombstone: true, Version: 3},
+			{Key: "c@3", Value: []byte("c3"), Version: 3},
+		},
+	}
+	for name, entries := range files {
+		l, err := wal.Create(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, l.Write(entries...))
+		assert.NoError(t, l.Close())
+		assert.NoError(t, os.Rename(path.Join(dir, "wal-"+l.Version()+".log"), path.Join(dir, name)))
+	}
+
+	mt := newMemtable(dir, DefaultConfig.FileMode, skiplist.New(4, 0.5), logger.GetLogger())
+	version, err := mt.recover(false, func(string) (*memtable, bool) { return nil, false })
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+
+	want := []types.Entry{
+		{Key: "a@3", Tombstone: true, Version: 3},
+		{Key: "a@1", Value: []byte("a1"), Version: 1},
+		{Key: "b@2", Value: []byte("b2"), Version: 2},
+		{Key: "c@3", Value: []byte("c3"), Version: 3},
+	}
+	assert.Equal(t, want, mt.all())
+
+	recovered := map[*memtable]map[types.Key]types.Entry{mt: {}}
+	for _, entry := range want {
+		recovered[mt][entry.Key] = entry
+	}
+	assert.NoError(t, mt.checkRecovered(recovered))
+
+	// an entry lost by recovery, and one whose key and version disagree
+	delete(recovered[mt], "c@3")
+	assert.Error(t, mt.checkRecovered(recovered))
+	recovered[mt]["c@3"] = types.Entry{Key: "c@3", Value: []byte("c3"), Version: 3}
+	mt.index.Set(types.Entry{Key: "d@4", Value: []byte("d4"), Version: 5})
+	recovered[mt]["d@4"] = types.Entry{Key: "d@4", Value: []byte("d4"), Version: 5}
+	assert.ErrorIs(t, mt.checkRecovered(recovered), ErrVersionMismatch)
+
+	assert.NoError(t, mt.wal.Delete())
+}
@@ -16,9 +16,14 @@ package originium
 
 import (
 	"context"
+	"maps"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/watermark"
+	"github.com/B1NARY-GR0UP/originium/types"
 )
 
 type oracle struct {
@@ -29,6 +34,8 @@ type oracle struct {
 
 	nextTs        uint64
 	lastCleanUpTs uint64
+	// discard watermark persisted before restart, floor of discardAtOrBelow
+	discardTs atomic.Uint64
 
 	// used to track active read txn
 	//
@@ -49,13 +56,22 @@ type oracle struct {
 	// and new read transactions can safely read this data.
 	commitMark *watermark.WaterMark
 
+	// ts of the first commit held in write buffer, 0 if none, txns begun meanwhile read before it
+	bufferedFrom uint64
+
 	committedTxns []committedTxn
+	// number of active serializable update txns, keys written are recorded for them if > 0
+	serializable atomic.Int64
 }
 
 type committedTxn struct {
 	// commitTs
 	ts       uint64
 	writesFp map[uint64]struct{}
+	// sorted keys written to each keyspace, nil is the default one, for range conflict detection
+	keys map[*CF][]types.Key
+	// keys of the default keyspace are unknown, e.g. spilled to disk, any range of it conflicts with the txn
+	allKeys bool
 }
 
 func newOracle() *oracle {
@@ -65,45 +81,177 @@ func newOracle() *oracle {
 	}
 }
 
+// recover start timestamps after maxTs, the max version found in wal and sstables
+// a fresh db starts with maxTs 0, its first read is at ts 0 which no commit is allocated,
+// so it reads nothing, and commitMark is done until 0, so it does not wait.
+func (o *oracle) recover(maxTs uint64) error {
+	// math.MaxUint64 is never allocated, nextTs would wrap to 0 and readTs underflow
+	if maxTs == math.MaxUint64 {
+		return ErrTsExhausted
+	}
+	o.readMark.Done(maxTs)
+	o.commitMark.Done(maxTs)
+	o.nextTs = maxTs + 1
+	return nil
+}
+
 func (o *oracle) Stop() {
 	o.readMark.Stop()
 	o.commitMark.Stop()
 }
 
-func (o *oracle) readTs() uint64 {
+// readTs allocate the read ts of a txn, serializable is counted with the same lock,
+// so every txn committed after readTs records its keys.
+func (o *oracle) readTs(ctx context.Context, serializable bool) (uint64, error) {
 	o.Lock()
 	readTs := o.nextTs - 1
+	// buffered commits are the latest ones and not acknowledged yet, the snapshot before them needs no wait
+	if o.bufferedFrom > 0 {
+		readTs = o.bufferedFrom - 1
+	}
 	o.readMark.Begin(readTs)
+	if serializable {
+		o.serializable.Add(1)
+	}
 	o.Unlock()
 
 	// ensure current txn can read the latest value of txn at ts <= readTs
-	if err := o.commitMark.WaitForMark(context.Background(), readTs); err != nil {
-		panic(err)
+	if err := o.commitMark.WaitForMark(ctx, readTs); err != nil {
+		// txn never begins, it must not hold back the read watermark
+		o.readMark.Done(readTs)
+		if serializable {
+			o.serializable.Add(-1)
+		}
+		return 0, err
+	}
+	return readTs, nil
+}
+
+// readAt begin a read at ts before nextTs like readTs, e.g. for ViewAt
+// versions at or below ts must not have been discarded, so ts must not be below discardAtOrBelow.
+func (o *oracle) readAt(ctx context.Context, ts uint64) error {
+	o.Lock()
+	switch {
+	case ts >= o.nextTs:
+		o.Unlock()
+		return ErrFutureTs
+	case ts < o.discardAtOrBelow():
+		o.Unlock()
+		return ErrTsTooOld
+	}
+	o.readMark.Begin(ts)
+	o.Unlock()
+
+	if err := o.commitMark.WaitForMark(ctx, ts); err != nil {
+		o.readMark.Done(ts)
+		return err
+	}
+	return nil
+}
+
+// importTs make a write at ts visible like a commit, nextTs is advanced past ts,
+// done must be called after the write. a ts before nextTs is written in the past of active txns.
+func (o *oracle) importTs(ts uint64) (done func(), err error) {
+	o.Lock()
+	defer o.Unlock()
+
+	if ts == math.MaxUint64 {
+		return nil, ErrTsExhausted
+	}
+	if ts < o.nextTs {
+		return func() {}, nil
+	}
+
+	o.nextTs = ts + 1
+	o.commitMark.Begin(ts)
+	return func() {
+		o.doneCommit(ts)
+	}, nil
+}
+
+// bufferFrom record ts of the first commit held in write buffer, 0 once it is drained
+func (o *oracle) bufferFrom(ts uint64) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.bufferedFrom = ts
+}
+
+// staleReadTs allocate a read ts like readTs without waiting for commits before it to complete,
+// the caller must call readMark.Done with it after reading.
+func (o *oracle) staleReadTs() uint64 {
+	o.Lock()
+	defer o.Unlock()
+
+	readTs := o.nextTs - 1
+	if o.bufferedFrom > 0 {
+		readTs = o.bufferedFrom - 1
 	}
+	o.readMark.Begin(readTs)
 	return readTs
 }
 
-func (o *oracle) newCommitTs(txn *Txn) (uint64, bool) {
+func (o *oracle) newCommitTs(txn *Txn) (uint64, error) {
 	o.Lock()
 	defer o.Unlock()
 
 	if o.hasConflict(txn) {
-		return 0, true
+		return 0, ErrConflictTxn
+	}
+	if o.exhausted() {
+		return 0, ErrTsExhausted
 	}
 
 	o.doneRead(txn)
 	o.cleanUpCommittedTxns()
 
+	// begin the mark before unlock, a txn reading at ts or later waits for it,
+	// so a txn begun after Commit returns always reads the writes of the commit
 	ts := o.nextTs
 	o.nextTs++
 	o.commitMark.Begin(ts)
 
-	o.committedTxns = append(o.committedTxns, committedTxn{
+	ct := committedTxn{
 		ts:       ts,
 		writesFp: txn.writesFp,
-	})
+	}
+	if o.serializable.Load() > 0 {
+		ct.keys = make(map[*CF][]types.Key, len(txn.cfWrites)+1)
+		for cf, writes := range txn.cfWrites {
+			ct.keys[cf] = slices.Sorted(maps.Keys(writes))
+		}
+		if txn.spill != nil {
+			ct.allKeys = true
+		} else {
+			ct.keys[nil] = slices.Sorted(maps.Keys(txn.pendingWrites))
+		}
+	}
+	o.committedTxns = append(o.committedTxns, ct)
+
+	return ts, nil
+}
+
+// nextCommitTs allocate a commit ts without conflict detection, e.g. for DropPrefix
+func (o *oracle) nextCommitTs() (uint64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.exhausted() {
+		return 0, ErrTsExhausted
+	}
 
-	return ts, false
+	ts := o.nextTs
+	o.nextTs++
+	o.commitMark.Begin(ts)
+	return ts, nil
+}
+
+// exhausted report whether nextTs is the last timestamp, it is never allocated,
+// so nextTs never wraps and readTs = nextTs-1 never underflows.
+//
+// NOTE: call with lock
+func (o *oracle) exhausted() bool {
+	return o.nextTs == math.MaxUint64
 }
 
 func (o *oracle) doneRead(txn *Txn) {
@@ -111,6 +259,9 @@ func (o *oracle) doneRead(txn *Txn) {
 		return
 	}
 	o.readMark.Done(txn.readTs)
+	if !txn.readOnly && txn.isolation == Serializable {
+		o.serializable.Add(-1)
+	}
 	txn.doneRead = true
 }
 
@@ -169,8 +320,10 @@ func (o *oracle) cleanUpCommittedTxns() {
 //
 // But in reality, "user:1" already existed at ts=10 and should be visible to the transaction with ts=25.
 // Therefore, we must retain the largest version among those with timestamps ≤ D to ensure the correctness of the historical view.
+//
+// The read marks are not persisted, so the discard watermark restored by Open is used as a floor.
 func (o *oracle) discardAtOrBelow() uint64 {
-	return o.readMark.DoneUntil()
+	return max(o.readMark.DoneUntil(), o.discardTs.Load())
 }
 
 // hasConflict should be call with lock
@@ -194,8 +347,11 @@ func (o *oracle) discardAtOrBelow() uint64 {
 // - ts=102 > txn1.readTs 100
 // - conflictKeys include the fp of key=counter
 // - return err conflict
+//
+// A serializable txn also conflicts with a committed txn which wrote any key in the ranges it scanned,
+// e.g. both txns check a range is empty and insert a different key into it.
 func (o *oracle) hasConflict(txn *Txn) bool {
-	if len(txn.readsFp) == 0 {
+	if len(txn.readsFp) == 0 && len(txn.readRanges) == 0 {
 		return false
 	}
 	for _, ct := range o.committedTxns {
@@ -209,6 +365,18 @@ func (o *oracle) hasConflict(txn *Txn) bool {
 				return true
 			}
 		}
+
+		for _, r := range txn.readRanges {
+			if r.cf == nil && ct.allKeys {
+				return true
+			}
+			// first key written to the keyspace of range >= start
+			keys := ct.keys[r.cf]
+			i, _ := slices.BinarySearch(keys, r.start)
+			if i < len(keys) && keys[i] < r.end {
+				return true
+			}
+		}
 	}
 	return false
 }
@@ -16,10 +16,14 @@ package originium
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"math"
 	"testing"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/stretchr/testify/assert"
 )
 
 type priestess = oracle
@@ -52,3 +56,114 @@ func TestLittleEndianAndBigEndian(t *testing.T) {
 	compareResult2 := bytes.Compare(littleEndianBytes, littleEndianBytes2)
 	fmt.Println(compareResult2) // 1
 }
+
+func TestFreshDBTs(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, uint64(1), db.oracle.nextTs)
+
+	// first read is at ts 0 and does not wait for any commit
+	first := db.Begin(false)
+	defer first.Discard()
+	assert.Equal(t, uint64(0), first.readTs)
+	_, ok := first.Get("key")
+	assert.False(t, ok)
+	kvs, _ := first.ScanLimit("", "\xff", 0)
+	assert.Empty(t, kvs)
+
+	// first write is at ts 1
+	err = db.Update(func(txn *Txn) error {
+		assert.Equal(t, uint64(0), txn.readTs)
+		return txn.Set("key", []byte("value"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), db.oracle.nextTs)
+
+	// invisible to the first read, visible to later ones
+	_, ok = first.Get("key")
+	assert.False(t, ok)
+	err = db.View(func(txn *Txn) error {
+		assert.Equal(t, uint64(1), txn.readTs)
+		value, ok := txn.Get("key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("value"), value)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTsExhausted(t *testing.T) {
+	o := newOracle()
+	defer o.Stop()
+	assert.ErrorIs(t, o.recover(math.MaxUint64), ErrTsExhausted)
+
+	assert.NoError(t, o.recover(math.MaxUint64-1))
+	_, err := o.nextCommitTs()
+	assert.ErrorIs(t, err, ErrTsExhausted)
+	readTs, err := o.readTs(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64-1), readTs)
+	o.readMark.Done(readTs)
+
+	// a db whose max version is the last ts allocated is readable but takes no more commits
+	dir := t.TempDir()
+	db, err := Open(dir, Config{})
+	assert.NoError(t, err)
+	ts := uint64(math.MaxUint64 - 1)
+	db.rawset(types.Entry{Key: types.KeyWithTs("key", ts), Value: []byte("value"), Version: types.Version(ts)})
+	db.Close()
+
+	db, err = Open(dir, Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(txn *Txn) error {
+		value, ok := txn.Get("key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("value"), value)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("key", []byte("new"))
+	})
+	assert.ErrorIs(t, err, ErrTsExhausted)
+	assert.ErrorIs(t, db.DropPrefix("k"), ErrTsExhausted)
+	assert.Equal(t, uint64(math.MaxUint64), db.oracle.nextTs)
+}
+
+func TestRangeConflictColumnFamily(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+
+	begin := func() *Txn {
+		txn, err := db.BeginWithOptions(TxnOptions{Update: true, Isolation: Serializable})
+		assert.NoError(t, err)
+		return txn
+	}
+	write := func(cf *CF, key string) {
+		err := db.Update(func(txn *Txn) error {
+			return txn.SetCF(cf, key, []byte("value"))
+		})
+		assert.NoError(t, err)
+	}
+
+	// a range of the default keyspace does not conflict with the same keys of a column family
+	txn := begin()
+	txn.ScanLimit("a", "z", 0)
+	assert.NoError(t, txn.Set("x", []byte("value")))
+	write(users, "b")
+	assert.NoError(t, txn.Commit())
+
+	// a range of a column family conflicts with keys written to it
+	txn = begin()
+	txn.readRanges = append(txn.readRanges, keyRange{cf: users, start: "a", end: "z"})
+	assert.NoError(t, txn.Set("y", []byte("value")))
+	write(users, "c")
+	assert.ErrorIs(t, txn.Commit(), ErrConflictTxn)
+}
@@ -19,19 +19,32 @@ import (
 	"sync"
 )
 
+// DefaultMaxCap buffers larger than it are not pooled by New,
+// e.g. a buffer of a whole sstable built by compaction
+const DefaultMaxCap = 1 << 20
+
 var Pool = New()
 
 type BufferPool struct {
 	pool sync.Pool
+	// buffers with larger capacity are dropped by Put, no limit if <= 0
+	maxCap int
 }
 
 func New() *BufferPool {
+	return NewWithMaxCap(DefaultMaxCap)
+}
+
+// NewWithMaxCap create a pool which drops buffers with capacity larger than maxCap,
+// so a few huge buffers do not stay in the pool forever. no limit if maxCap <= 0
+func NewWithMaxCap(maxCap int) *BufferPool {
 	return &BufferPool{
 		pool: sync.Pool{
 			New: func() any {
 				return new(bytes.Buffer)
 			},
 		},
+		maxCap: maxCap,
 	}
 }
 
@@ -40,6 +53,10 @@ func (p *BufferPool) Get() *bytes.Buffer {
 }
 
 func (p *BufferPool) Put(buf *bytes.Buffer) {
+	// let GC reclaim oversized buffer
+	if p.maxCap > 0 && buf.Cap() > p.maxCap {
+		return
+	}
 	buf.Reset()
 	p.pool.Put(buf)
 }
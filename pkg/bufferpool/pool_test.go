@@ -0,0 +1,38 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufferpool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutOversized(t *testing.T) {
+	p := NewWithMaxCap(1024)
+
+	large := p.Get()
+	large.Write(make([]byte, 1<<20))
+	p.Put(large)
+
+	for range 100 {
+		buf := p.Get()
+		assert.True(t, buf != large)
+		assert.LessOrEqual(t, buf.Cap(), 1024)
+		assert.Equal(t, 0, buf.Len())
+		buf.Write(make([]byte, 512))
+		p.Put(buf)
+	}
+}
@@ -17,21 +17,27 @@ package filter
 import (
 	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/spaolacci/murmur3"
-	"hash"
 	"math"
 )
 
 const _defaultP = 0.01
 
 type Filter struct {
-	bitset  []bool
-	hashFns []hash.Hash32
+	bitset []bool
+	// seeds of murmur3 hash functions, a key is hashed per call, so the filter is safe for concurrent Contains
+	seeds []uint32
 }
 
 // New creates a new BloomFilter with the given size and number of hash functions.
 // n: expected nums of elements
 // p: expected rate of false errors
 func New(n int, p float64) *Filter {
+	return NewWithSeed(n, p, 0)
+}
+
+// NewWithSeed same as New, but hash functions are seeded from seed + i instead of i,
+// so the bits set by a key are not predictable without seed.
+func NewWithSeed(n int, p float64, seed uint32) *Filter {
 	if n <= 0 || (p <= 0 || p >= 1) {
 		panic("invalid parameters")
 	}
@@ -43,44 +49,103 @@ func New(n int, p float64) *Filter {
 	// k = (m/n) * ln(2)
 	k := int(math.Round((float64(m) / float64(n)) * math.Log(2)))
 
-	hashFns := make([]hash.Hash32, k)
+	seeds := make([]uint32, k)
 	for i := range k {
-		hashFns[i] = murmur3.New32WithSeed(uint32(i))
+		seeds[i] = seed + uint32(i)
 	}
 
 	return &Filter{
-		bitset:  make([]bool, m),
-		hashFns: hashFns,
+		bitset: make([]bool, m),
+		seeds:  seeds,
 	}
 }
 
-func Build(kvs []types.Entry) *Filter {
-	filter := New(len(kvs), _defaultP)
+// Build filter of kvs with false positive rate p, use default rate if p is invalid
+//
+// NOTE: the filter is built over base keys (without @ts), because presence of a key is version-independent,
+// so Contains must be probed with base keys as well.
+func Build(kvs []types.Entry, p float64) *Filter {
+	return BuildWithSeed(kvs, p, 0)
+}
+
+// BuildWithSeed same as Build, but hash functions are seeded by seed, see NewWithSeed
+func BuildWithSeed(kvs []types.Entry, p float64, seed uint32) *Filter {
+	if p <= 0 || p >= 1 {
+		p = _defaultP
+	}
+	filter := NewWithSeed(len(kvs), p, seed)
 	for _, e := range kvs {
 		filter.Add(types.ParseKey(e.Key))
 	}
 	return filter
 }
 
+// BuildPrefixWithSeed build filter of the first n bytes of base keys of kvs sorted by key, seeded like BuildWithSeed,
+// so Contains must be probed with prefixes of n bytes. keys shorter than n have no such prefix and are not added.
+func BuildPrefixWithSeed(kvs []types.Entry, n int, p float64, seed uint32) *Filter {
+	if p <= 0 || p >= 1 {
+		p = _defaultP
+	}
+
+	var prefixes []string
+	for _, e := range kvs {
+		key := types.ParseKey(e.Key)
+		if len(key) < n {
+			continue
+		}
+		// versions and keys of the same prefix are adjacent
+		if len(prefixes) == 0 || prefixes[len(prefixes)-1] != key[:n] {
+			prefixes = append(prefixes, key[:n])
+		}
+	}
+
+	// a filter without any prefix contains nothing
+	filter := NewWithSeed(max(len(prefixes), 1), p, seed)
+	for _, prefix := range prefixes {
+		filter.Add(prefix)
+	}
+	return filter
+}
+
+// Size of bitset
+func (f *Filter) Size() int {
+	return len(f.bitset)
+}
+
+// Stats return size of bitset, number of hash functions,
+// and false positive rate estimated from the fraction of set bits: (set / bits) ^ hashFns
+func (f *Filter) Stats() (bits int, hashFns int, estimatedFPRate float64) {
+	set := 0
+	for _, b := range f.bitset {
+		if b {
+			set++
+		}
+	}
+	bits, hashFns = len(f.bitset), len(f.seeds)
+	if bits == 0 {
+		return bits, hashFns, 0
+	}
+	return bits, hashFns, math.Pow(float64(set)/float64(bits), float64(hashFns))
+}
+
 // Add adds an element to the BloomFilter.
 func (f *Filter) Add(key string) {
-	for _, fn := range f.hashFns {
-		_, _ = fn.Write([]byte(key))
-		index := int(fn.Sum32()) % len(f.bitset)
-		f.bitset[index] = true
-		fn.Reset()
+	for _, seed := range f.seeds {
+		f.bitset[f.index(key, seed)] = true
 	}
 }
 
 // Contains checks if an element is in the BloomFilter.
 func (f *Filter) Contains(key string) bool {
-	for _, fn := range f.hashFns {
-		_, _ = fn.Write([]byte(key))
-		index := int(fn.Sum32()) % len(f.bitset)
-		fn.Reset()
-		if !f.bitset[index] {
+	for _, seed := range f.seeds {
+		if !f.bitset[f.index(key, seed)] {
 			return false
 		}
 	}
 	return true
 }
+
+// index of bit of key hashed with seed
+func (f *Filter) index(key string, seed uint32) int {
+	return int(murmur3.Sum32WithSeed([]byte(key), seed)) % len(f.bitset)
+}
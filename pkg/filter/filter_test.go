@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,3 +57,100 @@ func TestFalsePositiveRate(t *testing.T) {
 	actualP := float64(falsePositives) / float64(testSize)
 	t.Log(actualP)
 }
+
+func TestStats(t *testing.T) {
+	n := 1000
+	p := 0.01
+	bf := New(n, p)
+
+	bits, hashFns, rate := bf.Stats()
+	assert.Equal(t, bf.Size(), bits)
+	assert.Equal(t, 7, hashFns)
+	assert.Equal(t, 0.0, rate)
+
+	for i := 0; i < n; i++ {
+		bf.Add(strconv.Itoa(i))
+	}
+
+	falsePositives := 0
+	testSize := 100000
+	for i := n; i < n+testSize; i++ {
+		if bf.Contains(strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	actualP := float64(falsePositives) / float64(testSize)
+
+	// fully filled to n, estimate is close to both expected and measured rate
+	_, _, rate = bf.Stats()
+	t.Log(rate, actualP)
+	assert.InDelta(t, p, rate, 0.005)
+	assert.InDelta(t, actualP, rate, 0.005)
+
+	// over filled, rate grows
+	for i := n; i < 2*n; i++ {
+		bf.Add(strconv.Itoa(-i))
+	}
+	_, _, overRate := bf.Stats()
+	assert.Greater(t, overRate, 5*rate)
+}
+
+func TestSeed(t *testing.T) {
+	var kvs []types.Entry
+	for i := range 100 {
+		kvs = append(kvs, types.Entry{Key: types.KeyWithTs(strconv.Itoa(i), 1)})
+	}
+
+	// seed 0 is the same as Build
+	assert.Equal(t, Build(kvs, 0.01).bitset, BuildWithSeed(kvs, 0.01, 0).bitset)
+
+	bf1 := BuildWithSeed(kvs, 0.01, 1234)
+	bf2 := BuildWithSeed(kvs, 0.01, 5678)
+	assert.Equal(t, bf1.Size(), bf2.Size())
+	assert.NotEqual(t, bf1.bitset, bf2.bitset)
+
+	// same key set, no false negatives with either seed
+	for i := range 100 {
+		assert.True(t, bf1.Contains(strconv.Itoa(i)))
+		assert.True(t, bf2.Contains(strconv.Itoa(i)))
+	}
+}
+
+func TestBuildPrefix(t *testing.T) {
+	kvs := []types.Entry{
+		{Key: types.KeyWithTs("a", 1)},
+		{Key: types.KeyWithTs("user1:a", 2)},
+		{Key: types.KeyWithTs("user1:a", 1)},
+		{Key: types.KeyWithTs("user1:b", 1)},
+		{Key: types.KeyWithTs("user3:a", 1)},
+	}
+	bf := BuildPrefixWithSeed(kvs, 5, 0.001, 7)
+
+	assert.True(t, bf.Contains("user1"))
+	assert.True(t, bf.Contains("user3"))
+	assert.False(t, bf.Contains("user2"))
+	// shorter keys have no prefix
+	assert.False(t, bf.Contains("a"))
+
+	// no key is long enough
+	bf = BuildPrefixWithSeed(kvs[:1], 5, 0.001, 7)
+	assert.False(t, bf.Contains("user1"))
+}
+
+func TestBuildBaseKeys(t *testing.T) {
+	kvs := []types.Entry{
+		{Key: types.KeyWithTs("apple", 30)},
+		{Key: types.KeyWithTs("apple", 20)},
+		{Key: types.KeyWithTs("apple", 10)},
+		{Key: types.KeyWithTs("banana", 5)},
+	}
+	bf := Build(kvs, 0.001)
+
+	// base keys are included regardless of version
+	assert.True(t, bf.Contains("apple"))
+	assert.True(t, bf.Contains("banana"))
+
+	// full keys and absent base keys are excluded
+	assert.False(t, bf.Contains(types.KeyWithTs("apple", 30)))
+	assert.False(t, bf.Contains("cherry"))
+}
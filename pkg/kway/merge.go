@@ -21,7 +21,17 @@ import (
 	"github.com/B1NARY-GR0UP/originium/types"
 )
 
+// Merge merge sorted lists into sorted entries, tombstones are dropped
+// the entry of a later list wins if a key is in more than one list.
 func Merge(lists ...[]types.Entry) []types.Entry {
+	return slices.DeleteFunc(MergeWithTombstones(lists...), func(entry types.Entry) bool {
+		return entry.Tombstone
+	})
+}
+
+// MergeWithTombstones same as Merge, but tombstones are kept
+// a compaction must keep them to shadow older versions of the key in lower levels.
+func MergeWithTombstones(lists ...[]types.Entry) []types.Entry {
 	h := &Heap{}
 	heap.Init(h)
 
@@ -52,12 +62,8 @@ func Merge(lists ...[]types.Entry) []types.Entry {
 		}
 	}
 
-	var merged []types.Entry
-
+	merged := make([]types.Entry, 0, len(latest))
 	for _, entry := range latest {
-		if entry.Tombstone {
-			continue
-		}
 		merged = append(merged, entry)
 	}
 
@@ -67,3 +73,36 @@ func Merge(lists ...[]types.Entry) []types.Entry {
 
 	return merged
 }
+
+// MergeAll merge sorted lists into sorted entries, no entry is dropped,
+// an entry in more than one list is kept once per list, in order of lists.
+func MergeAll(lists ...[]types.Entry) []types.Entry {
+	h := &Heap{}
+	heap.Init(h)
+
+	var n int
+	for i, list := range lists {
+		n += len(list)
+		if len(list) > 0 {
+			heap.Push(h, Element{
+				Entry: list[0],
+				LI:    i,
+			})
+			lists[i] = list[1:]
+		}
+	}
+
+	merged := make([]types.Entry, 0, n)
+	for h.Len() > 0 {
+		e := heap.Pop(h).(Element)
+		merged = append(merged, e.Entry)
+		if len(lists[e.LI]) > 0 {
+			heap.Push(h, Element{
+				Entry: lists[e.LI][0],
+				LI:    e.LI,
+			})
+			lists[e.LI] = lists[e.LI][1:]
+		}
+	}
+	return merged
+}
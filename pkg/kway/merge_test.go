@@ -156,3 +156,45 @@ func TestMergeTombstone(t *testing.T) {
 	result := Merge(list1, list2)
 	assert.Equal(t, expected, result)
 }
+
+func TestMergeWithTombstones(t *testing.T) {
+	list1 := []types.Entry{
+		{Key: "a@1", Value: []byte("1")},
+		{Key: "b@1", Value: []byte("2")},
+	}
+	list2 := []types.Entry{
+		{Key: "a@2", Tombstone: true},
+		{Key: "b@1", Tombstone: true},
+	}
+
+	expected := []types.Entry{
+		{Key: "a@2", Tombstone: true},
+		{Key: "a@1", Value: []byte("1")},
+		{Key: "b@1", Tombstone: true},
+	}
+
+	result := MergeWithTombstones(list1, list2)
+	assert.Equal(t, expected, result)
+}
+
+func TestMergeAll(t *testing.T) {
+	list1 := []types.Entry{
+		{Key: "a@1", Value: []byte("1")},
+		{Key: "b@1", Value: []byte("2")},
+	}
+	list2 := []types.Entry{
+		{Key: "a@2", Tombstone: true},
+		{Key: "b@1", Tombstone: true},
+	}
+
+	expected := []types.Entry{
+		{Key: "a@2", Tombstone: true},
+		{Key: "a@1", Value: []byte("1")},
+		{Key: "b@1", Value: []byte("2")},
+		{Key: "b@1", Tombstone: true},
+	}
+
+	result := MergeAll(list1, list2)
+	assert.Equal(t, expected, result)
+	assert.Empty(t, MergeAll())
+}
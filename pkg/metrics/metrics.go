@@ -0,0 +1,160 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histograms
+const (
+	GetLatency    = "get_latency"
+	SetLatency    = "set_latency"
+	FlushDuration = "flush_duration"
+)
+
+// counters
+const (
+	CompactionBytes = "compaction_bytes"
+	// data blocks read from sstable files, there is no block cache, so it stands for cache misses
+	BlockReads = "block_reads"
+	// lookups of a table skipped by its bloom filter
+	BloomTrueNegatives = "bloom_true_negatives"
+	// lookups of a table passed by its bloom filter, but the table has no version of the key
+	BloomFalsePositives = "bloom_false_positives"
+	WriteSlowdowns      = "write_slowdowns"
+	WriteStalls         = "write_stalls"
+)
+
+var _ Recorder = (*Memory)(nil)
+
+// Nop discard all metrics
+var Nop Recorder = nop{}
+
+// Recorder receive metrics of db operations
+type Recorder interface {
+	// Add delta to counter name
+	Add(name string, delta uint64)
+	// Observe record a sample of histogram name
+	Observe(name string, d time.Duration)
+}
+
+type nop struct{}
+
+func (nop) Add(string, uint64) {}
+
+func (nop) Observe(string, time.Duration) {}
+
+// upper bounds of histogram buckets
+var _buckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Memory in-memory recorder
+type Memory struct {
+	mu         sync.Mutex
+	counters   map[string]uint64
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	count uint64
+	sum   time.Duration
+	// counts[i]: samples <= _buckets[i], counts[len(_buckets)]: samples > last bucket
+	counts []uint64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		counters:   make(map[string]uint64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (m *Memory) Add(name string, delta uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[name] += delta
+}
+
+func (m *Memory) Observe(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histograms[name]
+	if !ok {
+		h = &histogram{
+			counts: make([]uint64, len(_buckets)+1),
+		}
+		m.histograms[name] = h
+	}
+
+	h.count++
+	h.sum += d
+	i, _ := slices.BinarySearch(_buckets, d)
+	h.counts[i]++
+}
+
+// Snapshot return a copy of all metrics
+//
+// histogram is flattened into name_count, name_sum (in nanoseconds)
+// and cumulative name_bucket_le_<bound> entries.
+func (m *Memory) Snapshot() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make(map[string]uint64, len(m.counters)+len(m.histograms)*(len(_buckets)+3))
+	for name, v := range m.counters {
+		res[name] = v
+	}
+	for name, h := range m.histograms {
+		res[name+"_count"] = h.count
+		res[name+"_sum"] = uint64(h.sum)
+		var cumulative uint64
+		for i, bound := range _buckets {
+			cumulative += h.counts[i]
+			res[fmt.Sprintf("%s_bucket_le_%s", name, bound)] = cumulative
+		}
+		res[name+"_bucket_le_inf"] = h.count
+	}
+	return res
+}
+
+// String dump all metrics as sorted "name value" lines
+func (m *Memory) String() string {
+	snapshot := m.Snapshot()
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		_, _ = fmt.Fprintf(&sb, "%s %d\n", name, snapshot[name])
+	}
+	return sb.String()
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCounter(t *testing.T) {
+	m := NewMemory()
+
+	m.Add(BlockReads, 1)
+	m.Add(BlockReads, 2)
+
+	assert.Equal(t, uint64(3), m.Snapshot()[BlockReads])
+}
+
+func TestMemoryHistogram(t *testing.T) {
+	m := NewMemory()
+
+	m.Observe(GetLatency, 5*time.Microsecond)
+	m.Observe(GetLatency, 50*time.Microsecond)
+	m.Observe(GetLatency, 2*time.Second)
+
+	snapshot := m.Snapshot()
+	assert.Equal(t, uint64(3), snapshot["get_latency_count"])
+	assert.Equal(t, uint64(2*time.Second+55*time.Microsecond), snapshot["get_latency_sum"])
+	assert.Equal(t, uint64(1), snapshot["get_latency_bucket_le_10µs"])
+	assert.Equal(t, uint64(2), snapshot["get_latency_bucket_le_100µs"])
+	assert.Equal(t, uint64(2), snapshot["get_latency_bucket_le_1s"])
+	assert.Equal(t, uint64(3), snapshot["get_latency_bucket_le_inf"])
+}
+
+func TestMemoryString(t *testing.T) {
+	m := NewMemory()
+
+	m.Add(CompactionBytes, 10)
+	m.Add(BlockReads, 1)
+
+	assert.Equal(t, "block_reads 1\ncompaction_bytes 10\n", m.String())
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUnlimited(t *testing.T) {
+	assert.True(t, New(0) == nil)
+	assert.True(t, New(-1) == nil)
+
+	// nil limiter never waits
+	var l *Limiter
+	start := time.Now()
+	l.WaitN(1 << 30)
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.True(t, l.Writer(&buf) == &buf)
+}
+
+func TestWaitN(t *testing.T) {
+	l := New(1 << 20)
+
+	// a full bucket is taken at once
+	start := time.Now()
+	l.WaitN(1 << 20)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// the next 200ms of tokens are waited for
+	start = time.Now()
+	l.WaitN(200 << 10)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestWaitNConcurrent(t *testing.T) {
+	l := New(1 << 20)
+	l.WaitN(1 << 20)
+
+	// callers share the rate, 4 * 64KB takes about 250ms in total
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.WaitN(64 << 10)
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestWriter(t *testing.T) {
+	l := New(1 << 20)
+
+	var buf bytes.Buffer
+	w := l.Writer(&buf)
+	data := bytes.Repeat([]byte("x"), 1<<20+300<<10)
+
+	start := time.Now()
+	n, err := w.Write(data)
+	elapsed := time.Since(start)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, data, buf.Bytes())
+	// bytes over the bucket are written at the rate
+	assert.GreaterOrEqual(t, elapsed, 250*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
@@ -16,14 +16,12 @@ package skiplist
 
 import (
 	"math/rand"
+	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/B1NARY-GR0UP/originium/types"
 )
 
-const _head = "HEAD"
-
 // SkipList
 //
 // Level 3:       3 ----------- 9 ----------- 21 --------- 26
@@ -34,13 +32,18 @@ const _head = "HEAD"
 // next of head [ ->3, ->3, ->3 ]
 //
 // Element with same key only has one instance within the skip list
+// head is a sentinel whose key is never compared, its entry is empty, so any key including "HEAD" and "" can be set.
+var _ types.MemtableIndex = (*SkipList)(nil)
+
 type SkipList struct {
 	maxLevel int
 	p        float64
 	level    int
-	rand     *rand.Rand
-	size     int
-	head     *Element
+	// rand is not safe for concurrent use, guarded by randMu
+	randMu sync.Mutex
+	rand   *rand.Rand
+	size   int
+	head   *Element
 }
 
 type Element struct {
@@ -49,26 +52,37 @@ type Element struct {
 }
 
 func New(maxLevel int, p float64) *SkipList {
+	return NewWithSeed(maxLevel, p, time.Now().UnixNano())
+}
+
+// NewWithSeed create a skiplist whose level assignment is determined by seed
+// skiplists created with the same seed have the same structure after the same operations.
+func NewWithSeed(maxLevel int, p float64, seed int64) *SkipList {
+	return NewWithRand(maxLevel, p, rand.New(rand.NewSource(seed)))
+}
+
+// NewWithRand create a skiplist which assigns levels of elements with r
+// it is a hook for tests to build skiplists of known shape, r must not be used by others after.
+func NewWithRand(maxLevel int, p float64, r *rand.Rand) *SkipList {
 	return &SkipList{
 		maxLevel: maxLevel,
 		p:        p,
 		level:    1,
-		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		rand:     r,
 		size:     0,
 		head: &Element{
-			Entry: types.Entry{
-				Key:       _head,
-				Value:     nil,
-				Tombstone: false,
-				Version:   0,
-			},
 			next: make([]*Element, maxLevel),
 		},
 	}
 }
 
-func (s *SkipList) Reset() *SkipList {
-	return New(s.maxLevel, s.p)
+// Reset return an empty skiplist, its rand is seeded from the rand of s to keep determinism
+func (s *SkipList) Reset() types.MemtableIndex {
+	s.randMu.Lock()
+	seed := s.rand.Int63()
+	s.randMu.Unlock()
+
+	return NewWithSeed(s.maxLevel, s.p, seed)
 }
 
 func (s *SkipList) Size() int {
@@ -88,11 +102,13 @@ func (s *SkipList) Set(entry types.Entry) {
 
 	// update entry
 	if curr.next[0] != nil && types.CompareKeys(curr.next[0].Key, entry.Key) == 0 {
-		s.size += len(entry.Value) - len(curr.next[0].Value)
+		prevSize := types.EncodedSize(curr.next[0].Entry)
 
 		// update value and tombstone
 		curr.next[0].Value = entry.Value
 		curr.next[0].Tombstone = entry.Tombstone
+
+		s.size += types.EncodedSize(curr.next[0].Entry) - prevSize
 		return
 	}
 
@@ -120,10 +136,7 @@ func (s *SkipList) Set(entry types.Entry) {
 		e.next[i] = update[i].next[i]
 		update[i].next[i] = e
 	}
-	s.size += len(entry.Key) + len(entry.Value) +
-		int(unsafe.Sizeof(entry.Tombstone)) +
-		int(unsafe.Sizeof(entry.Version)) +
-		len(e.next)*int(unsafe.Sizeof((*Element)(nil)))
+	s.size += types.EncodedSize(e.Entry)
 }
 
 func (s *SkipList) Get(key types.Key) (types.Entry, bool) {
@@ -234,10 +247,7 @@ func (s *SkipList) Delete(key types.Key) bool {
 			}
 			update[i].next[i] = curr.next[i]
 		}
-		s.size -= len(curr.Key) + len(curr.Value) +
-			int(unsafe.Sizeof(curr.Tombstone)) +
-			int(unsafe.Sizeof(curr.Version)) +
-			len(curr.next)*int(unsafe.Sizeof((*Element)(nil)))
+		s.size -= types.EncodedSize(curr.Entry)
 
 		for s.level > 1 && s.head.next[s.level-1] == nil {
 			s.level--
@@ -249,6 +259,9 @@ func (s *SkipList) Delete(key types.Key) bool {
 
 // n < MaxLevel, return level == n has probability P^n
 func (s *SkipList) randomLevel() int {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+
 	level := 1
 	for s.rand.Float64() < s.p && level < s.maxLevel {
 		level++
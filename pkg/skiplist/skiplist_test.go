@@ -15,6 +15,9 @@
 package skiplist
 
 import (
+	"fmt"
+	"math/rand"
+	"sync"
 	"testing"
 
 	"github.com/B1NARY-GR0UP/originium/types"
@@ -29,7 +32,7 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, 1, sl.level)
 	assert.Equal(t, 0, sl.size)
 	assert.NotNil(t, sl.head)
-	assert.Equal(t, _head, sl.head.Key)
+	assert.Equal(t, types.Entry{}, sl.head.Entry)
 }
 
 func TestSetAndGet(t *testing.T) {
@@ -86,6 +89,36 @@ func TestGetNonExistent(t *testing.T) {
 	assert.Equal(t, types.Entry{}, result)
 }
 
+func TestBareKeys(t *testing.T) {
+	sl := New(4, 0.5)
+	sl.Set(types.Entry{Key: "k2", Value: []byte("v2")})
+	sl.Set(types.Entry{Key: "", Value: []byte("empty")})
+	sl.Set(types.Entry{Key: "HEAD", Value: []byte("head")})
+	sl.Set(types.Entry{Key: "k1@1", Value: []byte("v1")})
+
+	result, found := sl.Get("k2")
+	assert.True(t, found)
+	assert.Equal(t, []byte("v2"), result.Value)
+
+	result, found = sl.Get("")
+	assert.True(t, found)
+	assert.Equal(t, []byte("empty"), result.Value)
+
+	result, found = sl.Get("HEAD")
+	assert.True(t, found)
+	assert.Equal(t, []byte("head"), result.Value)
+
+	_, found = sl.Get("k3")
+	assert.False(t, found)
+
+	all := sl.All()
+	assert.Len(t, all, 4)
+	assert.Equal(t, "", all[0].Key)
+	assert.Equal(t, "HEAD", all[1].Key)
+	assert.Equal(t, "k1@1", all[2].Key)
+	assert.Equal(t, "k2", all[3].Key)
+}
+
 func TestDelete(t *testing.T) {
 	sl := New(4, 0.5)
 	entry1 := types.Entry{Key: "key1@1", Value: []byte("value1"), Tombstone: false}
@@ -111,6 +144,23 @@ func TestDelete(t *testing.T) {
 	assert.False(t, deleted)
 }
 
+func TestSize(t *testing.T) {
+	sl := New(4, 0.5)
+	entry1 := types.Entry{Key: "key1@1", Value: []byte("value1"), Version: 1}
+	entry2 := types.Entry{Key: "key2@1", Value: []byte("value2"), Version: 1}
+	sl.Set(entry1)
+	sl.Set(entry2)
+	assert.Equal(t, types.EncodedSize(entry1)+types.EncodedSize(entry2), sl.Size())
+
+	// update value
+	entry1.Value = []byte("a much longer value1")
+	sl.Set(entry1)
+	assert.Equal(t, types.EncodedSize(entry1)+types.EncodedSize(entry2), sl.Size())
+
+	sl.Delete("key2@1")
+	assert.Equal(t, types.EncodedSize(entry1), sl.Size())
+}
+
 func TestAll(t *testing.T) {
 	sl := New(4, 0.5)
 	entries := []types.Entry{
@@ -135,7 +185,7 @@ func TestReset(t *testing.T) {
 	entry := types.Entry{Key: "key1@1", Value: []byte("value1"), Tombstone: false}
 	sl.Set(entry)
 
-	sl = sl.Reset()
+	sl = sl.Reset().(*SkipList)
 	assert.Equal(t, 0, sl.size)
 	assert.Equal(t, 1, sl.level)
 	assert.Nil(t, sl.head.next[0])
@@ -232,3 +282,80 @@ func TestScanWithVersions(t *testing.T) {
 	assert.Equal(t, types.KeyWithTs("b", 2), results[2].Key)
 	assert.Equal(t, types.KeyWithTs("b", 1), results[3].Key)
 }
+
+func TestNewWithSeed(t *testing.T) {
+	levels := func(sl *SkipList) []int {
+		var res []int
+		for curr := sl.head.next[0]; curr != nil; curr = curr.next[0] {
+			res = append(res, len(curr.next))
+		}
+		return res
+	}
+
+	sl1 := NewWithSeed(9, 0.5, 42)
+	sl2 := NewWithSeed(9, 0.5, 42)
+	for i := range 100 {
+		entry := types.Entry{Key: fmt.Sprintf("key%03d@1", i), Value: []byte("value")}
+		sl1.Set(entry)
+		sl2.Set(entry)
+	}
+
+	assert.Equal(t, sl1.level, sl2.level)
+	assert.Equal(t, levels(sl1), levels(sl2))
+
+	// reset skiplists are still reproducible
+	sl1, sl2 = sl1.Reset().(*SkipList), sl2.Reset().(*SkipList)
+	for i := range 100 {
+		entry := types.Entry{Key: fmt.Sprintf("key%03d@1", i), Value: []byte("value")}
+		sl1.Set(entry)
+		sl2.Set(entry)
+	}
+	assert.Equal(t, levels(sl1), levels(sl2))
+}
+
+func TestRandomLevelConcurrent(t *testing.T) {
+	sl := New(9, 0.5)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				level := sl.randomLevel()
+				assert.True(t, level >= 1 && level <= 9)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewWithRand(t *testing.T) {
+	// keys of each level from top to bottom
+	structure := func(sl *SkipList) [][]string {
+		var res [][]string
+		for i := sl.maxLevel - 1; i >= 0; i-- {
+			var keys []string
+			for curr := sl.head.next[i]; curr != nil; curr = curr.next[i] {
+				keys = append(keys, curr.Key)
+			}
+			res = append(res, keys)
+		}
+		return res
+	}
+
+	sl1 := NewWithRand(6, 0.5, rand.New(rand.NewSource(7)))
+	sl2 := NewWithRand(6, 0.5, rand.New(rand.NewSource(7)))
+	for i := range 50 {
+		entry := types.Entry{Key: fmt.Sprintf("key%03d@1", (i*17)%50), Value: []byte("value")}
+		sl1.Set(entry)
+		sl2.Set(entry)
+	}
+	sl1.Delete("key010@1")
+	sl2.Delete("key010@1")
+
+	assert.Equal(t, sl1.level, sl2.level)
+	assert.Equal(t, structure(sl1), structure(sl2))
+	// skiplist has more than one level
+	assert.Greater(t, sl1.level, 1)
+}
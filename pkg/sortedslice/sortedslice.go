@@ -0,0 +1,110 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sortedslice
+
+import (
+	"slices"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+)
+
+var _ types.MemtableIndex = (*SortedSlice)(nil)
+
+// SortedSlice memtable index of entries in a slice sorted by types.CompareKeys
+// lookups are a binary search without pointer chasing, but a Set of a new key shifts the entries after it,
+// so it suits small memtables whose point lookups dominate.
+type SortedSlice struct {
+	entries []types.Entry
+	size    int
+}
+
+func New() *SortedSlice {
+	return &SortedSlice{}
+}
+
+// Reset return an empty sorted slice
+func (s *SortedSlice) Reset() types.MemtableIndex {
+	return New()
+}
+
+func (s *SortedSlice) Size() int {
+	return s.size
+}
+
+func (s *SortedSlice) Set(entry types.Entry) {
+	i, found := s.search(entry.Key)
+
+	// update entry
+	if found {
+		prevSize := types.EncodedSize(s.entries[i])
+
+		// update value and tombstone
+		s.entries[i].Value = entry.Value
+		s.entries[i].Tombstone = entry.Tombstone
+
+		s.size += types.EncodedSize(s.entries[i]) - prevSize
+		return
+	}
+
+	// add entry
+	e := types.Entry{
+		Key:       entry.Key,
+		Value:     entry.Value,
+		Tombstone: entry.Tombstone,
+		Version:   entry.Version,
+	}
+	s.entries = slices.Insert(s.entries, i, e)
+	s.size += types.EncodedSize(e)
+}
+
+func (s *SortedSlice) Get(key types.Key) (types.Entry, bool) {
+	i, found := s.search(key)
+	if !found {
+		return types.Entry{}, false
+	}
+	return s.entries[i], true
+}
+
+func (s *SortedSlice) LowerBound(key types.Key) (types.Entry, bool) {
+	i, _ := s.search(key)
+	if i == len(s.entries) {
+		return types.Entry{}, false
+	}
+	return s.entries[i], true
+}
+
+// Scan [start, end)
+func (s *SortedSlice) Scan(start, end types.Key) []types.Entry {
+	low, _ := s.search(start)
+	high, _ := s.search(end)
+	if low >= high {
+		return nil
+	}
+	return slices.Clone(s.entries[low:high])
+}
+
+func (s *SortedSlice) All() []types.Entry {
+	if len(s.entries) == 0 {
+		return nil
+	}
+	return slices.Clone(s.entries)
+}
+
+// search return the index of the first entry whose key is not before key, and whether it is key
+func (s *SortedSlice) search(key types.Key) (int, bool) {
+	return slices.BinarySearchFunc(s.entries, key, func(e types.Entry, k types.Key) int {
+		return types.CompareKeys(e.Key, k)
+	})
+}
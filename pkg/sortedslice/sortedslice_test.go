@@ -0,0 +1,119 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sortedslice
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/B1NARY-GR0UP/originium/pkg/skiplist"
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetAndGet(t *testing.T) {
+	s := New()
+	entry := types.Entry{Key: "key1@1", Value: []byte("value1"), Tombstone: false}
+	s.Set(entry)
+
+	result, found := s.Get("key1@1")
+	assert.True(t, found)
+	assert.Equal(t, entry, result)
+	assert.Equal(t, types.EncodedSize(entry), s.Size())
+
+	// update the entry
+	entry.Value = []byte("value22")
+	s.Set(entry)
+	result, found = s.Get("key1@1")
+	assert.True(t, found)
+	assert.Equal(t, entry, result)
+	assert.Equal(t, types.EncodedSize(entry), s.Size())
+
+	_, found = s.Get("key1@2")
+	assert.False(t, found)
+}
+
+func TestRange(t *testing.T) {
+	s := New()
+	entries := []types.Entry{
+		{Key: "key1@1", Value: []byte("value1"), Tombstone: false},
+		{Key: "key2@1", Value: []byte("value2"), Tombstone: false},
+		{Key: "key3@1", Value: []byte("value3"), Tombstone: false},
+		{Key: "key4@1", Value: []byte("value4"), Tombstone: false},
+	}
+
+	for _, i := range []int{2, 0, 3, 1} {
+		s.Set(entries[i])
+	}
+
+	tests := []struct {
+		start, end string
+		expected   []types.Entry
+	}{
+		{"key1@1", "key3@1", entries[:2]},
+		{"key2@1", "key4@1", entries[1:3]},
+		{"key1@1", "key5@1", entries},
+		{"key3@1", "key3@1", nil},
+		{"key0@1", "key1@1", nil},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, s.Scan(tt.start, tt.end))
+	}
+	assert.Equal(t, entries, s.All())
+
+	entry, found := s.LowerBound("key2@5")
+	assert.True(t, found)
+	assert.Equal(t, entries[1], entry)
+	_, found = s.LowerBound("key5")
+	assert.False(t, found)
+
+	reset := s.Reset()
+	assert.Equal(t, 0, reset.Size())
+	assert.Nil(t, reset.All())
+}
+
+func TestSameAsSkipList(t *testing.T) {
+	s := New()
+	sl := skiplist.New(9, 0.5)
+	for range 1000 {
+		entry := types.Entry{
+			Key:       types.KeyWithTs(fmt.Sprintf("key%03d", rand.Intn(200)), uint64(rand.Intn(5))),
+			Value:     []byte(fmt.Sprintf("value%d", rand.Intn(100))),
+			Tombstone: rand.Intn(10) == 0,
+		}
+		s.Set(entry)
+		sl.Set(entry)
+	}
+
+	assert.Equal(t, sl.All(), s.All())
+	assert.Equal(t, sl.Size(), s.Size())
+	for i := range 210 {
+		key := types.KeyWithTs(fmt.Sprintf("key%03d", i), 2)
+		entry, found := s.Get(key)
+		slEntry, slFound := sl.Get(key)
+		assert.Equal(t, slFound, found, key)
+		assert.Equal(t, slEntry, entry, key)
+
+		entry, found = s.LowerBound(key)
+		slEntry, slFound = sl.LowerBound(key)
+		assert.Equal(t, slFound, found, key)
+		assert.Equal(t, slEntry, entry, key)
+
+		end := types.KeyWithTs(fmt.Sprintf("key%03d", i+7), 0)
+		assert.Equal(t, sl.Scan(key, end), s.Scan(key, end), key)
+	}
+}
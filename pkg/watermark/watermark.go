@@ -17,14 +17,18 @@ package watermark
 import (
 	"container/heap"
 	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 )
 
 const _markCBufferSize = 100
 
+var ErrStopped = errors.New("watermark stopped")
+
 type WaterMark struct {
-	wg sync.WaitGroup
+	wg   sync.WaitGroup
+	once sync.Once
 
 	doneUntil atomic.Uint64
 
@@ -50,23 +54,41 @@ func New() *WaterMark {
 	return w
 }
 
-// Stop WaterMark do not used with Begin
+// Stop WaterMark
+// Begin and Done after Stop are ignored, WaitForMark returns ErrStopped.
 func (w *WaterMark) Stop() {
-	close(w.stopC)
+	w.once.Do(func() {
+		close(w.stopC)
+	})
 	w.wg.Wait()
 }
 
+// send mark to process, dropped after Stop
+func (w *WaterMark) send(m mark) bool {
+	select {
+	case <-w.stopC:
+		return false
+	default:
+	}
+	select {
+	case w.markC <- m:
+		return true
+	case <-w.stopC:
+		return false
+	}
+}
+
 func (w *WaterMark) Begin(ts uint64) {
-	w.markC <- mark{
+	w.send(mark{
 		ts: ts,
-	}
+	})
 }
 
 func (w *WaterMark) Done(ts uint64) {
-	w.markC <- mark{
+	w.send(mark{
 		ts:   ts,
 		done: true,
-	}
+	})
 }
 
 func (w *WaterMark) DoneUntil() uint64 {
@@ -79,9 +101,11 @@ func (w *WaterMark) WaitForMark(ctx context.Context, ts uint64) error {
 	}
 
 	waiter := make(chan struct{})
-	w.markC <- mark{
+	if !w.send(mark{
 		ts:     ts,
 		waiter: waiter,
+	}) {
+		return ErrStopped
 	}
 
 	select {
@@ -89,6 +113,8 @@ func (w *WaterMark) WaitForMark(ctx context.Context, ts uint64) error {
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-w.stopC:
+		return ErrStopped
 	}
 }
 
@@ -103,7 +129,6 @@ func (w *WaterMark) process() {
 	for {
 		select {
 		case <-w.stopC:
-			close(w.markC)
 			return
 		case m := <-w.markC:
 			if m.waiter != nil {
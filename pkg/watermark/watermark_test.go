@@ -165,3 +165,24 @@ func TestWaterMarkWaitForPastMark(t *testing.T) {
 	err = w.WaitForMark(context.Background(), 100)
 	assert.NoError(t, err)
 }
+
+func TestWaterMarkStop(t *testing.T) {
+	w := New()
+
+	w.Begin(1)
+	errC := make(chan error)
+	go func() {
+		errC <- w.WaitForMark(context.Background(), 1)
+	}()
+
+	// a blocked waiter returns once stopped
+	time.Sleep(10 * time.Millisecond)
+	w.Stop()
+	assert.ErrorIs(t, <-errC, ErrStopped)
+
+	// no panic after stop
+	w.Begin(2)
+	w.Done(2)
+	assert.ErrorIs(t, w.WaitForMark(context.Background(), 2), ErrStopped)
+	w.Stop()
+}
@@ -0,0 +1,228 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/B1NARY-GR0UP/originium/pkg/bufferpool"
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
+)
+
+const _spillExt = ".spill"
+
+// spill pending writes of a large txn spilled to a temp file
+// each spill appends a batch of entries sorted by key, a key may be in more than one batch,
+// the entry in the newest batch wins.
+type spill struct {
+	fd *os.File
+	// end offset of each batch, old -> new
+	batches []int64
+}
+
+func newSpill(dir string) (*spill, error) {
+	fd, err := os.CreateTemp(dir, "txn-*"+_spillExt)
+	if err != nil {
+		return nil, err
+	}
+	return &spill{
+		fd: fd,
+	}, nil
+}
+
+// removeSpills remove spill files of txns not committed before crash
+func removeSpills(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != _spillExt {
+			continue
+		}
+		if err = os.Remove(path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write append entries as a new batch
+func (s *spill) write(entries []types.Entry) error {
+	slices.SortFunc(entries, func(a, b types.Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	buf := bufferpool.Pool.Get()
+	defer bufferpool.Pool.Put(buf)
+
+	// same as wal: data length + data body
+	for _, entry := range entries {
+		data, err := utils.TMarshal(&entry)
+		if err != nil {
+			return err
+		}
+		if err = binary.Write(buf, binary.LittleEndian, int64(len(data))); err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+
+	if _, err := s.fd.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.batches = append(s.batches, s.offset(len(s.batches))+int64(buf.Len()))
+	return nil
+}
+
+// offset return start offset of the i-th batch
+func (s *spill) offset(i int) int64 {
+	if i == 0 {
+		return 0
+	}
+	return s.batches[i-1]
+}
+
+// iter return iterator of the i-th batch
+func (s *spill) iter(i int) *spillIterator {
+	start := s.offset(i)
+	return &spillIterator{
+		r: bufio.NewReader(io.NewSectionReader(s.fd, start, s.batches[i]-start)),
+	}
+}
+
+// get search the newest spilled entry of key
+func (s *spill) get(key string) (types.Entry, bool, error) {
+	for i := len(s.batches) - 1; i >= 0; i-- {
+		it := s.iter(i)
+		for it.next() {
+			if it.entry.Key == key {
+				return it.entry, true, nil
+			}
+			// batch is sorted, key is not in it
+			if it.entry.Key > key {
+				break
+			}
+		}
+		if it.err != nil {
+			return types.Entry{}, false, it.err
+		}
+	}
+	return types.Entry{}, false, nil
+}
+
+// merge call fn with the newest entry of each key in key order until fn returns false,
+// pending writes in memory are newer than all batches, they are merged as the newest one.
+func (s *spill) merge(pending []types.Entry, fn func(types.Entry) bool) error {
+	slices.SortFunc(pending, func(a, b types.Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	its := make([]*spillIterator, 0, len(s.batches)+1)
+	for i := range s.batches {
+		its = append(its, s.iter(i))
+	}
+	its = append(its, &spillIterator{pending: pending})
+
+	for i := len(its) - 1; i >= 0; i-- {
+		if !its[i].next() {
+			if its[i].err != nil {
+				return its[i].err
+			}
+			its = slices.Delete(its, i, i+1)
+		}
+	}
+
+	for len(its) > 0 {
+		// smallest key, the newest iterator wins if the key is in more than one of them
+		newest := 0
+		for i, it := range its {
+			if it.entry.Key <= its[newest].entry.Key {
+				newest = i
+			}
+		}
+		key := its[newest].entry.Key
+		if !fn(its[newest].entry) {
+			return nil
+		}
+
+		// advance all iterators at key
+		for i := len(its) - 1; i >= 0; i-- {
+			if its[i].entry.Key != key {
+				continue
+			}
+			if !its[i].next() {
+				if its[i].err != nil {
+					return its[i].err
+				}
+				its = slices.Delete(its, i, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// close close and remove the spill file
+func (s *spill) close() error {
+	return errors.Join(s.fd.Close(), os.Remove(s.fd.Name()))
+}
+
+// spillIterator iterate entries of a batch, or sorted pending writes in memory if r is nil
+type spillIterator struct {
+	r       *bufio.Reader
+	pending []types.Entry
+
+	entry types.Entry
+	err   error
+}
+
+func (it *spillIterator) next() bool {
+	if it.r == nil {
+		if len(it.pending) == 0 {
+			return false
+		}
+		it.entry, it.pending = it.pending[0], it.pending[1:]
+		return true
+	}
+
+	var n int64
+	if err := binary.Read(it.r, binary.LittleEndian, &n); err != nil {
+		if !errors.Is(err, io.EOF) {
+			it.err = err
+		}
+		return false
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(it.r, data); err != nil {
+		it.err = err
+		return false
+	}
+
+	var entry types.Entry
+	if err := utils.TUnmarshal(data, &entry); err != nil {
+		it.err = err
+		return false
+	}
+	it.entry = entry
+	return true
+}
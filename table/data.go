@@ -17,12 +17,20 @@ package table
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/bufferpool"
 	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/B1NARY-GR0UP/originium/utils"
 )
 
+var ErrCorruptedBlock = errors.New("error corrupted data block")
+
+// a data block uses a value dictionary only if at most this fraction of its values are distinct
+const _dictMaxDistinct = 0.5
+
 // Data Block
 type Data struct {
 	Entries []types.Entry
@@ -44,73 +52,85 @@ func (d *Data) Search(key types.Key) (types.Entry, bool) {
 }
 
 func (d *Data) LowerBound(key types.Key) (types.Entry, bool) {
-	low, high := 0, len(d.Entries)-1
-	for low <= high {
-		mid := low + ((high - low) >> 1)
-		if types.CompareKeys(d.Entries[mid].Key, key) >= 0 {
-			if mid == 0 || types.CompareKeys(d.Entries[mid-1].Key, key) < 0 {
-				return d.Entries[mid], true
-			}
-			high = mid - 1
-		} else {
-			low = mid + 1
-		}
+	i := d.lowerBound(key)
+	if i == len(d.Entries) {
+		return types.Entry{}, false
 	}
-	return types.Entry{}, false
+	return d.Entries[i], true
 }
 
+// Scan [start, end)
 func (d *Data) Scan(start, end types.Key) []types.Entry {
-	var res []types.Entry
-	var found bool
-	low, high := 0, len(d.Entries)-1
-
-	// find the first key >= start
-	var mid int
-	for low <= high {
-		mid = low + ((high - low) >> 1)
-		if types.CompareKeys(d.Entries[mid].Key, start) >= 0 {
-			if mid == 0 || types.CompareKeys(d.Entries[mid-1].Key, start) < 0 {
-				// used as return
-				found = true
-				break
-			}
-			high = mid - 1
-		} else {
-			low = mid + 1
-		}
-	}
-
-	for i := mid; i < len(d.Entries) && types.CompareKeys(d.Entries[i].Key, end) < 0 && found; i++ {
-		res = append(res, d.Entries[i])
+	low, high := d.lowerBound(start), d.lowerBound(end)
+	if low >= high {
+		return nil
 	}
+	return slices.Clone(d.Entries[low:high])
+}
 
-	return res
+// lowerBound return index of the first entry >= key, len(d.Entries) if there is none
+func (d *Data) lowerBound(key types.Key) int {
+	i, _ := slices.BinarySearchFunc(d.Entries, key, func(entry types.Entry, key types.Key) int {
+		return types.CompareKeys(entry.Key, key)
+	})
+	return i
 }
 
 func (d *Data) Encode() ([]byte, error) {
+	return d.EncodeWithLevel(utils.CompressionDefault)
+}
+
+// EncodeWithLevel encode and compress the block with level,
+// values are stored once in a dictionary of the block if they repeat enough
+func (d *Data) EncodeWithLevel(level utils.CompressionLevel) ([]byte, error) {
+	return d.encode(level, true)
+}
+
+func (d *Data) encode(level utils.CompressionLevel, useDict bool) ([]byte, error) {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
 	w := utils.NewErrorWriter(buf)
+
+	// dictionary: count | value length | value | ...
+	var dict [][]byte
+	var refs []int
+	if useDict {
+		dict, refs = d.valueDict()
+	}
+	if dict != nil {
+		w.WriteUvarint(uint64(len(dict)))
+		for _, value := range dict {
+			w.WriteUvarint(uint64(len(value)))
+			w.Write(binary.LittleEndian, value)
+		}
+	}
+
 	var prevKey string
-	for _, entry := range d.Entries {
+	var prevVersion uint64
+	for i, entry := range d.Entries {
 		lcp := utils.LCP(entry.Key, prevKey)
 		suffix := entry.Key[lcp:]
 
 		// lcp
-		w.Write(binary.LittleEndian, uint16(lcp))
+		w.WriteUvarint(uint64(lcp))
 
 		// suffix length
-		w.Write(binary.LittleEndian, uint16(len(suffix)))
+		w.WriteUvarint(uint64(len(suffix)))
 
 		// suffix
 		w.Write(binary.LittleEndian, []byte(suffix))
 
-		// value length
-		w.Write(binary.LittleEndian, uint16(len(entry.Value)))
+		if dict != nil {
+			// value index in dictionary
+			w.WriteUvarint(uint64(refs[i]))
+		} else {
+			// value length
+			w.WriteUvarint(uint64(len(entry.Value)))
 
-		// value
-		w.Write(binary.LittleEndian, entry.Value)
+			// value
+			w.Write(binary.LittleEndian, entry.Value)
+		}
 
 		// tombstone
 		tombstone := uint8(0)
@@ -120,26 +140,114 @@ func (d *Data) Encode() ([]byte, error) {
 		w.Write(binary.LittleEndian, tombstone)
 
 		// version
-		version := uint64(entry.Version)
-		w.Write(binary.LittleEndian, version)
+		// versions of the same key are stored as delta from the previous one,
+		// first entry of each block has no lcp, so the delta is reset at block boundary
+		if lcp > 0 && types.IsSameKey(entry.Key, prevKey) {
+			// wraps around if the version grows, it is undone by decode in the same modular arithmetic
+			w.WriteVarint(int64(prevVersion - types.Ts(entry)))
+		} else {
+			w.WriteUvarint(types.Ts(entry))
+		}
 
 		if w.Error() != nil {
 			return nil, w.Error()
 		}
 
 		prevKey = entry.Key
+		prevVersion = types.Ts(entry)
 	}
 
-	compressed := bufferpool.Pool.Get()
-	defer bufferpool.Pool.Put(compressed)
-
-	if err := utils.Compress(buf, compressed); err != nil {
+	block, err := compressBlock(buf, level)
+	if err != nil {
 		return nil, err
 	}
-	return compressed.Bytes(), nil
+	if dict != nil {
+		block[0] |= _blockDict
+	}
+	return block, nil
+}
+
+// valueDict return distinct values of entries and the index of the value of each entry in them,
+// nil if the dictionary does not pay off, e.g. values are mostly unique
+func (d *Data) valueDict() ([][]byte, []int) {
+	if len(d.Entries) == 0 {
+		return nil, nil
+	}
+
+	index := make(map[string]int)
+	var dict [][]byte
+	refs := make([]int, len(d.Entries))
+	// size of values stored inline and with dictionary
+	var inline, dictSize int
+	for i, entry := range d.Entries {
+		size := types.UvarintLen(uint64(len(entry.Value))) + len(entry.Value)
+		inline += size
+
+		j, ok := index[string(entry.Value)]
+		if !ok {
+			j = len(dict)
+			index[string(entry.Value)] = j
+			dict = append(dict, entry.Value)
+			dictSize += size
+		}
+		refs[i] = j
+		dictSize += types.UvarintLen(uint64(j))
+	}
+	dictSize += types.UvarintLen(uint64(len(dict)))
+
+	if float64(len(dict)) > float64(len(d.Entries))*_dictMaxDistinct || dictSize >= inline {
+		return nil, nil
+	}
+	return dict, refs
 }
 
 func (d *Data) Decode(data []byte) error {
+	return d.decode(data, false)
+}
+
+// DecodeVersion decode data blocks of a sstable of format version, see Meta.CheckVersion
+func (d *Data) DecodeVersion(data []byte, version uint64) error {
+	if version == _baselineFormatVersion {
+		return d.decodeBaseline(data, false)
+	}
+	return d.decode(data, false)
+}
+
+// DecodeKeysVersion decode data blocks like DecodeVersion, but values are skipped and left nil,
+// e.g. to check whether a key is live without copying its value.
+func (d *Data) DecodeKeysVersion(data []byte, version uint64) error {
+	if version == _baselineFormatVersion {
+		return d.decodeBaseline(data, true)
+	}
+	return d.decode(data, true)
+}
+
+func (d *Data) decode(data []byte, keysOnly bool) error {
+	buf := bufferpool.Pool.Get()
+	defer bufferpool.Pool.Put(buf)
+
+	// data blocks of a sstable are decoded as a whole, each may have its own dictionary
+	for len(data) > 0 {
+		flag, body, rest, err := nextBlock(data)
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		if err = decompressBody(flag&^_blockDict, body, buf); err != nil {
+			return err
+		}
+		if err = d.decodeEntries(buf.Bytes(), flag&_blockDict != 0, keysOnly); err != nil {
+			return err
+		}
+		data = rest
+	}
+	return nil
+}
+
+// decodeBaseline decode data blocks of format version 0, each block is a s2 stream of
+// lcp(2) | suffix length(2) | suffix | value length(2) | value | tombstone(1) | version(8),
+// concatenated streams decode as one, and lcp of the first entry of a block is 0.
+func (d *Data) decodeBaseline(data []byte, keysOnly bool) error {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
@@ -152,46 +260,173 @@ func (d *Data) Decode(data []byte) error {
 
 	var prevKey string
 	for reader.Len() > 0 {
-		// lcp
-		var lcp uint16
+		var lcp, suffixLen, valueLen uint16
 		r.Read(binary.LittleEndian, &lcp)
+		r.Read(binary.LittleEndian, &suffixLen)
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if int(lcp) > len(prevKey) || int(suffixLen) > reader.Len() {
+			return ErrCorruptedBlock
+		}
+		suffix := make([]byte, suffixLen)
+		r.Read(binary.LittleEndian, &suffix)
+
+		r.Read(binary.LittleEndian, &valueLen)
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if int(valueLen) > reader.Len() {
+			return ErrCorruptedBlock
+		}
+		var value []byte
+		if keysOnly {
+			_, _ = reader.Seek(int64(valueLen), io.SeekCurrent)
+		} else {
+			value = make([]byte, valueLen)
+			r.Read(binary.LittleEndian, &value)
+		}
+
+		var tombstone uint8
+		r.Read(binary.LittleEndian, &tombstone)
+		var version uint64
+		r.Read(binary.LittleEndian, &version)
+		if r.Error() != nil {
+			return r.Error()
+		}
+
+		key := prevKey[:lcp] + string(suffix)
+		d.Entries = append(d.Entries, types.Entry{
+			Key:       key,
+			Value:     value,
+			Tombstone: tombstone == 1,
+			Version:   types.Version(version),
+		})
+		prevKey = key
+	}
+	return nil
+}
+
+// decodeEntries decode raw bytes of a data block, which start with a value dictionary if hasDict,
+// values are skipped if keysOnly
+func (d *Data) decodeEntries(raw []byte, hasDict, keysOnly bool) error {
+	reader := bytes.NewReader(raw)
+	r := utils.NewErrorReader(reader)
+
+	var dict [][]byte
+	if hasDict {
+		n := r.ReadUvarint()
+		if r.Error() != nil {
+			return r.Error()
+		}
+		// each value takes at least a byte of length
+		if n > uint64(reader.Len()) {
+			return ErrCorruptedBlock
+		}
+		dict = make([][]byte, n)
+		for i := range dict {
+			valueLen := r.ReadUvarint()
+			if r.Error() != nil {
+				return r.Error()
+			}
+			if valueLen > uint64(reader.Len()) {
+				return ErrCorruptedBlock
+			}
+			if keysOnly {
+				_, _ = reader.Seek(int64(valueLen), io.SeekCurrent)
+				continue
+			}
+			dict[i] = make([]byte, valueLen)
+			r.Read(binary.LittleEndian, &dict[i])
+		}
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+
+	var prevKey string
+	var prevVersion uint64
+	for reader.Len() > 0 {
+		// lcp
+		lcp := r.ReadUvarint()
 
 		// suffix length
-		var suffixLen uint16
-		r.Read(binary.LittleEndian, &suffixLen)
+		suffixLen := r.ReadUvarint()
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if lcp > uint64(len(prevKey)) || suffixLen > uint64(reader.Len()) {
+			return ErrCorruptedBlock
+		}
 
 		// suffix
 		suffix := make([]byte, suffixLen)
 		r.Read(binary.LittleEndian, &suffix)
 
-		// value length
-		var valueLen uint16
-		r.Read(binary.LittleEndian, &valueLen)
+		var value []byte
+		if hasDict {
+			// value index in dictionary
+			idx := r.ReadUvarint()
+			if r.Error() != nil {
+				return r.Error()
+			}
+			if idx >= uint64(len(dict)) {
+				return ErrCorruptedBlock
+			}
+			// entries must not share the backing array of a value
+			if !keysOnly {
+				value = bytes.Clone(dict[idx])
+			}
+		} else {
+			// value length
+			valueLen := r.ReadUvarint()
+			if r.Error() != nil {
+				return r.Error()
+			}
+			if valueLen > uint64(reader.Len()) {
+				return ErrCorruptedBlock
+			}
 
-		// value
-		value := make([]byte, valueLen)
-		r.Read(binary.LittleEndian, &value)
+			// value
+			if keysOnly {
+				_, _ = reader.Seek(int64(valueLen), io.SeekCurrent)
+			} else {
+				value = make([]byte, valueLen)
+				r.Read(binary.LittleEndian, &value)
+			}
+		}
 
 		// tombstone
 		var tombstone uint8
 		r.Read(binary.LittleEndian, &tombstone)
 
+		if r.Error() != nil {
+			return r.Error()
+		}
+
+		key := prevKey[:lcp] + string(suffix)
+
+		// version
 		var version uint64
-		r.Read(binary.LittleEndian, &version)
+		if lcp > 0 && types.IsSameKey(key, prevKey) {
+			version = prevVersion - uint64(r.ReadVarint())
+		} else {
+			version = r.ReadUvarint()
+		}
 
 		if r.Error() != nil {
 			return r.Error()
 		}
 
-		key := prevKey[:lcp] + string(suffix)
 		d.Entries = append(d.Entries, types.Entry{
 			Key:       key,
 			Value:     value,
 			Tombstone: tombstone == 1,
-			Version:   int64(version),
+			Version:   types.Version(version),
 		})
 
 		prevKey = key
+		prevVersion = version
 	}
 	return nil
 }
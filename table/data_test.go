@@ -16,10 +16,19 @@ package table
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDataEncodeDecode(t *testing.T) {
@@ -145,12 +154,50 @@ func TestScan(t *testing.T) {
 			{Key: "key5@1", Value: []byte("value5"), Tombstone: true},
 		}},
 		{"key6@1", "key7@1", nil},
+		// start beyond all keys
+		{"key6@1", "key9@1", nil},
+		// end before all keys
+		{"key0@1", "key0@9", nil},
+		{"key0@1", "key1@1", nil},
+		// exact boundary hits, start is included and end is excluded
+		{"key5@1", "key6@1", []types.Entry{
+			{Key: "key5@1", Value: []byte("value5"), Tombstone: true},
+		}},
+		{"key2@1", "key3@1", []types.Entry{
+			{Key: "key2@1", Value: []byte("value2"), Tombstone: true},
+		}},
+		{"key1@1", "key5@1", []types.Entry{
+			{Key: "key1@1", Value: []byte("value1"), Tombstone: false},
+			{Key: "key2@1", Value: []byte("value2"), Tombstone: true},
+			{Key: "key3@1", Value: []byte("value3"), Tombstone: false},
+			{Key: "key4@1", Value: []byte("value4"), Tombstone: false},
+		}},
+		// empty and inverted ranges
+		{"key3@1", "key3@1", nil},
+		{"key4@1", "key2@1", nil},
 	}
 
 	for _, tt := range tests {
 		result := data.Scan(tt.start, tt.end)
-		assert.Equal(t, tt.expected, result)
+		assert.Equal(t, tt.expected, result, "[%s, %s)", tt.start, tt.end)
 	}
+
+	// versions of a key sort newer first, start and end between them
+	data = Data{
+		Entries: []types.Entry{
+			{Key: types.KeyWithTs("key1", 30)},
+			{Key: types.KeyWithTs("key1", 20)},
+			{Key: types.KeyWithTs("key1", 10)},
+		},
+	}
+	assert.Equal(t, data.Entries[1:], data.Scan(types.KeyWithTs("key1", 25), types.KeyWithTs("key1", 5)))
+	assert.Equal(t, data.Entries[:2], data.Scan(types.KeyWithTs("key1", 30), types.KeyWithTs("key1", 10)))
+	assert.Nil(t, (&Data{}).Scan("key0@1", "key9@1"))
+
+	// result does not alias entries of the block
+	result := data.Scan(types.KeyWithTs("key1", 30), types.KeyWithTs("key1", 20))
+	result[0].Key = "modified"
+	assert.Equal(t, types.KeyWithTs("key1", 30), data.Entries[0].Key)
 }
 
 func TestLowerBound(t *testing.T) {
@@ -284,3 +331,604 @@ func TestLowerBound(t *testing.T) {
 		})
 	}
 }
+
+func TestDataEncodeDecodeLargeVersion(t *testing.T) {
+	data := Data{
+		Entries: []types.Entry{
+			{Key: types.KeyWithTs("key1", 1<<40), Value: bytes.Repeat([]byte("v"), 1<<17), Version: 1 << 40},
+			{Key: types.KeyWithTs("key2", 1), Value: []byte{}, Tombstone: true, Version: 1},
+			// ts beyond int64, delta of versions of the same key wraps around
+			{Key: types.KeyWithTs("key3", math.MaxUint64), Value: []byte("max"), Version: types.Version(math.MaxUint64)},
+			{Key: types.KeyWithTs("key3", 1<<63+1), Value: []byte("v"), Version: types.Version(1<<63 + 1)},
+			{Key: types.KeyWithTs("key3", 1<<32), Value: []byte("v"), Version: types.Version(1 << 32)},
+		},
+	}
+
+	encoded, err := data.Encode()
+	assert.NoError(t, err)
+
+	var decoded Data
+	err = decoded.Decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	// ts round trips between key and version
+	for _, entry := range decoded.Entries {
+		assert.Equal(t, types.ParseTs(entry.Key), types.Ts(entry))
+	}
+}
+
+func TestDataEncodeDeltaVersion(t *testing.T) {
+	// hot key with 50 versions, descending timestamps
+	var entries []types.Entry
+	for i := 50; i > 0; i-- {
+		version := uint64(1_700_000_000_000 + i*3)
+		entries = append(entries, types.Entry{
+			Key:     types.KeyWithTs("hot", version),
+			Value:   []byte(fmt.Sprintf("v%d", i)),
+			Version: types.Version(version),
+		})
+	}
+	data := Data{Entries: entries}
+
+	encoded, err := data.Encode()
+	assert.NoError(t, err)
+
+	var decoded Data
+	err = decoded.Decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	fixed, err := encodeFixed(entries)
+	assert.NoError(t, err)
+
+	// compare sizes before compression
+	assert.Less(t, rawSize(t, encoded)*2, rawSize(t, fixed))
+}
+
+func TestDataEncodeDeltaVersionAcrossBlocks(t *testing.T) {
+	entries := []types.Entry{
+		{Key: types.KeyWithTs("hot", 30), Value: []byte("v30"), Version: 30},
+		{Key: types.KeyWithTs("hot", 20), Value: []byte("v20"), Version: 20},
+		{Key: types.KeyWithTs("hot", 10), Value: []byte("v10"), Version: 10},
+		{Key: types.KeyWithTs("hot", 5), Value: []byte("v5"), Version: 5},
+	}
+
+	// versions of the same key split into two blocks, decoded as a whole
+	var buf bytes.Buffer
+	for _, data := range []Data{{Entries: entries[:2]}, {Entries: entries[2:]}} {
+		encoded, err := data.Encode()
+		assert.NoError(t, err)
+		buf.Write(encoded)
+	}
+
+	var decoded Data
+	err := decoded.Decode(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, entries, decoded.Entries)
+}
+
+func TestEncodedSize(t *testing.T) {
+	entries := []types.Entry{
+		{Key: "a@1", Value: []byte("v"), Version: 1},
+		{Key: "key@1", Value: []byte{}, Tombstone: true, Version: 1},
+		{Key: types.KeyWithTs(string(bytes.Repeat([]byte("k"), 200)), 1<<40), Value: bytes.Repeat([]byte("v"), 1000), Version: 1 << 40},
+		{Key: "neg@1", Value: []byte("value"), Version: -1},
+	}
+
+	total := 0
+	for _, entry := range entries {
+		data := Data{Entries: []types.Entry{entry}}
+		encoded, err := data.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, rawSize(t, encoded), types.EncodedSize(entry))
+		total += types.EncodedSize(entry)
+	}
+
+	// prefix sharing and delta versions only make a block smaller than the sum of its entries
+	data := Data{Entries: entries}
+	encoded, err := data.Encode()
+	require.NoError(t, err)
+	assert.True(t, rawSize(t, encoded) <= total)
+}
+
+func TestDataEncodeIncompressible(t *testing.T) {
+	data := Data{Entries: incompressibleEntries(100)}
+
+	encoded, err := data.Encode()
+	require.NoError(t, err)
+	// stored raw, no larger than the raw bytes plus the block header
+	assert.Equal(t, _blockRaw, encoded[0])
+	assert.LessOrEqual(t, len(encoded), 1+binary.MaxVarintLen64+rawSize(t, encoded))
+
+	var decoded Data
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, data, decoded)
+
+	// compressible block is still compressed
+	compressible := Data{Entries: benchmarkEntries(100)}
+	encoded, err = compressible.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, _blockS2, encoded[0])
+
+	// raw and compressed blocks of a sstable are decoded as a whole
+	var buf bytes.Buffer
+	for _, block := range []Data{data, compressible} {
+		encoded, err = block.Encode()
+		require.NoError(t, err)
+		buf.Write(encoded)
+	}
+	decoded = Data{}
+	require.NoError(t, decoded.Decode(buf.Bytes()))
+	assert.Equal(t, append(data.Entries, compressible.Entries...), decoded.Entries)
+
+	// unknown flag or truncated block
+	encoded, err = data.Encode()
+	require.NoError(t, err)
+	assert.ErrorIs(t, (&Data{}).Decode(append([]byte{0xff}, encoded[1:]...)), ErrCorruptedBlock)
+	assert.ErrorIs(t, (&Data{}).Decode(encoded[:len(encoded)-1]), ErrCorruptedBlock)
+}
+
+func TestDataValueDict(t *testing.T) {
+	// enum-like values, random so that they are not compressed away
+	enums := make([][]byte, 4)
+	for i := range enums {
+		enums[i] = make([]byte, 64)
+		_, _ = rand.Read(enums[i])
+	}
+	var entries []types.Entry
+	for i := range 1000 {
+		entries = append(entries, types.Entry{
+			Key:       types.KeyWithTs(fmt.Sprintf("key%08d", i), 1),
+			Value:     enums[i%len(enums)],
+			Tombstone: i%100 == 0,
+			Version:   1,
+		})
+	}
+	data := Data{Entries: entries}
+
+	encoded, err := data.Encode()
+	require.NoError(t, err)
+	assert.NotZero(t, encoded[0]&_blockDict)
+	inline, err := data.encode(utils.CompressionDefault, false)
+	require.NoError(t, err)
+	assert.Zero(t, inline[0]&_blockDict)
+	assert.Less(t, len(encoded), len(inline))
+
+	var decoded Data
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, data, decoded)
+	// decoded entries do not share values
+	decoded.Entries[0].Value[0]++
+	assert.NotEqual(t, decoded.Entries[0].Value, decoded.Entries[len(enums)].Value)
+
+	// mostly unique values are stored inline
+	unique := Data{Entries: benchmarkEntries(1000)}
+	encoded, err = unique.Encode()
+	require.NoError(t, err)
+	assert.Zero(t, encoded[0]&_blockDict)
+	inline, err = unique.encode(utils.CompressionDefault, false)
+	require.NoError(t, err)
+	assert.Equal(t, inline, encoded)
+
+	// blocks with and without dictionary of a sstable are decoded as a whole
+	var buf bytes.Buffer
+	for _, block := range []Data{data, unique, data} {
+		encoded, err = block.Encode()
+		require.NoError(t, err)
+		buf.Write(encoded)
+	}
+	decoded = Data{}
+	require.NoError(t, decoded.Decode(buf.Bytes()))
+	assert.Equal(t, slices.Concat(data.Entries, unique.Entries, data.Entries), decoded.Entries)
+
+	// index of a value out of dictionary
+	small := Data{Entries: entries[:8]}
+	encoded, err = small.encode(utils.CompressionDefault, true)
+	require.NoError(t, err)
+	flag, body, _, err := nextBlock(encoded)
+	require.NoError(t, err)
+	require.NotZero(t, flag&_blockDict)
+	var raw bytes.Buffer
+	require.NoError(t, decompressBody(flag&^_blockDict, body, &raw))
+	corrupted := raw.Bytes()
+	// dictionary is count | length | value ..., the first entry follows it with lcp | suffix length | suffix | value index
+	offset := 1 + len(enums)*(1+64)
+	offset += 2 + len(entries[0].Key)
+	corrupted[offset] = byte(len(enums))
+	block := append([]byte{_blockDict | _blockRaw}, binary.AppendUvarint(nil, uint64(len(corrupted)))...)
+	assert.ErrorIs(t, (&Data{}).Decode(append(block, corrupted...)), ErrCorruptedBlock)
+}
+
+func rawSize(t testing.TB, encoded []byte) int {
+	var raw bytes.Buffer
+	err := decompressBlock(encoded, &raw)
+	assert.NoError(t, err)
+	return raw.Len()
+}
+
+// realistic block: sequential user keys, small versions, short values
+func benchmarkEntries(n int) []types.Entry {
+	entries := make([]types.Entry, 0, n)
+	for i := range n {
+		version := uint64(i%64 + 1)
+		entries = append(entries, types.Entry{
+			Key:     types.KeyWithTs(fmt.Sprintf("user:%08d", i), version),
+			Value:   []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d"}`, i, i)),
+			Version: types.Version(version),
+		})
+	}
+	return entries
+}
+
+// encodeFixed encode entries in format version 0 (fixed-width lengths and version)
+func encodeFixed(entries []types.Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := utils.NewErrorWriter(&buf)
+	var prevKey string
+	for _, entry := range entries {
+		lcp := utils.LCP(entry.Key, prevKey)
+		suffix := entry.Key[lcp:]
+		w.Write(binary.LittleEndian, uint16(lcp))
+		w.Write(binary.LittleEndian, uint16(len(suffix)))
+		w.Write(binary.LittleEndian, []byte(suffix))
+		w.Write(binary.LittleEndian, uint16(len(entry.Value)))
+		w.Write(binary.LittleEndian, entry.Value)
+		w.Write(binary.LittleEndian, uint8(0))
+		w.Write(binary.LittleEndian, uint64(entry.Version))
+		prevKey = entry.Key
+	}
+	if w.Error() != nil {
+		return nil, w.Error()
+	}
+
+	return compressBlock(&buf, utils.CompressionDefault)
+}
+
+func BenchmarkDataEncode(b *testing.B) {
+	data := Data{Entries: benchmarkEntries(1000)}
+
+	fixed, err := encodeFixed(data.Entries)
+	require.NoError(b, err)
+
+	encoded, err := data.Encode()
+	require.NoError(b, err)
+
+	var decoded Data
+	require.NoError(b, decoded.Decode(encoded))
+	require.Equal(b, data, decoded)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = data.Encode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(fixed)), "fixed-bytes/block")
+	b.ReportMetric(float64(len(encoded)), "varint-bytes/block")
+}
+
+// values already compressed or encrypted
+func incompressibleEntries(n int) []types.Entry {
+	entries := make([]types.Entry, 0, n)
+	for i := range n {
+		value := make([]byte, 256)
+		_, _ = rand.Read(value)
+		entries = append(entries, types.Entry{
+			Key:     types.KeyWithTs(fmt.Sprintf("blob:%08d", i), 1),
+			Value:   value,
+			Version: 1,
+		})
+	}
+	return entries
+}
+
+func BenchmarkDataIncompressible(b *testing.B) {
+	data := Data{Entries: incompressibleEntries(100)}
+
+	encoded, err := data.Encode()
+	require.NoError(b, err)
+
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := data.Encode(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(len(encoded)), "bytes/block")
+	})
+
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var decoded Data
+			if err := decoded.Decode(encoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDataEncodeCompressionLevel(t *testing.T) {
+	data := Data{Entries: benchmarkEntries(1000)}
+
+	var sizes []int
+	for _, level := range []utils.CompressionLevel{utils.CompressionDefault, utils.CompressionBetter, utils.CompressionBest} {
+		encoded, err := data.EncodeWithLevel(level)
+		require.NoError(t, err)
+		assert.Equal(t, _blockS2, encoded[0])
+		sizes = append(sizes, len(encoded))
+
+		// every level is decoded the same way
+		var decoded Data
+		require.NoError(t, decoded.Decode(encoded))
+		assert.Equal(t, data, decoded)
+	}
+	assert.GreaterOrEqual(t, sizes[0], sizes[1])
+	assert.Greater(t, sizes[0], sizes[2])
+
+	encoded, err := data.Encode()
+	require.NoError(t, err)
+	assert.Len(t, encoded, sizes[0])
+}
+
+func TestEncodeCompressionNone(t *testing.T) {
+	data := Data{Entries: benchmarkEntries(1000)}
+	compressed, err := data.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, _blockS2, compressed[0])
+
+	encoded, err := data.EncodeWithLevel(utils.CompressionNone)
+	require.NoError(t, err)
+	assert.Equal(t, _blockRaw, encoded[0])
+	assert.Greater(t, len(encoded), len(compressed))
+
+	var decoded Data
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, data, decoded)
+
+	// raw and compressed blocks of a sstable are decoded as a whole
+	decoded = Data{}
+	require.NoError(t, decoded.Decode(append(encoded, compressed...)))
+	assert.Equal(t, slices.Concat(data.Entries, data.Entries), decoded.Entries)
+
+	var index Index
+	for i, entry := range data.Entries {
+		index.Entries = append(index.Entries, IndexEntry{
+			StartKey:   entry.Key,
+			EndKey:     entry.Key,
+			DataHandle: BlockHandle{Offset: uint64(i), Length: 1},
+		})
+	}
+	encoded, err = index.EncodeWithLevel(utils.CompressionNone)
+	require.NoError(t, err)
+	assert.Equal(t, _blockRaw, encoded[0])
+
+	var decodedIndex Index
+	require.NoError(t, decodedIndex.Decode(encoded))
+	assert.Equal(t, index, decodedIndex)
+}
+
+func BenchmarkDataCompressionLevel(b *testing.B) {
+	data := Data{Entries: benchmarkEntries(1000)}
+
+	for _, bc := range []struct {
+		name  string
+		level utils.CompressionLevel
+	}{
+		{"default", utils.CompressionDefault},
+		{"better", utils.CompressionBetter},
+		{"best", utils.CompressionBest},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var decoded Data
+			encoded, err := data.EncodeWithLevel(bc.level)
+			require.NoError(b, err)
+			require.NoError(b, decoded.Decode(encoded))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err = data.EncodeWithLevel(bc.level); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(rawSize(b, encoded))/float64(len(encoded)), "ratio")
+			b.ReportMetric(float64(len(encoded)), "bytes/block")
+		})
+	}
+}
+
+// fuzzEntries build entries from fuzz input, each entry consumes flags | key length | value length | key | value | version,
+// flags: 1 another version of the previous key, 2 key repeated repeat times, 4 tombstone
+func fuzzEntries(data []byte, repeat uint16) []types.Entry {
+	var entries []types.Entry
+	for len(data) >= 3 {
+		flags, keyLen, valueLen := data[0], int(data[1]), int(data[2])
+		data = data[3:]
+
+		keyLen = min(keyLen, len(data))
+		key := string(data[:keyLen])
+		data = data[keyLen:]
+		valueLen = min(valueLen, len(data))
+		value := bytes.Clone(data[:valueLen])
+		data = data[valueLen:]
+
+		var version uint64
+		for i := 0; i < 8 && len(data) > 0; i++ {
+			version = version<<8 | uint64(data[0])
+			data = data[1:]
+		}
+
+		switch {
+		case flags&1 != 0 && len(entries) > 0:
+			key = types.ParseKey(entries[len(entries)-1].Key)
+		case flags&2 != 0:
+			key = strings.Repeat(key, int(repeat))
+		}
+		entries = append(entries, types.Entry{
+			Key:       types.KeyWithTs(key, version),
+			Value:     value,
+			Tombstone: flags&4 != 0,
+			Version:   types.Version(version),
+		})
+	}
+	return entries
+}
+
+// assertEntriesEqual values of entries are compared by bytes, so nil and empty values are equal
+func assertEntriesEqual(t *testing.T, expected, actual []types.Entry) {
+	require.Equal(t, len(expected), len(actual))
+	for i := range expected {
+		require.Equal(t, expected[i].Key, actual[i].Key)
+		require.Equal(t, expected[i].Version, actual[i].Version)
+		require.Equal(t, expected[i].Tombstone, actual[i].Tombstone)
+		require.True(t, bytes.Equal(expected[i].Value, actual[i].Value), "value of %q", expected[i].Key)
+	}
+}
+
+// fuzzSeeds inputs of fuzzEntries: empty values, binary bytes, many versions, versions near overflow and long keys
+var fuzzSeeds = []struct {
+	data   []byte
+	repeat uint16
+}{
+	{nil, 0},
+	{[]byte{0, 3, 5, 'k', 'e', 'y', 'v', 'a', 'l', 'u', 'e', 0, 0, 0, 0, 0, 0, 0, 1}, 0},
+	{[]byte{0, 2, 0, 0xff, 0x00, 1, 1, 0, 0, 0, 4, 0, 0xff, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0},
+	{[]byte{0, 1, 1, 'a', '@', 5, 1, 0, 1, 'b', 9, 1, 0, 1, 'c', 1, 5, 0, 0, 7}, 0},
+	{[]byte{2, 4, 1, 'l', 'o', 'n', 'g', 'v', 1, 1, 0, 0, 2}, 20000},
+	// repeated values stored in a dictionary
+	{bytes.Repeat([]byte{0, 1, 3, 'k', 'y', 'e', 's', 0, 0, 0, 0, 0, 0, 0, 1}, 4), 0},
+}
+
+func FuzzDataEncodeDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed.data, seed.repeat)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, repeat uint16) {
+		entries := fuzzEntries(data, repeat)
+
+		encoded, err := (&Data{Entries: entries}).Encode()
+		require.NoError(t, err)
+
+		var decoded Data
+		require.NoError(t, decoded.Decode(encoded))
+		assertEntriesEqual(t, entries, decoded.Entries)
+	})
+}
+
+func FuzzDataDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		encoded, err := (&Data{Entries: fuzzEntries(seed.data, seed.repeat)}).Encode()
+		require.NoError(f, err)
+		f.Add(encoded)
+	}
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		// arbitrary input is either rejected or decoded into entries which encode and decode the same
+		var decoded Data
+		if err := decoded.Decode(encoded); err != nil {
+			return
+		}
+		reencoded, err := decoded.Encode()
+		require.NoError(t, err)
+
+		var again Data
+		require.NoError(t, again.Decode(reencoded))
+		assertEntriesEqual(t, decoded.Entries, again.Entries)
+	})
+}
+
+// testdata/baseline.db is a sstable of L0 written by table.Build of the v0.2.1 release
+func TestDataDecodeKeys(t *testing.T) {
+	enums := [][]byte{[]byte("enum1"), []byte("enum2")}
+	var entries []types.Entry
+	for i := range 100 {
+		entries = append(entries, types.Entry{
+			Key:       types.KeyWithTs(fmt.Sprintf("key%03d", i), 1),
+			Value:     enums[i%len(enums)],
+			Tombstone: i%10 == 0,
+			Version:   1,
+		})
+	}
+	dict := Data{Entries: entries}
+	unique := Data{Entries: benchmarkEntries(100)}
+
+	// blocks with and without dictionary
+	var buf bytes.Buffer
+	for _, block := range []Data{dict, unique} {
+		encoded, err := block.Encode()
+		require.NoError(t, err)
+		buf.Write(encoded)
+	}
+	var want []types.Entry
+	for _, entry := range slices.Concat(dict.Entries, unique.Entries) {
+		entry.Value = nil
+		want = append(want, entry)
+	}
+	var decoded Data
+	require.NoError(t, decoded.DecodeKeysVersion(buf.Bytes(), _formatVersion))
+	assert.Equal(t, want, decoded.Entries)
+
+	// format version 0
+	sstable, err := os.ReadFile("testdata/baseline.db")
+	require.NoError(t, err)
+	var footer Footer
+	require.NoError(t, footer.Decode(sstable[len(sstable)-FooterSize:]))
+	var index Index
+	handle := footer.IndexBlock
+	require.NoError(t, index.DecodeVersion(sstable[handle.Offset:handle.Offset+handle.Length], _baselineFormatVersion))
+	handle = index.DataBlock
+	var data, keys Data
+	require.NoError(t, data.DecodeVersion(sstable[handle.Offset:handle.Offset+handle.Length], _baselineFormatVersion))
+	require.NoError(t, keys.DecodeKeysVersion(sstable[handle.Offset:handle.Offset+handle.Length], _baselineFormatVersion))
+	require.Len(t, keys.Entries, len(data.Entries))
+	for i, entry := range data.Entries {
+		entry.Value = nil
+		assert.Equal(t, entry, keys.Entries[i])
+	}
+}
+
+func TestDecodeBaseline(t *testing.T) {
+	sstable, err := os.ReadFile("testdata/baseline.db")
+	require.NoError(t, err)
+
+	meta, err := ReadMeta(sstable)
+	require.NoError(t, err)
+	assert.Equal(t, _baselineFormatVersion, meta.Version)
+	assert.Zero(t, meta.Level)
+	assert.NoError(t, meta.CheckVersion())
+
+	var footer Footer
+	require.NoError(t, footer.Decode(sstable[len(sstable)-FooterSize:]))
+	handle := footer.IndexBlock
+	var index Index
+	require.NoError(t, index.DecodeVersion(sstable[handle.Offset:handle.Offset+handle.Length], meta.Version))
+	assert.Greater(t, len(index.Entries), 1)
+
+	var want []types.Entry
+	for i := range 20 {
+		key := fmt.Sprintf("key%02d", i)
+		if i%5 == 4 {
+			want = append(want, types.Entry{Key: types.KeyWithTs(key, 3), Value: []byte{}, Tombstone: true, Version: 3})
+		}
+		want = append(want, types.Entry{Key: types.KeyWithTs(key, 2), Value: []byte(fmt.Sprintf("value%02d", i)), Version: 2})
+	}
+
+	// all data blocks at once
+	handle = index.DataBlock
+	var data Data
+	require.NoError(t, data.DecodeVersion(sstable[handle.Offset:handle.Offset+handle.Length], meta.Version))
+	assert.Equal(t, want, data.Entries)
+
+	// one data block
+	handle = index.Entries[1].DataHandle
+	var block Data
+	require.NoError(t, block.DecodeVersion(sstable[handle.Offset:handle.Offset+handle.Length], meta.Version))
+	assert.Equal(t, index.Entries[1].StartKey, block.Entries[0].Key)
+	assert.Equal(t, index.Entries[1].EndKey, block.Entries[len(block.Entries)-1].Key)
+
+	// the layout is not the one of the current version
+	assert.Error(t, (&Data{}).Decode(sstable[index.DataBlock.Offset:index.DataBlock.Length]))
+}
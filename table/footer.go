@@ -27,6 +27,9 @@ const _magic uint64 = 0x5bc2aa5766250562
 
 var ErrInvalidMagic = errors.New("error invalid magic")
 
+// FooterSize size of encoded footer at the end of sstable
+const FooterSize = 40
+
 // Footer
 // 16 + 16 + 8 = 40 bytes
 type Footer struct {
@@ -49,7 +52,8 @@ func (f *Footer) Encode() ([]byte, error) {
 	if w.Error() != nil {
 		return nil, w.Error()
 	}
-	return buf.Bytes(), nil
+	// buf is reused once returned to the pool, so the block must be copied out of it
+	return bytes.Clone(buf.Bytes()), nil
 }
 
 func (f *Footer) Decode(footer []byte) error {
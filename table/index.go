@@ -68,10 +68,36 @@ func (i *Index) Search(key types.Key) (BlockHandle, bool) {
 	return BlockHandle{}, false
 }
 
+// LowerBound data block included the first key greater or equal than key
+// Search for the first element whose EndKey is greater than or equal to the given value
+func (i *Index) LowerBound(key types.Key) (BlockHandle, bool) {
+	n := len(i.Entries)
+	if n == 0 {
+		return BlockHandle{}, false
+	}
+
+	low, high := 0, n-1
+	for low <= high {
+		mid := low + ((high - low) >> 1)
+		if types.CompareKeys(i.Entries[mid].EndKey, key) >= 0 {
+			if mid == 0 || types.CompareKeys(i.Entries[mid-1].EndKey, key) < 0 {
+				return i.Entries[mid].DataHandle, true
+			}
+			high = mid - 1
+		} else {
+			low = mid + 1
+		}
+	}
+	return BlockHandle{}, false
+}
+
+// Scan [start, end)
+// return handles of data blocks which may contain keys in range,
+// a block starting at end is excluded, same as Data.Scan and SkipList.Scan
 func (i *Index) Scan(start, end types.Key) []BlockHandle {
 	var res []BlockHandle
 	for _, entry := range i.Entries {
-		if types.CompareKeys(entry.EndKey, start) >= 0 && types.CompareKeys(entry.StartKey, end) <= 0 {
+		if types.CompareKeys(entry.EndKey, start) >= 0 && types.CompareKeys(entry.StartKey, end) < 0 {
 			res = append(res, entry.DataHandle)
 		}
 	}
@@ -79,6 +105,11 @@ func (i *Index) Scan(start, end types.Key) []BlockHandle {
 }
 
 func (i *Index) Encode() ([]byte, error) {
+	return i.EncodeWithLevel(utils.CompressionDefault)
+}
+
+// EncodeWithLevel encode and compress the block with level
+func (i *Index) EncodeWithLevel(level utils.CompressionLevel) ([]byte, error) {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
@@ -87,9 +118,9 @@ func (i *Index) Encode() ([]byte, error) {
 	w.Write(binary.LittleEndian, i.DataBlock.Length)
 
 	for _, entry := range i.Entries {
-		w.Write(binary.LittleEndian, uint16(len(entry.StartKey)))
+		w.WriteUvarint(uint64(len(entry.StartKey)))
 		w.Write(binary.LittleEndian, []byte(entry.StartKey))
-		w.Write(binary.LittleEndian, uint16(len(entry.EndKey)))
+		w.WriteUvarint(uint64(len(entry.EndKey)))
 		w.Write(binary.LittleEndian, []byte(entry.EndKey))
 		w.Write(binary.LittleEndian, entry.DataHandle.Offset)
 		w.Write(binary.LittleEndian, entry.DataHandle.Length)
@@ -99,16 +130,20 @@ func (i *Index) Encode() ([]byte, error) {
 		return nil, w.Error()
 	}
 
-	compressed := bufferpool.Pool.Get()
-	defer bufferpool.Pool.Put(compressed)
+	return compressBlock(buf, level)
+}
 
-	if err := utils.Compress(buf, compressed); err != nil {
-		return nil, err
+// DecodeVersion decode index block of a sstable of format version, see Meta.CheckVersion
+func (i *Index) DecodeVersion(index []byte, version uint64) error {
+	if version == _baselineFormatVersion {
+		return i.decodeBaseline(index)
 	}
-	return compressed.Bytes(), nil
+	return i.Decode(index)
 }
 
-func (i *Index) Decode(index []byte) error {
+// decodeBaseline decode index block of format version 0, a s2 stream of data block handle(16) followed by
+// entries of start key length(2) | start key | end key length(2) | end key | data handle(16)
+func (i *Index) decodeBaseline(index []byte) error {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
@@ -122,14 +157,75 @@ func (i *Index) Decode(index []byte) error {
 	r.Read(binary.LittleEndian, &i.DataBlock.Offset)
 	r.Read(binary.LittleEndian, &i.DataBlock.Length)
 
+	readKey := func() []byte {
+		var n uint16
+		r.Read(binary.LittleEndian, &n)
+		if r.Error() != nil || int(n) > reader.Len() {
+			return nil
+		}
+		key := make([]byte, n)
+		r.Read(binary.LittleEndian, &key)
+		return key
+	}
+
 	for reader.Len() > 0 {
-		var startKeyLen uint16
-		r.Read(binary.LittleEndian, &startKeyLen)
+		startKey := readKey()
+		endKey := readKey()
+		var offset, length uint64
+		r.Read(binary.LittleEndian, &offset)
+		r.Read(binary.LittleEndian, &length)
+
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if startKey == nil || endKey == nil {
+			return ErrCorruptedBlock
+		}
+
+		i.Entries = append(i.Entries, IndexEntry{
+			StartKey: string(startKey),
+			EndKey:   string(endKey),
+			DataHandle: BlockHandle{
+				Offset: offset,
+				Length: length,
+			},
+		})
+	}
+	return nil
+}
+
+func (i *Index) Decode(index []byte) error {
+	buf := bufferpool.Pool.Get()
+	defer bufferpool.Pool.Put(buf)
+
+	if err := decompressBlock(index, buf); err != nil {
+		return err
+	}
+
+	reader := bytes.NewReader(buf.Bytes())
+	r := utils.NewErrorReader(reader)
+
+	r.Read(binary.LittleEndian, &i.DataBlock.Offset)
+	r.Read(binary.LittleEndian, &i.DataBlock.Length)
+
+	for reader.Len() > 0 {
+		startKeyLen := r.ReadUvarint()
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if startKeyLen > uint64(reader.Len()) {
+			return ErrCorruptedBlock
+		}
 		startKey := make([]byte, startKeyLen)
 		r.Read(binary.LittleEndian, &startKey)
 
-		var endKeyLen uint16
-		r.Read(binary.LittleEndian, &endKeyLen)
+		endKeyLen := r.ReadUvarint()
+		if r.Error() != nil {
+			return r.Error()
+		}
+		if endKeyLen > uint64(reader.Len()) {
+			return ErrCorruptedBlock
+		}
 		endKey := make([]byte, endKeyLen)
 		r.Read(binary.LittleEndian, &endKey)
 
@@ -17,7 +17,9 @@ package table
 import (
 	"testing"
 
+	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIndexSearch(t *testing.T) {
@@ -80,6 +82,90 @@ func TestIndexSearch(t *testing.T) {
 	assert.Equal(t, uint64(0), dataH.Offset)
 }
 
+func TestIndexLowerBound(t *testing.T) {
+	index := Index{
+		Entries: []IndexEntry{
+			{
+				StartKey:   "b@3",
+				EndKey:     "c@1",
+				DataHandle: BlockHandle{Offset: 2, Length: 1},
+			},
+			{
+				StartKey:   "d@1",
+				EndKey:     "e@1",
+				DataHandle: BlockHandle{Offset: 4, Length: 1},
+			},
+			{
+				StartKey:   "f@1",
+				EndKey:     "h@1",
+				DataHandle: BlockHandle{Offset: 6, Length: 1},
+			},
+		},
+	}
+
+	tests := []struct {
+		key    string
+		offset uint64
+		found  bool
+	}{
+		// newer version of the first key
+		{"b@5", 2, true},
+		{"a@1", 2, true},
+		{"c@1", 2, true},
+		{"c@0", 4, true},
+		{"e@5", 4, true},
+		{"g@1", 6, true},
+		{"h@1", 6, true},
+		{"i@1", 0, false},
+	}
+
+	for _, tt := range tests {
+		dataH, found := index.LowerBound(tt.key)
+		assert.Equal(t, tt.found, found, tt.key)
+		assert.Equal(t, tt.offset, dataH.Offset, tt.key)
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	index := Index{
+		Entries: []IndexEntry{
+			{
+				StartKey:   "b@1",
+				EndKey:     "c@1",
+				DataHandle: BlockHandle{Offset: 2, Length: 1},
+			},
+			{
+				StartKey:   "d@1",
+				EndKey:     "e@1",
+				DataHandle: BlockHandle{Offset: 4, Length: 1},
+			},
+		},
+	}
+
+	tests := []struct {
+		start   string
+		end     string
+		offsets []uint64
+	}{
+		{"a@1", "z@1", []uint64{2, 4}},
+		// end is exclusive, the block starting at end is not scanned
+		{"a@1", "d@1", []uint64{2}},
+		{"a@1", "b@1", nil},
+		// start is inclusive
+		{"c@1", "d@1", []uint64{2}},
+		{"e@1", "z@1", []uint64{4}},
+		{"f@1", "z@1", nil},
+	}
+
+	for _, tt := range tests {
+		var offsets []uint64
+		for _, handle := range index.Scan(tt.start, tt.end) {
+			offsets = append(offsets, handle.Offset)
+		}
+		assert.Equal(t, tt.offsets, offsets, tt.start+" "+tt.end)
+	}
+}
+
 func TestIndexEncodeDecode(t *testing.T) {
 	index := Index{
 		DataBlock: BlockHandle{
@@ -123,3 +209,58 @@ func TestIndexEncodeDecode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, index, decodedIndex)
 }
+
+// fuzzIndex build an index of a data block handle per entry of fuzzEntries, start and end keys may be long
+func fuzzIndex(data []byte, repeat uint16) Index {
+	var index Index
+	for i, entry := range fuzzEntries(data, repeat) {
+		index.Entries = append(index.Entries, IndexEntry{
+			StartKey: entry.Key,
+			EndKey:   types.ParseKey(entry.Key) + string(entry.Value),
+			DataHandle: BlockHandle{
+				Offset: uint64(entry.Version),
+				Length: uint64(i),
+			},
+		})
+		index.DataBlock.Length += uint64(len(entry.Value))
+	}
+	return index
+}
+
+func FuzzIndexEncodeDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed.data, seed.repeat)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, repeat uint16) {
+		index := fuzzIndex(data, repeat)
+
+		encoded, err := index.Encode()
+		require.NoError(t, err)
+
+		var decoded Index
+		require.NoError(t, decoded.Decode(encoded))
+		assert.Equal(t, index, decoded)
+	})
+}
+
+func FuzzIndexDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		index := fuzzIndex(seed.data, seed.repeat)
+		encoded, err := index.Encode()
+		require.NoError(f, err)
+		f.Add(encoded)
+	}
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		// arbitrary input is either rejected or decoded into an index which encodes and decodes the same
+		var decoded Index
+		if err := decoded.Decode(encoded); err != nil {
+			return
+		}
+		reencoded, err := decoded.Encode()
+		require.NoError(t, err)
+
+		var again Index
+		require.NoError(t, again.Decode(reencoded))
+		assert.Equal(t, decoded, again)
+	})
+}
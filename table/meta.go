@@ -17,15 +17,55 @@ package table
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/bufferpool"
 	"github.com/B1NARY-GR0UP/originium/utils"
 )
 
+// format version of sstable
+// 0: fixed-width lengths and version in data block, blocks are plain s2 streams, written by v0.2.1
+// 1: varint lengths and version in data block
+// 2: versions of the same key delta-encoded in data block
+// 3: data and index blocks prefixed with a compression flag, incompressible blocks stored raw
+// 4: varint key lengths in index block, keys are no longer limited to 64KB
+// 5: optional value dictionary of data block, flagged in its block header
+// 6: number of entries in meta block
+const _formatVersion uint64 = 6
+
+// _baselineFormatVersion format version of sstables of the v0.2.1 release, which has no version in meta block
+const _baselineFormatVersion uint64 = 0
+
+// oldest format version of sstable which can be decoded besides the baseline one,
+// versions 1 to 3 were never written by a release
+const _minFormatVersion uint64 = 4
+
+var ErrUnsupportedFormat = errors.New("unsupported sstable format version")
+
+// FormatVersion return the format version of sstables written by this release, it is stored in the meta block,
+// a reader fails with ErrUnsupportedFormat on a version it can not decode instead of misdecoding blocks
+func FormatVersion() int {
+	return int(_formatVersion)
+}
+
 // Meta Block
 type Meta struct {
 	CreatedUnix int64
 	Level       uint64
+	// format version of sstable
+	Version uint64
+	// number of entries of sstable, 0 if written before format version 6
+	NumEntries uint64
+}
+
+// CheckVersion return ErrUnsupportedFormat if blocks of the sstable can not be decoded,
+// e.g. it is written by a newer release
+func (m *Meta) CheckVersion() error {
+	if m.Version != _baselineFormatVersion && (m.Version < _minFormatVersion || m.Version > _formatVersion) {
+		return fmt.Errorf("%w: %d, supported %d and %d to %d", ErrUnsupportedFormat, m.Version, _baselineFormatVersion, _minFormatVersion, _formatVersion)
+	}
+	return nil
 }
 
 func (m *Meta) Encode() ([]byte, error) {
@@ -36,12 +76,15 @@ func (m *Meta) Encode() ([]byte, error) {
 
 	w.Write(binary.LittleEndian, m.CreatedUnix)
 	w.Write(binary.LittleEndian, m.Level)
+	w.Write(binary.LittleEndian, m.Version)
+	w.Write(binary.LittleEndian, m.NumEntries)
 
 	if err := w.Error(); err != nil {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	// buf is reused once returned to the pool, so the block must be copied out of it
+	return bytes.Clone(buf.Bytes()), nil
 }
 
 func (m *Meta) Decode(data []byte) error {
@@ -50,9 +93,17 @@ func (m *Meta) Decode(data []byte) error {
 	r := utils.NewErrorReader(reader)
 
 	var createdUnix int64
-	var level uint64
+	var level, version, numEntries uint64
 	r.Read(binary.LittleEndian, &createdUnix)
 	r.Read(binary.LittleEndian, &level)
+	// meta block written before format version 1 has no version
+	if reader.Len() > 0 {
+		r.Read(binary.LittleEndian, &version)
+	}
+	// meta block written before format version 6 has no number of entries
+	if reader.Len() > 0 {
+		r.Read(binary.LittleEndian, &numEntries)
+	}
 
 	if err := r.Error(); err != nil {
 		return err
@@ -60,5 +111,7 @@ func (m *Meta) Decode(data []byte) error {
 
 	m.CreatedUnix = createdUnix
 	m.Level = level
+	m.Version = version
+	m.NumEntries = numEntries
 	return nil
 }
@@ -25,6 +25,8 @@ func TestMetaEncodeDecode(t *testing.T) {
 	meta := &Meta{
 		CreatedUnix: time.Now().Unix(),
 		Level:       3,
+		Version:     _formatVersion,
+		NumEntries:  42,
 	}
 
 	encoded, err := meta.Encode()
@@ -37,4 +39,26 @@ func TestMetaEncodeDecode(t *testing.T) {
 
 	assert.Equal(t, meta.CreatedUnix, decodedMeta.CreatedUnix)
 	assert.Equal(t, meta.Level, decodedMeta.Level)
+	assert.Equal(t, meta.Version, decodedMeta.Version)
+	assert.Equal(t, meta.NumEntries, decodedMeta.NumEntries)
+
+	// meta block written before format version 6 has no number of entries
+	decodedMeta = &Meta{}
+	err = decodedMeta.Decode(encoded[:len(encoded)-8])
+	assert.NoError(t, err)
+	assert.Equal(t, meta.Version, decodedMeta.Version)
+	assert.Zero(t, decodedMeta.NumEntries)
+}
+
+func TestMetaCheckVersion(t *testing.T) {
+	assert.NoError(t, (&Meta{Version: uint64(FormatVersion())}).CheckVersion())
+
+	for version := range _formatVersion + 2 {
+		meta := &Meta{Version: version}
+		if version == _baselineFormatVersion || version >= _minFormatVersion && version <= _formatVersion {
+			assert.NoError(t, meta.CheckVersion(), version)
+		} else {
+			assert.ErrorIs(t, meta.CheckVersion(), ErrUnsupportedFormat, version)
+		}
+	}
 }
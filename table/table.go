@@ -15,12 +15,24 @@
 package table
 
 import (
+	"bytes"
+	"encoding/binary"
 	"time"
 
 	"github.com/B1NARY-GR0UP/originium/pkg/bufferpool"
 	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/B1NARY-GR0UP/originium/utils"
 )
 
+// compression flag of data and index blocks
+const (
+	_blockRaw uint8 = iota
+	_blockS2
+)
+
+// _blockDict is set in the flag of a data block whose raw body starts with a value dictionary
+const _blockDict uint8 = 1 << 7
+
 // TODO: introduce builder
 // TODO: binary.LittleEndian.Put
 
@@ -29,7 +41,72 @@ type BlockHandle struct {
 	Length uint64
 }
 
-func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
+// compressBlock compress raw with s2, raw is stored as is if compressed is not smaller,
+// e.g. values already compressed or encrypted, or if level is CompressionNone
+//
+// block format: flag(1) | body length(uvarint) | body
+// data blocks of a sstable are read and decoded as a whole, so the length is needed to find the next block.
+func compressBlock(raw *bytes.Buffer, level utils.CompressionLevel) ([]byte, error) {
+	compressed := bufferpool.Pool.Get()
+	defer bufferpool.Pool.Put(compressed)
+
+	flag, body := _blockRaw, raw.Bytes()
+	// no s2 writer is allocated if compression is disabled
+	if level != utils.CompressionNone {
+		if err := utils.CompressWithLevel(bytes.NewReader(raw.Bytes()), compressed, level); err != nil {
+			return nil, err
+		}
+		if compressed.Len() < raw.Len() {
+			flag, body = _blockS2, compressed.Bytes()
+		}
+	}
+	// buffers are returned to the pool, so the block must be copied out of them
+	block := make([]byte, 0, 1+binary.MaxVarintLen64+len(body))
+	block = append(block, flag)
+	block = binary.AppendUvarint(block, uint64(len(body)))
+	return append(block, body...), nil
+}
+
+// decompressBlock write raw bytes of one or more consecutive blocks without value dictionary to buf
+func decompressBlock(blocks []byte, buf *bytes.Buffer) error {
+	for len(blocks) > 0 {
+		flag, body, rest, err := nextBlock(blocks)
+		if err != nil {
+			return err
+		}
+		if err = decompressBody(flag, body, buf); err != nil {
+			return err
+		}
+		blocks = rest
+	}
+	return nil
+}
+
+// nextBlock split the first block of blocks into its flag and body, rest is the blocks after it
+func nextBlock(blocks []byte) (flag uint8, body, rest []byte, err error) {
+	flag = blocks[0]
+	n, size := binary.Uvarint(blocks[1:])
+	if size <= 0 || n > uint64(len(blocks)-1-size) {
+		return 0, nil, nil, ErrCorruptedBlock
+	}
+	return flag, blocks[1+size : 1+size+int(n)], blocks[1+size+int(n):], nil
+}
+
+// decompressBody write raw bytes of body compressed as flag to buf
+func decompressBody(flag uint8, body []byte, buf *bytes.Buffer) error {
+	switch flag {
+	case _blockRaw:
+		buf.Write(body)
+	case _blockS2:
+		return utils.Decompress(bytes.NewReader(body), buf)
+	default:
+		return ErrCorruptedBlock
+	}
+	return nil
+}
+
+// Build build a sstable of level, blocks are compressed with compression
+func Build(entries []types.Entry, dataBlockSize, level int, compression utils.CompressionLevel) (Index, []byte) {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
@@ -44,9 +121,7 @@ func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
 			data = Data{}
 			currSize = 0
 		}
-		// key, value, tombstone byte sizes
-		entrySize := len(entry.Key) + len(entry.Value) + 1
-		currSize += entrySize
+		currSize += types.EncodedSize(entry)
 		data.Entries = append(data.Entries, entry)
 	}
 	if len(data.Entries) > 0 {
@@ -57,7 +132,7 @@ func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
 	var indexBlock Index
 	var offset uint64
 	for _, block := range dataBlocks {
-		dataBytes, err := block.Encode()
+		dataBytes, err := block.EncodeWithLevel(compression)
 		if err != nil {
 			panic(err)
 		}
@@ -86,6 +161,8 @@ func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
 	metaBlock := Meta{
 		CreatedUnix: time.Now().Unix(),
 		Level:       uint64(level),
+		Version:     _formatVersion,
+		NumEntries:  uint64(len(entries)),
 	}
 	metaBytes, err := metaBlock.Encode()
 	if err != nil {
@@ -100,7 +177,7 @@ func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
 	}
 
 	// build footer
-	indexBytes, err := indexBlock.Encode()
+	indexBytes, err := indexBlock.EncodeWithLevel(compression)
 	if err != nil {
 		panic(err)
 	}
@@ -133,5 +210,26 @@ func Build(entries []types.Entry, dataBlockSize, level int) (Index, []byte) {
 		panic(err)
 	}
 
-	return indexBlock, buf.Bytes()
+	// buf is reused once returned to the pool, so the sstable must be copied out of it
+	return indexBlock, bytes.Clone(buf.Bytes())
+}
+
+// ReadMeta decode meta block of sstable, e.g. one just returned by Build
+func ReadMeta(sstable []byte) (Meta, error) {
+	if len(sstable) < FooterSize {
+		return Meta{}, ErrCorruptedBlock
+	}
+	var footer Footer
+	if err := footer.Decode(sstable[len(sstable)-FooterSize:]); err != nil {
+		return Meta{}, err
+	}
+	handle := footer.MetaBlock
+	if handle.Offset > uint64(len(sstable)) || handle.Length > uint64(len(sstable))-handle.Offset {
+		return Meta{}, ErrCorruptedBlock
+	}
+	var meta Meta
+	if err := meta.Decode(sstable[handle.Offset : handle.Offset+handle.Length]); err != nil {
+		return Meta{}, err
+	}
+	return meta, nil
 }
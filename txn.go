@@ -16,6 +16,11 @@ package originium
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
 
 	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/B1NARY-GR0UP/originium/utils"
@@ -24,102 +29,691 @@ import (
 var (
 	ErrReadOnlyTxn  = errors.New("transaction is read-only")
 	ErrDiscardedTxn = errors.New("transaction has been discarded")
+	ErrCommittedTxn = errors.New("transaction has been committed")
 	ErrConflictTxn  = errors.New("transaction has a conflict")
 	ErrEmptyKey     = errors.New("key is empty")
+	ErrKeyDeleted   = errors.New("key has been deleted")
+	// ErrWriteStalled is returned by Commit while flush falls behind, the txn can be retried later
+	ErrWriteStalled = errors.New("writes are stalled by pending flushes")
+	// ErrTsExhausted is returned when no timestamp is left below math.MaxUint64
+	ErrTsExhausted = errors.New("timestamps are exhausted")
+	// ErrFutureTs is returned by ViewAt for a ts after the latest commit
+	ErrFutureTs = errors.New("ts is after the latest commit")
+	// ErrTsTooOld is returned by ViewAt for a ts whose versions may have been discarded by compaction
+	ErrTsTooOld = errors.New("versions at ts may have been discarded")
 )
 
+// IsolationLevel isolation level of update txn, read-only txns always read a consistent snapshot at readTs
+type IsolationLevel int
+
+const (
+	// SnapshotIsolation detect conflicts on keys read by point reads and returned by scans,
+	// a key inserted into a scanned range by a concurrent txn is not detected, so write skew on ranges is permitted.
+	SnapshotIsolation IsolationLevel = iota
+	// Serializable also detect conflicts on ranges scanned, a concurrent write to any key in them aborts the txn.
+	Serializable
+)
+
+// TxnOptions options of a txn begun by BeginWithOptions
+type TxnOptions struct {
+	// Update begin an update txn, otherwise a read-only one which tracks no reads
+	Update bool
+	// Isolation of an update txn, SnapshotIsolation by default
+	Isolation IsolationLevel
+}
+
+// Txn all keys accepted and returned by Txn are user keys,
+// versions are kept in internal keys (key@ts) which never leave the db.
+// values returned by reads are copies owned by the caller, which may modify them
+// without affecting values stored in the db or pending in the txn.
 type Txn struct {
 	readOnly  bool
 	discarded bool
+	committed bool
 	doneRead  bool
 
 	db *DB
 
-	readTs uint64
+	readTs    uint64
+	isolation IsolationLevel
 
 	readsFp  []uint64
 	writesFp map[uint64]struct{}
+	// ranges scanned by a serializable txn
+	readRanges []keyRange
 
 	pendingWrites map[types.Key]types.Entry
+	// pending writes of column families
+	cfWrites map[*CF]map[types.Key]types.Entry
+
+	// encoded size of pendingWrites, they are spilled once it exceeds TxnSpillThreshold
+	pendingSize int
+	// encoded size of cfWrites
+	cfSize int
+	// encoded size of writes spilled, a key spilled more than once is counted each time
+	spilledSize int
+	// pending writes spilled to disk, nil if never spilled
+	spill *spill
 }
 
 type TxnFunc func(*Txn) error
 
+// Commit write pending writes of the txn atomically, the txn is discarded after commit whether it succeeds or not
 func (t *Txn) Commit() error {
 	// pre-check
+	if t.committed {
+		return ErrCommittedTxn
+	}
 	if t.discarded {
 		return ErrDiscardedTxn
 	}
 
-	if len(t.pendingWrites) == 0 {
+	if len(t.pendingWrites) == 0 && len(t.cfWrites) == 0 && t.spill == nil {
+		t.committed = true
 		t.Discard()
 		return nil
 	}
 
 	defer t.Discard()
 
+	// spilled writes are read again after commitTs is taken, once they are set to memtables a failure can
+	// not be undone, so a damaged spill fails the commit before
+	if t.spill != nil {
+		if err := t.spill.merge(nil, func(types.Entry) bool { return true }); err != nil {
+			return fmt.Errorf("failed to read spilled writes: %w", err)
+		}
+	}
+
 	orc := t.db.oracle
 
+	t.db.slowdown()
+
+	// a buffered commit is acknowledged once the write buffer is drained, which is waited for without writeLock
+	var drained <-chan struct{}
+	defer func() {
+		if drained != nil {
+			<-drained
+		}
+	}()
 	orc.writeLock.Lock()
 	defer orc.writeLock.Unlock()
 
-	commitTs, hasConflict := orc.newCommitTs(t)
-	if hasConflict {
-		return ErrConflictTxn
+	// memtables are frozen by Close
+	if t.db.State() == StateClosed {
+		return ErrDBClosed
 	}
 
-	// TODO: support txn crush recovery (txnEnt and txnFin)
+	// immutables are only added under writeLock, so the check is exact
+	if t.db.stalled() {
+		return ErrWriteStalled
+	}
+	if err := t.db.writable(); err != nil {
+		return err
+	}
 
-	for _, v := range t.pendingWrites {
-		t.db.rawset(types.Entry{
+	commitTs, err := orc.newCommitTs(t)
+	if err != nil {
+		return err
+	}
+
+	// writes of all keyspaces are logged as one wal batch, so a commit is recovered as a whole
+	entry := func(v types.Entry) types.Entry {
+		return types.Entry{
 			Key:       types.KeyWithTs(v.Key, commitTs),
 			Value:     v.Value,
 			Tombstone: v.Tombstone,
-			Version:   int64(commitTs),
-		})
+			Version:   types.Version(commitTs),
+		}
+	}
+	writes := make(map[*CF][]types.Entry, len(t.cfWrites)+1)
+	// writes of all column families share the same commitTs
+	for cf, pending := range t.cfWrites {
+		for _, v := range pending {
+			writes[cf] = append(writes[cf], entry(v))
+		}
+	}
+	var group []types.Entry
+	// keys of the commit recorded in txn log
+	var keys []string
+	if t.spill != nil {
+		// partial batches of a spilled commit are logged after buffered commits, which are not coalesced with it
+		t.db.drainLocked()
+		// stream spilled writes, the newest one of each key is written
+		// a spilled txn is too large for one wal batch, so it is written as partial batches of about
+		// TxnSpillThreshold, which are only replayed once the last batch is logged.
+		// memtables are not rotated until the commit is done, so no part of it is flushed before.
+		var size int
+		add := func(v types.Entry) bool {
+			if t.db.txnLog != nil {
+				keys = append(keys, v.Key)
+			}
+			e := entry(v)
+			group = append(group, e)
+			size += types.EncodedSize(e)
+			if size >= t.db.config.TxnSpillThreshold {
+				t.db.rawsetBatch(map[*CF][]types.Entry{nil: group}, commitTs, true)
+				group, size = nil, 0
+			}
+			return true
+		}
+		if err := t.spill.merge(slices.Collect(maps.Values(t.pendingWrites)), add); err != nil {
+			// the spill was read before commitTs was taken, some of it may be in memtables already
+			t.db.logger.Panicf("failed to read spilled writes: %v", err)
+		}
+	} else {
+		group = make([]types.Entry, 0, len(t.pendingWrites))
+		for _, v := range t.pendingWrites {
+			group = append(group, entry(v))
+		}
+		if t.db.txnLog != nil {
+			keys = slices.Collect(maps.Keys(t.pendingWrites))
+		}
+	}
+	if len(group) > 0 {
+		writes[nil] = group
+	}
+	// a buffered commit is done once the write buffer is drained
+	buffered := t.db.writeBuffer != nil && t.spill == nil
+	if buffered {
+		drained = t.db.bufferCommit(writes, commitTs)
+	} else {
+		// the last batch ends partial ones even if it is empty
+		t.db.rawsetBatch(writes, commitTs, false)
+		t.db.rotateIfFull()
+	}
+
+	// a failure to log the commit is not a failure of it, it is in wal or write buffer already
+	if t.db.txnLog != nil {
+		if err = t.db.txnLog.append(t.txnRecord(commitTs, keys)); err != nil {
+			t.db.logger.Errorf("failed to log txn %d: %v", commitTs, err)
+		}
 	}
 
-	orc.doneCommit(commitTs)
+	if !buffered {
+		orc.doneCommit(commitTs)
+	}
+	t.committed = true
 
 	return nil
 }
 
+// txnRecord record of the commit at commitTs, keys are the ones written to the default keyspace
+func (t *Txn) txnRecord(commitTs uint64, keys []string) TxnRecord {
+	slices.Sort(keys)
+	record := TxnRecord{
+		CommitTs: commitTs,
+		Keys:     keys,
+	}
+	for cf, pending := range t.cfWrites {
+		if len(pending) == 0 {
+			continue
+		}
+		if record.CFKeys == nil {
+			record.CFKeys = make(map[string][]string, len(t.cfWrites))
+		}
+		record.CFKeys[cf.name] = slices.Sorted(maps.Keys(pending))
+	}
+	return record
+}
+
+// Discard abandon the txn and release its read timestamp, pending writes are dropped
+// it is a no-op if the txn has been committed or discarded, so it is safe to defer.
 func (t *Txn) Discard() {
 	if t.discarded {
 		return
 	}
 	t.db.oracle.doneRead(t)
 	t.discarded = true
+
+	if t.spill != nil {
+		if err := t.spill.close(); err != nil {
+			t.db.logger.Errorf("failed to remove spill file: %v", err)
+		}
+	}
+}
+
+// Rollback discard a txn not committed yet, return ErrCommittedTxn if it has been committed
+func (t *Txn) Rollback() error {
+	if t.committed {
+		return ErrCommittedTxn
+	}
+	t.Discard()
+	return nil
 }
 
 func (t *Txn) Get(key string) ([]byte, bool) {
+	return t.get(nil, key)
+}
+
+// GetCF get key from column family cf
+func (t *Txn) GetCF(cf *CF, key string) ([]byte, bool) {
+	return t.get(cf, key)
+}
+
+func (t *Txn) get(cf *CF, key string) ([]byte, bool) {
+	val, ok, err := t.read(cf, key)
+	if err != nil {
+		t.db.logger.Errorf(err.Error())
+		return nil, false
+	}
+	return val, ok
+}
+
+// read get key from column family cf, or the default keyspace if cf is nil, a value that fails to decode is an error
+// read-modify-write helpers must read through it, a value they can not decode must not be overwritten.
+func (t *Txn) read(cf *CF, key string) ([]byte, bool, error) {
 	// validation
 	switch {
 	case t.discarded:
-		t.db.logger.Errorf(ErrDiscardedTxn.Error())
-		return nil, false
+		return nil, false, ErrDiscardedTxn
 	case key == "":
-		t.db.logger.Errorf(ErrEmptyKey.Error())
-		return nil, false
+		return nil, false, ErrEmptyKey
 	}
 
 	// write txn
 	if !t.readOnly {
-		if v, ok := t.pendingWrites[key]; ok {
+		if v, ok := t.pending(cf, key); ok {
 			if v.Tombstone {
-				return nil, false
+				return nil, false, nil
 			}
-			return v.Value, true
+			return t.decode(key, v.Value)
 		}
 		// Q: Why is not need to record readFp when read hit the cache?
 		// A: Record readFp is for conflict detection, a conflict will occur when reading a key modified by a committed txn.
 		// Data stored in cache belongs to current txn, other txn will not be able to view these changes.
 		//
 		// record read fingerprint
+		t.readsFp = append(t.readsFp, fingerprint(cf, key))
+	}
+
+	val, ok := t.db.searchCF(cf, types.KeyWithTs(key, t.readTs))
+	if !ok {
+		return nil, false, nil
+	}
+	return t.decode(key, val)
+}
+
+// value decode value of key read by the txn, a value that fails to decode is logged and treated as absent
+func (t *Txn) value(key string, data []byte) ([]byte, bool) {
+	val, ok, err := t.decode(key, data)
+	if err != nil {
+		t.db.logger.Errorf(err.Error())
+	}
+	return val, ok
+}
+
+// decode decode value of key read by the txn, return the error of UnmarshalValue
+func (t *Txn) decode(key string, data []byte) ([]byte, bool, error) {
+	val, err := t.db.unmarshalValue(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal value of %s: %w", key, err)
+	}
+	return val, true, nil
+}
+
+// GetWithTombstone get key like Get, but tell a deleted key from an absent one
+// ErrKeyDeleted is returned if the newest version of key visible to the txn is a tombstone.
+func (t *Txn) GetWithTombstone(key string) ([]byte, bool, error) {
+	// validation
+	switch {
+	case t.discarded:
+		return nil, false, ErrDiscardedTxn
+	case key == "":
+		return nil, false, ErrEmptyKey
+	}
+
+	// write txn
+	if !t.readOnly {
+		if v, ok := t.pending(nil, key); ok {
+			if v.Tombstone {
+				return nil, false, ErrKeyDeleted
+			}
+			val, err := t.db.unmarshalValue(v.Value)
+			if err != nil {
+				return nil, false, err
+			}
+			return val, true, nil
+		}
+		// record read fingerprint
+		t.readsFp = append(t.readsFp, utils.Hash(key))
+	}
+
+	entry, ok := t.db.searchEntry(&t.db.keyspace, types.KeyWithTs(key, t.readTs))
+	switch {
+	case !ok:
+		return nil, false, nil
+	case entry.Tombstone:
+		return nil, false, ErrKeyDeleted
+	}
+	val, err := t.db.unmarshalValue(entry.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// GetVersioned get key with the commit ts of the txn which wrote the value
+// version can be used to implement optimistic concurrency control at application layer,
+// the version of a value which is pending in the txn is 0.
+func (t *Txn) GetVersioned(key string) ([]byte, uint64, bool, error) {
+	// validation
+	switch {
+	case t.discarded:
+		return nil, 0, false, ErrDiscardedTxn
+	case key == "":
+		return nil, 0, false, ErrEmptyKey
+	}
+
+	// write txn
+	if !t.readOnly {
+		if v, ok := t.pending(nil, key); ok {
+			if v.Tombstone {
+				return nil, 0, false, nil
+			}
+			val, err := t.db.unmarshalValue(v.Value)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			return val, 0, true, nil
+		}
+		// record read fingerprint
+		t.readsFp = append(t.readsFp, utils.Hash(key))
+	}
+
+	entry, ok := t.db.searchEntry(&t.db.keyspace, types.KeyWithTs(key, t.readTs))
+	if !ok || entry.Tombstone {
+		return nil, 0, false, nil
+	}
+	val, err := t.db.unmarshalValue(entry.Value)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return val, types.ParseTs(entry.Key), true, nil
+}
+
+// History return committed versions of key visible to the txn, ordered new -> old, tombstones included,
+// pending writes of the txn are not. e.Key is the user key and e.Version its commit ts, values are decoded like Get.
+// versions at or below the discard watermark are removed by compaction but the newest Config.NumVersionsToKeep of them.
+func (t *Txn) History(key string) ([]types.Entry, error) {
+	switch {
+	case t.discarded:
+		return nil, ErrDiscardedTxn
+	case key == "":
+		return nil, ErrEmptyKey
+	}
+
+	// record read fingerprint
+	if !t.readOnly {
 		t.readsFp = append(t.readsFp, utils.Hash(key))
 	}
 
-	return t.db.search(types.KeyWithTs(key, t.readTs))
+	it, release := t.db.versions(&t.db.keyspace, key, key+"\x00")
+	defer release()
+
+	var history []types.Entry
+	for entry, ok := it.next(); ok; entry, ok = it.next() {
+		ts := types.ParseTs(entry.Key)
+		if ts > t.readTs {
+			continue
+		}
+		entry = t.db.visible(entry, t.readTs)
+		version := types.Entry{
+			Key:       key,
+			Tombstone: entry.Tombstone,
+			Version:   types.Version(ts),
+		}
+		if !entry.Tombstone {
+			val, err := t.db.unmarshalValue(entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			version.Value = val
+		}
+		history = append(history, version)
+	}
+	return history, nil
+}
+
+// MultiGet get values of keys in a batch, result is in the same order as keys
+// keys are searched in sorted order, so a data block is read at most once even if it holds many of them.
+func (t *Txn) MultiGet(keys []string) ([][]byte, []bool) {
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+
+	if t.discarded {
+		t.db.logger.Errorf(ErrDiscardedTxn.Error())
+		return values, found
+	}
+
+	// index of keys to be searched in db
+	var idx []int
+	for i, key := range keys {
+		if key == "" {
+			t.db.logger.Errorf(ErrEmptyKey.Error())
+			continue
+		}
+		// write txn
+		if !t.readOnly {
+			if v, ok := t.pending(nil, key); ok {
+				if !v.Tombstone {
+					values[i], found[i] = t.value(key, v.Value)
+				}
+				continue
+			}
+			// record read fingerprint
+			t.readsFp = append(t.readsFp, utils.Hash(key))
+		}
+		idx = append(idx, i)
+	}
+
+	tsKeys := make([]types.Key, len(keys))
+	for _, i := range idx {
+		tsKeys[i] = types.KeyWithTs(keys[i], t.readTs)
+	}
+	slices.SortFunc(idx, func(a, b int) int {
+		return types.CompareKeys(tsKeys[a], tsKeys[b])
+	})
+	sorted := make([]types.Key, len(idx))
+	for j, i := range idx {
+		sorted[j] = tsKeys[i]
+	}
+
+	res, oks := t.db.multiSearch(sorted)
+	for j, i := range idx {
+		if oks[j] {
+			values[i], found[i] = t.value(keys[i], res[j])
+		}
+	}
+	return values, found
+}
+
+// ScanLimit scan live keys in [start, end) in order, at most limit of them if limit > 0
+// next is the first key not returned, pass it as start to get the next page,
+// next is empty if there is no more key in range.
+// keys are merged from memtables, sstables and pending writes as they are read, the scan stops after limit of them.
+func (t *Txn) ScanLimit(start, end string, limit int) ([]types.KV, string) {
+	if t.discarded {
+		t.db.logger.Errorf(ErrDiscardedTxn.Error())
+		return nil, ""
+	}
+
+	it, release := t.db.iterate(&t.db.keyspace, start, end, t.readTs)
+	defer release()
+
+	var kvs []types.KV
+	var next string
+	// add the newest version of a key, return false once limit is reached
+	add := func(entry types.Entry) bool {
+		if entry.Tombstone {
+			return true
+		}
+		if limit > 0 && len(kvs) == limit {
+			next = entry.Key
+			return false
+		}
+		// record read fingerprint
+		if !t.readOnly {
+			t.readsFp = append(t.readsFp, utils.Hash(entry.Key))
+		}
+		if val, ok := t.value(entry.Key, entry.Value); ok {
+			kvs = append(kvs, types.KV{
+				K: entry.Key,
+				V: val,
+			})
+		}
+		return true
+	}
+	// committed entries have internal keys
+	committed, ok := it.next()
+	committed.Key = types.ParseKey(committed.Key)
+	advance := func() {
+		committed, ok = it.next()
+		committed.Key = types.ParseKey(committed.Key)
+	}
+
+	// pending writes of the txn shadow committed ones
+	more := true
+	err := t.mergePending(start, end, func(pending types.Entry) bool {
+		for ; ok && committed.Key < pending.Key; advance() {
+			if more = add(committed); !more {
+				return false
+			}
+		}
+		if ok && committed.Key == pending.Key {
+			advance()
+		}
+		more = add(pending)
+		return more
+	})
+	if err != nil {
+		t.db.logger.Errorf("failed to read spilled writes: %v", err)
+	}
+	for ; more && ok; advance() {
+		more = add(committed)
+	}
+
+	if next != "" {
+		t.readRange(start, next)
+		return kvs, next
+	}
+	t.readRange(start, end)
+	return kvs, ""
+}
+
+// mergePending call fn with the pending write of each key in [start, end) of the default keyspace in key order,
+// spilled ones included, until fn returns false
+func (t *Txn) mergePending(start, end string, fn func(types.Entry) bool) error {
+	var pending []types.Entry
+	for _, entry := range t.pendingWrites {
+		if entry.Key >= start && entry.Key < end {
+			pending = append(pending, entry)
+		}
+	}
+	inRange := func(entry types.Entry) bool {
+		if entry.Key < start {
+			return true
+		}
+		return entry.Key < end && fn(entry)
+	}
+	if t.spill != nil {
+		return t.spill.merge(pending, inRange)
+	}
+
+	slices.SortFunc(pending, func(a, b types.Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	for _, entry := range pending {
+		if !fn(entry) {
+			break
+		}
+	}
+	return nil
+}
+
+// readRange record range [start, end) scanned by a serializable update txn
+func (t *Txn) readRange(start, end string) {
+	if t.readOnly || t.isolation != Serializable {
+		return
+	}
+	t.readRanges = append(t.readRanges, keyRange{
+		start: start,
+		end:   end,
+	})
+}
+
+// Seek return the smallest live key >= key visible to the txn and its value, pending writes included
+// it is the building block of iterators, e.g. seek key + "\x00" for the key next to key.
+// keys seeked over are recorded as read like Get.
+func (t *Txn) Seek(key string) (string, []byte, bool) {
+	if t.discarded {
+		t.db.logger.Errorf(ErrDiscardedTxn.Error())
+		return "", nil, false
+	}
+
+	for {
+		// all versions of key are >= key@MaxUint64
+		next, ok := t.db.seekKeyspace(&t.db.keyspace, types.KeyWithTs(key, math.MaxUint64))
+		if pending, pok := t.seekPending(key); pok && (!ok || pending < next) {
+			next, ok = pending, true
+		}
+		if !ok {
+			return "", nil, false
+		}
+
+		// next may be deleted or only have versions newer than readTs
+		if val, found := t.Get(next); found {
+			return next, val, true
+		}
+		key = next + "\x00"
+	}
+}
+
+// seekPending return the smallest key >= key of pending writes of the default keyspace, tombstones included
+func (t *Txn) seekPending(key string) (string, bool) {
+	var res string
+	var found bool
+	lowerBound := func(entry types.Entry) {
+		if entry.Key >= key && (!found || entry.Key < res) {
+			res, found = entry.Key, true
+		}
+	}
+
+	if t.spill != nil {
+		// spilled writes are merged in key order, the first one >= key is the smallest
+		err := t.spill.merge(nil, func(entry types.Entry) bool {
+			lowerBound(entry)
+			return !found
+		})
+		if err != nil {
+			t.db.logger.Errorf("failed to read spilled writes: %v", err)
+		}
+	}
+	for _, entry := range t.pendingWrites {
+		lowerBound(entry)
+	}
+	return res, found
+}
+
+// Exists report whether a live version of key is visible to the txn, the value is not returned
+func (t *Txn) Exists(key string) (bool, error) {
+	// validation
+	switch {
+	case t.discarded:
+		return false, ErrDiscardedTxn
+	case key == "":
+		return false, ErrEmptyKey
+	}
+
+	// write txn
+	if !t.readOnly {
+		if v, ok := t.pending(nil, key); ok {
+			return !v.Tombstone, nil
+		}
+		// record read fingerprint
+		t.readsFp = append(t.readsFp, utils.Hash(key))
+	}
+
+	return t.db.exists(types.KeyWithTs(key, t.readTs)), nil
 }
 
 func (t *Txn) Set(key string, value []byte) error {
@@ -129,6 +723,39 @@ func (t *Txn) Set(key string, value []byte) error {
 	})
 }
 
+// SetIfAbsent set key only if no live version of it is visible to the txn, return whether the write is staged
+// the read is recorded, so the txn conflicts at commit if another txn inserts key concurrently.
+func (t *Txn) SetIfAbsent(key string, value []byte) (bool, error) {
+	if t.readOnly {
+		return false, ErrReadOnlyTxn
+	}
+
+	exists, err := t.Exists(key)
+	if err != nil || exists {
+		return false, err
+	}
+	if err = t.Set(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Append stage the value of key visible to the txn concatenated with suffix, an absent key is treated as empty
+// nothing is staged if the value fails to unmarshal, see Config.UnmarshalValue.
+// the read is recorded, so the txn conflicts at commit if another txn modifies key concurrently.
+func (t *Txn) Append(key string, suffix []byte) error {
+	if t.readOnly {
+		return ErrReadOnlyTxn
+	}
+
+	// the value read is a copy, so suffix is appended to it in place
+	val, _, err := t.read(nil, key)
+	if err != nil {
+		return err
+	}
+	return t.Set(key, append(val, suffix...))
+}
+
 func (t *Txn) Delete(key string) error {
 	return t.SetEntry(types.Entry{
 		Key:       key,
@@ -137,23 +764,163 @@ func (t *Txn) Delete(key string) error {
 	})
 }
 
+// DeleteMulti delete keys like Delete of each of them, e.g. to invalidate a known set of keys
+// nothing is staged if any key is empty.
+func (t *Txn) DeleteMulti(keys []string) error {
+	if slices.Contains(keys, "") {
+		return ErrEmptyKey
+	}
+	for _, key := range keys {
+		if err := t.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetEntry set e.Key to e.Value, or delete it if e.Tombstone, e.Version is ignored and assigned by Commit
+// use DB.ImportEntry to write an entry at its own version.
 func (t *Txn) SetEntry(e types.Entry) error {
-	return t.modify(e)
+	return t.modify(nil, e)
 }
 
-func (t *Txn) modify(e types.Entry) error {
+// SetCF set key to column family cf
+func (t *Txn) SetCF(cf *CF, key string, value []byte) error {
+	return t.modify(cf, types.Entry{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// DeleteCF delete key from column family cf
+func (t *Txn) DeleteCF(cf *CF, key string) error {
+	return t.modify(cf, types.Entry{
+		Key:       key,
+		Value:     []byte{},
+		Tombstone: true,
+	})
+}
+
+func (t *Txn) modify(cf *CF, e types.Entry) error {
 	switch {
 	case t.readOnly:
 		return ErrReadOnlyTxn
+	case t.committed:
+		return ErrCommittedTxn
 	case t.discarded:
 		return ErrDiscardedTxn
 	case e.Key == "":
 		return ErrEmptyKey
 	}
 
+	if !e.Tombstone {
+		value, err := t.db.marshalValue(e.Value)
+		if err != nil {
+			return err
+		}
+		e.Value = value
+	}
+
 	// record key fingerprint
-	t.writesFp[utils.Hash(e.Key)] = struct{}{}
+	t.writesFp[fingerprint(cf, e.Key)] = struct{}{}
 	// memory storage writer buffer
-	t.pendingWrites[e.Key] = e
+	if cf == nil {
+		if old, ok := t.pendingWrites[e.Key]; ok {
+			t.pendingSize -= types.EncodedSize(old)
+		}
+		t.pendingWrites[e.Key] = e
+		t.pendingSize += types.EncodedSize(e)
+		// no file to spill to in memory-only db
+		if threshold := t.db.config.TxnSpillThreshold; threshold > 0 && t.pendingSize > threshold && !t.db.inMemory {
+			return t.spillWrites()
+		}
+		return nil
+	}
+	if t.cfWrites[cf] == nil {
+		t.cfWrites[cf] = make(map[types.Key]types.Entry)
+	}
+	if old, ok := t.cfWrites[cf][e.Key]; ok {
+		t.cfSize -= types.EncodedSize(old)
+	}
+	t.cfWrites[cf][e.Key] = e
+	t.cfSize += types.EncodedSize(e)
 	return nil
 }
+
+// spillWrites spill pending writes of the default keyspace to disk, fingerprints are kept in memory
+func (t *Txn) spillWrites() error {
+	if t.spill == nil {
+		dir := t.db.config.TempDir
+		if dir == "" {
+			dir = t.db.dir
+		}
+		sp, err := newSpill(dir)
+		if err != nil {
+			return err
+		}
+		t.spill = sp
+	}
+	if err := t.spill.write(slices.Collect(maps.Values(t.pendingWrites))); err != nil {
+		return err
+	}
+	clear(t.pendingWrites)
+	t.spilledSize += t.pendingSize
+	t.pendingSize = 0
+	return nil
+}
+
+// NumPendingWrites return number of distinct keys written by the txn in all keyspaces, including spilled ones
+func (t *Txn) NumPendingWrites() int {
+	return len(t.writesFp)
+}
+
+// IsDirty report whether the txn has writes not committed or discarded yet
+func (t *Txn) IsDirty() bool {
+	return !t.discarded && len(t.writesFp) > 0
+}
+
+// PendingWritesSize return estimated encoded size of writes of the txn in all keyspaces,
+// e.g. to commit a large txn in batches. a key overwritten replaces its old size,
+// unless the old write has been spilled to disk.
+func (t *Txn) PendingWritesSize() int {
+	return t.pendingSize + t.cfSize + t.spilledSize
+}
+
+// pending return the pending write of key in column family cf, including spilled ones
+func (t *Txn) pending(cf *CF, key string) (types.Entry, bool) {
+	if v, ok := t.writes(cf)[key]; ok || cf != nil || t.spill == nil {
+		return v, ok
+	}
+	// key is never written by the txn
+	if _, ok := t.writesFp[fingerprint(nil, key)]; !ok {
+		return types.Entry{}, false
+	}
+	v, ok, err := t.spill.get(key)
+	if err != nil {
+		t.db.logger.Errorf("failed to read spilled writes: %v", err)
+	}
+	return v, ok
+}
+
+// writes return pending writes of column family cf, nil cf means the default one
+func (t *Txn) writes(cf *CF) map[types.Key]types.Entry {
+	if cf == nil {
+		return t.pendingWrites
+	}
+	return t.cfWrites[cf]
+}
+
+type keyRange struct {
+	// column family of the range, nil is the default keyspace
+	cf    *CF
+	start types.Key
+	end   types.Key
+}
+
+// fingerprint of key in column family cf, same key in different families will not conflict
+func fingerprint(cf *CF, key string) uint64 {
+	if cf == nil {
+		return utils.Hash(key)
+	}
+	return utils.Hash(cf.name + "\x00" + key)
+}
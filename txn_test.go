@@ -15,11 +15,18 @@
 package originium
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/B1NARY-GR0UP/originium/pkg/metrics"
+	"github.com/B1NARY-GR0UP/originium/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -203,6 +210,289 @@ func TestTxnDiscard(t *testing.T) {
 	assert.False(t, found)
 }
 
+func TestTxnStateTransitions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// commit -> discard, commit -> commit, commit -> set, commit -> rollback
+	txn := db.Begin(true)
+	assert.NoError(t, txn.Set("key", []byte("value")))
+	assert.NoError(t, txn.Commit())
+	txn.Discard()
+	assert.Equal(t, ErrCommittedTxn, txn.Commit())
+	assert.Equal(t, ErrCommittedTxn, txn.Set("key", []byte("value2")))
+	assert.Equal(t, ErrCommittedTxn, txn.Rollback())
+
+	// commit without writes
+	txn = db.Begin(true)
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, ErrCommittedTxn, txn.Commit())
+
+	// rollback -> set, rollback -> commit, rollback -> rollback
+	txn = db.Begin(true)
+	assert.NoError(t, txn.Set("key", []byte("value3")))
+	assert.NoError(t, txn.Rollback())
+	assert.Equal(t, ErrDiscardedTxn, txn.Set("key", []byte("value3")))
+	assert.Equal(t, ErrDiscardedTxn, txn.Commit())
+	assert.NoError(t, txn.Rollback())
+
+	// discard -> set, discard -> commit
+	txn = db.Begin(true)
+	txn.Discard()
+	assert.Equal(t, ErrDiscardedTxn, txn.Set("key", []byte("value4")))
+	assert.Equal(t, ErrDiscardedTxn, txn.Commit())
+
+	// conflict discards the txn without committing it
+	txn1 := db.Begin(true)
+	txn2 := db.Begin(true)
+	_, _ = txn1.Get("key")
+	assert.NoError(t, txn1.Set("key", []byte("value5")))
+	assert.NoError(t, txn2.Set("key", []byte("value6")))
+	assert.NoError(t, txn2.Commit())
+	assert.Equal(t, ErrConflictTxn, txn1.Commit())
+	assert.Equal(t, ErrDiscardedTxn, txn1.Commit())
+	assert.NoError(t, txn1.Rollback())
+
+	// txn2 is the last committed write of key
+	err := db.View(func(txn *Txn) error {
+		val, found := txn.Get("key")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value6"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnRewriteSameKey(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// versions of key staged in memtable
+	versions := func(key string) []types.Entry {
+		return db.memtable.scan(types.KeyWithTs(key, math.MaxUint64), types.KeyWithTs(key+"\x00", math.MaxUint64))
+	}
+
+	// set -> delete -> set
+	txn := db.Begin(true)
+	assert.NoError(t, txn.Set("key1", []byte("value1")))
+	val, found := txn.Get("key1")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value1"), val)
+
+	assert.NoError(t, txn.Delete("key1"))
+	_, found = txn.Get("key1")
+	assert.False(t, found)
+	_, _, err := txn.GetWithTombstone("key1")
+	assert.Equal(t, ErrKeyDeleted, err)
+
+	assert.NoError(t, txn.Set("key1", []byte("value2")))
+	val, found = txn.Get("key1")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value2"), val)
+	exists, err := txn.Exists("key1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// delete -> set -> delete
+	assert.NoError(t, txn.Delete("key2"))
+	assert.NoError(t, txn.Set("key2", []byte("value1")))
+	assert.NoError(t, txn.Delete("key2"))
+	_, found = txn.Get("key2")
+	assert.False(t, found)
+
+	assert.Len(t, txn.writesFp, 2)
+	assert.NoError(t, txn.Commit())
+
+	// exactly one entry of each key is written at commitTs
+	commitTs := db.oracle.nextTs - 1
+	entries := versions("key1")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, types.KeyWithTs("key1", commitTs), entries[0].Key)
+	assert.Equal(t, []byte("value2"), entries[0].Value)
+	assert.False(t, entries[0].Tombstone)
+
+	entries = versions("key2")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, types.KeyWithTs("key2", commitTs), entries[0].Key)
+	assert.True(t, entries[0].Tombstone)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("key1")
+		assert.True(t, found)
+		assert.Equal(t, []byte("value2"), val)
+		_, found = txn.Get("key2")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnPendingWrites(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf")
+	assert.NoError(t, err)
+
+	size := func(key string, value []byte) int {
+		return types.EncodedSize(types.Entry{Key: key, Value: value})
+	}
+
+	txn := db.Begin(true)
+	assert.False(t, txn.IsDirty())
+	assert.Equal(t, 0, txn.NumPendingWrites())
+	assert.Equal(t, 0, txn.PendingWritesSize())
+
+	assert.NoError(t, txn.Set("key1", []byte("value1")))
+	assert.True(t, txn.IsDirty())
+	assert.Equal(t, 1, txn.NumPendingWrites())
+	assert.Equal(t, size("key1", []byte("value1")), txn.PendingWritesSize())
+
+	// overwrite replaces the size of the old write
+	assert.NoError(t, txn.Set("key1", []byte("a longer value1")))
+	assert.Equal(t, 1, txn.NumPendingWrites())
+	assert.Equal(t, size("key1", []byte("a longer value1")), txn.PendingWritesSize())
+
+	assert.NoError(t, txn.Delete("key1"))
+	assert.Equal(t, 1, txn.NumPendingWrites())
+	assert.Equal(t, size("key1", []byte{}), txn.PendingWritesSize())
+
+	assert.NoError(t, txn.Delete("key2"))
+	assert.NoError(t, txn.SetCF(cf, "key1", []byte("value1")))
+	assert.NoError(t, txn.SetCF(cf, "key1", []byte("value2")))
+	assert.Equal(t, 3, txn.NumPendingWrites())
+	assert.Equal(t, 2*size("key1", []byte{})+size("key1", []byte("value2")), txn.PendingWritesSize())
+
+	// failed writes are not staged
+	assert.ErrorIs(t, txn.Set("", []byte("value")), ErrEmptyKey)
+	assert.Equal(t, 3, txn.NumPendingWrites())
+
+	assert.NoError(t, txn.Commit())
+	assert.False(t, txn.IsDirty())
+
+	// read-only txns never write
+	txn = db.Begin(false)
+	defer txn.Discard()
+	assert.ErrorIs(t, txn.Set("key1", []byte("value1")), ErrReadOnlyTxn)
+	assert.False(t, txn.IsDirty())
+	assert.Equal(t, 0, txn.NumPendingWrites())
+}
+
+func TestTxnSpill(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  64 * 1024,
+		ImmutableBuffer:        10,
+		TxnSpillThreshold:      1024,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	spills := func() []string {
+		files, err := filepath.Glob(path.Join(dir, "*"+_spillExt))
+		assert.NoError(t, err)
+		return files
+	}
+
+	txn := db.Begin(true)
+	for i := range 1000 {
+		assert.NoError(t, txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))))
+	}
+	// rewrite keys already spilled, the last write wins
+	for i := 0; i < 1000; i += 100 {
+		assert.NoError(t, txn.Set(fmt.Sprintf("key%04d", i), []byte("rewritten")))
+		assert.NoError(t, txn.Delete(fmt.Sprintf("key%04d", i+1)))
+	}
+	assert.NotNil(t, txn.spill)
+	assert.Greater(t, len(txn.spill.batches), 1)
+	assert.Len(t, spills(), 1)
+	// spilled writes are counted
+	assert.Equal(t, 1000, txn.NumPendingWrites())
+	assert.Greater(t, txn.PendingWritesSize(), 1000*len("key0000value0"))
+
+	// spilled writes are visible to the txn
+	val, found := txn.Get("key0002")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value2"), val)
+	val, found = txn.Get("key0100")
+	assert.True(t, found)
+	assert.Equal(t, []byte("rewritten"), val)
+	_, found = txn.Get("key0101")
+	assert.False(t, found)
+	kvs, _ := txn.ScanLimit("key0099", "key0103", 0)
+	assert.Equal(t, []types.KV{
+		{K: "key0099", V: []byte("value99")},
+		{K: "key0100", V: []byte("rewritten")},
+		{K: "key0102", V: []byte("value102")},
+	}, kvs)
+
+	// nothing is visible to other txns before commit
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.Get("key0002")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, txn.Commit())
+	assert.Empty(t, spills())
+
+	// all writes are visible atomically at one commitTs
+	commitTs := db.oracle.nextTs - 1
+	err = db.View(func(txn *Txn) error {
+		for i := range 1000 {
+			key := fmt.Sprintf("key%04d", i)
+			val, version, found, err := txn.GetVersioned(key)
+			assert.NoError(t, err)
+			switch i % 100 {
+			case 0:
+				assert.True(t, found)
+				assert.Equal(t, []byte("rewritten"), val)
+			case 1:
+				assert.False(t, found)
+				continue
+			default:
+				assert.True(t, found)
+				assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), val)
+			}
+			assert.Equal(t, commitTs, version)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// spill file of a discarded txn is removed
+	txn = db.Begin(true)
+	for i := range 100 {
+		assert.NoError(t, txn.Set(fmt.Sprintf("discarded%04d", i), []byte("value")))
+	}
+	assert.Len(t, spills(), 1)
+	txn.Discard()
+	assert.Empty(t, spills())
+
+	// spill files are written to TempDir
+	db.Close()
+	config.TempDir = t.TempDir()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	txn = db.Begin(true)
+	defer txn.Discard()
+	for i := range 100 {
+		assert.NoError(t, txn.Set(fmt.Sprintf("temp%04d", i), []byte("value")))
+	}
+	assert.Empty(t, spills())
+	files, err := filepath.Glob(path.Join(config.TempDir, "*"+_spillExt))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
 // Test concurrent transactions
 func TestConcurrentTxns(t *testing.T) {
 	db := setupTestDB(t)
@@ -278,6 +568,113 @@ func TestConcurrentTxns(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTxnReadYourWrites(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// each client reads its own write right after commit, while memtables of others are flushed and compacted
+	const clients, n = 4, 500
+	var wg sync.WaitGroup
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("client%d", c)
+			for i := range n {
+				value := []byte(fmt.Sprintf("value%04d", i))
+				err := db.Update(func(txn *Txn) error {
+					return txn.Set(key, value)
+				})
+				assert.NoError(t, err)
+
+				err = db.View(func(txn *Txn) error {
+					got, ok := txn.Get(key)
+					assert.True(t, ok)
+					assert.Equal(t, value, got)
+					return nil
+				})
+				assert.NoError(t, err)
+
+				txn := db.Begin(false)
+				got, ok := txn.Get(key)
+				assert.True(t, ok)
+				assert.Equal(t, value, got)
+				txn.Discard()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTxnAppend(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// absent key is appended to an empty value, pending writes are appended in place
+	err := db.Update(func(txn *Txn) error {
+		if err := txn.Append("counter", []byte("0")); err != nil {
+			return err
+		}
+		return txn.Append("counter", []byte("0"))
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	concurrentTxns := 10
+	successCount := 0
+	var mu sync.Mutex
+
+	for i := 0; i < concurrentTxns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for attempt := 0; attempt < 3; attempt++ {
+				err := db.Update(func(txn *Txn) error {
+					return txn.Append("counter", []byte("1"))
+				})
+
+				if err == nil {
+					mu.Lock()
+					successCount++
+					mu.Unlock()
+					break
+				}
+
+				// If conflict occurs, wait a bit and retry
+				if errors.Is(err, ErrConflictTxn) {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+
+				t.Errorf("Unexpected error: %v", err)
+				break
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("counter")
+		assert.True(t, found)
+		// no append is lost, +2 is for initial "00"
+		assert.Equal(t, successCount+2, len(val))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// errors
+	err = db.View(func(txn *Txn) error {
+		return txn.Append("counter", []byte("1"))
+	})
+	assert.ErrorIs(t, err, ErrReadOnlyTxn)
+	err = db.Update(func(txn *Txn) error {
+		return txn.Append("", []byte("1"))
+	})
+	assert.ErrorIs(t, err, ErrEmptyKey)
+}
+
 // Test empty key and error handling
 func TestTxnErrorHandling(t *testing.T) {
 	db := setupTestDB(t)
@@ -302,3 +699,845 @@ func TestTxnErrorHandling(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestTxnExists(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	err := db.Update(func(txn *Txn) error {
+		if err := txn.Set("present", []byte("value")); err != nil {
+			return err
+		}
+		if err := txn.Set("deleted", []byte("value")); err != nil {
+			return err
+		}
+		// visible in the same txn
+		exists, err := txn.Exists("present")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("deleted")
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		exists, err := txn.Exists("present")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		// only a tombstone is visible
+		exists, err = txn.Exists("deleted")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		exists, err = txn.Exists("absent")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		_, err = txn.Exists("")
+		assert.Equal(t, ErrEmptyKey, err)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnGetVersioned(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var versions []uint64
+	for _, value := range []string{"v1", "v2", "v3"} {
+		txn := db.Begin(true)
+		assert.NoError(t, txn.Set("key", []byte(value)))
+		// commit ts of txn is the next ts of oracle
+		commitTs := db.oracle.nextTs
+		assert.NoError(t, txn.Commit())
+		versions = append(versions, commitTs)
+
+		err := db.View(func(txn *Txn) error {
+			val, version, found, err := txn.GetVersioned("key")
+			assert.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, []byte(value), val)
+			assert.Equal(t, commitTs, version)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	assert.True(t, versions[0] < versions[1] && versions[1] < versions[2])
+
+	err := db.Update(func(txn *Txn) error {
+		// pending write has no version yet
+		assert.NoError(t, txn.Set("key", []byte("v4")))
+		val, version, found, err := txn.GetVersioned("key")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("v4"), val)
+		assert.Equal(t, uint64(0), version)
+
+		assert.NoError(t, txn.Delete("key"))
+		_, _, found, err = txn.GetVersioned("key")
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		_, _, _, err = txn.GetVersioned("")
+		assert.Equal(t, ErrEmptyKey, err)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+// setupSSTableDB open a db with n keys flushed to a single sstable
+func setupSSTableDB(tb testing.TB, n int, recorder metrics.Recorder) *DB {
+	dir := tb.TempDir()
+	config := Config{
+		SkipListMaxLevel:       4,
+		SkipListP:              0.5,
+		L0TargetNum:            4,
+		LevelRatio:             10,
+		DataBlockByteThreshold: 4096,
+		MemtableByteThreshold:  1 << 20,
+		ImmutableBuffer:        10,
+		Metrics:                recorder,
+	}
+
+	db, err := Open(dir, config)
+	assert.NoError(tb, err)
+	err = db.Update(func(txn *Txn) error {
+		for i := range n {
+			if err := txn.Set(fmt.Sprintf("key%04d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(tb, err)
+	// flush memtable to sstable
+	db.Close()
+
+	db, err = Open(dir, config)
+	assert.NoError(tb, err)
+	return db
+}
+
+func TestTxnScanLimitStreaming(t *testing.T) {
+	recorder := metrics.NewMemory()
+	// about 5 data blocks
+	db := setupSSTableDB(t, 1000, recorder)
+	defer db.Close()
+
+	blockReads := func(fn func(txn *Txn)) uint64 {
+		before := recorder.Snapshot()[metrics.BlockReads]
+		err := db.View(func(txn *Txn) error {
+			fn(txn)
+			return nil
+		})
+		assert.NoError(t, err)
+		return recorder.Snapshot()[metrics.BlockReads] - before
+	}
+
+	// a page only reads the data blocks it needs
+	assert.Equal(t, uint64(1), blockReads(func(txn *Txn) {
+		kvs, next := txn.ScanLimit("key0000", "key1000", 10)
+		assert.Len(t, kvs, 10)
+		assert.Equal(t, "key0010", next)
+	}))
+	assert.Greater(t, blockReads(func(txn *Txn) {
+		kvs, next := txn.ScanLimit("key0000", "key1000", 0)
+		assert.Len(t, kvs, 1000)
+		assert.Equal(t, "", next)
+	}), uint64(1))
+}
+
+func TestTxnMultiGet(t *testing.T) {
+	recorder := metrics.NewMemory()
+	db := setupSSTableDB(t, 1000, recorder)
+	defer db.Close()
+
+	keys := []string{"key0999", "key0000", "absent", "key0500", "key0000", "key0001"}
+	err := db.View(func(txn *Txn) error {
+		values, found := txn.MultiGet(keys)
+		assert.Equal(t, len(keys), len(values))
+		for i, key := range keys {
+			val, ok := txn.Get(key)
+			assert.Equal(t, ok, found[i])
+			assert.Equal(t, val, values[i])
+		}
+		assert.False(t, found[2])
+		assert.Equal(t, []byte("value999"), values[0])
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// every data block is fetched at most once
+	blocks := len(db.manager.levels[0].Front().Value.(tableHandle).dataBlockIndex.Entries)
+	before := recorder.Snapshot()[metrics.BlockReads]
+	keys = make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", 999-i)
+	}
+	err = db.View(func(txn *Txn) error {
+		_, found := txn.MultiGet(keys)
+		for _, ok := range found {
+			assert.True(t, ok)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(blocks), recorder.Snapshot()[metrics.BlockReads]-before)
+
+	err = db.Update(func(txn *Txn) error {
+		assert.NoError(t, txn.Set("key0001", []byte("pending")))
+		assert.NoError(t, txn.Delete("key0002"))
+		values, found := txn.MultiGet([]string{"key0001", "key0002", "key0003"})
+		assert.Equal(t, []bool{true, false, true}, found)
+		assert.Equal(t, []byte("pending"), values[0])
+		assert.Equal(t, []byte("value3"), values[2])
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestBeginWithContext(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	err := db.Update(func(txn *Txn) error {
+		return txn.Set("key", []byte("value"))
+	})
+	assert.NoError(t, err)
+
+	// stuck committer, a read must wait for the commit at its read ts
+	ts, err := db.oracle.nextCommitTs()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	txn, err := db.BeginWithContext(ctx, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, txn == nil)
+	assert.Less(t, time.Since(start), time.Second)
+
+	db.config.ReadTimeout = 50 * time.Millisecond
+	err = db.View(func(txn *Txn) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	err = db.Update(func(txn *Txn) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// failed reads do not hold back the read watermark
+	db.oracle.doneCommit(ts)
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), ts))
+
+	txn, err = db.BeginWithContext(context.Background(), false)
+	assert.NoError(t, err)
+	val, found := txn.Get("key")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+	txn.Discard()
+}
+
+func BenchmarkTxnMultiGet(b *testing.B) {
+	db := setupSSTableDB(b, 1000, nil)
+	defer db.Close()
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", i)
+	}
+
+	b.Run("MultiGet", func(b *testing.B) {
+		txn := db.Begin(false)
+		defer txn.Discard()
+		for i := 0; i < b.N; i++ {
+			txn.MultiGet(keys)
+		}
+	})
+
+	b.Run("LoopedGet", func(b *testing.B) {
+		txn := db.Begin(false)
+		defer txn.Discard()
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				txn.Get(key)
+			}
+		}
+	})
+}
+
+// setupPrefetchDB open a db of n keys in sstables of small data blocks, which scans fetch depth blocks ahead
+func setupPrefetchDB(tb testing.TB, dir string, n, depth int) *DB {
+	config := Config{
+		DataBlockByteThreshold: 1024,
+		MemtableByteThreshold:  1 << 24,
+		ScanPrefetchBlocks:     depth,
+	}
+	db, err := Open(dir, config)
+	assert.NoError(tb, err)
+	if db.oracle.nextTs > 1 {
+		return db
+	}
+
+	err = db.Update(func(txn *Txn) error {
+		for i := range n {
+			if err := txn.Set(fmt.Sprintf("key%06d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(tb, err)
+	// flush memtable to sstable
+	db.Close()
+
+	db, err = Open(dir, config)
+	assert.NoError(tb, err)
+	return db
+}
+
+func TestTxnScanPrefetch(t *testing.T) {
+	dir := t.TempDir()
+	db := setupPrefetchDB(t, dir, 10000, 0)
+	var want []types.KV
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		want, _ = txn.ScanLimit("key000100", "key009900", 0)
+		return nil
+	}))
+	db.Close()
+	assert.Len(t, want, 9800)
+
+	db = setupPrefetchDB(t, dir, 10000, 4)
+	defer db.Close()
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		kvs, next := txn.ScanLimit("key000100", "key009900", 0)
+		assert.Equal(t, want, kvs)
+		assert.Empty(t, next)
+
+		// a scan done early stops prefetching
+		kvs, next = txn.ScanLimit("key000100", "key009900", 10)
+		assert.Equal(t, want[:10], kvs)
+		assert.Equal(t, "key000110", next)
+		return nil
+	}))
+	// once scans are done no prefetch is holding sstables
+	db.triggerCompaction()
+}
+
+// large sequential scans with and without prefetch
+func BenchmarkTxnScanPrefetch(b *testing.B) {
+	dir := b.TempDir()
+	setupPrefetchDB(b, dir, 100000, 0).Close()
+
+	for _, depth := range []int{0, 4} {
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			db := setupPrefetchDB(b, dir, 100000, depth)
+			defer db.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = db.View(func(txn *Txn) error {
+					if kvs, _ := txn.ScanLimit("key", "key~", 0); len(kvs) != 100000 {
+						b.Fatalf("scan %d keys", len(kvs))
+					}
+					return nil
+				})
+			}
+		})
+	}
+}
+
+func TestTxnScanComposite(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// events of users keyed by user and zero-padded time, users whose ids are prefixes of each other
+	events := [][2]string{
+		{"user1", "0003"},
+		{"user1", "0001"},
+		{"user1", "0002"},
+		{"user10", "0001"},
+		{"user1@2", "0001"},
+		{"user", "0001"},
+	}
+	assert.NoError(t, db.Update(func(txn *Txn) error {
+		for _, e := range events {
+			if err := txn.Set(types.CompositeKey(e[0], e[1]), []byte(e[0]+"/"+e[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// some events are in sstables
+	assert.NoError(t, db.Sync())
+	assert.NoError(t, db.Update(func(txn *Txn) error {
+		return errors.Join(
+			txn.Set(types.CompositeKey("user1", "0004"), []byte("user1/0004")),
+			txn.Delete(types.CompositeKey("user1", "0002")),
+		)
+	}))
+
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		start, end := types.CompositeRange("user1")
+		kvs, next := txn.ScanLimit(start, end, 0)
+		assert.Empty(t, next)
+		var got []string
+		for _, kv := range kvs {
+			parts, err := types.SplitCompositeKey(kv.K)
+			assert.NoError(t, err)
+			assert.Equal(t, "user1", parts[0])
+			got = append(got, string(kv.V))
+		}
+		assert.Equal(t, []string{"user1/0001", "user1/0003", "user1/0004"}, got)
+
+		// pages stay within the range
+		kvs, next = txn.ScanLimit(start, end, 2)
+		assert.Len(t, kvs, 2)
+		assert.Equal(t, types.CompositeKey("user1", "0004"), next)
+		kvs, next = txn.ScanLimit(next, end, 2)
+		assert.Equal(t, []types.KV{{K: types.CompositeKey("user1", "0004"), V: []byte("user1/0004")}}, kvs)
+		assert.Empty(t, next)
+		return nil
+	}))
+}
+
+func TestTxnScanLimit(t *testing.T) {
+	// key0000 - key0099 in sstable
+	db := setupSSTableDB(t, 100, nil)
+	defer db.Close()
+
+	// newer versions and deletes in memtable
+	err := db.Update(func(txn *Txn) error {
+		for i := 0; i < 100; i += 10 {
+			if err := txn.Delete(fmt.Sprintf("key%04d", i)); err != nil {
+				return err
+			}
+		}
+		return txn.Set("key0015", []byte("updated"))
+	})
+	assert.NoError(t, err)
+
+	var expected []string
+	for i := 5; i < 95; i++ {
+		if i%10 != 0 {
+			expected = append(expected, fmt.Sprintf("key%04d", i))
+		}
+	}
+
+	// page through the range without duplicates or gaps
+	var got []string
+	start := "key0005"
+	for pages := 0; ; pages++ {
+		assert.Less(t, pages, 20)
+		var kvs []types.KV
+		var next string
+		err = db.View(func(txn *Txn) error {
+			kvs, next = txn.ScanLimit(start, "key0095", 7)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(kvs), 7)
+		for _, kv := range kvs {
+			got = append(got, kv.K)
+			if kv.K == "key0015" {
+				assert.Equal(t, []byte("updated"), kv.V)
+			}
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	assert.Equal(t, expected, got)
+
+	// fewer than limit results
+	err = db.View(func(txn *Txn) error {
+		kvs, next := txn.ScanLimit("key0091", "key0095", 10)
+		assert.Len(t, kvs, 4)
+		assert.Equal(t, "", next)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// next key deleted before the follow-up call
+	var next string
+	err = db.View(func(txn *Txn) error {
+		_, next = txn.ScanLimit("key0021", "key0030", 2)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "key0023", next)
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete(next)
+	})
+	assert.NoError(t, err)
+	err = db.View(func(txn *Txn) error {
+		kvs, _ := txn.ScanLimit(next, "key0030", 2)
+		assert.Equal(t, []types.KV{
+			{K: "key0024", V: []byte("value24")},
+			{K: "key0025", V: []byte("value25")},
+		}, kvs)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// pending writes of the txn are visible
+	err = db.Update(func(txn *Txn) error {
+		assert.NoError(t, txn.Set("key0030", []byte("pending")))
+		assert.NoError(t, txn.Delete("key0031"))
+		kvs, next := txn.ScanLimit("key0030", "key0033", 0)
+		assert.Equal(t, []types.KV{
+			{K: "key0030", V: []byte("pending")},
+			{K: "key0032", V: []byte("value32")},
+		}, kvs)
+		assert.Equal(t, "", next)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnSetIfAbsent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// absent
+	err := db.Update(func(txn *Txn) error {
+		staged, err := txn.SetIfAbsent("lock", []byte("owner1"))
+		assert.NoError(t, err)
+		assert.True(t, staged)
+
+		// present in pending writes
+		staged, err = txn.SetIfAbsent("lock", []byte("owner2"))
+		assert.NoError(t, err)
+		assert.False(t, staged)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// present
+	err = db.Update(func(txn *Txn) error {
+		staged, err := txn.SetIfAbsent("lock", []byte("owner2"))
+		assert.NoError(t, err)
+		assert.False(t, staged)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("lock")
+		assert.True(t, found)
+		assert.Equal(t, []byte("owner1"), val)
+
+		_, err := txn.SetIfAbsent("other", []byte("value"))
+		assert.Equal(t, ErrReadOnlyTxn, err)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// deleted key is absent
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("lock")
+	})
+	assert.NoError(t, err)
+
+	// concurrent insert
+	txn1 := db.Begin(true)
+	staged, err := txn1.SetIfAbsent("lock", []byte("owner3"))
+	assert.NoError(t, err)
+	assert.True(t, staged)
+
+	err = db.Update(func(txn *Txn) error {
+		staged, err := txn.SetIfAbsent("lock", []byte("owner4"))
+		assert.True(t, staged)
+		return err
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrConflictTxn, txn1.Commit())
+
+	err = db.View(func(txn *Txn) error {
+		val, found := txn.Get("lock")
+		assert.True(t, found)
+		assert.Equal(t, []byte("owner4"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnDeleteMulti(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	err := db.Update(func(txn *Txn) error {
+		for i := range 100 {
+			if err := txn.Set(fmt.Sprintf("key%03d", i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// nothing is staged with an empty key
+	err = db.Update(func(txn *Txn) error {
+		assert.ErrorIs(t, txn.DeleteMulti([]string{"key000", ""}), ErrEmptyKey)
+		assert.False(t, txn.IsDirty())
+		return nil
+	})
+	assert.NoError(t, err)
+
+	var keys []string
+	for i := 0; i < 100; i += 2 {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	err = db.Update(func(txn *Txn) error {
+		// duplicates and keys never written
+		if err := txn.DeleteMulti(append(keys, keys[0], "absent")); err != nil {
+			return err
+		}
+		assert.Equal(t, len(keys)+1, txn.NumPendingWrites())
+		_, found := txn.Get(keys[0])
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		return txn.DeleteMulti(keys)
+	})
+	assert.Equal(t, ErrReadOnlyTxn, err)
+
+	check := func() {
+		err := db.View(func(txn *Txn) error {
+			for i := range 100 {
+				_, found := txn.Get(fmt.Sprintf("key%03d", i))
+				assert.Equal(t, i%2 == 1, found)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check()
+	assert.NoError(t, db.Sync())
+	check()
+}
+
+func TestTxnGetWithTombstone(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	err := db.Update(func(txn *Txn) error {
+		if err := txn.Set("live", []byte("value")); err != nil {
+			return err
+		}
+		return txn.Set("deleted", []byte("value"))
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(txn *Txn) error {
+		return txn.Delete("deleted")
+	})
+	assert.NoError(t, err)
+
+	err = db.View(func(txn *Txn) error {
+		val, found, err := txn.GetWithTombstone("live")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("value"), val)
+
+		_, found, err = txn.GetWithTombstone("deleted")
+		assert.Equal(t, ErrKeyDeleted, err)
+		assert.False(t, found)
+
+		_, found, err = txn.GetWithTombstone("absent")
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		// Get does not tell them apart
+		_, found = txn.Get("deleted")
+		assert.False(t, found)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// pending writes of the txn
+	err = db.Update(func(txn *Txn) error {
+		assert.NoError(t, txn.Delete("live"))
+		_, _, err := txn.GetWithTombstone("live")
+		assert.Equal(t, ErrKeyDeleted, err)
+
+		assert.NoError(t, txn.Set("deleted", []byte("revived")))
+		val, found, err := txn.GetWithTombstone("deleted")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("revived"), val)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTxnWriteSkew(t *testing.T) {
+	// both txns check no slot is booked, then book a different slot
+	book := func(db *DB, isolation IsolationLevel, slot string) (*Txn, error) {
+		txn, err := db.BeginWithOptions(TxnOptions{
+			Update:    true,
+			Isolation: isolation,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if kvs, _ := txn.ScanLimit("slot/", "slot0", 0); len(kvs) > 0 {
+			return nil, errors.New("slot already booked")
+		}
+		return txn, txn.Set("slot/"+slot, []byte("booked"))
+	}
+
+	tests := []struct {
+		name      string
+		isolation IsolationLevel
+		conflict  bool
+	}{
+		{"SnapshotIsolation", SnapshotIsolation, false},
+		{"Serializable", Serializable, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			defer db.Close()
+
+			txn1, err := book(db, tt.isolation, "1")
+			assert.NoError(t, err)
+			txn2, err := book(db, tt.isolation, "2")
+			assert.NoError(t, err)
+
+			assert.NoError(t, txn1.Commit())
+			err = txn2.Commit()
+			if tt.conflict {
+				assert.ErrorIs(t, err, ErrConflictTxn)
+			} else {
+				// write skew, both slots are booked
+				assert.NoError(t, err)
+			}
+
+			var kvs []types.KV
+			err = db.View(func(txn *Txn) error {
+				kvs, _ = txn.ScanLimit("slot/", "slot0", 0)
+				return nil
+			})
+			assert.NoError(t, err)
+			if tt.conflict {
+				assert.Len(t, kvs, 1)
+			} else {
+				assert.Len(t, kvs, 2)
+			}
+			assert.Equal(t, int64(0), db.oracle.serializable.Load())
+		})
+	}
+}
+
+func TestTxnSerializableRange(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// write outside the scanned range does not conflict
+	txn, err := db.BeginWithOptions(TxnOptions{Update: true, Isolation: Serializable})
+	assert.NoError(t, err)
+	txn.ScanLimit("b", "d", 0)
+	assert.NoError(t, txn.Set("x", []byte("x")))
+
+	err = db.Update(func(txn *Txn) error {
+		return errors.Join(txn.Set("a", []byte("a")), txn.Set("d", []byte("d")))
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit())
+
+	// read-only txns track no reads
+	txn, err = db.BeginWithOptions(TxnOptions{Isolation: Serializable})
+	assert.NoError(t, err)
+	txn.ScanLimit("a", "z", 0)
+	assert.Empty(t, txn.readRanges)
+	assert.Empty(t, txn.readsFp)
+	assert.Equal(t, int64(0), db.oracle.serializable.Load())
+	txn.Discard()
+}
+
+func TestTxnSeek(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// b and d in sstable, f in memtable, c is deleted
+	err := db.Update(func(txn *Txn) error {
+		return errors.Join(txn.Set("b", []byte("b")), txn.Set("c", []byte("c")), txn.Set("d", []byte("d")))
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Sync())
+	err = db.Update(func(txn *Txn) error {
+		return errors.Join(txn.Delete("c"), txn.Set("f", []byte("f")), txn.Set("a@b", []byte("a@b")))
+	})
+	assert.NoError(t, err)
+
+	before := db.Begin(false)
+	defer before.Discard()
+	err = db.Update(func(txn *Txn) error {
+		return txn.Set("e", []byte("e"))
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		seek  string
+		key   string
+		found bool
+	}{
+		{"", "a@b", true},
+		{"a", "a@b", true},
+		{"a@b", "a@b", true},
+		{"a@b\x00", "b", true},
+		{"b", "b", true},
+		// gap between keys, deleted key is skipped
+		{"bb", "d", true},
+		{"c", "d", true},
+		{"d\x00", "e", true},
+		{"f", "f", true},
+		// greater than all keys
+		{"g", "", false},
+	}
+
+	err = db.View(func(txn *Txn) error {
+		for _, tt := range tests {
+			key, val, found := txn.Seek(tt.seek)
+			assert.Equal(t, tt.found, found, tt.seek)
+			assert.Equal(t, tt.key, key, tt.seek)
+			if found {
+				assert.Equal(t, []byte(tt.key), val, tt.seek)
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// e is newer than the read ts
+	key, _, found := before.Seek("d\x00")
+	assert.True(t, found)
+	assert.Equal(t, "f", key)
+
+	// pending writes are seen, pending deletes are skipped
+	txn := db.Begin(true)
+	defer txn.Discard()
+	assert.NoError(t, txn.Set("bb", []byte("bb")))
+	assert.NoError(t, txn.Delete("d"))
+	key, val, found := txn.Seek("ba")
+	assert.True(t, found)
+	assert.Equal(t, "bb", key)
+	assert.Equal(t, []byte("bb"), val)
+	key, _, found = txn.Seek("bb\x00")
+	assert.True(t, found)
+	assert.Equal(t, "e", key)
+	key, _, found = txn.Seek("g")
+	assert.False(t, found)
+	assert.Equal(t, "", key)
+}
@@ -0,0 +1,423 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/B1NARY-GR0UP/originium/pkg/logger"
+)
+
+const (
+	_txnLogFile = "TXN_LOG"
+	// size of a segment of the txn log over which records are appended to a new one
+	_txnLogSegmentSize = 4 << 20
+)
+
+var (
+	ErrTxnLogDisabled   = errors.New("txn log is not enabled")
+	ErrCorruptedTxnLog  = errors.New("txn log is corrupted")
+	errTxnLogRecordSize = errors.New("txn log record is truncated")
+	errTxnLogTorn       = errors.New("txn log record is torn")
+)
+
+// TxnRecord keys written by a committed txn, see Config.TxnLog
+type TxnRecord struct {
+	CommitTs uint64
+	// keys written to the default keyspace in key order, deletes included
+	Keys []string
+	// keys written to column families by name in key order, nil if there is none
+	CFKeys map[string][]string
+}
+
+// TransactionLog return records of txns committed in [fromTs, toTs] in commit order, see Config.TxnLog
+// records at or below the discard watermark are pruned by compaction, so ErrTsTooOld is returned if fromTs is not after it.
+func (db *DB) TransactionLog(fromTs, toTs uint64) ([]TxnRecord, error) {
+	if db.State() == StateClosed {
+		return nil, ErrDBClosed
+	}
+	if db.txnLog == nil {
+		return nil, ErrTxnLogDisabled
+	}
+
+	// the watermark is persisted before records are pruned, so the check holds while they are read
+	db.discardMu.Lock()
+	defer db.discardMu.Unlock()
+
+	if db.discardTs > 0 && fromTs <= db.discardTs {
+		return nil, ErrTsTooOld
+	}
+	return db.txnLog.read(fromTs, toTs)
+}
+
+// txnLog append-only segments of records of commits in commit order, each record is a frame like Export
+// records are appended to the last segment, which is sealed once it reaches segmentSize. pruning removes
+// whole sealed segments, so it never rewrites records appends wait for.
+type txnLog struct {
+	mu     sync.Mutex
+	dir    string
+	mode   os.FileMode
+	logger logger.Logger
+	// segments in commit order, protected by mu
+	segments []txnSegment
+	// last segment and bytes of it, protected by mu
+	fd   *os.File
+	size int64
+	// size of the last segment over which it is sealed, _txnLogSegmentSize
+	segmentSize int64
+}
+
+// txnSegment a file of the txn log
+type txnSegment struct {
+	seq int
+	// commit ts of the last record, 0 if it has none
+	maxTs uint64
+}
+
+// segmentName return path of segment seq of the txn log in dir
+func segmentName(dir string, seq int) string {
+	return path.Join(dir, fmt.Sprintf("%s.%06d", _txnLogFile, seq))
+}
+
+// openTxnLog open the txn log in dir, a record torn by a crash ends the last segment and is truncated.
+// a record which can not be read before the end of a segment fails open with ErrCorruptedTxnLog,
+// unless db is opened by Repair, which truncates the segment at it instead.
+func openTxnLog(dir string, mode os.FileMode, repair bool, lg logger.Logger) (*txnLog, error) {
+	l := &txnLog{
+		dir:         dir,
+		mode:        mode,
+		logger:      lg,
+		segmentSize: _txnLogSegmentSize,
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int
+	for _, file := range files {
+		var seq int
+		if _, err = fmt.Sscanf(file.Name(), _txnLogFile+".%d", &seq); err == nil && path.Base(segmentName(dir, seq)) == file.Name() {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+
+	for i, seq := range seqs {
+		name := segmentName(dir, seq)
+		segment := txnSegment{seq: seq}
+		// offset after the last complete record
+		var end int64
+		err = scanSegment(name, func(record TxnRecord, offset int64) bool {
+			segment.maxTs, end = record.CommitTs, offset
+			return true
+		})
+		last := i == len(seqs)-1
+		switch {
+		case err == nil:
+		case errors.Is(err, errTxnLogTorn) && last:
+			lg.Warnf("truncate record of txn log torn by crash at offset %d of %s", end, name)
+		case errors.Is(err, errTxnLogTorn) || errors.Is(err, ErrCorruptedTxnLog):
+			if !repair {
+				return nil, fmt.Errorf("%w: segment %s at offset %d", ErrCorruptedTxnLog, name, end)
+			}
+			lg.Warnf("txn log is corrupted at offset %d of %s, truncate records after it", end, name)
+		default:
+			return nil, err
+		}
+		if err != nil {
+			if err = os.Truncate(name, end); err != nil {
+				return nil, err
+			}
+		}
+		l.segments = append(l.segments, segment)
+		l.size = end
+	}
+
+	if len(l.segments) == 0 {
+		if err = l.create(1); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	fd, err := os.OpenFile(segmentName(dir, l.segments[len(l.segments)-1].seq), os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	l.fd = fd
+	return l, nil
+}
+
+// create start segment seq, records are appended to it from now on
+// NOTE: call with mu
+func (l *txnLog) create(seq int) error {
+	fd, err := os.OpenFile(segmentName(l.dir, seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, l.mode)
+	if err != nil {
+		return err
+	}
+	// records synced to the segment are lost if it is not found after a crash
+	if err = syncDir(l.dir); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if l.fd != nil {
+		_ = l.fd.Close()
+	}
+	l.fd = fd
+	l.size = 0
+	l.segments = append(l.segments, txnSegment{seq: seq})
+	return nil
+}
+
+// append write record durably, the last segment is sealed once it reaches segmentSize
+func (l *txnLog) append(record TxnRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, encodeTxnRecord(record)); err != nil {
+		return err
+	}
+	if _, err := l.fd.Write(buf.Bytes()); err != nil {
+		// a partial record would be followed by the next one, which reads as corruption on open
+		_ = l.fd.Truncate(l.size)
+		return err
+	}
+	if err := l.fd.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(buf.Len())
+	l.segments[len(l.segments)-1].maxTs = record.CommitTs
+
+	if l.size >= l.segmentSize {
+		return l.create(l.segments[len(l.segments)-1].seq + 1)
+	}
+	return nil
+}
+
+// read return records committed in [fromTs, toTs]
+func (l *txnLog) read(fromTs, toTs uint64) ([]TxnRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var records []TxnRecord
+	for _, segment := range l.segments {
+		// records of the segment are all before fromTs
+		if segment.maxTs < fromTs {
+			continue
+		}
+		done := false
+		err := scanSegment(segmentName(l.dir, segment.seq), func(record TxnRecord, _ int64) bool {
+			if record.CommitTs > toTs {
+				done = true
+				return false
+			}
+			if record.CommitTs >= fromTs {
+				records = append(records, record)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return records, nil
+}
+
+// prune remove segments whose records are all committed at or before ts, the last one is sealed first if
+// its records are. files are removed once mu is released, appends only wait for a new segment to be created.
+func (l *txnLog) prune(ts uint64) error {
+	l.mu.Lock()
+	if last := l.segments[len(l.segments)-1]; last.maxTs != 0 && last.maxTs <= ts {
+		if err := l.create(last.seq + 1); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+	var pruned []int
+	for len(l.segments) > 1 && l.segments[0].maxTs <= ts {
+		pruned = append(pruned, l.segments[0].seq)
+		l.segments = l.segments[1:]
+	}
+	l.mu.Unlock()
+
+	var err error
+	for _, seq := range pruned {
+		// a segment left by a failure is found on open, its records are at or below the watermark
+		err = errors.Join(err, os.Remove(segmentName(l.dir, seq)))
+	}
+	return err
+}
+
+func (l *txnLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fd.Close()
+}
+
+// scanSegment call fn with each record of segment name and the offset after it until fn returns false,
+// errTxnLogTorn is returned at a record which ends the segment incompletely, e.g. torn by a crash,
+// and ErrCorruptedTxnLog at a record which can not be read before the end of it.
+func scanSegment(name string, fn func(record TxnRecord, offset int64) bool) error {
+	fd, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	r := bufio.NewReader(fd)
+	var offset int64
+	var header [8]byte
+	for offset < size {
+		if size-offset < int64(len(header)) {
+			return errTxnLogTorn
+		}
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		n := int64(binary.LittleEndian.Uint32(header[:4]))
+		// the record ends the segment, it is not followed by any written after it
+		end := offset + int64(len(header)) + n
+		if end > size {
+			return errTxnLogTorn
+		}
+		corrupted := ErrCorruptedTxnLog
+		if end == size {
+			corrupted = errTxnLogTorn
+		}
+		if n == 0 {
+			return corrupted
+		}
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if crc32.Checksum(data, _crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+			return corrupted
+		}
+		record, err := decodeTxnRecord(data)
+		if err != nil {
+			return corrupted
+		}
+		offset = end
+		if !fn(record, offset) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// encodeTxnRecord encode record as: commitTs | count of keyspaces | (name | count of keys | key*)*,
+// the default keyspace is named "", all numbers are uvarint and strings are prefixed by uvarint length
+func encodeTxnRecord(record TxnRecord) []byte {
+	data := binary.AppendUvarint(nil, record.CommitTs)
+	data = binary.AppendUvarint(data, uint64(1+len(record.CFKeys)))
+
+	appendKeys := func(name string, keys []string) {
+		data = binary.AppendUvarint(data, uint64(len(name)))
+		data = append(data, name...)
+		data = binary.AppendUvarint(data, uint64(len(keys)))
+		for _, key := range keys {
+			data = binary.AppendUvarint(data, uint64(len(key)))
+			data = append(data, key...)
+		}
+	}
+	appendKeys("", record.Keys)
+	for _, name := range slices.Sorted(maps.Keys(record.CFKeys)) {
+		appendKeys(name, record.CFKeys[name])
+	}
+	return data
+}
+
+func decodeTxnRecord(data []byte) (TxnRecord, error) {
+	r := bytes.NewReader(data)
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(r.Len()) {
+			return "", errTxnLogRecordSize
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	var record TxnRecord
+	var err error
+	if record.CommitTs, err = binary.ReadUvarint(r); err != nil {
+		return TxnRecord{}, err
+	}
+	spaces, err := binary.ReadUvarint(r)
+	if err != nil {
+		return TxnRecord{}, err
+	}
+	for range spaces {
+		name, err := readString()
+		if err != nil {
+			return TxnRecord{}, err
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return TxnRecord{}, err
+		}
+		if n > uint64(r.Len()) {
+			return TxnRecord{}, errTxnLogRecordSize
+		}
+		keys := make([]string, 0, n)
+		for range n {
+			key, err := readString()
+			if err != nil {
+				return TxnRecord{}, err
+			}
+			keys = append(keys, key)
+		}
+		if name == "" {
+			record.Keys = keys
+			continue
+		}
+		if record.CFKeys == nil {
+			record.CFKeys = make(map[string][]string)
+		}
+		record.CFKeys[name] = keys
+	}
+	return record, nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionLog(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{TxnLog: true, TxnSpillThreshold: 1024}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	users, err := db.CreateColumnFamily("users")
+	assert.NoError(t, err)
+
+	commit := func(fn func(txn *Txn) error) uint64 {
+		assert.NoError(t, db.Update(fn))
+		return db.oracle.nextTs - 1
+	}
+
+	ts1 := commit(func(txn *Txn) error {
+		return errors.Join(txn.Set("b", []byte("b")), txn.Set("a", []byte("a")))
+	})
+	ts2 := commit(func(txn *Txn) error {
+		return errors.Join(txn.Delete("a"), txn.SetCF(users, "u1", []byte("u1")))
+	})
+	// spilled writes are recorded as they are streamed
+	var spilled []string
+	ts3 := commit(func(txn *Txn) error {
+		for i := range 100 {
+			key := fmt.Sprintf("key%03d", i)
+			spilled = append(spilled, key)
+			if err := txn.Set(key, make([]byte, 64)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// read-only txns are not recorded
+	assert.NoError(t, db.View(func(*Txn) error { return nil }))
+
+	want := []TxnRecord{
+		{CommitTs: ts1, Keys: []string{"a", "b"}},
+		{CommitTs: ts2, Keys: []string{"a"}, CFKeys: map[string][]string{"users": {"u1"}}},
+		{CommitTs: ts3, Keys: spilled},
+	}
+	records, err := db.TransactionLog(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, want, records)
+
+	records, err = db.TransactionLog(ts2, ts2)
+	assert.NoError(t, err)
+	assert.Equal(t, want[1:2], records)
+
+	// records survive restart, a record torn by a crash is dropped
+	db.Close()
+	fd, err := os.OpenFile(segmentName(dir, 1), os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write([]byte{1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+
+	records, err = db.TransactionLog(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, want, records)
+
+	ts4 := commit(func(txn *Txn) error {
+		return txn.Set("c", []byte("c"))
+	})
+	records, err = db.TransactionLog(ts3, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, []TxnRecord{want[2], {CommitTs: ts4, Keys: []string{"c"}}}, records)
+
+	// records at or below the discard watermark are pruned by compaction
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.oracle.readMark.WaitForMark(context.Background(), ts4))
+	db.triggerCompaction()
+	_, err = db.TransactionLog(ts1, math.MaxUint64)
+	assert.ErrorIs(t, err, ErrTsTooOld)
+
+	ts5 := commit(func(txn *Txn) error {
+		return txn.Set("d", []byte("d"))
+	})
+	records, err = db.TransactionLog(ts5, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, []TxnRecord{{CommitTs: ts5, Keys: []string{"d"}}}, records)
+
+	records, err = db.txnLog.read(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, []TxnRecord{{CommitTs: ts5, Keys: []string{"d"}}}, records)
+}
+
+func TestTransactionLogSegments(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{TxnLog: true}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	db.txnLog.segmentSize = 64
+
+	commit := func(key string) uint64 {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte(key))
+		}))
+		return db.oracle.nextTs - 1
+	}
+	var ts []uint64
+	for i := range 10 {
+		ts = append(ts, commit(fmt.Sprintf("key%02d-%s", i, strings.Repeat("x", 64))))
+	}
+	segments := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, _txnLogFile+".*"))
+		assert.NoError(t, err)
+		return files
+	}
+	assert.Len(t, segments(), 11)
+
+	// pruning removes whole segments, the ones left are not rewritten
+	info, err := os.Stat(segmentName(dir, 10))
+	assert.NoError(t, err)
+	assert.NoError(t, db.txnLog.prune(ts[8]))
+	assert.Len(t, segments(), 2)
+	kept, err := os.Stat(segmentName(dir, 10))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(info, kept))
+	records, err := db.txnLog.read(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, ts[9], records[0].CommitTs)
+
+	// the last segment is sealed once all its records are pruned
+	assert.NoError(t, db.txnLog.prune(ts[9]))
+	assert.Equal(t, []string{segmentName(dir, 11)}, segments())
+	ts10 := commit("key10")
+	records, err = db.txnLog.read(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Equal(t, []TxnRecord{{CommitTs: ts10, Keys: []string{"key10"}}}, records)
+	db.Close()
+}
+
+func TestTransactionLogCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{TxnLog: true}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b"} {
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte(key))
+		}))
+	}
+	db.Close()
+
+	// a record which can not be read is followed by another one, it is not torn by a crash
+	data, err := os.ReadFile(segmentName(dir, 1))
+	assert.NoError(t, err)
+	data[len(data)/2-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(segmentName(dir, 1), data, 0o644))
+
+	_, err = Open(dir, config)
+	assert.ErrorIs(t, err, ErrCorruptedTxnLog)
+
+	// repair truncates the log at the corrupted record
+	db, err = Repair(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+	records, err := db.TransactionLog(0, math.MaxUint64)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestTransactionLogDisabled(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{})
+	assert.NoError(t, err)
+
+	_, err = db.TransactionLog(0, math.MaxUint64)
+	assert.ErrorIs(t, err, ErrTxnLogDisabled)
+
+	db.Close()
+	_, err = db.TransactionLog(0, math.MaxUint64)
+	assert.ErrorIs(t, err, ErrDBClosed)
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"errors"
+	"strings"
+)
+
+// composite key encoding: each part is escaped and terminated by _partEnd,
+// a 0x00 byte of a part is escaped as 0x00 0xff, which sorts after _partEnd,
+// so composite keys sort by their parts in order, a shorter part before any longer part it is a prefix of,
+// e.g. ("a", "2") < ("a", "10") < ("ab", "1"). neither byte is '@', so ParseKey and ParseTs are not confused.
+const (
+	_partEnd    = "\x00\x01"
+	_escapedNul = "\x00\xff"
+)
+
+var ErrInvalidCompositeKey = errors.New("invalid composite key")
+
+// CompositeKey encode parts into a user key ordered by parts in order, e.g. CompositeKey(userID, timestamp),
+// any byte is allowed in a part, parts must have a fixed width or be zero-padded to sort numbers by value.
+func CompositeKey(parts ...string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(strings.ReplaceAll(part, "\x00", _escapedNul))
+		b.WriteString(_partEnd)
+	}
+	return b.String()
+}
+
+// SplitCompositeKey decode parts of a key encoded by CompositeKey
+func SplitCompositeKey(key string) ([]string, error) {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0x00 {
+			part.WriteByte(key[i])
+			continue
+		}
+		if i+1 == len(key) {
+			return nil, ErrInvalidCompositeKey
+		}
+		switch key[i+1] {
+		case _partEnd[1]:
+			parts = append(parts, part.String())
+			part.Reset()
+		case _escapedNul[1]:
+			part.WriteByte(0x00)
+		default:
+			return nil, ErrInvalidCompositeKey
+		}
+		i++
+	}
+	if part.Len() > 0 {
+		return nil, ErrInvalidCompositeKey
+	}
+	return parts, nil
+}
+
+// CompositeRange return [start, end) of composite keys whose leading parts are parts, at least one, e.g. all keys of a user,
+// to be passed to a range scan like Txn.ScanLimit. keys with more parts after them are included,
+// and keys whose leading part merely starts with a part are not, e.g. ("ab", ...) is not in range of ("a").
+func CompositeRange(parts ...string) (start, end string) {
+	start = CompositeKey(parts...)
+	if start == "" {
+		return "", ""
+	}
+	// all keys with prefix start are before start with its last byte 0x01 of _partEnd incremented
+	return start, start[:len(start)-1] + "\x02"
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompositeKeyOrder(t *testing.T) {
+	// in order of parts
+	ordered := [][]string{
+		{"a"},
+		{"a", ""},
+		{"a", "1"},
+		{"a", "1", "x"},
+		{"a", "2"},
+		{"a\x00", "1"},
+		{"a\x00\x01", "1"},
+		{"a@1", "1"},
+		{"ab", "1"},
+		{"b"},
+	}
+	var keys []string
+	for _, parts := range ordered {
+		keys = append(keys, CompositeKey(parts...))
+	}
+	assert.True(t, slices.IsSorted(keys))
+
+	// versions of composite keys sort by parts first like any user key
+	for i := 1; i < len(keys); i++ {
+		assert.Negative(t, CompareKeys(KeyWithTs(keys[i-1], 1), KeyWithTs(keys[i], 2)))
+		assert.Equal(t, keys[i], ParseKey(KeyWithTs(keys[i], 2)))
+	}
+
+	for i, key := range keys {
+		parts, err := SplitCompositeKey(key)
+		assert.NoError(t, err)
+		assert.Equal(t, ordered[i], parts)
+	}
+}
+
+func TestSplitCompositeKeyInvalid(t *testing.T) {
+	for _, key := range []string{"a", "a\x00", "a\x00\x02", CompositeKey("a") + "b"} {
+		_, err := SplitCompositeKey(key)
+		assert.ErrorIs(t, err, ErrInvalidCompositeKey, key)
+	}
+}
+
+func TestCompositeRange(t *testing.T) {
+	start, end := CompositeRange("a")
+	in := func(key string) bool {
+		return key >= start && key < end
+	}
+	assert.True(t, in(CompositeKey("a")))
+	assert.True(t, in(CompositeKey("a", "")))
+	assert.True(t, in(CompositeKey("a", "\xff\xff")))
+	assert.True(t, in(CompositeKey("a", "1", "x")))
+	assert.False(t, in(CompositeKey("ab")))
+	assert.False(t, in(CompositeKey("a\x00")))
+	assert.False(t, in(CompositeKey("")))
+	assert.False(t, in("a"))
+
+	start, end = CompositeRange("a", "1")
+	assert.True(t, in(CompositeKey("a", "1", "x")))
+	assert.False(t, in(CompositeKey("a", "10")))
+	assert.False(t, in(CompositeKey("a")))
+}
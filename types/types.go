@@ -47,6 +47,23 @@ func Value(entry Entry) ([]byte, bool) {
 	return entry.Value, true
 }
 
+// Ts return the commit ts of entry
+// Version is an i64 in thrift, which has no unsigned type, it holds the bits of the uint64 ts as is.
+func Ts(entry Entry) uint64 {
+	return uint64(entry.Version)
+}
+
+// Version return Entry.Version of commit ts, the inverse of Ts
+func Version(ts uint64) int64 {
+	return int64(ts)
+}
+
+// KeyWithTs encode user key and ts into an internal key: key@ts
+//
+// ts is always the last part and never contains '@', so the user key is everything before the last '@'.
+// user keys containing '@', even ending with "@digits" like "user@5", are decoded as is,
+// e.g. KeyWithTs("user@5", 7) = "user@5@7", ParseKey gives "user@5" and ParseTs gives 7.
+// bare keys without '@', like the skiplist HEAD sentinel, are treated as the whole key at ts 0.
 func KeyWithTs(key string, ts uint64) string {
 	return key + "@" + strconv.FormatUint(ts, 10)
 }
@@ -55,16 +72,23 @@ func IsSameKey(key1, key2 string) bool {
 	return ParseKey(key1) == ParseKey(key2)
 }
 
+// ParseKey return user key of internal key, key without ts is returned as is
 func ParseKey(key string) string {
-	return key[:strings.LastIndex(key, "@")]
+	i := strings.LastIndex(key, "@")
+	if i < 0 {
+		return key
+	}
+	return key[:i]
 }
 
+// ParseTs return ts of internal key, 0 if key has no valid ts
 func ParseTs(key string) uint64 {
-	if key == "" {
+	i := strings.LastIndex(key, "@")
+	if i < 0 {
 		return 0
 	}
 
-	ts, err := strconv.ParseUint(key[strings.LastIndex(key, "@")+1:], 10, 64)
+	ts, err := strconv.ParseUint(key[i+1:], 10, 64)
 	if err != nil {
 		return 0
 	}
@@ -72,6 +96,7 @@ func ParseTs(key string) uint64 {
 	return ts
 }
 
+// CompareKeys order keys by user key, then by ts descending, bare keys are compared as ts 0
 func CompareKeys(key1, key2 string) int {
 	if cmp := strings.Compare(ParseKey(key1), ParseKey(key2)); cmp != 0 {
 		return cmp
@@ -87,3 +112,44 @@ func CompareKeys(key1, key2 string) int {
 	}
 	return 0
 }
+
+// EncodedSize size of entry in data block encoding, before compression and without key prefix sharing
+// it is used as the size of an entry everywhere on the write path,
+// so that MemtableByteThreshold and DataBlockByteThreshold are measured in the same unit.
+func EncodedSize(entry Entry) int {
+	// lcp is always 0 without key prefix sharing
+	return UvarintLen(0) +
+		UvarintLen(uint64(len(entry.Key))) + len(entry.Key) +
+		UvarintLen(uint64(len(entry.Value))) + len(entry.Value) +
+		// tombstone
+		1 +
+		UvarintLen(Ts(entry))
+}
+
+// UvarintLen number of bytes of x encoded as uvarint
+func UvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// MemtableIndex in-memory index of a memtable, entries are ordered by CompareKeys
+// an entry set with the key of an existing one replaces its value and tombstone.
+// it is guarded by the memtable, implementations need not be safe for concurrent use.
+type MemtableIndex interface {
+	Set(entry Entry)
+	// Get return the entry of exactly key
+	Get(key Key) (Entry, bool)
+	// LowerBound return the first entry whose key is not before key
+	LowerBound(key Key) (Entry, bool)
+	// Scan return entries in [start, end)
+	Scan(start, end Key) []Entry
+	All() []Entry
+	// Size return the sum of EncodedSize of entries
+	Size() int
+	// Reset return an empty index of the same kind
+	Reset() MemtableIndex
+}
@@ -15,6 +15,7 @@
 package types
 
 import (
+	"math"
 	"sort"
 	"testing"
 
@@ -64,6 +65,9 @@ func TestParseKey(t *testing.T) {
 		{"k1@1", "k1"},
 		{"hello@12345", "hello"},
 		{"@0", ""},
+		{"invalid", "invalid"},
+		{"HEAD", "HEAD"},
+		{"", ""},
 	}
 
 	for _, test := range tests {
@@ -72,6 +76,37 @@ func TestParseKey(t *testing.T) {
 	}
 }
 
+func TestKeyWithAt(t *testing.T) {
+	keys := []string{"a@b.com", "user@5", "user@", "@", "@@1", "plain", ""}
+	for _, key := range keys {
+		for _, ts := range []uint64{0, 7, math.MaxUint64} {
+			internal := KeyWithTs(key, ts)
+			assert.Equal(t, key, ParseKey(internal), internal)
+			assert.Equal(t, ts, ParseTs(internal), internal)
+			assert.True(t, IsSameKey(internal, KeyWithTs(key, 1)), internal)
+		}
+	}
+
+	// "user@5" is a different key from "user" at ts 5
+	assert.False(t, IsSameKey(KeyWithTs("user@5", 1), KeyWithTs("user", 5)))
+
+	// ordered by user key, then ts descending
+	sorted := []string{
+		KeyWithTs("user", 9),
+		KeyWithTs("user", 5),
+		KeyWithTs("user@", 1),
+		KeyWithTs("user@5", 9),
+		KeyWithTs("user@5", 1),
+	}
+	for i := 1; i < len(sorted); i++ {
+		assert.Equal(t, -1, CompareKeys(sorted[i-1], sorted[i]), sorted[i])
+	}
+
+	// key without ts does not panic
+	assert.Equal(t, "plain", ParseKey("plain"))
+	assert.Equal(t, uint64(0), ParseTs("plain"))
+}
+
 func TestCompareKeys(t *testing.T) {
 	tests := []struct {
 		key1   string
@@ -85,6 +120,15 @@ func TestCompareKeys(t *testing.T) {
 		{"k1@1", "k2@1", -1},
 		{"k1@5", "k2@10", -1},
 		{"k2@10", "k1@5", 1},
+		// bare keys
+		{"k1", "k1", 0},
+		{"k1", "k1@0", 0},
+		{"k1", "k1@1", 1},
+		{"k1", "k2", -1},
+		{"", "", 0},
+		{"", "k1@1", -1},
+		{"HEAD", "k1@1", -1},
+		{"HEAD", "HEAD", 0},
 	}
 
 	for _, test := range tests {
@@ -39,6 +39,26 @@ func (w *ErrorWriter) Write(order binary.ByteOrder, data any) {
 	w.err = binary.Write(w.buf, order, data)
 }
 
+// WriteUvarint write v in varint encoding
+func (w *ErrorWriter) WriteUvarint(v uint64) {
+	if w.err != nil {
+		return
+	}
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	_, w.err = w.buf.Write(b[:n])
+}
+
+// WriteVarint write v in zig-zag varint encoding
+func (w *ErrorWriter) WriteVarint(v int64) {
+	if w.err != nil {
+		return
+	}
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	_, w.err = w.buf.Write(b[:n])
+}
+
 func (w *ErrorWriter) Error() error {
 	return w.err
 }
@@ -62,6 +82,46 @@ func (r *ErrorReader) Read(order binary.ByteOrder, data any) {
 	r.err = binary.Read(r.r, order, data)
 }
 
+// ReadUvarint read a varint encoded uint64
+func (r *ErrorReader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	br, ok := r.r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r.r}
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(br)
+	return v
+}
+
+// ReadVarint read a zig-zag varint encoded int64
+func (r *ErrorReader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	br, ok := r.r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r.r}
+	}
+	var v int64
+	v, r.err = binary.ReadVarint(br)
+	return v
+}
+
 func (r *ErrorReader) Error() error {
 	return r.err
 }
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(b.r, buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
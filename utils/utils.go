@@ -64,8 +64,38 @@ func Pow(x, n int) int {
 	return res
 }
 
+// CompressionLevel trade cpu for compression ratio, the output of every level is decoded by Decompress
+type CompressionLevel int
+
+const (
+	// CompressionDefault s2 default, the fastest
+	CompressionDefault CompressionLevel = iota
+	// CompressionBetter s2 better, smaller output at about twice the cpu
+	CompressionBetter
+	// CompressionBest s2 best, smallest output at much more cpu
+	CompressionBest
+	// CompressionNone no compression, e.g. for read latency of small values,
+	// it is handled by the block format of sstables, CompressWithLevel treats it as CompressionDefault
+	CompressionNone
+)
+
+func (l CompressionLevel) options() []s2.WriterOption {
+	switch l {
+	case CompressionBetter:
+		return []s2.WriterOption{s2.WriterBetterCompression()}
+	case CompressionBest:
+		return []s2.WriterOption{s2.WriterBestCompression()}
+	default:
+		return nil
+	}
+}
+
 func Compress(src io.Reader, dst io.Writer) error {
-	enc := s2.NewWriter(dst)
+	return CompressWithLevel(src, dst, CompressionDefault)
+}
+
+func CompressWithLevel(src io.Reader, dst io.Writer, level CompressionLevel) error {
+	enc := s2.NewWriter(dst, level.options()...)
 	_, err := io.Copy(enc, src)
 	if err != nil {
 		_ = enc.Close()
@@ -14,7 +14,17 @@
 
 package originium
 
+import "github.com/B1NARY-GR0UP/originium/table"
+
 const (
 	Name    = "originium"
 	Version = "v0.2.1"
 )
+
+// FormatVersion return the format version of sstables written by this release, for tools reading files externally.
+// it is bumped whenever the layout of a block changes, see the table package for the history of layouts.
+// keys in sstables and wal are internal keys of the user key and commit ts, "key@ts", see types.KeyWithTs,
+// the ts is the last '@' part in decimal. Open fails with table.ErrUnsupportedFormat on a newer version.
+func FormatVersion() int {
+	return table.FormatVersion()
+}
@@ -20,9 +20,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +37,30 @@ import (
 
 var errNilFD = errors.New("fd must not be nil")
 
+var (
+	// ErrCorruptedRecord a record of wal can not be decoded
+	ErrCorruptedRecord = errors.New("wal record is corrupted")
+	// ErrTruncatedRecord the last record of wal is cut short, e.g. torn by a crash while being written,
+	// so it was never synced and acknowledged
+	ErrTruncatedRecord = fmt.Errorf("%w: truncated at the end of wal", ErrCorruptedRecord)
+)
+
+// DefaultFileMode permission of wal files if Options.FileMode is not set
+const DefaultFileMode os.FileMode = 0644
+
+const (
+	// header of a batch record, see WriteBatch
+	_batchMarker = math.MinInt64
+	// flag of a batch followed by more batches of the same commit
+	_batchPartial = 1
+)
+
+// Record entry read from wal with the column family it is written to, "" is the default one
+type Record struct {
+	Family string
+	Entry  types.Entry
+}
+
 type WAL struct {
 	mu      sync.Mutex
 	logger  logger.Logger
@@ -42,41 +68,74 @@ type WAL struct {
 	dir     string
 	path    string
 	version string
+	// permission of wal files created by Reset
+	mode os.FileMode
+}
+
+// Options of wal files, zero values are replaced with defaults
+type Options struct {
+	Logger logger.Logger
+	// permission of wal files created, subject to umask
+	FileMode os.FileMode
 }
 
 func Create(dir string) (*WAL, error) {
+	return CreateWithOptions(dir, Options{})
+}
+
+// CreateWithLogger same as Create, but the wal logs with l
+func CreateWithLogger(dir string, l logger.Logger) (*WAL, error) {
+	return CreateWithOptions(dir, Options{Logger: l})
+}
+
+// CreateWithOptions same as Create, but with opts
+func CreateWithOptions(dir string, opts Options) (*WAL, error) {
+	if opts.Logger == nil {
+		opts.Logger = logger.GetLogger()
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = DefaultFileMode
+	}
+
 	createdAt := time.Now()
 	version := fmt.Sprintf("%s-%d", createdAt.Format("20060102150405"), createdAt.Nanosecond())
 
 	name := path.Join(dir, fmt.Sprintf("wal-%s.log", version))
 
-	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0755)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, opts.FileMode)
 	if err != nil {
 		return nil, err
 	}
 	return &WAL{
-		logger:  logger.GetLogger(),
+		logger:  opts.Logger,
 		fd:      file,
 		dir:     dir,
 		path:    name,
 		version: version,
+		mode:    opts.FileMode,
 	}, nil
 }
 
 func Open(file string) (*WAL, error) {
+	return OpenWithLogger(file, logger.GetLogger())
+}
+
+// OpenWithLogger same as Open, but the wal logs with l
+func OpenWithLogger(file string, l logger.Logger) (*WAL, error) {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		return nil, err
 	}
-	fd, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND, 0755)
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND, 0)
 	if err != nil {
 		return nil, err
 	}
 	return &WAL{
-		logger:  logger.GetLogger(),
+		logger:  l,
 		fd:      fd,
 		dir:     filepath.Dir(file),
 		path:    file,
 		version: ParseVersion(path.Base(file)),
+		mode:    DefaultFileMode,
 	}, nil
 }
 
@@ -92,7 +151,10 @@ func (w *WAL) Reset() (*WAL, error) {
 	if err := w.close(); err != nil {
 		return nil, err
 	}
-	return Create(w.dir)
+	return CreateWithOptions(w.dir, Options{
+		Logger:   w.logger,
+		FileMode: w.mode,
+	})
 }
 
 func (w *WAL) Delete() error {
@@ -107,40 +169,119 @@ func (w *WAL) Delete() error {
 	return nil
 }
 
+// Write write each entry as a record
+//
+// record format: length(8) | entry encoded by thrift
 func (w *WAL) Write(entries ...types.Entry) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	return w.write(entries, false, 0)
+}
 
-	if w.fd == nil {
-		return errNilFD
+// WriteGroup write entries of a commit at commitTs as one group record,
+// which is read as a whole or not at all, e.g. if it is torn by a crash
+//
+// group record format: -count(8) | commitTs(8) | count records
+// the negative count tells a group from a record, whose length is never negative.
+func (w *WAL) WriteGroup(entries []types.Entry, commitTs uint64) error {
+	if len(entries) == 0 {
+		return nil
 	}
+	return w.write(entries, true, commitTs)
+}
 
-	if _, err := w.fd.Seek(0, io.SeekEnd); err != nil {
-		return err
+// WriteBatch write entries of a commit at commitTs to column families as one batch record, keyed by family name,
+// "" is the default one. a commit too large for one record is written as several batches of the same commitTs,
+// all but the last one partial, they are read together once the last one is written, or not at all.
+// a batch is written even if it is empty, e.g. the last batch of a commit ends its partial ones.
+//
+// batch record format: marker(8) | commitTs(8) | flags(8) | count(8) | count (family length(8) | family | record)
+// the marker is math.MinInt64, which is never the negative count of a group.
+func (w *WAL) WriteBatch(families map[string][]types.Entry, commitTs uint64, partial bool) error {
+	buf := bufferpool.Pool.Get()
+	defer bufferpool.Pool.Put(buf)
+
+	var count int64
+	for _, entries := range families {
+		count += int64(len(entries))
+	}
+	var flags uint64
+	if partial {
+		flags |= _batchPartial
 	}
+	for _, v := range []any{int64(_batchMarker), commitTs, flags, count} {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	for family, entries := range families {
+		for _, entry := range entries {
+			if err := binary.Write(buf, binary.LittleEndian, int64(len(family))); err != nil {
+				return err
+			}
+			buf.WriteString(family)
+			if err := w.encode(buf, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return w.commit(buf)
+}
 
+func (w *WAL) write(entries []types.Entry, group bool, commitTs uint64) error {
 	buf := bufferpool.Pool.Get()
 	defer bufferpool.Pool.Put(buf)
 
-	for _, entry := range entries {
-		data, err := utils.TMarshal(&entry)
-		if err != nil {
+	if group {
+		if err := binary.Write(buf, binary.LittleEndian, -int64(len(entries))); err != nil {
 			return err
 		}
-
-		// data length
-		n := int64(len(data))
-		err = binary.Write(buf, binary.LittleEndian, n)
-		if err != nil {
+		if err := binary.Write(buf, binary.LittleEndian, commitTs); err != nil {
 			return err
 		}
-		// data body
-		err = binary.Write(buf, binary.LittleEndian, data)
-		if err != nil {
+	}
+
+	for _, entry := range entries {
+		if err := w.encode(buf, entry); err != nil {
 			return err
 		}
+	}
+	return w.commit(buf)
+}
+
+// encode append entry to buf as a record
+func (w *WAL) encode(buf *bytes.Buffer, entry types.Entry) error {
+	data, err := utils.TMarshal(&entry)
+	if err != nil {
+		return err
+	}
 
-		w.logger.Debugf("wal prepare entry: %+v", entry)
+	// data length
+	n := int64(len(data))
+	err = binary.Write(buf, binary.LittleEndian, n)
+	if err != nil {
+		return err
+	}
+	// data body
+	err = binary.Write(buf, binary.LittleEndian, data)
+	if err != nil {
+		return err
+	}
+
+	w.logger.Debugf("wal prepare entry: %+v", entry)
+	return nil
+}
+
+// commit append records encoded in buf to wal and sync it
+func (w *WAL) commit(buf *bytes.Buffer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd == nil {
+		return errNilFD
+	}
+
+	if _, err := w.fd.Seek(0, io.SeekEnd); err != nil {
+		return err
 	}
 
 	if err := binary.Write(w.fd, binary.LittleEndian, buf.Bytes()); err != nil {
@@ -154,7 +295,21 @@ func (w *WAL) Write(entries ...types.Entry) error {
 	return nil
 }
 
+// Read decode all entries of wal, see ReadRecords
 func (w *WAL) Read() ([]types.Entry, error) {
+	records, err := w.ReadRecords()
+	entries := make([]types.Entry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, record.Entry)
+	}
+	return entries, err
+}
+
+// ReadRecords decode all records of wal, entries of a group or batch record are read together,
+// and partial batches of a commit only once its last batch is read.
+// if a record is corrupted, records before it are returned along with ErrCorruptedRecord,
+// or ErrTruncatedRecord if it is cut short at the end of wal.
+func (w *WAL) ReadRecords() ([]Record, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -184,29 +339,139 @@ func (w *WAL) Read() ([]types.Entry, error) {
 		return nil, err
 	}
 
-	var entries []types.Entry
+	var records []Record
+	// records of partial batches of the commit at partialTs, they are dropped if the commit never ends,
+	// e.g. it is torn by a crash
+	var partial []Record
+	var partialTs uint64
 	reader := bytes.NewReader(buf.Bytes())
 	for reader.Len() > 0 {
-		// data length
+		// data length, negative count of a group or batch marker
 		var n int64
 		if err = binary.Read(reader, binary.LittleEndian, &n); err != nil {
-			return nil, err
+			return records, ErrTruncatedRecord
+		}
+		if n == _batchMarker {
+			commitTs, more, batch, err := readBatch(reader)
+			if err != nil {
+				return records, err
+			}
+			if len(partial) > 0 && partialTs != commitTs {
+				partial = nil
+			}
+			partial, partialTs = append(partial, batch...), commitTs
+			if !more {
+				records, partial = append(records, partial...), nil
+			}
+			continue
+		}
+		// records after partial batches are never written by their commit
+		partial = nil
+
+		if n >= 0 {
+			entry, err := readRecord(reader, n)
+			if err != nil {
+				return records, err
+			}
+			records = append(records, Record{Entry: entry})
+			continue
 		}
 
-		// data body
-		data := make([]byte, n)
-		if err = binary.Read(reader, binary.LittleEndian, &data); err != nil {
-			return nil, err
+		// commit ts of group is only for inspection, entries keep their own ts
+		var commitTs uint64
+		if err = binary.Read(reader, binary.LittleEndian, &commitTs); err != nil {
+			return records, ErrTruncatedRecord
+		}
+		// every record of group takes at least its length
+		count := -n
+		if count > int64(reader.Len()/8) {
+			return records, ErrTruncatedRecord
+		}
+		group := make([]Record, 0, count)
+		for range count {
+			entry, err := readLengthAndRecord(reader)
+			if err != nil {
+				return records, err
+			}
+			group = append(group, Record{Entry: entry})
 		}
+		records = append(records, group...)
+	}
+
+	return records, nil
+}
 
-		var entry types.Entry
-		if err = utils.TUnmarshal(data, &entry); err != nil {
-			return nil, err
+// readBatch read a batch record whose marker has been read
+func readBatch(reader *bytes.Reader) (commitTs uint64, partial bool, records []Record, err error) {
+	var flags uint64
+	var count int64
+	for _, v := range []any{&commitTs, &flags, &count} {
+		if err = binary.Read(reader, binary.LittleEndian, v); err != nil {
+			return 0, false, nil, ErrTruncatedRecord
+		}
+	}
+	if count < 0 {
+		return 0, false, nil, fmt.Errorf("%w: negative count %d of batch", ErrCorruptedRecord, count)
+	}
+	// every record of batch takes at least its family length and length
+	if count > int64(reader.Len()/16) {
+		return 0, false, nil, ErrTruncatedRecord
+	}
+
+	records = make([]Record, 0, count)
+	for range count {
+		var n int64
+		if err = binary.Read(reader, binary.LittleEndian, &n); err != nil {
+			return 0, false, nil, ErrTruncatedRecord
+		}
+		if n < 0 {
+			return 0, false, nil, fmt.Errorf("%w: negative family length %d in batch", ErrCorruptedRecord, n)
+		}
+		if n > int64(reader.Len()) {
+			return 0, false, nil, ErrTruncatedRecord
+		}
+		family := make([]byte, n)
+		if _, err = io.ReadFull(reader, family); err != nil {
+			return 0, false, nil, ErrTruncatedRecord
 		}
-		entries = append(entries, entry)
+		entry, err := readLengthAndRecord(reader)
+		if err != nil {
+			return 0, false, nil, err
+		}
+		records = append(records, Record{Family: string(family), Entry: entry})
 	}
+	return commitTs, flags&_batchPartial != 0, records, nil
+}
 
-	return entries, nil
+// readLengthAndRecord read a record of a group or batch
+func readLengthAndRecord(reader *bytes.Reader) (types.Entry, error) {
+	var n int64
+	if err := binary.Read(reader, binary.LittleEndian, &n); err != nil {
+		return types.Entry{}, ErrTruncatedRecord
+	}
+	if n < 0 {
+		return types.Entry{}, fmt.Errorf("%w: negative length %d of record", ErrCorruptedRecord, n)
+	}
+	return readRecord(reader, n)
+}
+
+// readRecord read the entry of a record whose length n has been read
+func readRecord(reader *bytes.Reader, n int64) (types.Entry, error) {
+	if n > int64(reader.Len()) {
+		return types.Entry{}, ErrTruncatedRecord
+	}
+
+	// data body
+	data := make([]byte, n)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return types.Entry{}, ErrTruncatedRecord
+	}
+
+	var entry types.Entry
+	if err := utils.TUnmarshal(data, &entry); err != nil {
+		return types.Entry{}, fmt.Errorf("%w: %w", ErrCorruptedRecord, err)
+	}
+	return entry, nil
 }
 
 func (w *WAL) Version() string {
@@ -241,9 +506,13 @@ func CompareVersion(v1, v2 string) int {
 		return 1
 	}
 
-	if parts1[1] < parts2[1] {
+	// nanoseconds are not zero padded, compare them as numbers
+	nano1, _ := strconv.Atoi(parts1[1])
+	nano2, _ := strconv.Atoi(parts2[1])
+
+	if nano1 < nano2 {
 		return -1
-	} else if parts1[1] > parts2[1] {
+	} else if nano1 > nano2 {
 		return 1
 	}
 
@@ -90,3 +90,150 @@ func TestWriteAndRead(t *testing.T) {
 	err = wal.Delete()
 	assert.NoError(t, err)
 }
+
+func TestReadCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Create(dir)
+	assert.NoError(t, err)
+	defer wal.Delete()
+
+	entries := []types.Entry{
+		{Key: "hello", Value: []byte("world")},
+		{Key: "foo", Value: []byte("bar")},
+	}
+	err = wal.Write(entries...)
+	assert.NoError(t, err)
+	info, err := os.Stat(wal.path)
+	assert.NoError(t, err)
+
+	// tail of the last record is torn
+	assert.NoError(t, os.Truncate(wal.path, info.Size()-1))
+	readEntries, err := wal.Read()
+	assert.ErrorIs(t, err, ErrCorruptedRecord)
+	assert.Equal(t, entries[:1], readEntries)
+
+	// length of a record is out of file
+	assert.NoError(t, os.Truncate(wal.path, 4))
+	readEntries, err = wal.Read()
+	assert.ErrorIs(t, err, ErrCorruptedRecord)
+	assert.Empty(t, readEntries)
+}
+
+func TestWriteGroup(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Create(dir)
+	assert.NoError(t, err)
+	defer wal.Delete()
+
+	groups := [][]types.Entry{
+		{
+			{Key: types.KeyWithTs("a", 1), Value: []byte("1")},
+			{Key: types.KeyWithTs("b", 1), Value: []byte("1")},
+		},
+		{
+			{Key: types.KeyWithTs("c", 2), Value: []byte("2")},
+		},
+		{
+			{Key: types.KeyWithTs("a", 3), Tombstone: true},
+			{Key: types.KeyWithTs("d", 3), Value: []byte("3")},
+			{Key: types.KeyWithTs("e", 3), Value: []byte("3")},
+		},
+	}
+	var sizes []int64
+	for i, group := range groups {
+		assert.NoError(t, wal.WriteGroup(group, uint64(i+1)))
+		info, err := os.Stat(wal.path)
+		assert.NoError(t, err)
+		sizes = append(sizes, info.Size())
+	}
+	// empty group writes nothing
+	assert.NoError(t, wal.WriteGroup(nil, 4))
+	// groups and single records are mixed
+	single := types.Entry{Key: types.KeyWithTs("f", 5), Value: []byte("5")}
+	assert.NoError(t, wal.Write(single))
+
+	all := append(append(append(append([]types.Entry{}, groups[0]...), groups[1]...), groups[2]...), single)
+	readEntries, err := wal.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, all, readEntries)
+
+	// final group is torn anywhere, none of its entries is read
+	for _, size := range []int64{sizes[2] - 1, sizes[1] + 20, sizes[1] + 12, sizes[1] + 4} {
+		assert.NoError(t, os.Truncate(wal.path, size))
+		readEntries, err = wal.Read()
+		assert.ErrorIs(t, err, ErrTruncatedRecord, size)
+		assert.ErrorIs(t, err, ErrCorruptedRecord, size)
+		assert.Equal(t, all[:3], readEntries, size)
+	}
+
+	// whole groups are read
+	assert.NoError(t, os.Truncate(wal.path, sizes[1]))
+	readEntries, err = wal.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, all[:3], readEntries)
+}
+
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Create(dir)
+	assert.NoError(t, err)
+	defer wal.Delete()
+
+	size := func() int64 {
+		info, err := os.Stat(wal.path)
+		assert.NoError(t, err)
+		return info.Size()
+	}
+
+	// a commit to two column families
+	first := []Record{
+		{Family: "", Entry: types.Entry{Key: types.KeyWithTs("a", 1), Value: []byte("1")}},
+		{Family: "users", Entry: types.Entry{Key: types.KeyWithTs("a", 1), Value: []byte("users")}},
+	}
+	assert.NoError(t, wal.WriteBatch(map[string][]types.Entry{
+		"":      {first[0].Entry},
+		"users": {first[1].Entry},
+	}, 1, false))
+
+	// a large commit written as partial batches, ended by an empty one
+	second := []Record{
+		{Entry: types.Entry{Key: types.KeyWithTs("b", 2), Value: []byte("2")}},
+		{Entry: types.Entry{Key: types.KeyWithTs("c", 2), Value: []byte("2")}},
+	}
+	assert.NoError(t, wal.WriteBatch(map[string][]types.Entry{"": {second[0].Entry}}, 2, true))
+	assert.NoError(t, wal.WriteBatch(map[string][]types.Entry{"": {second[1].Entry}}, 2, true))
+	partial := size()
+	assert.NoError(t, wal.WriteBatch(nil, 2, false))
+
+	records, err := wal.ReadRecords()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, first, records[:2])
+	assert.Equal(t, second, records[2:])
+
+	// the commit is torn after its partial batches, none of them is read
+	assert.NoError(t, os.Truncate(wal.path, partial))
+	records, err = wal.ReadRecords()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, first, records)
+
+	// partial batches of a commit abandoned before its last batch are dropped by the next commit
+	third := Record{Entry: types.Entry{Key: types.KeyWithTs("d", 3), Value: []byte("3")}}
+	assert.NoError(t, wal.WriteBatch(map[string][]types.Entry{"": {third.Entry}}, 3, false))
+	records, err = wal.ReadRecords()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, first, records[:2])
+	assert.Equal(t, []Record{third}, records[2:])
+
+	// torn last batch
+	assert.NoError(t, os.Truncate(wal.path, size()-1))
+	records, err = wal.ReadRecords()
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+	assert.ElementsMatch(t, first, records)
+}
+
+func TestCompareVersion(t *testing.T) {
+	assert.Equal(t, -1, CompareVersion("20250101000000-999", "20250101000000-1000"))
+	assert.Equal(t, 1, CompareVersion("20250101000001-1", "20250101000000-999999999"))
+	assert.Equal(t, 0, CompareVersion("20250101000000-42", "20250101000000-42"))
+	assert.Equal(t, "20250101000000-999", ParseVersion("wal-20250101000000-999.log"))
+}
@@ -0,0 +1,140 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+)
+
+// writeBuffer coalescing buffer of commits in front of wal and memtables, see Config.WriteBufferWindow
+//
+// commits buffered since the last drain form a group, which is logged as one wal batch of the latest write
+// of each key at the ts of its last commit. a buffered commit is acknowledged once its group is drained,
+// so no acknowledged commit is lost by a crash. buffered commits are the latest ones, they are not done
+// in commitMark until drained, and txns begun meanwhile read the snapshot before them, see oracle.readTs,
+// so the versions a buffered write replaces are never read by anyone.
+// fields except pending are protected by oracle.writeLock.
+type writeBuffer struct {
+	window   time.Duration
+	maxBytes int
+	// latest write of each key of each keyspace since the last drain, nil is the default keyspace
+	writes map[*CF]map[types.Key]types.Entry
+	size   int
+	// commit ts of buffered commits, they are done once drained
+	commits []uint64
+	// closed once buffered commits are drained
+	drained chan struct{}
+	timer   *time.Timer
+	// number of buffered commits, read by drainWriteBuffer without writeLock
+	pending atomic.Int64
+}
+
+func newWriteBuffer(config Config) *writeBuffer {
+	if config.WriteBufferWindow <= 0 {
+		return nil
+	}
+	return &writeBuffer{
+		window:   config.WriteBufferWindow,
+		maxBytes: config.WriteBufferBytes,
+		writes:   make(map[*CF]map[types.Key]types.Entry),
+	}
+}
+
+// bufferCommit hold writes of a commit at commitTs in write buffer, a write of a key buffered before replaces it,
+// the buffer is drained once it exceeds WriteBufferBytes, or WriteBufferWindow after its first commit.
+// the returned channel is closed once the commit is drained, the committer waits for it without writeLock.
+// NOTE: call with writeLock
+func (db *DB) bufferCommit(writes map[*CF][]types.Entry, commitTs uint64) <-chan struct{} {
+	wb := db.writeBuffer
+	if len(wb.commits) == 0 {
+		wb.drained = make(chan struct{})
+		db.oracle.bufferFrom(commitTs)
+	}
+	for cf, entries := range writes {
+		buffered, ok := wb.writes[cf]
+		if !ok {
+			buffered = make(map[types.Key]types.Entry)
+			wb.writes[cf] = buffered
+		}
+		for _, entry := range entries {
+			key := types.ParseKey(entry.Key)
+			if old, ok := buffered[key]; ok {
+				wb.size -= types.EncodedSize(old)
+			}
+			buffered[key] = entry
+			wb.size += types.EncodedSize(entry)
+		}
+	}
+	wb.commits = append(wb.commits, commitTs)
+	wb.pending.Add(1)
+
+	drained := wb.drained
+	if wb.size >= wb.maxBytes {
+		db.drainLocked()
+		return drained
+	}
+	if wb.timer == nil {
+		wb.timer = time.AfterFunc(wb.window, db.drainWriteBuffer)
+	}
+	return drained
+}
+
+// drainWriteBuffer write buffered commits to wal and memtables and mark them done, a no-op if none is buffered
+func (db *DB) drainWriteBuffer() {
+	if db.writeBuffer == nil || db.writeBuffer.pending.Load() == 0 {
+		return
+	}
+	db.oracle.writeLock.Lock()
+	defer db.oracle.writeLock.Unlock()
+
+	db.drainLocked()
+}
+
+// drainLocked drain write buffer, buffered commits are logged as one wal batch, so they are recovered as a whole,
+// then they are done and their committers are acknowledged
+// NOTE: call with writeLock
+func (db *DB) drainLocked() {
+	wb := db.writeBuffer
+	if wb == nil || len(wb.commits) == 0 {
+		return
+	}
+	if wb.timer != nil {
+		wb.timer.Stop()
+		wb.timer = nil
+	}
+
+	writes := make(map[*CF][]types.Entry, len(wb.writes))
+	for cf, buffered := range wb.writes {
+		for _, entry := range buffered {
+			writes[cf] = append(writes[cf], entry)
+		}
+	}
+	db.rawsetBatch(writes, wb.commits[len(wb.commits)-1], false)
+	db.rotateIfFull()
+
+	db.oracle.bufferFrom(0)
+	for _, ts := range wb.commits {
+		db.oracle.doneCommit(ts)
+	}
+	close(wb.drained)
+	wb.writes = make(map[*CF]map[types.Key]types.Entry)
+	wb.size = 0
+	wb.commits = nil
+	wb.drained = nil
+	wb.pending.Store(0)
+}
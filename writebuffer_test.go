@@ -0,0 +1,162 @@
+// Copyright 2025 BINARY Members
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package originium
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/B1NARY-GR0UP/originium/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{WriteBufferWindow: 20 * time.Millisecond}
+	db, err := Open(dir, config)
+	assert.NoError(t, err)
+
+	// values of hot key records in wal
+	hotRecords := func() []string {
+		entries, err := db.memtable.wal.Read()
+		assert.NoError(t, err)
+		var values []string
+		for _, entry := range entries {
+			if types.ParseKey(entry.Key) == "hot" {
+				values = append(values, string(entry.Value))
+			}
+		}
+		return values
+	}
+
+	// writers updating a hot key one txn after another
+	const writers, updates = 8, 50
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range updates {
+				value := []byte(fmt.Sprintf("%d-%d", w, i))
+				assert.NoError(t, db.Update(func(txn *Txn) error {
+					return txn.Set("hot", value)
+				}))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, len(hotRecords()), writers*updates/4)
+
+	// an acknowledged commit is logged and read by a txn begun after it
+	for i := range 3 {
+		value := fmt.Sprintf("update%d", i)
+		assert.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set("hot", []byte(value))
+		}))
+		records := hotRecords()
+		assert.Equal(t, value, records[len(records)-1])
+		assert.NoError(t, db.View(func(txn *Txn) error {
+			val, ok := txn.Get("hot")
+			assert.True(t, ok)
+			assert.Equal(t, []byte(value), val)
+			return nil
+		}))
+	}
+
+	db.Close()
+	db, err = Open(dir, config)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		val, ok := txn.Get("hot")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("update2"), val)
+		return nil
+	}))
+}
+
+func TestWriteBufferRead(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{WriteBufferWindow: time.Minute})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// commit in background, the result is sent once it is acknowledged
+	update := func(value string) chan error {
+		committed := make(chan error, 1)
+		go func() {
+			committed <- db.Update(func(txn *Txn) error {
+				return txn.Set("key", []byte(value))
+			})
+		}()
+		assert.Eventually(t, func() bool {
+			return db.writeBuffer.pending.Load() == 1
+		}, time.Second, time.Millisecond)
+		return committed
+	}
+
+	committed := update("old")
+	assert.NoError(t, db.Sync())
+	assert.NoError(t, <-committed)
+
+	committed = update("new")
+	// readers neither wait for nor drain buffered commits, which are not acknowledged yet
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		val, ok := txn.Get("key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("old"), val)
+		return nil
+	}))
+	assert.Equal(t, int64(1), db.writeBuffer.pending.Load())
+	select {
+	case <-committed:
+		t.Fatal("commit acknowledged before it is logged")
+	default:
+	}
+
+	assert.NoError(t, db.Sync())
+	assert.NoError(t, <-committed)
+	assert.NoError(t, db.View(func(txn *Txn) error {
+		val, ok := txn.Get("key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("new"), val)
+		return nil
+	}))
+}
+
+func TestWriteBufferDrain(t *testing.T) {
+	db, err := Open(t.TempDir(), Config{WriteBufferWindow: 10 * time.Millisecond, WriteBufferBytes: 1024})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// drained once its window passes, commit returns after it
+	txn := db.Begin(true)
+	assert.NoError(t, txn.Set("key", []byte("value")))
+	assert.NoError(t, txn.Commit())
+	assert.Zero(t, db.writeBuffer.pending.Load())
+	entry, ok := db.memtable.get(types.KeyWithTs("key", db.oracle.nextTs-1))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), entry.Value)
+
+	// drained once it is full
+	txn = db.Begin(true)
+	for i := range 100 {
+		assert.NoError(t, txn.Set(fmt.Sprintf("key%03d", i), make([]byte, 16)))
+	}
+	assert.NoError(t, txn.Commit())
+	assert.Zero(t, db.writeBuffer.pending.Load())
+}